@@ -232,25 +232,35 @@ type BlobWriter interface {
 type BlobCache interface {
 	// CacheCatalog will cache the registry catalog.
 	CacheCatalog(ctx context.Context, content []byte) error
-	CacheCatalogInfo(ctx context.Context, content []byte) error
-	GetCatalogInfo(ctx context.Context) ([]byte, error)
 	// GetCatalog will get the cache in the storage.
 	GetCatalog(ctx context.Context) ([]byte, error)
+	// PutWarmUpCheckpoint stores the progress of the cache warm-up run when
+	// the registry starts.
+	PutWarmUpCheckpoint(ctx context.Context, content []byte) error
+	// GetWarmUpCheckpoint returns the progress of the cache warm-up.
+	GetWarmUpCheckpoint(ctx context.Context) ([]byte, error)
+	// DeleteWarmUpCheckpoint removes the progress of the cache warm-up once
+	// it has finished.
+	DeleteWarmUpCheckpoint(ctx context.Context) error
 	// CacheTagList will cache the taglist in the storage.
 	CacheTagList(ctx context.Context, content []byte, name string) error
 	// GetTagList will get the taglist which store in the storage.
 	GetTagList(ctx context.Context, name string) ([]byte, error)
-	SaveImageInfo(ctx context.Context, content []byte, name string) error
-	SaveTagInfo(ctx context.Context, content []byte, name, tag string) error
-	GetImageInfo(ctx context.Context, name string) ([]byte, error)
-	GetTagInfo(ctx context.Context, name, tag string) ([]byte, error)
 	InitItem(ctx context.Context, name, tag string) error
 
-	GetImageItemList(ctx context.Context, name string) ([]string, error)
-	GetTagItemList(ctx context.Context, name, tag string) ([]string, error)
-	SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) error
-	SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) error
-	ServeItem(ctx context.Context, w http.ResponseWriter, r *http.Request, path, item string) error
+	// GetImageItemList returns the items of the repository.
+	GetImageItemList(ctx context.Context, name string) ([]ItemInfo, error)
+	// GetTagItemList returns the items of the tag.
+	GetTagItemList(ctx context.Context, name, tag string) ([]ItemInfo, error)
+	// SaveImageItem stores the request body as an item of the repository.
+	// The content is kept in the blob store and linked from the item.
+	SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) (Descriptor, error)
+	// SaveTagItem stores the request body as an item of the tag.
+	SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) (Descriptor, error)
+	// ServeImageItem serves the content of an item of the repository.
+	ServeImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) error
+	// ServeTagItem serves the content of an item of the tag.
+	ServeTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) error
 	DeleteImageItem(ctx context.Context, name, item string) error
 	DeleteAllImageItems(ctx context.Context, name string) error
 	DeleteTagItem(ctx context.Context, name, tag, item string) error
@@ -2,10 +2,167 @@ package distribution
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
 )
 
+// TagInfo is the metadata record kept for a single tag of a repository.
+type TagInfo struct {
+	Name          string    `json:"name"`
+	Tag           string    `json:"tag"`
+	CreateTime    time.Time `json:"createTime"`
+	DownloadCount int       `json:"downloadCount"`
+	Size          int64     `json:"size"`
+
+	// Labels are the labels of the image configuration of the tag. For a
+	// manifest list, they are the labels of the image of the default
+	// platform.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Digest and MediaType identify the manifest the tag points to.
+	Digest    digest.Digest `json:"digest,omitempty"`
+	MediaType string        `json:"mediaType,omitempty"`
+
+	// Image describes the image of the tag. It is nil for a manifest list,
+	// whose images are described by Platforms.
+	Image *ImageDetails `json:"image,omitempty"`
+
+	// Platforms describes the image of each platform of a manifest list.
+	Platforms []PlatformImage `json:"platforms,omitempty"`
+}
+
+// ImageDetails describes an image, as read from its manifest and its
+// configuration.
+type ImageDetails struct {
+	Architecture string            `json:"architecture,omitempty"`
+	OS           string            `json:"os,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	ExposedPorts []string          `json:"exposedPorts,omitempty"`
+
+	// EnvKeys are the names of the environment variables set by the image.
+	// Their values are left out, since they may hold secrets.
+	EnvKeys []string `json:"envKeys,omitempty"`
+
+	// Layers are the layers of the image, from the base layer up.
+	LayerCount int         `json:"layerCount"`
+	Layers     []LayerInfo `json:"layers,omitempty"`
+}
+
+// LayerInfo describes a layer of an image.
+type LayerInfo struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+// PlatformImage describes the image of a platform of a manifest list.
+type PlatformImage struct {
+	Digest     digest.Digest `json:"digest"`
+	MediaType  string        `json:"mediaType,omitempty"`
+	Variant    string        `json:"variant,omitempty"`
+	CreateTime time.Time     `json:"createTime"`
+	Size       int64         `json:"size"`
+	ImageDetails
+}
+
+// ImageInfo summarizes the tag records of a repository. The set of all
+// ImageInfo records makes up the catalog info of the registry.
+type ImageInfo struct {
+	Name          string    `json:"name"`
+	Tags          []TagInfo `json:"tags"`
+	Size          int       `json:"size"`
+	DownloadCount int       `json:"downloadCount"`
+	LastModified  time.Time `json:"lastModified"`
+	CreateTime    time.Time `json:"createTime"`
+}
+
+// ItemInfo describes an item, such as a README or a scan report, attached to
+// a repository or to a tag.
+type ItemInfo struct {
+	Name      string        `json:"name"`
+	Size      int64         `json:"size"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+
+	// CreatedAt is the time the item was first saved. Updating the item
+	// keeps its creation time.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ModifiedAt is the time the content of the item was last saved.
+	ModifiedAt time.Time `json:"modifiedAt"`
+
+	// Annotations are arbitrary key-value pairs provided by the uploader.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// MetadataIndex stores per-tag metadata records and maintains the summary of
+// each repository incrementally. Every write only touches the records of a
+// single repository, so several registry instances may share one index
+// without overwriting each other's changes.
+type MetadataIndex interface {
+	// GetTagInfo returns the record of the tag, or ErrTagUnknown if the tag
+	// has not been indexed.
+	GetTagInfo(ctx context.Context, name, tag string) (TagInfo, error)
+
+	// PutTagInfo stores the record and refreshes the repository summary.
+	PutTagInfo(ctx context.Context, info TagInfo) error
+
+	// DeleteTagInfo removes the record of the tag and refreshes the
+	// repository summary.
+	DeleteTagInfo(ctx context.Context, name, tag string) error
+
+	// TagInfos returns the records of all indexed tags of the repository.
+	TagInfos(ctx context.Context, name string) ([]TagInfo, error)
+
+	// GetImageInfo returns the summary of the repository, or
+	// ErrRepositoryUnknown if the repository has not been indexed.
+	GetImageInfo(ctx context.Context, name string) (ImageInfo, error)
+
+	// DeleteImageInfo removes the repository summary and all of its tag
+	// records from the index.
+	DeleteImageInfo(ctx context.Context, name string) error
+
+	// ImageInfos returns the summaries of all indexed repositories, sorted
+	// by name.
+	ImageInfos(ctx context.Context) ([]ImageInfo, error)
+}
+
+// DownloadStats reports the pulls counted for a tag or a repository.
+type DownloadStats struct {
+	// Total is the number of pulls counted since the counter was created.
+	Total int `json:"total"`
+
+	// Daily maps days, formatted as "2006-01-02" in UTC, to the number of
+	// pulls counted on that day. Only recent days are retained.
+	Daily map[string]int `json:"daily"`
+}
+
+// DownloadCounter keeps pull counters for tags and repositories. Increments
+// must be atomic, so that concurrent pulls served by one or several registry
+// instances are all accounted for.
+type DownloadCounter interface {
+	// Increment counts one pull of the tag, and of its repository, at the
+	// given time.
+	Increment(ctx context.Context, name, tag string, at time.Time) error
+
+	// TagDownloads returns the pulls counted for the tag.
+	TagDownloads(ctx context.Context, name, tag string) (DownloadStats, error)
+
+	// RepositoryDownloads returns the pulls counted for all tags of the
+	// repository.
+	RepositoryDownloads(ctx context.Context, name string) (DownloadStats, error)
+
+	// DeleteTag removes the counters of the tag. The pulls remain counted
+	// for the repository.
+	DeleteTag(ctx context.Context, name, tag string) error
+
+	// DeleteRepository removes all counters of the repository.
+	DeleteRepository(ctx context.Context, name string) error
+}
+
 // CacheService provides access to information about cached objects.
 type CacheService interface {
 	// Create catalog cache will cache the catalog list so that when use
@@ -22,19 +179,28 @@ type CacheService interface {
 
 	GetTagList(ctx context.Context) ([]string, error)
 
-	SaveTagInfo(ctx context.Context, tag string, content []byte) error
+	// SaveTagInfo records the metadata of a tag in the metadata index.
+	SaveTagInfo(ctx context.Context, info TagInfo) error
 
-	SaveImageInfo(ctx context.Context, content []byte) error
+	// DeleteTagInfo removes the metadata of a tag from the metadata index.
+	DeleteTagInfo(ctx context.Context, tag string) error
 
-	GetImageInfo(ctx context.Context) ([]byte, error)
+	// GetImageInfo returns the repository summary kept by the metadata
+	// index.
+	GetImageInfo(ctx context.Context) (ImageInfo, error)
 
-	GetTagInfo(ctx context.Context, tag string) ([]byte, error)
+	// GetTagInfo returns the metadata of a tag kept by the metadata index.
+	GetTagInfo(ctx context.Context, tag string) (TagInfo, error)
 
-	GetImageItemList(ctx context.Context) ([]string, error)
+	// GetImageItemList returns the items of the repository.
+	GetImageItemList(ctx context.Context) ([]ItemInfo, error)
 
-	GetTagItemList(ctx context.Context, tag string) ([]string, error)
+	// GetTagItemList returns the items of the tag.
+	GetTagItemList(ctx context.Context, tag string) ([]ItemInfo, error)
 
-	SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error
+	// SaveImageItem stores the request body as an item of the repository
+	// and returns the descriptor of its content.
+	SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) (Descriptor, error)
 
 	DeleteImageItem(ctx context.Context, item string) error
 
@@ -42,7 +208,9 @@ type CacheService interface {
 
 	GetImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error
 
-	SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error
+	// SaveTagItem stores the request body as an item of the tag and returns
+	// the descriptor of its content.
+	SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) (Descriptor, error)
 
 	GetTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error
 
@@ -52,9 +220,9 @@ type CacheService interface {
 
 	InitItem(ctx context.Context, tag string) error
 
-	SaveCatalogInfo(ctx context.Context, content []byte) error
-
-	GetCatalogInfo(ctx context.Context) ([]byte, error)
+	// GetCatalogInfo returns the summaries of all repositories kept by the
+	// metadata index.
+	GetCatalogInfo(ctx context.Context) ([]ImageInfo, error)
 
 	DeleteImageRepository(ctx context.Context) error
 
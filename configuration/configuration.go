@@ -23,6 +23,10 @@ type Configuration struct {
 		Disable    bool `yaml:"disable"`
 		StartCheck bool `yaml:"startcheck"`
 		Auth       bool `yaml:"auth"`
+
+		// WarmUpWorkers is the number of tags whose caches are built
+		// concurrently by the warm-up enabled with StartCheck.
+		WarmUpWorkers int `yaml:"warmupworkers,omitempty"`
 	}
 
 	// Log supports setting various parameters related to the logging
@@ -169,6 +173,25 @@ type Configuration struct {
 
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// Replication configures the registries pushed images are copied to
+	Replication Replication `yaml:"replication,omitempty"`
+
+	// Quota configures the storage quotas of repositories
+	Quota Quota `yaml:"quota,omitempty"`
+
+	// TagProtection configures the rules protecting tags from being moved
+	// or deleted
+	TagProtection TagProtection `yaml:"tagprotection,omitempty"`
+
+	// Retention configures the rules pruning the old tags of repositories
+	Retention Retention `yaml:"retention,omitempty"`
+
+	// TokenServer configures the built-in token server
+	TokenServer TokenServer `yaml:"tokenserver,omitempty"`
+
+	// Robots configures the robot accounts
+	Robots Robots `yaml:"robots,omitempty"`
+
 	// Compatibility is used for configurations of working with older or deprecated features.
 	Compatibility struct {
 		// Schema1 configures how schema1 manifests will be handled
@@ -188,7 +211,8 @@ type LogHook struct {
 	// Disable lets user select to enable hook or not.
 	Disabled bool `yaml:"disabled,omitempty"`
 
-	// Type allows user to select which type of hook handler they want.
+	// Type allows user to select which type of hook handler they want:
+	// mail, webhook, syslog or file.
 	Type string `yaml:"type,omitempty"`
 
 	// Levels set which levels of log message will let hook executed.
@@ -196,6 +220,47 @@ type LogHook struct {
 
 	// MailOptions allows user to configurate email parameters.
 	MailOptions MailOptions `yaml:"options,omitempty"`
+
+	// Options holds the options section of any hook type, which is also
+	// decoded into MailOptions.
+	Options Parameters `yaml:"-"`
+
+	// QueueSize is the number of entries queued for delivery, beyond which
+	// entries are dropped
+	QueueSize int `yaml:"queuesize,omitempty"`
+
+	// RateLimit is the maximum number of entries delivered per minute, or
+	// no limit if negative
+	RateLimit int `yaml:"ratelimit,omitempty"`
+
+	// DedupWindow is the time during which the repeats of a message are
+	// only counted, or no deduplication if negative
+	DedupWindow time.Duration `yaml:"dedupwindow,omitempty"`
+
+	// Digest is the time during which entries are batched before being
+	// delivered
+	Digest time.Duration `yaml:"digest,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, decoding the
+// options of the hook both into MailOptions and Options.
+func (hook *LogHook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plainLogHook LogHook
+	var plain plainLogHook
+	if err := unmarshal(&plain); err != nil {
+		return err
+	}
+
+	var options struct {
+		Options Parameters `yaml:"options,omitempty"`
+	}
+	if err := unmarshal(&options); err != nil {
+		return err
+	}
+
+	*hook = LogHook(plain)
+	hook.Options = options.Options
+	return nil
 }
 
 // MailOptions provides the configuration sections to user, for specific handler.
@@ -511,6 +576,165 @@ type Endpoint struct {
 	Backoff   time.Duration `yaml:"backoff"`   // backoff duration
 }
 
+// Replication configures the replication of the manifests pushed to the
+// registry, and the blobs they reference, to other registries.
+type Replication struct {
+	// Policies lists the target registries and what is replicated to them
+	Policies []ReplicationPolicy `yaml:"policies,omitempty"`
+}
+
+// ReplicationPolicy describes a target registry of replication.
+type ReplicationPolicy struct {
+	Name     string `yaml:"name"`     // identifies the target in the registry instance.
+	Disabled bool   `yaml:"disabled"` // disables the replication to the target
+	URL      string `yaml:"url"`      // url of the target registry.
+
+	// Username and Password authenticate with the target registry
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// Repositories and Tags select the pushes which are replicated. Each
+	// is a list of patterns as understood by path.Match; an empty list
+	// matches everything. Pushes by digest only are replicated when Tags
+	// is empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+	Tags         []string `yaml:"tags,omitempty"`
+
+	Timeout   time.Duration `yaml:"timeout"`   // HTTP timeout
+	Threshold int           `yaml:"threshold"` // failed attempts before backing off
+	Backoff   time.Duration `yaml:"backoff"`   // backoff duration
+}
+
+// Quota configures storage quotas, which limit the bytes stored by a
+// repository and the repositories below it.
+type Quota struct {
+	// Enabled turns on the tracking of the bytes stored by repositories. The
+	// limits can be adjusted through the admin API even if none are
+	// configured.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Limits lists the quotas of repository prefixes
+	Limits []QuotaLimit `yaml:"limits,omitempty"`
+}
+
+// QuotaLimit is the quota of a repository prefix.
+type QuotaLimit struct {
+	Prefix string `yaml:"prefix"` // repository or namespace, empty for all repositories
+	Limit  int64  `yaml:"limit"`  // maximum bytes stored, zero or less is unlimited
+}
+
+// TagProtection configures the rules protecting tags from being moved or
+// deleted.
+type TagProtection struct {
+	Rules []TagRule `yaml:"rules,omitempty"`
+}
+
+// TagRule protects the tags matching its patterns in the repositories
+// matching its patterns.
+type TagRule struct {
+	// Repositories and Tags are lists of patterns as understood by
+	// path.Match. An empty list of repositories matches every repository.
+	Repositories []string `yaml:"repositories,omitempty"`
+	Tags         []string `yaml:"tags,omitempty"`
+
+	// TagRegexp is a regular expression matching more protected tags
+	TagRegexp string `yaml:"tagregexp,omitempty"`
+
+	Immutable bool `yaml:"immutable,omitempty"` // forbids moving the tags once pushed
+	NoDelete  bool `yaml:"nodelete,omitempty"`  // forbids deleting the tags and their manifests
+}
+
+// Retention configures the rules pruning the tags of repositories, which are
+// applied periodically.
+type Retention struct {
+	// Interval is the time between two runs of the rules, 24 hours by
+	// default
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// DryRun reports the tags falling out of the rules without pruning them
+	DryRun bool `yaml:"dryrun,omitempty"`
+
+	// Rules lists the rules of repository prefixes
+	Rules []RetentionRule `yaml:"rules,omitempty"`
+}
+
+// RetentionRule selects the tags kept in the repositories covered by a
+// prefix. The tags meeting none of its criteria are pruned.
+type RetentionRule struct {
+	Prefix   string   `yaml:"prefix"`             // repository or namespace, empty for all repositories
+	KeepLast int      `yaml:"keeplast,omitempty"` // keeps the most recently created tags
+	KeepDays int      `yaml:"keepdays,omitempty"` // keeps the tags pulled within the days
+	KeepTags []string `yaml:"keeptags,omitempty"` // patterns of the tags always kept
+}
+
+// Robots configures the robot accounts, which authenticate with basic auth
+// in front of the configured access controller and are managed through the
+// admin API.
+type Robots struct {
+	// Enabled turns on the robot accounts
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Realm is the realm of the basic authentication challenges answering
+	// invalid robot credentials, "registry" by default
+	Realm string `yaml:"realm,omitempty"`
+
+	// LastUsedInterval is the minimum time between two recordings of the
+	// use of an account by an instance, 1 minute by default
+	LastUsedInterval time.Duration `yaml:"lastusedinterval,omitempty"`
+}
+
+// TokenServer configures the built-in token server, which either runs on its
+// own with the token-server command or is mounted on the listener of the
+// registry.
+type TokenServer struct {
+	// Issuer is the issuer of the tokens
+	Issuer string `yaml:"issuer,omitempty"`
+
+	// Realm is the realm of the basic authentication challenges, the
+	// issuer by default
+	Realm string `yaml:"realm,omitempty"`
+
+	// Expiration is the lifetime of the tokens, 5 minutes by default
+	Expiration time.Duration `yaml:"expiration,omitempty"`
+
+	// RefreshExpiration is the lifetime of the refresh tokens, 24 hours by
+	// default
+	RefreshExpiration time.Duration `yaml:"refreshexpiration,omitempty"`
+
+	// Keys lists the key files, the first one signing the tokens and all
+	// of them being published
+	Keys []string `yaml:"keys,omitempty"`
+
+	// ACL is the ACL file selecting the granted scopes
+	ACL string `yaml:"acl,omitempty"`
+
+	// ReloadInterval is the minimum time between two checks of the key and
+	// ACL files for changes
+	ReloadInterval time.Duration `yaml:"reloadinterval,omitempty"`
+
+	// Backends lists the credential backends, tried in order
+	Backends []TokenServerBackend `yaml:"backends,omitempty"`
+
+	// Mount serves the token server on the listener of the registry, under
+	// Prefix
+	Mount bool `yaml:"mount,omitempty"`
+
+	// Prefix is the path prefix of the endpoints, /auth by default
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Addr is the address the token-server command listens on
+	Addr string `yaml:"addr,omitempty"`
+}
+
+// TokenServerBackend configures a credential backend of the token server.
+type TokenServerBackend struct {
+	// Type is the type of the backend, such as htpasswd, static or ldap
+	Type string `yaml:"type"`
+
+	// Options are the options of the backend
+	Options Parameters `yaml:"options,omitempty"`
+}
+
 // Reporting defines error reporting methods.
 type Reporting struct {
 	// Bugsnag configures error reporting for Bugsnag (bugsnag.com).
@@ -560,6 +784,96 @@ type Proxy struct {
 
 	// Password of the hub user
 	Password string `yaml:"password"`
+
+	// Upstreams lists the remote registries mirrored by the registry, in
+	// the order they are tried. A RemoteURL is an upstream mirroring every
+	// repository, tried after the listed upstreams. Repositories which are
+	// not mirrored from any upstream are hosted by the registry.
+	Upstreams []ProxyUpstream `yaml:"upstreams,omitempty"`
+
+	// TTL configures how long cached content is kept after it was pulled
+	// from an upstream
+	TTL struct {
+		// Manifests is the TTL of cached manifests
+		Manifests time.Duration `yaml:"manifests,omitempty"`
+
+		// Blobs is the TTL of cached blobs
+		Blobs time.Duration `yaml:"blobs,omitempty"`
+	} `yaml:"ttl,omitempty"`
+
+	// Eviction limits the size of the cached content. When a limit is
+	// exceeded, the least recently pulled content is removed.
+	Eviction struct {
+		// MaxBytes is the size of the content cached for every repository
+		MaxBytes int64 `yaml:"maxbytes,omitempty"`
+
+		// MaxRepositoryBytes is the size of the content cached for each
+		// repository
+		MaxRepositoryBytes int64 `yaml:"maxrepositorybytes,omitempty"`
+	} `yaml:"eviction,omitempty"`
+
+	// CircuitBreaker configures when an upstream is considered unavailable.
+	// The tags cached for the repositories it mirrors are then served
+	// without contacting it, and revalidated once it recovers.
+	CircuitBreaker struct {
+		// Threshold is the number of consecutive failed requests after
+		// which an upstream is unavailable
+		Threshold int `yaml:"threshold,omitempty"`
+
+		// Timeout is the time an unavailable upstream is not contacted
+		// for, before a request probes whether it recovered
+		Timeout time.Duration `yaml:"timeout,omitempty"`
+	} `yaml:"circuitbreaker,omitempty"`
+
+	// Pinned lists the cached content which is never expired nor evicted.
+	// An entry is a repository name, a "prefix/*" pattern matching the
+	// repositories under a prefix, or a "repository:tag" pinning the
+	// manifest of a tag and the blobs it references.
+	Pinned []string `yaml:"pinned,omitempty"`
+}
+
+// Enabled returns true if the registry is configured as a pull through
+// cache of at least one upstream.
+func (proxy Proxy) Enabled() bool {
+	return proxy.RemoteURL != "" || len(proxy.Upstreams) > 0
+}
+
+// ProxyUpstream configures a remote registry mirrored by a pull through
+// cache.
+type ProxyUpstream struct {
+	// RemoteURL is the URL of the remote registry
+	RemoteURL string `yaml:"remoteurl"`
+
+	// Match selects the repositories mirrored from the upstream. A pattern
+	// ending with "/*", such as "library/*", matches the repositories under
+	// that prefix, "*" or an empty pattern matches every repository, and
+	// any other pattern matches a single repository.
+	Match string `yaml:"match,omitempty"`
+
+	// Rewrite maps the matched repositories to their name on the upstream.
+	// The prefix of Match is replaced with the prefix of Rewrite, so
+	// "gcr/*" rewritten to "google-containers/*" mirrors gcr/pause from
+	// google-containers/pause, and rewritten to "*" strips the prefix.
+	Rewrite string `yaml:"rewrite,omitempty"`
+
+	// Username and Password authenticate with the upstream
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// TLS configures the connections to the upstream
+	TLS struct {
+		// CA is the path to the PEM encoded certificates trusted to
+		// verify the upstream, in addition to the system ones
+		CA string `yaml:"ca,omitempty"`
+
+		// Certificate and Key are the paths to the client certificate
+		// presented to the upstream and its key
+		Certificate string `yaml:"certificate,omitempty"`
+		Key         string `yaml:"key,omitempty"`
+
+		// Insecure disables the verification of the upstream certificate
+		Insecure bool `yaml:"insecure,omitempty"`
+	} `yaml:"tls,omitempty"`
 }
 
 // Parse parses an input configuration yaml document into a Configuration struct
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v2"
@@ -200,6 +201,44 @@ func (suite *ConfigSuite) TestParseInmemory(c *C) {
 	c.Assert(config, DeepEquals, suite.expectedConfig)
 }
 
+// TestParseLogHooks validates that the options of log hooks are decoded both
+// into the mail options and the generic options.
+func (suite *ConfigSuite) TestParseLogHooks(c *C) {
+	configYaml := inmemoryConfigYamlV0_1 + `
+log:
+  hooks:
+    - type: mail
+      options:
+        smtp:
+          addr: smtp.example.com:25
+        from: registry@example.com
+        to: [errors@example.com]
+    - type: webhook
+      levels: [error]
+      options:
+        url: https://hooks.example.com/registry
+      ratelimit: 10
+      dedupwindow: 5m
+      digest: 30s
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	c.Assert(err, IsNil)
+	c.Assert(config.Log.Hooks, HasLen, 2)
+
+	mail := config.Log.Hooks[0]
+	c.Assert(mail.MailOptions.SMTP.Addr, Equals, "smtp.example.com:25")
+	c.Assert(mail.MailOptions.To, DeepEquals, []string{"errors@example.com"})
+	c.Assert(mail.Options["from"], Equals, "registry@example.com")
+
+	webhook := config.Log.Hooks[1]
+	c.Assert(webhook.Type, Equals, "webhook")
+	c.Assert(webhook.Levels, DeepEquals, []string{"error"})
+	c.Assert(webhook.Options, DeepEquals, Parameters{"url": "https://hooks.example.com/registry"})
+	c.Assert(webhook.RateLimit, Equals, 10)
+	c.Assert(webhook.DedupWindow, Equals, 5*time.Minute)
+	c.Assert(webhook.Digest, Equals, 30*time.Second)
+}
+
 // TestParseIncomplete validates that an incomplete yaml configuration cannot
 // be parsed without providing environment variables to fill in the missing
 // components.
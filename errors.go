@@ -20,6 +20,11 @@ var ErrManifestNotModified = errors.New("manifest not modified")
 // performed
 var ErrUnsupported = errors.New("operation unsupported")
 
+// ErrSweepInProgress is returned when a blob cannot be linked because the
+// garbage collection is removing blobs. The write may be retried once the
+// sweep completes.
+var ErrSweepInProgress = errors.New("blobs are being garbage collected")
+
 // ErrTagUnknown is returned if the given tag is not known by the tag service
 type ErrTagUnknown struct {
 	Tag string
@@ -47,6 +52,53 @@ func (err ErrItemRepositoryUnknown) Error() string {
 	return fmt.Sprintf("unknown item repository name=%s", err.Name)
 }
 
+// ErrItemUnknown is returned if the item is not known by the repository or
+// by the tag.
+type ErrItemUnknown struct {
+	Name string
+	Tag  string
+	Item string
+}
+
+func (err ErrItemUnknown) Error() string {
+	if err.Tag != "" {
+		return fmt.Sprintf("unknown item name=%s tag=%s item=%s", err.Name, err.Tag, err.Item)
+	}
+	return fmt.Sprintf("unknown item name=%s item=%s", err.Name, err.Item)
+}
+
+// ErrItemTooLarge is returned when an item exceeds the maximum size
+// configured for items.
+type ErrItemTooLarge struct {
+	Size  int64
+	Limit int64
+}
+
+func (err ErrItemTooLarge) Error() string {
+	return fmt.Sprintf("item of %d bytes exceeds the limit of %d bytes", err.Size, err.Limit)
+}
+
+// ErrItemInvalid is returned when an item upload is malformed, for instance
+// because of an invalid annotation.
+type ErrItemInvalid struct {
+	Reason error
+}
+
+func (err ErrItemInvalid) Error() string {
+	return fmt.Sprintf("invalid item: %v", err.Reason)
+}
+
+// ErrItemPreconditionFailed is returned when the conditional headers of an
+// item request do not match the current item.
+type ErrItemPreconditionFailed struct {
+	Item      string
+	Condition string
+}
+
+func (err ErrItemPreconditionFailed) Error() string {
+	return fmt.Sprintf("precondition %s failed for item %s", err.Condition, err.Item)
+}
+
 // ErrRepositoryNameInvalid should be used to denote an invalid repository
 // name. Reason may set, indicating the cause of invalidity.
 type ErrRepositoryNameInvalid struct {
@@ -121,3 +173,73 @@ type ErrManifestNameInvalid struct {
 func (err ErrManifestNameInvalid) Error() string {
 	return fmt.Sprintf("manifest name %q invalid: %v", err.Name, err.Reason)
 }
+
+// ErrQuotaExceeded is returned when storing a blob in a repository would
+// exceed the quota applying to it.
+type ErrQuotaExceeded struct {
+	Name  string
+	Quota QuotaUsage
+	Size  int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("storing %d bytes in %s exceeds the quota of %q: %d of %d bytes used",
+		err.Size, err.Name, err.Quota.Prefix, err.Quota.Usage, err.Quota.Limit)
+}
+
+// ErrTagImmutable is returned when moving a tag which an immutability rule
+// protects to another manifest.
+type ErrTagImmutable struct {
+	Name string
+	Tag  string
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("tag %s of %s is immutable", err.Tag, err.Name)
+}
+
+// ErrTagProtected is returned when deleting a tag, or the manifest it points
+// to, which a rule protects against deletion.
+type ErrTagProtected struct {
+	Name string
+	Tag  string
+}
+
+func (err ErrTagProtected) Error() string {
+	return fmt.Sprintf("tag %s of %s is protected against deletion", err.Tag, err.Name)
+}
+
+// ErrRobotAuthenticationFailure is returned when a robot account is unknown,
+// expired or given another secret than its own.
+var ErrRobotAuthenticationFailure = errors.New("robot account authentication failure")
+
+// ErrRobotAccountUnknown is returned if the named robot account does not
+// exist.
+type ErrRobotAccountUnknown struct {
+	Name string
+}
+
+func (err ErrRobotAccountUnknown) Error() string {
+	return fmt.Sprintf("unknown robot account %s", err.Name)
+}
+
+// ErrRobotAccountExists is returned when creating a robot account which
+// already exists.
+type ErrRobotAccountExists struct {
+	Name string
+}
+
+func (err ErrRobotAccountExists) Error() string {
+	return fmt.Sprintf("robot account %s already exists", err.Name)
+}
+
+// ErrRobotAccountInvalid is returned when creating or updating a robot
+// account with an invalid name or scope.
+type ErrRobotAccountInvalid struct {
+	Name   string
+	Reason error
+}
+
+func (err ErrRobotAccountInvalid) Error() string {
+	return fmt.Sprintf("robot account %q invalid: %v", err.Name, err.Reason)
+}
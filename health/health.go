@@ -32,6 +32,12 @@ func NewRegistry() *Registry {
 // the registry used by the HTTP handler.
 var DefaultRegistry *Registry
 
+// ReadinessRegistry is the registry of the checks telling whether the
+// application is ready to serve at full speed, for instance once its caches
+// are warm. Unlike the checks of DefaultRegistry, failing readiness checks do
+// not disable the application through Handler.
+var ReadinessRegistry *Registry
+
 // Checker is the interface for a Health Checker
 type Checker interface {
 	// Check returns nil if the service is okay.
@@ -240,8 +246,18 @@ func RegisterPeriodicThresholdFunc(name string, period time.Duration, threshold
 // and their corresponding status.
 // Returns 503 if any Error status exists, 200 otherwise
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	statusHandler(DefaultRegistry, w, r)
+}
+
+// ReadinessHandler returns a JSON blob with the status of the readiness
+// checks. Returns 503 if any Error status exists, 200 otherwise
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	statusHandler(ReadinessRegistry, w, r)
+}
+
+func statusHandler(registry *Registry, w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		checks := CheckStatus()
+		checks := registry.CheckStatus()
 		status := http.StatusOK
 
 		// If there is an error, return 503
@@ -299,8 +315,11 @@ func statusResponse(w http.ResponseWriter, r *http.Request, status int, checks m
 	}
 }
 
-// Registers global /debug/health api endpoint, creates default registry
+// Registers global /debug/health and /debug/ready api endpoints, creates
+// default and readiness registries
 func init() {
 	DefaultRegistry = NewRegistry()
+	ReadinessRegistry = NewRegistry()
 	http.HandleFunc("/debug/health", StatusHandler)
+	http.HandleFunc("/debug/ready", ReadinessHandler)
 }
@@ -105,3 +105,41 @@ func TestHealthHandler(t *testing.T) {
 	updater.Update(nil)
 	checkUp(t, "when server is back up") // now we should be back up.
 }
+
+// TestReadinessHandler ensures that failing readiness checks are reported by
+// the readiness endpoint without disabling the web application.
+func TestReadinessHandler(t *testing.T) {
+	// clear out existing checks.
+	DefaultRegistry = NewRegistry()
+	ReadinessRegistry = NewRegistry()
+
+	updater := NewStatusUpdater()
+	ReadinessRegistry.Register("test_readiness", updater)
+	updater.Update(errors.New("not ready"))
+
+	req, err := http.NewRequest("GET", "https://fakeurl.com/debug/ready", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request.")
+	}
+
+	recorder := httptest.NewRecorder()
+	ReadinessHandler(recorder, req)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Did not get a 503 while not ready: %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("Application disabled by a readiness check: %d", recorder.Code)
+	}
+
+	updater.Update(nil)
+	recorder = httptest.NewRecorder()
+	ReadinessHandler(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Did not get a 200 once ready: %d", recorder.Code)
+	}
+}
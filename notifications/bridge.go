@@ -25,6 +25,8 @@ var _ Listener = &bridge{}
 type URLBuilder interface {
 	BuildManifestURL(name reference.Named) (string, error)
 	BuildBlobURL(ref reference.Canonical) (string, error)
+	BuildImageItemURL(name reference.Named, item string) (string, error)
+	BuildTagItemURL(name reference.Named, tag, item string) (string, error)
 }
 
 // NewBridge returns a notification listener that writes records to sink,
@@ -108,6 +110,61 @@ func (b *bridge) BlobDeleted(repo reference.Named, dgst digest.Digest) error {
 	return b.createBlobDeleteEventAndWrite(EventActionDelete, repo, dgst)
 }
 
+func (b *bridge) ItemPushed(repo reference.Named, tag, item string, desc distribution.Descriptor) error {
+	event := b.createItemEvent(EventActionItemPush, repo, tag, item)
+	event.Target.Descriptor = desc
+	event.Target.Length = desc.Size
+
+	var err error
+	if tag == "" {
+		event.Target.URL, err = b.ub.BuildImageItemURL(repo, item)
+	} else {
+		event.Target.URL, err = b.ub.BuildTagItemURL(repo, tag, item)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.sink.Write(*event)
+}
+
+func (b *bridge) ItemDeleted(repo reference.Named, tag, item string) error {
+	return b.sink.Write(*b.createItemEvent(EventActionItemDelete, repo, tag, item))
+}
+
+func (b *bridge) TagInfoUpdated(repo reference.Named, info distribution.TagInfo) error {
+	event := b.createEvent(EventActionTagInfoUpdate)
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = info.Tag
+	event.Target.Size = info.Size
+
+	return b.sink.Write(*event)
+}
+
+func (b *bridge) TagInfoDeleted(repo reference.Named, tag string) error {
+	event := b.createEvent(EventActionTagInfoDelete)
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+
+	return b.sink.Write(*event)
+}
+
+func (b *bridge) RepositoryDeleted(repo reference.Named) error {
+	event := b.createEvent(EventActionRepositoryDelete)
+	event.Target.Repository = repo.Name()
+
+	return b.sink.Write(*event)
+}
+
+func (b *bridge) createItemEvent(action string, repo reference.Named, tag, item string) *Event {
+	event := b.createEvent(action)
+	event.Target.Repository = repo.Name()
+	event.Target.Tag = tag
+	event.Target.Item = item
+
+	return event
+}
+
 func (b *bridge) createManifestEventAndWrite(action string, repo reference.Named, sm distribution.Manifest) error {
 	manifestEvent, err := b.createManifestEvent(action, repo, sm)
 	if err != nil {
@@ -106,6 +106,65 @@ func TestEventBridgeManifestDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeItemPushed(t *testing.T) {
+	desc := distribution.Descriptor{
+		MediaType: "text/markdown",
+		Size:      10,
+		Digest:    digest.FromBytes([]byte("# readme\n")),
+	}
+	l := createTestEnv(t, testSinkFn(func(events ...Event) error {
+		if len(events) != 1 {
+			t.Fatalf("unexpected number of events: %v != 1", len(events))
+		}
+		event := events[0]
+		if event.Action != EventActionItemPush {
+			t.Fatalf("unexpected event action: %q != %q", event.Action, EventActionItemPush)
+		}
+		if event.Target.Repository != repo || event.Target.Tag != "latest" || event.Target.Item != "README.md" {
+			t.Fatalf("unexpected event target: %#v", event.Target)
+		}
+		if event.Target.Digest != desc.Digest || event.Target.Length != desc.Size {
+			t.Fatalf("unexpected item descriptor: %#v", event.Target.Descriptor)
+		}
+
+		repoRef, _ := reference.ParseNamed(repo)
+		u, err := ub.BuildTagItemURL(repoRef, "latest", "README.md")
+		if err != nil {
+			t.Fatalf("error building expected url: %v", err)
+		}
+		if event.Target.URL != u {
+			t.Fatalf("incorrect url passed: \n%q != \n%q", event.Target.URL, u)
+		}
+		return nil
+	}))
+
+	repoRef, _ := reference.ParseNamed(repo)
+	if err := l.ItemPushed(repoRef, "latest", "README.md", desc); err != nil {
+		t.Fatalf("unexpected error notifying item push: %v", err)
+	}
+}
+
+func TestEventBridgeRepositoryDeleted(t *testing.T) {
+	l := createTestEnv(t, testSinkFn(func(events ...Event) error {
+		if len(events) != 1 {
+			t.Fatalf("unexpected number of events: %v != 1", len(events))
+		}
+		event := events[0]
+		if event.Action != EventActionRepositoryDelete || event.Target.Repository != repo {
+			t.Fatalf("unexpected event: %#v", event)
+		}
+		if event.Actor != actor || event.Source != source {
+			t.Fatalf("unexpected event actor or source: %#v", event)
+		}
+		return nil
+	}))
+
+	repoRef, _ := reference.ParseNamed(repo)
+	if err := l.RepositoryDeleted(repoRef); err != nil {
+		t.Fatalf("unexpected error notifying repository delete: %v", err)
+	}
+}
+
 func createTestEnv(t *testing.T, fn testSinkFn) Listener {
 	pk, err := libtrust.GenerateECP256PrivateKey()
 	if err != nil {
@@ -13,6 +13,20 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionItemPush and EventActionItemDelete are sent when an item
+	// of a repository or of a tag is saved or deleted.
+	EventActionItemPush   = "item.push"
+	EventActionItemDelete = "item.delete"
+
+	// EventActionTagInfoUpdate and EventActionTagInfoDelete are sent when
+	// the metadata record of a tag is written or removed.
+	EventActionTagInfoUpdate = "taginfo.update"
+	EventActionTagInfoDelete = "taginfo.delete"
+
+	// EventActionRepositoryDelete is sent when a whole repository is
+	// deleted.
+	EventActionRepositoryDelete = "repository.delete"
 )
 
 const (
@@ -71,6 +85,10 @@ type Event struct {
 
 		// Tag provides the tag
 		Tag string `json:"tag,omitempty"`
+
+		// Item identifies the item of item events. Items of a tag also
+		// carry the tag.
+		Item string `json:"item,omitempty"`
 	} `json:"target,omitempty"`
 
 	// Request covers the request that generated the event.
@@ -24,10 +24,22 @@ type BlobListener interface {
 	BlobDeleted(repo reference.Named, desc digest.Digest) error
 }
 
+// CacheListener describes a listener that can respond to events related to
+// items, tag metadata and whole repositories. The tag of item events is empty
+// for the items of the repository.
+type CacheListener interface {
+	ItemPushed(repo reference.Named, tag, item string, desc distribution.Descriptor) error
+	ItemDeleted(repo reference.Named, tag, item string) error
+	TagInfoUpdated(repo reference.Named, info distribution.TagInfo) error
+	TagInfoDeleted(repo reference.Named, tag string) error
+	RepositoryDeleted(repo reference.Named) error
+}
+
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
 	BlobListener
+	CacheListener
 }
 
 type repositoryListener struct {
@@ -61,6 +73,13 @@ func (rl *repositoryListener) Blobs(ctx context.Context) distribution.BlobStore
 	}
 }
 
+func (rl *repositoryListener) Caches(ctx context.Context) distribution.CacheService {
+	return &cacheServiceListener{
+		CacheService: rl.Repository.Caches(ctx),
+		parent:       rl,
+	}
+}
+
 type manifestServiceListener struct {
 	distribution.ManifestService
 	parent *repositoryListener
@@ -213,3 +232,112 @@ func (bwl *blobWriterListener) Commit(ctx context.Context, desc distribution.Des
 
 	return committed, err
 }
+
+type cacheServiceListener struct {
+	distribution.CacheService
+	parent *repositoryListener
+}
+
+func (csl *cacheServiceListener) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) (distribution.Descriptor, error) {
+	desc, err := csl.CacheService.SaveImageItem(ctx, w, r, item)
+	if err == nil {
+		if err := csl.parent.listener.ItemPushed(csl.parent.Repository.Named(), "", item, desc); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching item push to listener: %v", err)
+		}
+	}
+
+	return desc, err
+}
+
+func (csl *cacheServiceListener) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) (distribution.Descriptor, error) {
+	desc, err := csl.CacheService.SaveTagItem(ctx, w, r, tag, item)
+	if err == nil {
+		if err := csl.parent.listener.ItemPushed(csl.parent.Repository.Named(), tag, item, desc); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching item push to listener: %v", err)
+		}
+	}
+
+	return desc, err
+}
+
+func (csl *cacheServiceListener) DeleteImageItem(ctx context.Context, item string) error {
+	err := csl.CacheService.DeleteImageItem(ctx, item)
+	if err == nil {
+		csl.itemDeleted(ctx, "", item)
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) DeleteTagItem(ctx context.Context, tag, item string) error {
+	err := csl.CacheService.DeleteTagItem(ctx, tag, item)
+	if err == nil {
+		csl.itemDeleted(ctx, tag, item)
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) DeleteAllImageItems(ctx context.Context) error {
+	// list the items first, so an event can be sent for each of them
+	items, _ := csl.CacheService.GetImageItemList(ctx)
+	err := csl.CacheService.DeleteAllImageItems(ctx)
+	if err == nil {
+		for _, item := range items {
+			csl.itemDeleted(ctx, "", item.Name)
+		}
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) DeleteAllTagItems(ctx context.Context, tag string) error {
+	items, _ := csl.CacheService.GetTagItemList(ctx, tag)
+	err := csl.CacheService.DeleteAllTagItems(ctx, tag)
+	if err == nil {
+		for _, item := range items {
+			csl.itemDeleted(ctx, tag, item.Name)
+		}
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) itemDeleted(ctx context.Context, tag, item string) {
+	if err := csl.parent.listener.ItemDeleted(csl.parent.Repository.Named(), tag, item); err != nil {
+		context.GetLogger(ctx).Errorf("error dispatching item delete to listener: %v", err)
+	}
+}
+
+func (csl *cacheServiceListener) SaveTagInfo(ctx context.Context, info distribution.TagInfo) error {
+	err := csl.CacheService.SaveTagInfo(ctx, info)
+	if err == nil {
+		if err := csl.parent.listener.TagInfoUpdated(csl.parent.Repository.Named(), info); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching tag info update to listener: %v", err)
+		}
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) DeleteTagInfo(ctx context.Context, tag string) error {
+	err := csl.CacheService.DeleteTagInfo(ctx, tag)
+	if err == nil {
+		if err := csl.parent.listener.TagInfoDeleted(csl.parent.Repository.Named(), tag); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching tag info delete to listener: %v", err)
+		}
+	}
+
+	return err
+}
+
+func (csl *cacheServiceListener) DeleteImageRepository(ctx context.Context) error {
+	err := csl.CacheService.DeleteImageRepository(ctx)
+	if err == nil {
+		if err := csl.parent.listener.RepositoryDeleted(csl.parent.Repository.Named()); err != nil {
+			context.GetLogger(ctx).Errorf("error dispatching repository delete to listener: %v", err)
+		}
+	}
+
+	return err
+}
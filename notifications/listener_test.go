@@ -2,7 +2,9 @@ package notifications
 
 import (
 	"io"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/distribution"
@@ -42,6 +44,7 @@ func TestListener(t *testing.T) {
 
 	// Now take the registry through a number of operations
 	checkExerciseRepository(t, repository)
+	checkExerciseCaches(t, repository)
 
 	expectedOps := map[string]int{
 		"manifest:push":   1,
@@ -50,6 +53,8 @@ func TestListener(t *testing.T) {
 		"layer:push":      2,
 		"layer:pull":      2,
 		"layer:delete":    2,
+		"item:push":       2,
+		"item:delete":     2,
 	}
 
 	if !reflect.DeepEqual(tl.ops, expectedOps) {
@@ -98,6 +103,55 @@ func (tl *testListener) BlobDeleted(repo reference.Named, d digest.Digest) error
 	return nil
 }
 
+func (tl *testListener) ItemPushed(repo reference.Named, tag, item string, desc distribution.Descriptor) error {
+	tl.ops["item:push"]++
+	return nil
+}
+
+func (tl *testListener) ItemDeleted(repo reference.Named, tag, item string) error {
+	tl.ops["item:delete"]++
+	return nil
+}
+
+func (tl *testListener) TagInfoUpdated(repo reference.Named, info distribution.TagInfo) error {
+	tl.ops["taginfo:update"]++
+	return nil
+}
+
+func (tl *testListener) TagInfoDeleted(repo reference.Named, tag string) error {
+	tl.ops["taginfo:delete"]++
+	return nil
+}
+
+func (tl *testListener) RepositoryDeleted(repo reference.Named) error {
+	tl.ops["repository:delete"]++
+	return nil
+}
+
+// checkExerciseCaches saves and deletes items of the repository and of one
+// of its tags.
+func checkExerciseCaches(t *testing.T, repository distribution.Repository) {
+	ctx := context.Background()
+	caches := repository.Caches(ctx)
+
+	if _, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("readme")), "README"); err != nil {
+		t.Fatalf("unexpected error saving image item: %v", err)
+	}
+	if err := caches.DeleteImageItem(ctx, "README"); err != nil {
+		t.Fatalf("unexpected error deleting image item: %v", err)
+	}
+
+	if err := caches.InitItem(ctx, "thetag"); err != nil {
+		t.Fatalf("unexpected error initializing tag items: %v", err)
+	}
+	if _, err := caches.SaveTagItem(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("report")), "thetag", "report"); err != nil {
+		t.Fatalf("unexpected error saving tag item: %v", err)
+	}
+	if err := caches.DeleteAllTagItems(ctx, "thetag"); err != nil {
+		t.Fatalf("unexpected error deleting tag items: %v", err)
+	}
+}
+
 // checkExerciseRegistry takes the registry through all of its operations,
 // carrying out generic checks.
 func checkExerciseRepository(t *testing.T, repository distribution.Repository) {
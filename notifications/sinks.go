@@ -100,6 +100,15 @@ func (b *Broadcaster) run() {
 	}
 }
 
+// NewRetryingQueue returns a sink accepting events into an unbounded queue,
+// from which they are written to sink in the background. Failed writes are
+// retried until they succeed, backing off with the circuit breaker heuristics
+// of threshold and backoff, so that a slow or failing sink never blocks the
+// broadcaster.
+func NewRetryingQueue(sink Sink, threshold int, backoff time.Duration) Sink {
+	return newEventQueue(newRetryingSink(sink, threshold, backoff))
+}
+
 // eventQueue accepts all messages into a queue for asynchronous consumption
 // by a sink. It is unbounded and thread safe but the sink must be reliable or
 // events will be dropped.
@@ -0,0 +1,70 @@
+package distribution
+
+import (
+	"strings"
+
+	"github.com/docker/distribution/context"
+)
+
+// Quota limits the bytes stored by a repository and the repositories below
+// it.
+type Quota struct {
+	// Prefix is the name of the repository or namespace the quota applies
+	// to. "team" covers "team" as well as "team/app", but not "teamwork". An
+	// empty prefix covers all repositories.
+	Prefix string `json:"prefix"`
+
+	// Limit is the maximum number of bytes. Zero or less means unlimited.
+	Limit int64 `json:"limit"`
+}
+
+// Covers returns true if the named repository counts against the quota.
+func (q Quota) Covers(name string) bool {
+	return q.Prefix == "" || name == q.Prefix || strings.HasPrefix(name, q.Prefix+"/")
+}
+
+// QuotaUsage reports the bytes stored against a quota.
+type QuotaUsage struct {
+	Quota
+
+	// Usage is the number of bytes stored by the repositories covered by the
+	// quota.
+	Usage int64 `json:"usage"`
+}
+
+// QuotaService tracks the bytes stored by repositories and enforces the
+// quotas applying to them. A blob linked into a repository is counted once
+// against it, however many times it is pushed, and once against every
+// repository it is linked into.
+type QuotaService interface {
+	// Quota returns the quota applying to the named repository, which is
+	// the one with the longest prefix covering it, with its usage. If no
+	// quota applies, ok is false.
+	Quota(ctx context.Context, name string) (usage QuotaUsage, ok bool, err error)
+
+	// Quotas returns all quotas with their usage, sorted by prefix.
+	Quotas(ctx context.Context) ([]QuotaUsage, error)
+
+	// SetQuota sets the limit of the quota of a prefix, overriding the
+	// configured one.
+	SetQuota(ctx context.Context, quota Quota) error
+
+	// DeleteQuota removes the quota set for a prefix. A configured quota for
+	// the prefix applies again.
+	DeleteQuota(ctx context.Context, prefix string) error
+
+	// Usage returns the bytes stored by the named repository.
+	Usage(ctx context.Context, name string) (int64, error)
+
+	// Add adds size bytes to the usage of the named repository. A positive
+	// size exceeding the quota applying to the repository is not added and
+	// ErrQuotaExceeded is returned.
+	Add(ctx context.Context, name string, size int64) error
+
+	// Recount recomputes the usage of the named repository from the blobs
+	// linked into it, and returns it.
+	Recount(ctx context.Context, name string) (int64, error)
+
+	// DeleteRepository removes the usage of the named repository.
+	DeleteRepository(ctx context.Context, name string) error
+}
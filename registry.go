@@ -48,6 +48,23 @@ type Namespace interface {
 	BlobStatter() BlobStatter
 
 	BlobCache() BlobCache
+
+	// MetadataIndex returns the index holding tag and repository metadata.
+	MetadataIndex() MetadataIndex
+
+	// DownloadCounter returns the counters tracking tag and repository pulls.
+	DownloadCounter() DownloadCounter
+
+	// Quotas returns the service enforcing storage quotas, or nil if quotas
+	// are not enabled.
+	Quotas() QuotaService
+}
+
+// RepositoryRemover removes a repository along with its tags, manifests,
+// layer links, items and cached metadata. Blobs are left to the garbage
+// collector, since other repositories may reference them.
+type RepositoryRemover interface {
+	Remove(ctx context.Context, name reference.Named) error
 }
 
 // RepositoryEnumerator describes an operation to enumerate repositories
@@ -96,6 +96,64 @@ var (
 		Format:      "<digest>",
 	}
 
+	itemAnnotationHeader = ParameterDescriptor{
+		Name:        "Docker-Item-Annotation",
+		Type:        "string",
+		Description: "An annotation of the item, as a key=value pair. The header is repeated for each annotation.",
+		Format:      "<key>=<value>",
+	}
+
+	itemConditionalHeaders = []ParameterDescriptor{
+		{
+			Name:        "If-None-Match",
+			Type:        "etag",
+			Description: "On fetch, the item is only returned if its ETag differs, otherwise a 304 is returned. On upload, the item is only written if its current ETag differs, `*` requiring that the item does not exist yet.",
+			Format:      `"<digest>"`,
+		},
+		{
+			Name:        "If-Match",
+			Type:        "etag",
+			Description: "On upload, the item is only written if its current ETag matches.",
+			Format:      `"<digest>"`,
+		},
+		{
+			Name:        "If-Modified-Since",
+			Type:        "date",
+			Description: "On fetch, the item is only returned if it has been modified since the date, otherwise a 304 is returned.",
+			Format:      "<http date>",
+		},
+	}
+
+	itemResponseHeaders = []ParameterDescriptor{
+		digestHeader,
+		{
+			Name:        "ETag",
+			Type:        "etag",
+			Description: "The quoted digest of the item content.",
+			Format:      `"<digest>"`,
+		},
+		{
+			Name:        "Last-Modified",
+			Type:        "date",
+			Description: "The time the item was last uploaded.",
+			Format:      "<http date>",
+		},
+		itemAnnotationHeader,
+	}
+
+	itemPreconditionFailedResponseDescriptor = ResponseDescriptor{
+		Name:        "Precondition Failed",
+		Description: "The `If-Match` or `If-None-Match` condition of the upload does not hold for the current item.",
+		StatusCode:  http.StatusPreconditionFailed,
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodeItemPreconditionFailed,
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+	}
+
 	linkHeader = ParameterDescriptor{
 		Name:        "Link",
 		Type:        "link",
@@ -103,6 +161,37 @@ var (
 		Format:      `<<url>?n=<last n value>&last=<last entry from response>>; rel="next"`,
 	}
 
+	catalogInfoParameters = append(paginationParameters, []ParameterDescriptor{
+		{
+			Name:        "prefix",
+			Type:        "string",
+			Description: "Only include repositories with names starting with prefix.",
+			Format:      "<string>",
+			Required:    false,
+		},
+		{
+			Name:        "contains",
+			Type:        "string",
+			Description: "Only include repositories with names containing the value.",
+			Format:      "<string>",
+			Required:    false,
+		},
+		{
+			Name:        "sort",
+			Type:        "string",
+			Description: "Sort key of the result set, one of name, lastModified, downloadCount or createTime. Defaults to name. The download counts used to sort are refreshed every minute.",
+			Format:      "<string>",
+			Required:    false,
+		},
+		{
+			Name:        "order",
+			Type:        "string",
+			Description: "Order of the result set, asc or desc. Defaults to asc when sorting by name and desc otherwise.",
+			Format:      "<string>",
+			Required:    false,
+		},
+	}...)
+
 	paginationParameters = []ParameterDescriptor{
 		{
 			Name:        "n",
@@ -183,9 +272,118 @@ var (
 			errcode.ErrorCodeDenied,
 		},
 	}
+
+	quotaExceededResponseDescriptor = ResponseDescriptor{
+		Name:        "Quota Exceeded",
+		StatusCode:  http.StatusForbidden,
+		Description: "The repository, or the namespace it belongs to, has no room left in its storage quota.",
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodeQuotaExceeded,
+		},
+	}
+
+	robotNameParameter = ParameterDescriptor{
+		Name:        "name",
+		Type:        "string",
+		Description: "Name of the robot account.",
+		Format:      "<name>",
+	}
+
+	adminDeniedResponseDescriptor = ResponseDescriptor{
+		Name:        "Access Denied",
+		StatusCode:  http.StatusForbidden,
+		Description: "The client was not granted the admin access, or no access controller is configured.",
+		Body: BodyDescriptor{
+			ContentType: "application/json; charset=utf-8",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeDenied,
+		},
+	}
+
+	robotFailures = []ResponseDescriptor{
+		{
+			Description: "The account is invalid.",
+			StatusCode:  http.StatusBadRequest,
+		},
+		{
+			Description: "The account does not exist, or robot accounts are not enabled.",
+			StatusCode:  http.StatusNotFound,
+		},
+		{
+			Description: "The account already exists.",
+			StatusCode:  http.StatusConflict,
+		},
+		unauthorizedResponseDescriptor,
+		adminDeniedResponseDescriptor,
+	}
+
+	quotaPrefixParameter = ParameterDescriptor{
+		Name:        "prefix",
+		Type:        "string",
+		Description: "Repository prefix of the quota, which is empty to cover every repository.",
+		Format:      "<prefix>",
+	}
+
+	quotasSuccesses = []ResponseDescriptor{
+		{
+			StatusCode: http.StatusOK,
+			Body: BodyDescriptor{
+				ContentType: "application/json; charset=utf-8",
+				Format: `[
+	{
+		"prefix": <prefix>,
+		"limit": <limit>,
+		"usage": <usage>
+	},
+	...
+]`,
+			},
+		},
+	}
+
+	quotasFailures = []ResponseDescriptor{
+		{
+			Description: "The limit is invalid.",
+			StatusCode:  http.StatusBadRequest,
+		},
+		{
+			Description: "Quotas are not enabled.",
+			StatusCode:  http.StatusNotFound,
+		},
+		unauthorizedResponseDescriptor,
+		adminDeniedResponseDescriptor,
+	}
 )
 
 const (
+	robotAccountBody = `{
+	"name": <name>,
+	"scopes": [
+		{
+			"repository": <repository pattern>,
+			"actions": [<action>, ...]
+		},
+		...
+	],
+	"expiresAt": <expiry>,
+	"username": <basic auth user name>,
+	"secret": <secret>
+}`
+
 	manifestBody = `{
    "name": <name>,
    "tag": <tag>,
@@ -205,21 +403,104 @@ const (
       <taginfoBody>,
       ...
    ],
+   "size": <size>,
+   "downloadCount": <downloadCount>,
+   "recentDownloads": [
+      {
+         "date": <date>,
+         "count": <count>
+      },
+      ...
+   ],
    "lastModified": <lastModified>,
-   "size": <size>
+   "createTime": <createTime>,
+   "usage": <usage>,
+   "quota": {
+      "prefix": <prefix>,
+      "limit": <limit>,
+      "usage": <usage>
+   },
+   "tagRules": [
+      {
+         "repositories": [<pattern>, ...],
+         "tags": [<pattern>, ...],
+         "tagRegexp": <regexp>,
+         "immutable": <immutable>,
+         "noDelete": <noDelete>
+      },
+      ...
+   ]
 }`
 	taginfoBody = `{
    "name": <name>,
    "tag": <tag>,
    "createTime": <createTime>,
-   "size": <size>
-}`
-	itemNameListBody = `{
-   "nameList": [
-      <name>,
+   "downloadCount": <downloadCount>,
+   "recentDownloads": [
+      {
+         "date": <date>,
+         "count": <count>
+      },
       ...
-   ]
-	}`
+   ],
+   "size": <size>,
+   "labels": {
+      <key>: <value>,
+      ...
+   },
+   "digest": <digest>,
+   "mediaType": <media type>,
+   "image": {
+      "architecture": <architecture>,
+      "os": <os>,
+      "labels": {
+         <key>: <value>,
+         ...
+      },
+      "entrypoint": [<argument>, ...],
+      "cmd": [<argument>, ...],
+      "exposedPorts": [<port>, ...],
+      "envKeys": [<name>, ...],
+      "layerCount": <layerCount>,
+      "layers": [
+         {
+            "digest": <digest>,
+            "size": <size>
+         },
+         ...
+      ]
+   },
+   "platforms": [
+      {
+         "digest": <digest>,
+         "mediaType": <media type>,
+         "variant": <variant>,
+         "createTime": <createTime>,
+         "size": <size>,
+         "architecture": <architecture>,
+         "os": <os>,
+         ...
+      },
+      ...
+   ],
+   "immutable": <immutable>,
+   "protected": <protected>
+}`
+	itemNameListBody = `[
+   {
+      "name": <name>,
+      "size": <size>,
+      "digest": <digest>,
+      "mediaType": <media type>,
+      "createdAt": <time>,
+      "modifiedAt": <time>,
+      "annotations": {
+         <key>: <value>,
+         ...
+      }
+   },
+   ...
+]`
 	itemBody = `{
    "name": <name>,
    "content": <content>
@@ -528,7 +809,7 @@ var routeDescriptors = []RouteDescriptor{
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Fetch the tag info identified by `name` and `tag` . A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data.",
+				Description: "Fetch the tag info identified by `name` and `tag` . A `HEAD` request can also be issued to this endpoint to obtain resource information without receiving all data. The `image` of the tag is read from its manifest and image configuration. For a manifest list, `platforms` describes the image of each platform instead.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -627,7 +908,7 @@ var routeDescriptors = []RouteDescriptor{
 			},
 			{
 				Method:      "DELETE",
-				Description: "Delete all the image repository `name` .",
+				Description: "Delete the image repository `name`: its tags, manifest revisions, layer links, items and cached metadata. The blobs of the repository are removed by the next garbage collection. A removal that fails part way is finished by repeating the request, or when the registry restarts.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -639,7 +920,8 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						Successes: []ResponseDescriptor{
 							{
-								StatusCode: http.StatusAccepted,
+								Description: "The repository has been removed.",
+								StatusCode:  http.StatusAccepted,
 							},
 						},
 						Failures: []ResponseDescriptor{
@@ -733,29 +1015,32 @@ var routeDescriptors = []RouteDescriptor{
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Fetch the image item name list identified by `name` ",
+				Description: "Fetch the content of the item. `HEAD` returns the metadata of the item in the headers only.",
 				Requests: []RequestDescriptor{
 					{
-						Headers: []ParameterDescriptor{
+						Headers: append([]ParameterDescriptor{
 							hostHeader,
 							authHeader,
-						},
+						}, itemConditionalHeaders...),
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 							itemParameterDescriptor,
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The manifest identified by `name` and `tag`. The contents can be used to identify and resolve resources required to run the specified image.",
+								Description: "The content of the item, served with the media type it was uploaded with. A `HEAD` request returns the headers only.",
 								StatusCode:  http.StatusOK,
-								Headers: []ParameterDescriptor{
-									digestHeader,
-								},
+								Headers:     itemResponseHeaders,
 								Body: BodyDescriptor{
 									ContentType: "ContentType: application/octect-stream",
 									Format:      "<binary data>",
 								},
 							},
+							{
+								Description: "The item matches the `If-None-Match` or `If-Modified-Since` header of the request.",
+								StatusCode:  http.StatusNotModified,
+								Headers:     itemResponseHeaders,
+							},
 						},
 						Failures: []ResponseDescriptor{
 							{
@@ -778,13 +1063,14 @@ var routeDescriptors = []RouteDescriptor{
 			},
 			{
 				Method:      "POST",
-				Description: "To post the user update item file.",
+				Description: "Upload the item. `PUT` is accepted as well. The `Content-Type` of the request is kept as the media type of the item, and annotations may be given with `Docker-Item-Annotation` headers. Concurrent updates can be guarded with the `If-Match` and `If-None-Match` headers.",
 				Requests: []RequestDescriptor{
 					{
-						Headers: []ParameterDescriptor{
+						Headers: append([]ParameterDescriptor{
 							hostHeader,
 							authHeader,
-						},
+							itemAnnotationHeader,
+						}, itemConditionalHeaders...),
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 							itemParameterDescriptor,
@@ -803,6 +1089,7 @@ var routeDescriptors = []RouteDescriptor{
 										Type:   "url",
 										Format: "<item location>",
 									},
+									digestHeader,
 								},
 							},
 						},
@@ -812,8 +1099,10 @@ var routeDescriptors = []RouteDescriptor{
 								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeNameInvalid,
+									ErrorCodeItemInvalid,
 								},
 							},
+							itemPreconditionFailedResponseDescriptor,
 							{
 								Name:        "Not allowed",
 								Description: "Blob upload is not allowed because the registry is configured as a pull-through cache or for some other reason",
@@ -952,13 +1241,13 @@ var routeDescriptors = []RouteDescriptor{
 		Methods: []MethodDescriptor{
 			{
 				Method:      "GET",
-				Description: "Fetch the image item name list identified by `name` ",
+				Description: "Fetch the content of the item. `HEAD` returns the metadata of the item in the headers only.",
 				Requests: []RequestDescriptor{
 					{
-						Headers: []ParameterDescriptor{
+						Headers: append([]ParameterDescriptor{
 							hostHeader,
 							authHeader,
-						},
+						}, itemConditionalHeaders...),
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 							referenceParameterDescriptor,
@@ -966,16 +1255,19 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						Successes: []ResponseDescriptor{
 							{
-								Description: "The manifest identified by `name` and `tag`. The contents can be used to identify and resolve resources required to run the specified image.",
+								Description: "The content of the item, served with the media type it was uploaded with. A `HEAD` request returns the headers only.",
 								StatusCode:  http.StatusOK,
-								Headers: []ParameterDescriptor{
-									digestHeader,
-								},
+								Headers:     itemResponseHeaders,
 								Body: BodyDescriptor{
 									ContentType: "ContentType: application/octect-stream",
 									Format:      "<binary data>",
 								},
 							},
+							{
+								Description: "The item matches the `If-None-Match` or `If-Modified-Since` header of the request.",
+								StatusCode:  http.StatusNotModified,
+								Headers:     itemResponseHeaders,
+							},
 						},
 						Failures: []ResponseDescriptor{
 							{
@@ -998,13 +1290,14 @@ var routeDescriptors = []RouteDescriptor{
 			},
 			{
 				Method:      "POST",
-				Description: "To post the user update item file.",
+				Description: "Upload the item. `PUT` is accepted as well. The `Content-Type` of the request is kept as the media type of the item, and annotations may be given with `Docker-Item-Annotation` headers. Concurrent updates can be guarded with the `If-Match` and `If-None-Match` headers.",
 				Requests: []RequestDescriptor{
 					{
-						Headers: []ParameterDescriptor{
+						Headers: append([]ParameterDescriptor{
 							hostHeader,
 							authHeader,
-						},
+							itemAnnotationHeader,
+						}, itemConditionalHeaders...),
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 							referenceParameterDescriptor,
@@ -1024,6 +1317,7 @@ var routeDescriptors = []RouteDescriptor{
 										Type:   "url",
 										Format: "<item location>",
 									},
+									digestHeader,
 								},
 							},
 						},
@@ -1033,8 +1327,10 @@ var routeDescriptors = []RouteDescriptor{
 								StatusCode: http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeNameInvalid,
+									ErrorCodeItemInvalid,
 								},
 							},
+							itemPreconditionFailedResponseDescriptor,
 							{
 								Name:        "Not allowed",
 								Description: "Blob upload is not allowed because the registry is configured as a pull-through cache or for some other reason",
@@ -1218,6 +1514,7 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							quotaExceededResponseDescriptor,
 							{
 								Name:        "Missing Layer(s)",
 								Description: "One or more layers may be missing during a manifest upload. If so, the missing layers will be enumerated in the error response.",
@@ -1240,6 +1537,18 @@ var routeDescriptors = []RouteDescriptor{
 }`,
 								},
 							},
+							{
+								Name:        "Tag Immutable",
+								Description: "The tag already points to another manifest and a rule of the registry makes it immutable.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagImmutable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Not allowed",
 								Description: "Manifest put is not allowed because the registry is configured as a pull-through cache or for some other reason",
@@ -1287,6 +1596,18 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							{
+								Name:        "Tag Protected",
+								Description: "A tag pointing to the manifest is protected against deletion by a rule of the registry.",
+								StatusCode:  http.StatusForbidden,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagProtected,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Unknown Manifest",
 								Description: "The specified `name` or `reference` are unknown to the registry and the delete was unable to proceed. Clients can assume the manifest was already deleted if this response is returned.",
@@ -2049,6 +2370,7 @@ var routeDescriptors = []RouteDescriptor{
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
+							quotaExceededResponseDescriptor,
 						},
 					},
 				},
@@ -2181,6 +2503,88 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameSearch,
+		Path:        "/v2/_search",
+		Entity:      "Search",
+		Description: "Search the repositories in the local registry cluster by name, tag and image label. Only repositories in the cached catalog are searched.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the repositories matching all terms of the query, best matches first.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Search",
+						Description: "Search the repositories for the terms of the query.",
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "q",
+								Type:        "string",
+								Description: "Space separated terms to look up in repository names, tags and labels.",
+								Format:      "<string>",
+								Required:    true,
+							},
+							{
+								Name:        "n",
+								Type:        "integer",
+								Description: "Limit the number of results.",
+								Format:      "<integer>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the ranked matches as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format: `{
+	"query": <query>,
+	"results": [
+		{
+			"name": <name>,
+			"score": <score>,
+			"tags": [
+				<matching tag>,
+				...
+			],
+			"size": <size>,
+			"downloadCount": <downloadCount>,
+			"lastModified": <lastModified>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The query is empty or the limit is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryParameterInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameCatalogInfo,
 		Path:        "/v2/cataloginfo",
@@ -2218,6 +2622,210 @@ var routeDescriptors = []RouteDescriptor{
 							},
 						},
 					},
+					{
+						Name:            "Catalog Info Fetch Paginated",
+						Description:     "Return the specified portion of the filtered and sorted repositories. The `last` parameter is the cursor of the link header of the previous response: the name of its last repository when sorting by name, and otherwise the sort value of that repository, a comma and its name. The listing resumes after that position even if the repository was since removed.",
+						QueryParameters: catalogInfoParameters,
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format: `{
+	"imageinfos": [
+		<imageinfoBody>,
+		...
+	]
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The value of a query parameter is not supported.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeQueryParameterInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      errorsBody,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRobots,
+		Path:        "/v2/_robots",
+		Entity:      "Robots",
+		Description: "Manage the robot accounts of the registry. Requests require the `registry:admin:*` access, and the route is refused when no access controller is configured.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "List the robot accounts, or retrieve the account of the `name` parameter. Secrets are never returned.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{robotNameParameter},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      robotAccountBody,
+								},
+							},
+						},
+						Failures: robotFailures,
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Create the robot account of the body, or generate a new secret for the account of the `regenerate` parameter. The secret is only returned in this response.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "regenerate",
+								Type:        "string",
+								Description: "Name of the account whose secret is generated again.",
+								Format:      "<name>",
+							},
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json; charset=utf-8",
+							Format:      robotAccountBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The account was created.",
+								StatusCode:  http.StatusCreated,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      robotAccountBody,
+								},
+							},
+							{
+								Description: "The secret was generated again.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      robotAccountBody,
+								},
+							},
+						},
+						Failures: robotFailures,
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Replace the scopes and expiry of the robot account of the body.",
+				Requests: []RequestDescriptor{
+					{
+						Body: BodyDescriptor{
+							ContentType: "application/json; charset=utf-8",
+							Format:      robotAccountBody,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json; charset=utf-8",
+									Format:      robotAccountBody,
+								},
+							},
+						},
+						Failures: robotFailures,
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Revoke the robot account of the `name` parameter.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{robotNameParameter},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusNoContent,
+							},
+						},
+						Failures: robotFailures,
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameQuotas,
+		Path:        "/v2/_quotas",
+		Entity:      "Quotas",
+		Description: "Manage the storage quotas of the registry. Requests require the `registry:admin:*` access, and the route is refused when no access controller is configured. Every method returns the quotas with their usage.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "List the quotas with their usage.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: quotasSuccesses,
+						Failures:  quotasFailures,
+					},
+				},
+			},
+			{
+				Method:      "PUT",
+				Description: "Set the quota of a prefix, overriding the configured one. A limit of zero lifts the quota.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{
+							quotaPrefixParameter,
+							{
+								Name:        "limit",
+								Type:        "integer",
+								Description: "Limit of the quota in bytes.",
+								Format:      "<integer>",
+								Required:    true,
+							},
+						},
+						Successes: quotasSuccesses,
+						Failures:  quotasFailures,
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Remove the quota set for a prefix, so that the configured one applies again.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{quotaPrefixParameter},
+						Successes:       quotasSuccesses,
+						Failures:        quotasFailures,
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Recount the usage of the repositories covered by a prefix from the blobs linked into them.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{quotaPrefixParameter},
+						Successes:       quotasSuccesses,
+						Failures:        quotasFailures,
+					},
 				},
 			},
 		},
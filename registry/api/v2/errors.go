@@ -61,6 +61,36 @@ var (
 		HTTPStatusCode: http.StatusNotFound,
 	})
 
+	// ErrorCodeItemInvalid is returned when an item upload is malformed.
+	ErrorCodeItemInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ITEM_INVALID",
+		Message: "item invalid",
+		Description: `This is returned if an item upload carries malformed
+		metadata, such as an annotation which is not a key=value pair.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeItemPreconditionFailed is returned when the conditional
+	// headers of an item request do not match the current item.
+	ErrorCodeItemPreconditionFailed = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ITEM_PRECONDITION_FAILED",
+		Message: "item precondition failed",
+		Description: `This is returned if the If-Match or If-None-Match header
+		of an item upload does not match the current item, usually because it
+		has been updated concurrently.`,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+	})
+
+	// ErrorCodeItemTooLarge is returned when an uploaded item exceeds the
+	// maximum size configured for items.
+	ErrorCodeItemTooLarge = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ITEM_TOO_LARGE",
+		Message: "item exceeds the maximum size",
+		Description: `This is returned if the content of an item upload is
+		larger than the maximum item size configured in the registry.`,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+	})
+
 	// ErrorCodeNameUnknown when the repository name is not known.
 	ErrorCodeNameUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
 		Value:   "NAME_UNKNOWN",
@@ -142,4 +172,48 @@ var (
 		longer proceed.`,
 		HTTPStatusCode: http.StatusNotFound,
 	})
+
+	// ErrorCodeQueryParameterInvalid is returned when a query parameter of a
+	// listing request has an unsupported value.
+	ErrorCodeQueryParameterInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "QUERY_PARAMETER_INVALID",
+		Message: "invalid query parameter",
+		Description: `Returned when the value of a query parameter, such as
+		the number of entries or the sort order of a listing, is not
+		supported.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeQuotaExceeded is returned when storing a blob or manifest
+	// would exceed the storage quota of the repository.
+	ErrorCodeQuotaExceeded = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "QUOTA_EXCEEDED",
+		Message: "storage quota exceeded",
+		Description: `Returned when a blob upload or manifest put is denied
+		because the repository, or the namespace it belongs to, would
+		store more than its quota allows.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeTagImmutable is returned when a manifest put would move an
+	// immutable tag.
+	ErrorCodeTagImmutable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest is put with a tag which already
+		points to another manifest, and a rule of the registry makes the tag
+		immutable.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeTagProtected is returned when a delete would remove a tag
+	// protected against deletion.
+	ErrorCodeTagProtected = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_PROTECTED",
+		Message: "tag is protected against deletion",
+		Description: `Returned when deleting a manifest, or a repository,
+		would remove a tag which a rule of the registry protects against
+		deletion.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
 )
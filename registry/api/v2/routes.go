@@ -13,17 +13,21 @@ const (
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
 	RouteNameCatalogInfo     = "cataloginfo"
+	RouteNameSearch          = "search"
 	RouteNameTagInfo         = "taginfo"
 	RouteNameImageInfo       = "imageinfo"
 	RouteNameImageItemList   = "imageitemlist"
 	RouteNameTagItemList     = "tagitemlist"
 	RouteNameTagItem         = "tagitem"
 	RouteNameImageItem       = "imageitem"
+	RouteNameRobots          = "robots"
+	RouteNameQuotas          = "quotas"
 )
 
 var allEndpoints = []string{
 	RouteNameManifest,
 	RouteNameCatalog,
+	RouteNameSearch,
 	RouteNameTags,
 	RouteNameBlob,
 	RouteNameBlobUpload,
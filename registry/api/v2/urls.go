@@ -114,6 +114,31 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildCatalogInfoURL constructs a url to get the info of the repositories
+// in the catalog.
+func (ub *URLBuilder) BuildCatalogInfoURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameCatalogInfo)
+
+	catalogInfoURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(catalogInfoURL, values...).String(), nil
+}
+
+// BuildSearchURL constructs a url to search the repositories in the catalog.
+func (ub *URLBuilder) BuildSearchURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameSearch)
+
+	searchURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(searchURL, values...).String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -159,6 +184,31 @@ func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	return layerURL.String(), nil
 }
 
+// BuildImageItemURL constructs the url for the item of the named repository.
+func (ub *URLBuilder) BuildImageItemURL(name reference.Named, item string) (string, error) {
+	route := ub.cloneRoute(RouteNameImageItem)
+
+	itemURL, err := route.URL("name", name.Name(), "itemname", item)
+	if err != nil {
+		return "", err
+	}
+
+	return itemURL.String(), nil
+}
+
+// BuildTagItemURL constructs the url for the item of a tag of the named
+// repository.
+func (ub *URLBuilder) BuildTagItemURL(name reference.Named, tag, item string) (string, error) {
+	route := ub.cloneRoute(RouteNameTagItem)
+
+	itemURL, err := route.URL("name", name.Name(), "tag", tag, "itemname", item)
+	if err != nil {
+		return "", err
+	}
+
+	return itemURL.String(), nil
+}
+
 // BuildBlobUploadURL constructs a url to begin a blob upload in the
 // repository identified by name.
 func (ub *URLBuilder) BuildBlobUploadURL(name reference.Named, values ...url.Values) (string, error) {
@@ -22,6 +22,17 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 			expectedPath: "/v2/",
 			build:        urlBuilder.BuildBaseURL,
 		},
+		{
+			description:  "test catalog info url with sort and pagination",
+			expectedPath: "/v2/cataloginfo?last=foo&n=10&sort=downloadCount",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogInfoURL(url.Values{
+					"n":    []string{"10"},
+					"last": []string{"foo"},
+					"sort": []string{"downloadCount"},
+				})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
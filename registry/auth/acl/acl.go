@@ -0,0 +1,154 @@
+// Package acl implements access control lists granting actions on
+// repositories to users and groups of users.
+package acl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The actions an ACL grants on repositories. The admin action implies every
+// other action.
+const (
+	ActionPull      = "pull"
+	ActionPush      = "push"
+	ActionDelete    = "delete"
+	ActionItemWrite = "item-write"
+	ActionAdmin     = "admin"
+)
+
+// anyone matches every authenticated user in the users of a rule.
+const anyone = "*"
+
+// aclFile is the layout of an ACL file:
+//
+//	groups:
+//	  ci: [jenkins]
+//	rules:
+//	  - groups: [ci]
+//	    repositories: ["ci/*"]
+//	    actions: [pull, push, delete]
+//	  - users: ["*"]
+//	    repositories: ["library"]
+//	    actions: [pull]
+type aclFile struct {
+	// Groups maps the name of each group to its members.
+	Groups map[string][]string `yaml:"groups"`
+	Rules  []aclRule           `yaml:"rules"`
+}
+
+// aclRule grants actions on the repositories matching its patterns to the
+// users, and the members of the groups, it lists.
+type aclRule struct {
+	Users        []string `yaml:"users"`
+	Groups       []string `yaml:"groups"`
+	Repositories []string `yaml:"repositories"`
+	Actions      []string `yaml:"actions"`
+}
+
+// ACL holds the rules of an ACL file, indexed for evaluation.
+type ACL struct {
+	rules []aclRule
+
+	// memberOf maps users to the groups they belong to.
+	memberOf map[string][]string
+}
+
+// Parse parses the contents of an ACL file.
+func Parse(rd io.Reader) (*ACL, error) {
+	content, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	var f aclFile
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("acl: %v", err)
+	}
+
+	a := &ACL{
+		rules:    f.Rules,
+		memberOf: make(map[string][]string),
+	}
+	for group, members := range f.Groups {
+		for _, member := range members {
+			a.memberOf[member] = append(a.memberOf[member], group)
+		}
+	}
+	for i, rule := range f.Rules {
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("acl: invalid repository pattern %q in rule %d", pattern, i+1)
+			}
+		}
+		for _, action := range rule.Actions {
+			switch action {
+			case ActionPull, ActionPush, ActionDelete, ActionItemWrite, ActionAdmin, "*":
+			default:
+				return nil, fmt.Errorf("acl: unknown action %q in rule %d", action, i+1)
+			}
+		}
+	}
+	return a, nil
+}
+
+// Allowed returns true if one of the rules applying to the user grants the
+// action on the named resource.
+func (a *ACL) Allowed(user, name, action string) bool {
+	for _, rule := range a.rules {
+		if a.appliesTo(rule, user) && grantsAction(rule, action) && matchesRepository(rule.Repositories, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo returns true if the rule lists the user, or a group of the user.
+func (a *ACL) appliesTo(rule aclRule, user string) bool {
+	for _, u := range rule.Users {
+		if u == user || u == anyone {
+			return true
+		}
+	}
+	for _, group := range rule.Groups {
+		for _, g := range a.memberOf[user] {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func grantsAction(rule aclRule, action string) bool {
+	for _, a := range rule.Actions {
+		if a == action || a == ActionAdmin || a == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRepository returns true if one of the patterns, as understood by
+// path.Match, matches the name or one of the namespaces containing it, so
+// that "team/*" matches "team/app/web" and "*" matches every repository.
+func matchesRepository(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		for prefix := name; ; {
+			if ok, _ := path.Match(pattern, prefix); ok {
+				return true
+			}
+			i := strings.LastIndex(prefix, "/")
+			if i < 0 {
+				break
+			}
+			prefix = prefix[:i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package acl
+
+import (
+	"strings"
+	"testing"
+)
+
+const testACL = `
+groups:
+  ci: [frodo]
+rules:
+  - users: ["*"]
+    repositories: [library]
+    actions: [pull]
+  - groups: [ci]
+    repositories: ["ci/*"]
+    actions: [pull, push, delete]
+  - users: [sam]
+    repositories: ["*"]
+    actions: [admin]
+`
+
+func TestAllowed(t *testing.T) {
+	a, err := Parse(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatalf("unexpected error parsing acl: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		user, name, action string
+		allowed            bool
+	}{
+		{"bilbo", "library/ubuntu", ActionPull, true},
+		{"bilbo", "library/ubuntu", ActionPush, false},
+		{"bilbo", "ci/app", ActionPull, false},
+		{"frodo", "ci/app", ActionPush, true},
+		{"frodo", "ci/app/web", ActionDelete, true},
+		{"frodo", "ci", ActionPull, false},
+		{"frodo", "ci/app", ActionItemWrite, false},
+		{"frodo", "ci/app", ActionAdmin, false},
+		{"sam", "any/thing", ActionItemWrite, true},
+		{"sam", "catalog", ActionAdmin, true},
+	} {
+		if allowed := a.Allowed(testcase.user, testcase.name, testcase.action); allowed != testcase.allowed {
+			t.Errorf("expected %s on %s allowed to %s to be %v", testcase.action, testcase.name, testcase.user, testcase.allowed)
+		}
+	}
+
+	for _, invalid := range []string{
+		"rules:\n  - users: [bilbo]\n    repositories: [\"[\"]\n    actions: [pull]\n",
+		"rules:\n  - users: [bilbo]\n    repositories: [library]\n    actions: [write]\n",
+	} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package acl
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// File is an ACL file which is reloaded when it changes on disk.
+type File struct {
+	path string
+
+	// interval is the minimum time between two checks of the file.
+	interval time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	size    int64
+	acl     *ACL
+}
+
+// OpenFile loads the ACL file at path, which is then checked for changes at
+// most once per interval.
+func OpenFile(path string, interval time.Duration) (*File, error) {
+	f := &File{
+		path:     path,
+		interval: interval,
+	}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+	return f, nil
+}
+
+// ACL returns the current rules of the file, reloading them if the file
+// changed on disk. A file which fails to reload is reported and its previous
+// rules are kept.
+func (f *File) ACL() *ACL {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.checked) >= f.interval {
+		f.checked = time.Now()
+		reloaded, err := f.reload()
+		if err != nil {
+			logrus.Errorf("acl: error reloading %s, keeping its previous rules: %v", f.path, err)
+		} else if reloaded {
+			logrus.Infof("acl: reloaded %s", f.path)
+		}
+	}
+	return f.acl
+}
+
+// reload parses the file if it changed since it was last parsed, and returns
+// whether it did.
+func (f *File) reload() (bool, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return false, nil
+	}
+
+	rd, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+
+	a, err := Parse(rd)
+	if err != nil {
+		return false, err
+	}
+	f.acl = a
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return true, nil
+}
@@ -48,6 +48,10 @@ const (
 	// UserNameKey is used to get the user name from
 	// a user context
 	UserNameKey = "auth.user.name"
+
+	// GrantsKey is used to get the grants from
+	// a partially authorized context
+	GrantsKey = "auth.grants"
 )
 
 var (
@@ -90,6 +94,19 @@ type Challenge interface {
 	SetHeaders(w http.ResponseWriter)
 }
 
+// ErrAccessDenied is returned by access controllers which authenticated the
+// client but do not grant it the requested access. Unlike a Challenge, it
+// should be answered with a 403 Forbidden response, since authenticating
+// again would not help.
+type ErrAccessDenied struct {
+	User   string
+	Access []Access
+}
+
+func (err ErrAccessDenied) Error() string {
+	return fmt.Sprintf("access denied to user %q: %v", err.User, err.Access)
+}
+
 // AccessController controls access to registry resources based on a request
 // and required access levels for a request. Implementations can support both
 // complete denial and http authorization challenges.
@@ -106,11 +123,44 @@ type AccessController interface {
 	Authorized(ctx context.Context, access ...Access) (context.Context, error)
 }
 
+// Grants describes the actions granted to an authorized client on individual
+// resources.
+type Grants interface {
+	// Allowed returns whether the action is granted on the resource.
+	Allowed(access Access) bool
+}
+
+// GrantsProvider is implemented by access controllers which can tell the
+// actions granted to a client on individual resources. It allows requests
+// listing resources, such as the catalog, to be authorized partially and
+// their results to be filtered down to the granted resources.
+type GrantsProvider interface {
+	// Grants authenticates the client of the request held by the context
+	// and returns its grants along with an authenticated context. As with
+	// Authorized, the error may be of type Challenge.
+	Grants(ctx context.Context) (context.Context, Grants, error)
+}
+
+// AllGrants grants every action on every resource.
+var AllGrants Grants = allGrants{}
+
+type allGrants struct{}
+
+func (allGrants) Allowed(access Access) bool {
+	return true
+}
+
 // CredentialAuthenticator is an object which is able to authenticate credentials
 type CredentialAuthenticator interface {
 	AuthenticateUser(username, password string) error
 }
 
+// UserLookup is an object which is able to tell whether a user exists
+// without their credentials
+type UserLookup interface {
+	UserExists(username string) (bool, error)
+}
+
 // WithUser returns a context with the authorized user info.
 func WithUser(ctx context.Context, user UserInfo) context.Context {
 	return userInfoContext{
@@ -135,6 +185,19 @@ func (uic userInfoContext) Value(key interface{}) interface{} {
 	return uic.Context.Value(key)
 }
 
+// WithGrants returns a context with the grants of a partially authorized
+// client.
+func WithGrants(ctx context.Context, grants Grants) context.Context {
+	return context.WithValue(ctx, GrantsKey, grants)
+}
+
+// GetGrants returns the grants of a partially authorized client, or nil if
+// the context was fully authorized.
+func GetGrants(ctx context.Context) Grants {
+	grants, _ := ctx.Value(GrantsKey).(Grants)
+	return grants
+}
+
 // InitFunc is the type of an AccessController factory function and is used
 // to register the constructor for different AccesController backends.
 type InitFunc func(options map[string]interface{}) (AccessController, error)
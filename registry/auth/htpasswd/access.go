@@ -7,19 +7,42 @@ package htpasswd
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/acl"
+	"github.com/gorilla/mux"
 )
 
+// defaultReloadInterval is the minimum time between two checks of the
+// htpasswd and ACL files for changes.
+const defaultReloadInterval = 5 * time.Second
+
 type accessController struct {
-	realm    string
+	realm string
+
+	// interval is the minimum time between two checks of the htpasswd
+	// file, which is reloaded when it changes on disk.
+	interval time.Duration
+
+	mu       sync.RWMutex
+	checked  time.Time
 	htpasswd *htpasswd
+	file     *watchedFile
+
+	// acl is nil unless an ACL file is configured.
+	acl *acl.File
 }
 
 var _ auth.AccessController = &accessController{}
+var _ auth.GrantsProvider = &accessController{}
 
 func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
 	realm, present := options["realm"]
@@ -32,29 +55,89 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, fmt.Errorf(`"path" must be set for htpasswd access controller`)
 	}
 
-	f, err := os.Open(path.(string))
-	if err != nil {
+	ac := &accessController{
+		realm:    realm.(string),
+		interval: defaultReloadInterval,
+	}
+	ac.file = &watchedFile{path: path.(string), load: ac.loadHTPasswd}
+
+	if interval, present := options["reloadinterval"]; present {
+		d, err := time.ParseDuration(fmt.Sprint(interval))
+		if err != nil {
+			return nil, fmt.Errorf(`"reloadinterval" must be a duration for htpasswd access controller: %v`, err)
+		}
+		ac.interval = d
+	}
+
+	if _, err := ac.file.reload(); err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	ac.checked = time.Now()
+
+	if aclPath, present := options["acl"]; present {
+		if _, ok := aclPath.(string); !ok {
+			return nil, fmt.Errorf(`"acl" must be a path for htpasswd access controller`)
+		}
+		f, err := acl.OpenFile(aclPath.(string), ac.interval)
+		if err != nil {
+			return nil, err
+		}
+		ac.acl = f
+	}
+	return ac, nil
+}
 
-	h, err := newHTPasswd(f)
+// Authorized authenticates the user of the request and, if an ACL is
+// configured, checks that it grants every requested access to the user.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	username, err := ac.authenticate(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &accessController{realm: realm.(string), htpasswd: h}, nil
+	if ac.acl != nil {
+		a := ac.acl.ACL()
+		route := routeName(ctx)
+		var denied []auth.Access
+		for _, access := range accessRecords {
+			if !a.Allowed(username, access.Name, requiredAction(access, route)) {
+				denied = append(denied, access)
+			}
+		}
+		if len(denied) > 0 {
+			return nil, auth.ErrAccessDenied{User: username, Access: denied}
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
 }
 
-func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+// Grants authenticates the user of the request. Without an ACL,
+// authenticated users are granted every action on every repository.
+func (ac *accessController) Grants(ctx context.Context) (context.Context, auth.Grants, error) {
+	username, err := ac.authenticate(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var grants = auth.AllGrants
+	if ac.acl != nil {
+		grants = aclGrants{acl: ac.acl.ACL(), user: username}
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), grants, nil
+}
+
+// authenticate checks the basic auth credentials of the request and returns
+// the name of the user.
+func (ac *accessController) authenticate(ctx context.Context) (string, error) {
 	req, err := context.GetRequest(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	username, password, ok := req.BasicAuth()
 	if !ok {
-		return nil, &challenge{
+		return "", &challenge{
 			realm: ac.realm,
 			err:   auth.ErrInvalidCredential,
 		}
@@ -62,17 +145,162 @@ func (ac *accessController) Authorized(ctx context.Context, accessRecords ...aut
 
 	if err := ac.AuthenticateUser(username, password); err != nil {
 		context.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
-		return nil, &challenge{
+		return "", &challenge{
 			realm: ac.realm,
 			err:   auth.ErrAuthenticationFailure,
 		}
 	}
 
-	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+	return username, nil
 }
 
 func (ac *accessController) AuthenticateUser(username, password string) error {
-	return ac.htpasswd.authenticateUser(username, password)
+	ac.reload()
+
+	ac.mu.RLock()
+	h := ac.htpasswd
+	ac.mu.RUnlock()
+
+	return h.authenticateUser(username, password)
+}
+
+// UserExists returns true if the user is in the htpasswd file.
+func (ac *accessController) UserExists(username string) (bool, error) {
+	ac.reload()
+
+	ac.mu.RLock()
+	h := ac.htpasswd
+	ac.mu.RUnlock()
+
+	_, ok := h.entries[username]
+	return ok, nil
+}
+
+// reload reloads the htpasswd file if it changed on disk, unless it was
+// checked less than an interval ago. A file which fails to load is reported
+// and its previous contents are kept.
+func (ac *accessController) reload() {
+	ac.mu.Lock()
+	if time.Since(ac.checked) < ac.interval {
+		ac.mu.Unlock()
+		return
+	}
+	ac.checked = time.Now()
+	ac.mu.Unlock()
+
+	reloaded, err := ac.file.reload()
+	if err != nil {
+		logrus.Errorf("htpasswd: error reloading %s, keeping its previous contents: %v", ac.file.path, err)
+	} else if reloaded {
+		logrus.Infof("htpasswd: reloaded %s", ac.file.path)
+	}
+}
+
+func (ac *accessController) loadHTPasswd(rd io.Reader) error {
+	h, err := newHTPasswd(rd)
+	if err != nil {
+		return err
+	}
+	ac.mu.Lock()
+	ac.htpasswd = h
+	ac.mu.Unlock()
+	return nil
+}
+
+// watchedFile is a file loaded again whenever it changes on disk.
+type watchedFile struct {
+	path string
+	load func(io.Reader) error
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// reload loads the file if it changed since it was last loaded, and returns
+// whether it did.
+func (f *watchedFile) reload() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return false, nil
+	}
+
+	rd, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+
+	if err := f.load(rd); err != nil {
+		return false, err
+	}
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return true, nil
+}
+
+// requiredAction returns the action of the ACL required for the access
+// requested on the named route. The registry requests the "*" action to
+// delete, and the "push" action to write items.
+func requiredAction(access auth.Access, route string) string {
+	if access.Type != "repository" {
+		// such as listing the whole catalog
+		return acl.ActionAdmin
+	}
+
+	itemRoute := route == v2.RouteNameImageItem || route == v2.RouteNameTagItem
+	switch access.Action {
+	case "pull":
+		return acl.ActionPull
+	case "push":
+		if itemRoute {
+			return acl.ActionItemWrite
+		}
+		return acl.ActionPush
+	case "*":
+		switch {
+		case itemRoute:
+			return acl.ActionItemWrite
+		case route == v2.RouteNameImageInfo:
+			// deletes the whole repository
+			return acl.ActionAdmin
+		default:
+			return acl.ActionDelete
+		}
+	}
+	return acl.ActionAdmin
+}
+
+// routeName returns the name of the route of the request held by the
+// context, if any.
+func routeName(ctx context.Context) string {
+	req, err := context.GetRequest(ctx)
+	if err != nil {
+		return ""
+	}
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return ""
+	}
+	return route.GetName()
+}
+
+// aclGrants are the grants of a user according to an ACL.
+type aclGrants struct {
+	acl  *acl.ACL
+	user string
+}
+
+var _ auth.Grants = aclGrants{}
+
+func (g aclGrants) Allowed(access auth.Access) bool {
+	return g.acl.Allowed(g.user, access.Name, requiredAction(access, ""))
 }
 
 // challenge implements the auth.Challenge interface.
@@ -0,0 +1,130 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/acl"
+)
+
+const testACL = `
+groups:
+  ci: [frodo]
+rules:
+  - users: ["*"]
+    repositories: [library]
+    actions: [pull]
+  - groups: [ci]
+    repositories: ["ci/*"]
+    actions: [pull, push, delete]
+  - users: [sam]
+    repositories: ["*"]
+    actions: [admin]
+`
+
+func TestRequiredAction(t *testing.T) {
+	repository := func(action string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo"}, Action: action}
+	}
+	for _, testcase := range []struct {
+		access auth.Access
+		route  string
+		action string
+	}{
+		{repository("pull"), v2.RouteNameManifest, acl.ActionPull},
+		{repository("push"), v2.RouteNameManifest, acl.ActionPush},
+		{repository("push"), v2.RouteNameTagItem, acl.ActionItemWrite},
+		{repository("*"), v2.RouteNameManifest, acl.ActionDelete},
+		{repository("*"), v2.RouteNameImageItem, acl.ActionItemWrite},
+		{repository("*"), v2.RouteNameImageInfo, acl.ActionAdmin},
+		{auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}, v2.RouteNameCatalog, acl.ActionAdmin},
+	} {
+		if action := requiredAction(testcase.access, testcase.route); action != testcase.action {
+			t.Errorf("expected %v on route %s to require %s, got %s", testcase.access, testcase.route, testcase.action, action)
+		}
+	}
+}
+
+func TestACLAccessController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd-acl-test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	aclPath := filepath.Join(dir, "acl.yml")
+	// frodo's password is baggins
+	writeFile := func(path, content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("could not write %s: %v", path, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("could not change the times of %s: %v", path, err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	writeFile(htpasswdPath, "frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n", start)
+	writeFile(aclPath, testACL, start)
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm":          "The-Shire",
+		"path":           htpasswdPath,
+		"acl":            aclPath,
+		"reloadinterval": "0s",
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	authorize := func(user, password, name, action string) error {
+		req, _ := http.NewRequest("GET", "http://example.com/v2/", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		access := auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+		_, err := accessController.Authorized(context.WithRequest(context.Background(), req), access)
+		return err
+	}
+
+	if err := authorize("", "", "ci/app", "pull"); err == nil {
+		t.Fatalf("expected a challenge without credentials")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected a challenge without credentials, got %v", err)
+	}
+	if err := authorize("frodo", "baggins", "ci/app", "push"); err != nil {
+		t.Fatalf("unexpected error authorizing a push: %v", err)
+	}
+	if err := authorize("frodo", "baggins", "other/app", "pull"); err == nil {
+		t.Fatalf("expected access to be denied")
+	} else if denied, ok := err.(auth.ErrAccessDenied); !ok || denied.User != "frodo" || len(denied.Access) != 1 {
+		t.Fatalf("expected access to be denied, got %v", err)
+	}
+
+	// rewrite both files, the changes are picked up by the next request
+	writeFile(aclPath, "rules:\n  - users: [frodo]\n    repositories: [other]\n    actions: [pull]\n", start.Add(time.Minute))
+	if err := authorize("frodo", "baggins", "other/app", "pull"); err != nil {
+		t.Fatalf("unexpected error after reloading the acl: %v", err)
+	}
+	if err := authorize("frodo", "baggins", "ci/app", "push"); err == nil {
+		t.Fatalf("expected access to be denied after reloading the acl")
+	}
+
+	// an invalid file is reported and its previous contents are kept
+	writeFile(aclPath, "rules: [", start.Add(2*time.Minute))
+	if err := authorize("frodo", "baggins", "other/app", "pull"); err != nil {
+		t.Fatalf("unexpected error after failing to reload the acl: %v", err)
+	}
+
+	writeFile(htpasswdPath, "sam:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n", start.Add(time.Minute))
+	if err := authorize("frodo", "baggins", "other/app", "pull"); err == nil {
+		t.Fatalf("expected frodo to be removed after reloading the htpasswd file")
+	}
+}
@@ -0,0 +1,154 @@
+// Package robot provides a credential layer authenticating robot accounts
+// with basic auth in front of any access controller. Behind the token access
+// controller, robot accounts obtain bearer tokens from the robot backend of
+// the token server instead, which the wrapped controller verifies.
+package robot
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// UserPrefix prefixes the names of robot accounts in basic auth credentials,
+// and in the user names of the requests they authorize. Credentials of other
+// users are left to the wrapped access controller.
+const UserPrefix = "robot$"
+
+// accessController authenticates the robot accounts and passes the other
+// requests to the wrapped access controller.
+type accessController struct {
+	auth.AccessController
+
+	realm    string
+	accounts distribution.RobotAccountService
+}
+
+var _ auth.GrantsProvider = &accessController{}
+
+// NewAccessController returns an access controller authorizing the requests
+// bearing the basic auth credentials of a robot account, whose user name is
+// the name of the account prefixed by UserPrefix, for the actions of its
+// scopes. Other requests are authorized by the given access controller.
+func NewAccessController(ac auth.AccessController, accounts distribution.RobotAccountService, realm string) auth.AccessController {
+	return &accessController{
+		AccessController: ac,
+		realm:            realm,
+		accounts:         accounts,
+	}
+}
+
+// Authorized authorizes the requests of robot accounts, and passes the
+// others to the wrapped access controller.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	account, ok, err := ac.authenticate(ctx)
+	if !ok {
+		return ac.AccessController.Authorized(ctx, accessRecords...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	username := UserPrefix + account.Name
+	var denied []auth.Access
+	for _, access := range accessRecords {
+		if !Allowed(account, access) {
+			denied = append(denied, access)
+		}
+	}
+	if len(denied) > 0 {
+		return nil, auth.ErrAccessDenied{User: username, Access: denied}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+}
+
+// Grants returns the scopes of robot accounts, and passes the other requests
+// to the wrapped access controller if it provides grants.
+func (ac *accessController) Grants(ctx context.Context) (context.Context, auth.Grants, error) {
+	account, ok, err := ac.authenticate(ctx)
+	if !ok {
+		provider, ok := ac.AccessController.(auth.GrantsProvider)
+		if !ok {
+			return nil, nil, fmt.Errorf("access controller provides no grants")
+		}
+		return provider.Grants(ctx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: UserPrefix + account.Name}), grants{account}, nil
+}
+
+// authenticate authenticates the robot account of the request. If the
+// request bears no credentials of a robot account, ok is false.
+func (ac *accessController) authenticate(ctx context.Context) (account distribution.RobotAccount, ok bool, err error) {
+	req, err := context.GetRequest(ctx)
+	if err != nil {
+		return account, false, nil
+	}
+
+	username, secret, ok := req.BasicAuth()
+	if !ok || !strings.HasPrefix(username, UserPrefix) {
+		return account, false, nil
+	}
+
+	account, err = ac.accounts.Authenticate(ctx, strings.TrimPrefix(username, UserPrefix), secret)
+	if err != nil {
+		if err != distribution.ErrRobotAuthenticationFailure {
+			// storage errors must not pass for bad credentials
+			return account, true, err
+		}
+		context.GetLogger(ctx).Errorf("error authenticating robot account %q: %v", username, err)
+		return account, true, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrAuthenticationFailure,
+		}
+	}
+	return account, true, nil
+}
+
+// Allowed returns true if the account is granted the access. Robot accounts
+// are only granted actions on repositories, and "*" accesses require the
+// delete action.
+func Allowed(account distribution.RobotAccount, access auth.Access) bool {
+	if access.Type != "repository" {
+		return false
+	}
+	action := access.Action
+	if action == "*" {
+		action = "delete"
+	}
+	return account.Allowed(access.Name, action)
+}
+
+// grants implements auth.Grants for a robot account.
+type grants struct {
+	account distribution.RobotAccount
+}
+
+func (g grants) Allowed(access auth.Access) bool {
+	return Allowed(g.account, access)
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the basic challenge header on the response.
+func (ch challenge) SetHeaders(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("basic authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
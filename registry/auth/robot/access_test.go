@@ -0,0 +1,104 @@
+package robot
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// testAccounts authenticates the account "ci" with the secret "secret".
+type testAccounts struct {
+	distribution.RobotAccountService
+	err error
+}
+
+func (ta testAccounts) Authenticate(ctx context.Context, name, secret string) (distribution.RobotAccount, error) {
+	if ta.err != nil {
+		return distribution.RobotAccount{}, ta.err
+	}
+	if name != "ci" || secret != "secret" {
+		return distribution.RobotAccount{}, distribution.ErrRobotAuthenticationFailure
+	}
+	return distribution.RobotAccount{
+		Name:   "ci",
+		Scopes: []distribution.RobotScope{{Repository: "ci", Actions: []string{"pull", "delete"}}},
+	}, nil
+}
+
+// testAccessController authorizes every request as the user "next".
+type testAccessController struct{}
+
+func (testAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return auth.WithUser(ctx, auth.UserInfo{Name: "next"}), nil
+}
+
+func TestAccessController(t *testing.T) {
+	ac := NewAccessController(testAccessController{}, testAccounts{}, "test-realm")
+
+	request := func(username, password string) context.Context {
+		req, _ := http.NewRequest("GET", "http://example.com/v2/ci/tags/list", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		return context.WithRequest(context.Background(), req)
+	}
+	repository := func(name, action string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+	}
+
+	ctx, err := ac.Authorized(request("robot$ci", "secret"), repository("ci", "pull"), repository("ci", "*"))
+	if err != nil {
+		t.Fatalf("unexpected error authorizing robot account: %v", err)
+	}
+	if name := context.GetStringValue(ctx, auth.UserNameKey); name != "robot$ci" {
+		t.Fatalf("unexpected user name: %q", name)
+	}
+
+	_, err = ac.Authorized(request("robot$ci", "secret"), repository("ci", "pull"), repository("ci", "push"))
+	if denied, ok := err.(auth.ErrAccessDenied); !ok || len(denied.Access) != 1 || denied.Access[0].Action != "push" {
+		t.Fatalf("expected push access to be denied, got %v", err)
+	}
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	if _, err := ac.Authorized(request("robot$ci", "secret"), catalog); err == nil {
+		t.Fatalf("expected catalog access to be denied")
+	}
+
+	if _, err := ac.Authorized(request("robot$ci", "wrong"), repository("ci", "pull")); err == nil {
+		t.Fatalf("expected a wrong secret to be challenged")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+
+	for _, ctx := range []context.Context{request("", ""), request("frodo", "baggins")} {
+		ctx, err := ac.Authorized(ctx, repository("other", "push"))
+		if err != nil {
+			t.Fatalf("unexpected error authorizing other user: %v", err)
+		}
+		if name := context.GetStringValue(ctx, auth.UserNameKey); name != "next" {
+			t.Fatalf("expected other users to be authorized by the wrapped access controller, got %q", name)
+		}
+	}
+
+	_, grants, err := ac.(auth.GrantsProvider).Grants(request("robot$ci", "secret"))
+	if err != nil {
+		t.Fatalf("unexpected error getting grants: %v", err)
+	}
+	if !grants.Allowed(repository("ci", "pull")) || grants.Allowed(repository("other", "pull")) {
+		t.Fatalf("unexpected grants")
+	}
+	if _, _, err := ac.(auth.GrantsProvider).Grants(request("frodo", "baggins")); err == nil {
+		t.Fatalf("expected no grants from an access controller which provides none")
+	}
+
+	// failures of the accounts are not mistaken for wrong credentials
+	ac = NewAccessController(testAccessController{}, testAccounts{err: errors.New("storage failure")}, "test-realm")
+	if _, err := ac.Authorized(request("robot$ci", "secret"), repository("ci", "pull")); err == nil {
+		t.Fatalf("expected an error")
+	} else if _, ok := err.(auth.Challenge); ok {
+		t.Fatalf("expected an error which is no challenge, got %v", err)
+	}
+}
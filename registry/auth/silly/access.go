@@ -25,6 +25,7 @@ type accessController struct {
 }
 
 var _ auth.AccessController = &accessController{}
+var _ auth.GrantsProvider = &accessController{}
 
 func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
 	realm, present := options["realm"]
@@ -68,6 +69,17 @@ func (ac *accessController) Authorized(ctx context.Context, accessRecords ...aut
 	return auth.WithUser(ctx, auth.UserInfo{Name: "silly"}), nil
 }
 
+// Grants checks for the existence of the authorization header, like
+// Authorized, and grants every action on every repository if it is present.
+func (ac *accessController) Grants(ctx context.Context) (context.Context, auth.Grants, error) {
+	ctx, err := ac.Authorized(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ctx, auth.AllGrants, nil
+}
+
 type challenge struct {
 	realm   string
 	service string
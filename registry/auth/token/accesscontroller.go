@@ -1,19 +1,14 @@
 package token
 
 import (
-	"crypto"
-	"crypto/x509"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/auth"
-	"github.com/docker/libtrust"
 )
 
 // accessSet maps a typed, named resource to
@@ -53,6 +48,12 @@ func (s accessSet) contains(access auth.Access) bool {
 	return false
 }
 
+// Allowed implements auth.Grants, so that the access granted by a token can
+// be used to filter listings.
+func (s accessSet) Allowed(access auth.Access) bool {
+	return s.contains(access)
+}
+
 // scopeParam returns a collection of scopes which can
 // be used for a WWW-Authenticate challenge parameter.
 // See https://tools.ietf.org/html/rfc6750#section-3
@@ -119,20 +120,31 @@ func (ac authChallenge) SetHeaders(w http.ResponseWriter) {
 
 // accessController implements the auth.AccessController interface.
 type accessController struct {
-	realm       string
-	issuer      string
-	service     string
-	rootCerts   *x509.CertPool
-	trustedKeys map[string]libtrust.PublicKey
+	realm   string
+	service string
+
+	// issuers maps the names of the trusted issuers to their keys.
+	issuers map[string]*trustedIssuer
 }
 
+var _ auth.GrantsProvider = &accessController{}
+
 // tokenAccessOptions is a convenience type for handling
 // options to the contstructor of an accessController.
 type tokenAccessOptions struct {
 	realm          string
-	issuer         string
 	service        string
+	reloadInterval time.Duration
+	issuers        []issuerOptions
+}
+
+// issuerOptions configures a trusted issuer.
+type issuerOptions struct {
+	issuer         string
+	audiences      []string
 	rootCertBundle string
+	jwks           string
+	jwksRefresh    time.Duration
 }
 
 // checkOptions gathers the necessary options
@@ -140,7 +152,7 @@ type tokenAccessOptions struct {
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
 
-	keys := []string{"realm", "issuer", "service", "rootcertbundle"}
+	keys := []string{"realm", "service"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
@@ -150,64 +162,153 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		vals = append(vals, val)
 	}
 
-	opts.realm, opts.issuer, opts.service, opts.rootCertBundle = vals[0], vals[1], vals[2], vals[3]
+	opts.realm, opts.service = vals[0], vals[1]
+
+	opts.reloadInterval = defaultReloadInterval
+	if interval, present := options["reloadinterval"]; present {
+		d, err := time.ParseDuration(fmt.Sprint(interval))
+		if err != nil {
+			return opts, fmt.Errorf("token auth requires a valid duration: %q: %v", "reloadinterval", err)
+		}
+		opts.reloadInterval = d
+	}
+
+	// the issuer of the top level options, trusted for the service
+	if _, present := options["issuer"]; present || options["issuers"] == nil {
+		issuer, err := checkIssuerOptions(options)
+		if err != nil {
+			return opts, err
+		}
+		issuer.audiences = append([]string{opts.service}, issuer.audiences...)
+		opts.issuers = append(opts.issuers, issuer)
+	}
+
+	if issuers, present := options["issuers"]; present {
+		list, ok := issuers.([]interface{})
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a list of issuers: %q", "issuers")
+		}
+		for _, item := range list {
+			issuerMap, ok := stringMap(item)
+			if !ok {
+				return opts, fmt.Errorf("token auth requires issuers with options: %v", item)
+			}
+			issuer, err := checkIssuerOptions(issuerMap)
+			if err != nil {
+				return opts, err
+			}
+			if len(issuer.audiences) == 0 {
+				issuer.audiences = []string{opts.service}
+			}
+			opts.issuers = append(opts.issuers, issuer)
+		}
+	}
 
 	return opts, nil
 }
 
-// newAccessController creates an accessController using the given options.
-func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
-	config, err := checkOptions(options)
-	if err != nil {
-		return nil, err
-	}
+// checkIssuerOptions gathers the options of a trusted issuer, which requires
+// a root certificate bundle or a key set.
+func checkIssuerOptions(options map[string]interface{}) (issuerOptions, error) {
+	var opts issuerOptions
 
-	fp, err := os.Open(config.rootCertBundle)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+	var ok bool
+	if opts.issuer, ok = options["issuer"].(string); !ok {
+		return opts, fmt.Errorf("token auth requires a valid option string: %q", "issuer")
 	}
-	defer fp.Close()
 
-	rawCertBundle, err := ioutil.ReadAll(fp)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+	for _, key := range []string{"rootcertbundle", "jwks"} {
+		if val, present := options[key]; present {
+			if _, ok := val.(string); !ok {
+				return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
+			}
+		}
+	}
+	opts.rootCertBundle, _ = options["rootcertbundle"].(string)
+	opts.jwks, _ = options["jwks"].(string)
+	if opts.rootCertBundle == "" && opts.jwks == "" {
+		return opts, fmt.Errorf("token auth requires %q or %q for issuer %q", "rootcertbundle", "jwks", opts.issuer)
 	}
 
-	var rootCerts []*x509.Certificate
-	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
-	for pemBlock != nil {
-		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+	opts.jwksRefresh = defaultJWKSRefresh
+	if refresh, present := options["jwksrefresh"]; present {
+		d, err := time.ParseDuration(fmt.Sprint(refresh))
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+			return opts, fmt.Errorf("token auth requires a valid duration: %q: %v", "jwksrefresh", err)
+		}
+		opts.jwksRefresh = d
+	}
+
+	if audiences, present := options["audiences"]; present {
+		list, ok := audiences.([]interface{})
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a list of strings: %q", "audiences")
+		}
+		for _, audience := range list {
+			s, ok := audience.(string)
+			if !ok {
+				return opts, fmt.Errorf("token auth requires a list of strings: %q", "audiences")
+			}
+			opts.audiences = append(opts.audiences, s)
 		}
+	}
 
-		rootCerts = append(rootCerts, cert)
+	return opts, nil
+}
 
-		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
+// stringMap converts the maps decoded from the configuration, whose keys may
+// be of any type, to maps of strings.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			converted[key] = v
+		}
+		return converted, true
 	}
+	return nil, false
+}
 
-	if len(rootCerts) == 0 {
-		return nil, errors.New("token auth requires at least one token signing root certificate")
+// newAccessController creates an accessController using the given options.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	config, err := checkOptions(options)
+	if err != nil {
+		return nil, err
 	}
 
-	rootPool := x509.NewCertPool()
-	trustedKeys := make(map[string]libtrust.PublicKey, len(rootCerts))
-	for _, rootCert := range rootCerts {
-		rootPool.AddCert(rootCert)
-		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
-		if err != nil {
-			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+	ac := &accessController{
+		realm:   config.realm,
+		service: config.service,
+		issuers: make(map[string]*trustedIssuer, len(config.issuers)),
+	}
+	for _, issuer := range config.issuers {
+		if _, exists := ac.issuers[issuer.issuer]; exists {
+			return nil, fmt.Errorf("token auth issuer %q is configured more than once", issuer.issuer)
+		}
+
+		ti := &trustedIssuer{
+			name:      issuer.issuer,
+			audiences: issuer.audiences,
 		}
-		trustedKeys[pubKey.KeyID()] = pubKey
+		if issuer.rootCertBundle != "" {
+			if ti.bundle, err = newCertBundle(issuer.rootCertBundle, config.reloadInterval); err != nil {
+				return nil, err
+			}
+		}
+		if issuer.jwks != "" {
+			ti.jwks = newJWKSCache(issuer.jwks, issuer.jwksRefresh)
+		}
+		ac.issuers[issuer.issuer] = ti
 	}
 
-	return &accessController{
-		realm:       config.realm,
-		issuer:      config.issuer,
-		service:     config.service,
-		rootCerts:   rootPool,
-		trustedKeys: trustedKeys,
-	}, nil
+	return ac, nil
 }
 
 // Authorized handles checking whether the given request is authorized
@@ -219,6 +320,41 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		accessSet: newAccessSet(accessItems...),
 	}
 
+	token, err := ac.verifyToken(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	accessSet := token.accessSet()
+	for _, access := range accessItems {
+		if !accessSet.contains(access) {
+			challenge.err = ErrInsufficientScope
+			return nil, challenge
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), nil
+}
+
+// Grants returns the access granted by the token of the request, so that
+// listings can be filtered down to the resources named in the token.
+func (ac *accessController) Grants(ctx context.Context) (context.Context, auth.Grants, error) {
+	challenge := &authChallenge{
+		realm:   ac.realm,
+		service: ac.service,
+	}
+
+	token, err := ac.verifyToken(ctx, challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), token.accessSet(), nil
+}
+
+// verifyToken parses and verifies the bearer token of the request. Errors
+// are returned as the given challenge.
+func (ac *accessController) verifyToken(ctx context.Context, challenge *authChallenge) (*Token, error) {
 	req, err := context.GetRequest(ctx)
 	if err != nil {
 		return nil, err
@@ -239,27 +375,28 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		return nil, challenge
 	}
 
-	verifyOpts := VerifyOptions{
-		TrustedIssuers:    []string{ac.issuer},
-		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
-	}
-
-	if err = token.Verify(verifyOpts); err != nil {
-		challenge.err = err
+	issuer, ok := ac.issuers[token.Claims.Issuer]
+	if !ok {
+		context.GetLogger(ctx).Errorf("token from untrusted issuer: %q", token.Claims.Issuer)
+		challenge.err = ErrInvalidToken
 		return nil, challenge
 	}
 
-	accessSet := token.accessSet()
-	for _, access := range accessItems {
-		if !accessSet.contains(access) {
-			challenge.err = ErrInsufficientScope
+	verifyOpts := issuer.verifyOptions(false)
+	if err = token.Verify(verifyOpts); err != nil {
+		// the key set may not hold a key rotated in since it was fetched
+		keyID := token.Header.KeyID
+		if issuer.jwks == nil || keyID == "" || verifyOpts.TrustedKeys[keyID] != nil {
+			challenge.err = err
+			return nil, challenge
+		}
+		if err = token.Verify(issuer.verifyOptions(true)); err != nil {
+			challenge.err = err
 			return nil, challenge
 		}
 	}
 
-	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), nil
+	return token, nil
 }
 
 // init handles registering the token auth backend.
@@ -0,0 +1,344 @@
+package token
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libtrust"
+)
+
+const (
+	// defaultReloadInterval is the minimum time between two checks of a
+	// root certificate bundle for changes.
+	defaultReloadInterval = 5 * time.Second
+
+	// defaultJWKSRefresh is the time a key set is cached for, unless its
+	// response tells otherwise.
+	defaultJWKSRefresh = 5 * time.Minute
+
+	// minJWKSRefresh is the minimum time between two fetches of a key set,
+	// which is fetched again early to find the keys it did not hold.
+	minJWKSRefresh = 10 * time.Second
+)
+
+// certBundle is a root certificate bundle which is reloaded when it changes
+// on disk.
+type certBundle struct {
+	path string
+
+	// interval is the minimum time between two checks of the file.
+	interval time.Duration
+
+	mu          sync.Mutex
+	checked     time.Time
+	modTime     time.Time
+	size        int64
+	roots       *x509.CertPool
+	trustedKeys map[string]libtrust.PublicKey
+}
+
+func newCertBundle(path string, interval time.Duration) (*certBundle, error) {
+	b := &certBundle{
+		path:     path,
+		interval: interval,
+	}
+	if _, err := b.reload(); err != nil {
+		return nil, err
+	}
+	b.checked = time.Now()
+	return b, nil
+}
+
+// get returns the root certificates and their public keys, reloading the
+// bundle if it changed on disk. A bundle which fails to reload is reported
+// and its previous certificates are kept.
+func (b *certBundle) get() (*x509.CertPool, map[string]libtrust.PublicKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Since(b.checked) >= b.interval {
+		b.checked = time.Now()
+		reloaded, err := b.reload()
+		if err != nil {
+			log.Errorf("error reloading token auth root certificate bundle %q, keeping its previous certificates: %v", b.path, err)
+		} else if reloaded {
+			log.Infof("reloaded token auth root certificate bundle %q", b.path)
+		}
+	}
+	return b.roots, b.trustedKeys
+}
+
+// reload parses the bundle if it changed since it was last parsed, and
+// returns whether it did.
+func (b *certBundle) reload() (bool, error) {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", b.path, err)
+	}
+	if fi.ModTime().Equal(b.modTime) && fi.Size() == b.size {
+		return false, nil
+	}
+
+	rawCertBundle, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return false, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", b.path, err)
+	}
+
+	var rootCerts []*x509.Certificate
+	pemBlock, rawCertBundle := pem.Decode(rawCertBundle)
+	for pemBlock != nil {
+		cert, err := x509.ParseCertificate(pemBlock.Bytes)
+		if err != nil {
+			return false, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+		}
+
+		rootCerts = append(rootCerts, cert)
+
+		pemBlock, rawCertBundle = pem.Decode(rawCertBundle)
+	}
+
+	if len(rootCerts) == 0 {
+		return false, errors.New("token auth requires at least one token signing root certificate")
+	}
+
+	rootPool := x509.NewCertPool()
+	trustedKeys := make(map[string]libtrust.PublicKey, len(rootCerts))
+	for _, rootCert := range rootCerts {
+		rootPool.AddCert(rootCert)
+		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
+		if err != nil {
+			return false, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+		}
+		trustedKeys[pubKey.KeyID()] = pubKey
+	}
+
+	b.roots = rootPool
+	b.trustedKeys = trustedKeys
+	b.modTime = fi.ModTime()
+	b.size = fi.Size()
+	return true, nil
+}
+
+// jwksCache caches a JSON Web Key Set fetched from a URL.
+type jwksCache struct {
+	url        string
+	client     *http.Client
+	refresh    time.Duration
+	minRefresh time.Duration
+
+	// mu is never held while fetching the set. fetching is closed once the
+	// fetch in flight, if any, completes.
+	mu          sync.Mutex
+	fetching    chan struct{}
+	fetched     time.Time
+	expires     time.Time
+	trustedKeys map[string]libtrust.PublicKey
+}
+
+func newJWKSCache(url string, refresh time.Duration) *jwksCache {
+	c := &jwksCache{
+		url:        url,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		refresh:    refresh,
+		minRefresh: minJWKSRefresh,
+	}
+
+	now := time.Now()
+	c.fetched = now
+	trustedKeys, ttl, err := c.fetch()
+	if err != nil {
+		// the issuer may come up after the registry
+		log.Warnf("unable to fetch token auth key set %q, retrying on demand: %v", url, err)
+		c.expires = now.Add(c.minRefresh)
+		return c
+	}
+	c.trustedKeys = trustedKeys
+	c.expires = now.Add(ttl)
+	return c
+}
+
+// get returns the keys of the set, indexed by key ID, fetching the set again
+// if it expired, or if early is set and it was fetched long enough ago. A set
+// which fails to be fetched is reported and its previous keys are kept.
+//
+// Only one fetch is in flight at a time. Meanwhile, the previous keys are
+// returned, unless early is set or there are none yet, in which case the
+// fetch is waited for.
+func (c *jwksCache) get(early bool) map[string]libtrust.PublicKey {
+	c.mu.Lock()
+	if fetching := c.fetching; fetching != nil {
+		trustedKeys := c.trustedKeys
+		c.mu.Unlock()
+		if trustedKeys != nil && !early {
+			return trustedKeys
+		}
+
+		<-fetching
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.trustedKeys
+	}
+
+	now := time.Now()
+	if !now.After(c.expires) && (!early || now.Sub(c.fetched) < c.minRefresh) {
+		defer c.mu.Unlock()
+		return c.trustedKeys
+	}
+
+	done := make(chan struct{})
+	c.fetching = done
+	c.fetched = now
+	// retry failures no sooner than early fetches
+	c.expires = now.Add(c.minRefresh)
+	c.mu.Unlock()
+
+	trustedKeys, ttl, err := c.fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		log.Errorf("unable to fetch token auth key set %q, keeping its previous keys: %v", c.url, err)
+	} else {
+		c.trustedKeys = trustedKeys
+		c.expires = now.Add(ttl)
+	}
+	c.fetching = nil
+	close(done)
+	return c.trustedKeys
+}
+
+var maxAgeRegexp = regexp.MustCompile(`(?:^|[ ,])max-age=(\d+)`)
+
+// fetch fetches the key set, and returns it along with how long to cache it:
+// the max-age of the response, or the refresh interval.
+func (c *jwksCache) fetch() (map[string]libtrust.PublicKey, time.Duration, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	trustedKeys, err := parseJWKS(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ttl := c.refresh
+	if m := maxAgeRegexp.FindStringSubmatch(resp.Header.Get("Cache-Control")); m != nil {
+		if seconds, err := strconv.Atoi(m[1]); err == nil {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	if ttl < c.minRefresh {
+		ttl = c.minRefresh
+	}
+	return trustedKeys, ttl, nil
+}
+
+// parseJWKS parses a JSON Web Key Set. The keys are indexed both by their
+// libtrust key ID and by the "kid" of the set, which issuers other than
+// libtrust choose freely. Keys which are not for signatures or of
+// unsupported types are skipped.
+func parseJWKS(data []byte) (map[string]libtrust.PublicKey, error) {
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to decode key set: %v", err)
+	}
+
+	trustedKeys := make(map[string]libtrust.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if use, ok := jwk["use"].(string); ok && use != "sig" {
+			continue
+		}
+		kid, _ := jwk["kid"].(string)
+		// libtrust requires the kid to be its own key ID
+		delete(jwk, "kid")
+
+		raw, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := libtrust.UnmarshalPublicKeyJWK(raw)
+		if err != nil {
+			log.Warnf("skipping key %q of token auth key set: %v", kid, err)
+			continue
+		}
+
+		trustedKeys[pubKey.KeyID()] = pubKey
+		if kid != "" {
+			trustedKeys[kid] = pubKey
+		}
+	}
+
+	if len(trustedKeys) == 0 {
+		return nil, errors.New("key set holds no usable key")
+	}
+	return trustedKeys, nil
+}
+
+// trustedIssuer is an issuer whose tokens are accepted for its audiences,
+// when signed by the keys of its root certificate bundle or key set.
+type trustedIssuer struct {
+	name      string
+	audiences []string
+	bundle    *certBundle
+	jwks      *jwksCache
+}
+
+// verifyOptions returns the options verifying the tokens of the issuer. Its
+// key set is fetched early if requested.
+func (ti *trustedIssuer) verifyOptions(early bool) VerifyOptions {
+	opts := VerifyOptions{
+		TrustedIssuers:    []string{ti.name},
+		AcceptedAudiences: ti.audiences,
+		// never the system roots
+		Roots: x509.NewCertPool(),
+	}
+
+	var bundleKeys, jwksKeys map[string]libtrust.PublicKey
+	if ti.bundle != nil {
+		opts.Roots, bundleKeys = ti.bundle.get()
+	}
+	if ti.jwks != nil {
+		jwksKeys = ti.jwks.get(early)
+	}
+
+	switch {
+	case len(jwksKeys) == 0:
+		opts.TrustedKeys = bundleKeys
+	case len(bundleKeys) == 0:
+		opts.TrustedKeys = jwksKeys
+	default:
+		opts.TrustedKeys = make(map[string]libtrust.PublicKey, len(bundleKeys)+len(jwksKeys))
+		for id, key := range bundleKeys {
+			opts.TrustedKeys[id] = key
+		}
+		for id, key := range jwksKeys {
+			opts.TrustedKeys[id] = key
+		}
+	}
+	return opts
+}
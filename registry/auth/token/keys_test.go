@@ -0,0 +1,257 @@
+package token
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/libtrust"
+)
+
+// makeKeyIDToken makes a token naming its signing key with a key ID, as
+// issuers publishing key sets do.
+func makeKeyIDToken(t *testing.T, issuer, audience string, key libtrust.PrivateKey, keyID string) string {
+	header, err := json.Marshal(Header{Type: "JWT", SigningAlg: "ES256", KeyID: keyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims, err := json.Marshal(ClaimSet{
+		Issuer:     issuer,
+		Subject:    "foo",
+		Audience:   audience,
+		Expiration: now.Add(5 * time.Minute).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		Access:     []*ResourceActions{{Type: "repository", Name: "foo/bar", Actions: []string{"pull"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodingToSign := fmt.Sprintf("%s.%s", joseBase64UrlEncode(header), joseBase64UrlEncode(claims))
+	signature, _, err := key.Sign(strings.NewReader(encodingToSign), crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s.%s", encodingToSign, joseBase64UrlEncode(signature))
+}
+
+// jwksServer serves the public keys it holds under the given key IDs.
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string]libtrust.PublicKey
+	fetches int
+}
+
+func newJWKSServer() *jwksServer {
+	s := &jwksServer{keys: make(map[string]libtrust.PublicKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+
+		var keys []map[string]interface{}
+		for kid, key := range s.keys {
+			raw, _ := key.MarshalJSON()
+			var jwk map[string]interface{}
+			json.Unmarshal(raw, &jwk)
+			jwk["kid"] = kid
+			jwk["use"] = "sig"
+			keys = append(keys, jwk)
+		}
+		keys = append(keys, map[string]interface{}{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"})
+
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	return s
+}
+
+func (s *jwksServer) setKey(kid string, key libtrust.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func TestMultipleIssuers(t *testing.T) {
+	rootKeys, err := makeRootKeys(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	jwks := newJWKSServer()
+	defer jwks.Close()
+	jwks.setKey("key-1", rootKeys[1].PublicKey())
+
+	service := "registry.example.com"
+	controller, err := newAccessController(map[string]interface{}{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         "legacy-issuer",
+		"service":        service,
+		"rootcertbundle": rootCertBundleFilename,
+		"issuers": []interface{}{
+			map[interface{}]interface{}{
+				"issuer":    "sso-issuer",
+				"audiences": []interface{}{service, "mirror.example.com"},
+				"jwks":      jwks.URL,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := controller.(*accessController)
+	ac.issuers["sso-issuer"].jwks.minRefresh = 0
+
+	access := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	authorize := func(rawToken string) error {
+		req, err := http.NewRequest("GET", "http://example.com/v2/foo/bar/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+rawToken)
+		_, err = ac.Authorized(context.WithRequest(context.Background(), req), access)
+		return err
+	}
+
+	if err := authorize(makeKeyIDToken(t, "legacy-issuer", service, rootKeys[0], rootKeys[0].KeyID())); err != nil {
+		t.Fatalf("unexpected error authorizing a token of the bundle: %v", err)
+	}
+	if err := authorize(makeKeyIDToken(t, "sso-issuer", "mirror.example.com", rootKeys[1], "key-1")); err != nil {
+		t.Fatalf("unexpected error authorizing a token of the key set: %v", err)
+	}
+	if err := authorize(makeKeyIDToken(t, "sso-issuer", "other.example.com", rootKeys[1], "key-1")); err == nil {
+		t.Fatalf("expected a token for another audience to be rejected")
+	}
+	if err := authorize(makeKeyIDToken(t, "legacy-issuer", "mirror.example.com", rootKeys[0], rootKeys[0].KeyID())); err == nil {
+		t.Fatalf("expected a token for an audience of another issuer to be rejected")
+	}
+	// each issuer only trusts its own keys
+	if err := authorize(makeKeyIDToken(t, "legacy-issuer", service, rootKeys[1], "key-1")); err == nil {
+		t.Fatalf("expected a token signed with a key of another issuer to be rejected")
+	}
+	if err := authorize(makeKeyIDToken(t, "unknown-issuer", service, rootKeys[0], rootKeys[0].KeyID())); err == nil {
+		t.Fatalf("expected a token of an unknown issuer to be rejected")
+	}
+
+	// the key set is cached, and fetched again for unknown keys
+	jwks.setKey("key-2", rootKeys[2].PublicKey())
+	fetches := jwks.fetches
+	if err := authorize(makeKeyIDToken(t, "sso-issuer", service, rootKeys[1], "key-1")); err != nil {
+		t.Fatalf("unexpected error authorizing a token of the key set: %v", err)
+	}
+	if jwks.fetches != fetches {
+		t.Fatalf("expected the key set to be cached")
+	}
+	if err := authorize(makeKeyIDToken(t, "sso-issuer", service, rootKeys[2], "key-2")); err != nil {
+		t.Fatalf("unexpected error authorizing a token of a rotated key: %v", err)
+	}
+	if jwks.fetches != fetches+1 {
+		t.Fatalf("expected the key set to be fetched again, got %d fetches", jwks.fetches-fetches)
+	}
+	if err := authorize(makeKeyIDToken(t, "sso-issuer", service, rootKeys[3], "key-3")); err == nil {
+		t.Fatalf("expected a token signed with an unknown key to be rejected")
+	}
+
+	// the bundle is reloaded when it changes on disk
+	ac.issuers["legacy-issuer"].bundle.interval = 0
+	rotated, err := writeTempRootCerts(rootKeys[3:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotated)
+	if err := os.Rename(rotated, rootCertBundleFilename); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(rootCertBundleFilename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(makeKeyIDToken(t, "legacy-issuer", service, rootKeys[3], rootKeys[3].KeyID())); err != nil {
+		t.Fatalf("unexpected error authorizing a token of the reloaded bundle: %v", err)
+	}
+	if err := authorize(makeKeyIDToken(t, "legacy-issuer", service, rootKeys[0], rootKeys[0].KeyID())); err == nil {
+		t.Fatalf("expected a token signed with a key removed from the bundle to be rejected")
+	}
+}
+
+func TestIssuerOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"realm": "r", "service": "s", "issuer": "i"},
+		{"realm": "r", "service": "s", "issuers": []interface{}{map[interface{}]interface{}{"issuer": "i"}}},
+		{"realm": "r", "service": "s", "issuers": []interface{}{"i"}},
+		{"realm": "r", "service": "s", "issuer": "i", "jwks": "http://127.0.0.1:0", "audiences": "s"},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected options %v to be rejected", options)
+		}
+	}
+}
+
+func TestJWKSCacheServesDuringFetch(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSServer()
+	defer jwks.Close()
+	jwks.setKey("key-1", rootKeys[0].PublicKey())
+
+	c := newJWKSCache(jwks.URL, time.Hour)
+	c.minRefresh = 0
+	if c.get(false)["key-1"] == nil {
+		t.Fatalf("expected the key set to be fetched")
+	}
+
+	// the issuer hangs while the key set is fetched early
+	jwks.mu.Lock()
+	fetched := make(chan map[string]libtrust.PublicKey)
+	go func() {
+		fetched <- c.get(true)
+	}()
+	for {
+		c.mu.Lock()
+		fetching := c.fetching != nil
+		c.mu.Unlock()
+		if fetching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	served := make(chan map[string]libtrust.PublicKey)
+	go func() {
+		served <- c.get(false)
+	}()
+	select {
+	case keys := <-served:
+		if keys["key-1"] == nil {
+			t.Fatalf("expected the cached keys to be served during the fetch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the cached keys were not served during the fetch")
+	}
+
+	jwks.mu.Unlock()
+	if keys := <-fetched; keys["key-1"] == nil {
+		t.Fatalf("unexpected keys after the fetch: %v", keys)
+	}
+}
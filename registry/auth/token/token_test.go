@@ -384,4 +384,31 @@ func TestAccessController(t *testing.T) {
 	if userInfo.Name != "foo" {
 		t.Fatalf("expected user name %q, got %q", "foo", userInfo.Name)
 	}
+
+	// 5. The grants of the token only hold the access it names.
+	provider, ok := accessController.(auth.GrantsProvider)
+	if !ok {
+		t.Fatal("token accessController does not provide grants")
+	}
+
+	_, grants, err := provider.Grants(ctx)
+	if err != nil {
+		t.Fatalf("accessController returned unexpected error: %s", err)
+	}
+
+	if !grants.Allowed(testAccess) {
+		t.Fatalf("expected grants to allow %v", testAccess)
+	}
+
+	otherAccess := testAccess
+	otherAccess.Name = "qux"
+	if grants.Allowed(otherAccess) {
+		t.Fatalf("expected grants not to allow %v", otherAccess)
+	}
+
+	// 6. An invalid token has no grants.
+	req.Header.Set("Authorization", "Bearer invalid")
+	if _, _, err := provider.Grants(ctx); err == nil {
+		t.Fatal("expected error getting grants of an invalid token")
+	}
 }
@@ -0,0 +1,68 @@
+package tokenserver
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/registry/auth"
+	// the htpasswd backend is built on the htpasswd access controller
+	_ "github.com/docker/distribution/registry/auth/htpasswd"
+)
+
+// BackendInitFunc is the type of a credential backend factory function and
+// is used to register the constructors of the different backends. Refresh
+// tokens are only issued to the users of the backends which also implement
+// auth.UserLookup, so that refreshing checks the user still exists.
+type BackendInitFunc func(options map[string]interface{}) (auth.CredentialAuthenticator, error)
+
+var backends = make(map[string]BackendInitFunc)
+
+// RegisterBackend is used to register a BackendInitFunc for a credential
+// backend with the given name.
+func RegisterBackend(name string, initFunc BackendInitFunc) error {
+	if _, exists := backends[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	backends[name] = initFunc
+
+	return nil
+}
+
+// GetBackend constructs a credential backend with the given options using
+// the named backend.
+func GetBackend(name string, options map[string]interface{}) (auth.CredentialAuthenticator, error) {
+	if initFunc, exists := backends[name]; exists {
+		return initFunc(options)
+	}
+
+	return nil, fmt.Errorf("no token server backend registered with name: %s", name)
+}
+
+// newHTPasswdBackend authenticates users against an htpasswd file, which is
+// reloaded when it changes like with the htpasswd access controller.
+func newHTPasswdBackend(options map[string]interface{}) (auth.CredentialAuthenticator, error) {
+	opts := map[string]interface{}{"realm": "token-server"}
+	for k, v := range options {
+		opts[k] = v
+	}
+
+	ac, err := auth.GetAccessController("htpasswd", opts)
+	if err != nil {
+		return nil, err
+	}
+	authenticator, ok := ac.(auth.CredentialAuthenticator)
+	if !ok {
+		return nil, fmt.Errorf("htpasswd access controller does not authenticate credentials")
+	}
+	if _, ok := ac.(auth.UserLookup); !ok {
+		return nil, fmt.Errorf("htpasswd access controller does not look users up")
+	}
+	return authenticator, nil
+}
+
+func init() {
+	RegisterBackend("htpasswd", newHTPasswdBackend)
+	RegisterBackend("static", newStaticBackend)
+	RegisterBackend("ldap", newLDAPBackend)
+	RegisterBackend("robot", newRobotBackend)
+}
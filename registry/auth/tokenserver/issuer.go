@@ -0,0 +1,257 @@
+package tokenserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/docker/libtrust"
+)
+
+// issuer signs tokens with the first of its keys, and publishes the public
+// keys of all of them so that tokens signed with a previous key stay valid
+// while keys are rotated. The key files are reloaded when they change on
+// disk.
+type issuer struct {
+	name  string
+	paths []string
+
+	// interval is the minimum time between two checks of the key files.
+	interval time.Duration
+
+	mu          sync.Mutex
+	checked     time.Time
+	modTimes    []time.Time
+	signingKey  libtrust.PrivateKey
+	trustedKeys map[string]libtrust.PublicKey
+}
+
+func newIssuer(name string, paths []string, interval time.Duration) (*issuer, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("token server requires at least one signing key")
+	}
+
+	is := &issuer{
+		name:     name,
+		paths:    paths,
+		interval: interval,
+	}
+	if _, err := is.reload(); err != nil {
+		return nil, err
+	}
+	is.checked = time.Now()
+	return is, nil
+}
+
+// keys returns the current signing key and the trusted public keys, indexed
+// by key ID, reloading the key files if they changed on disk. Key files which
+// fail to reload are reported and the previous keys are kept.
+func (is *issuer) keys() (libtrust.PrivateKey, map[string]libtrust.PublicKey) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if time.Since(is.checked) >= is.interval {
+		is.checked = time.Now()
+		reloaded, err := is.reload()
+		if err != nil {
+			logrus.Errorf("token server: error reloading the keys, keeping the previous keys: %v", err)
+		} else if reloaded {
+			logrus.Infof("token server: reloaded the keys, signing with %s", is.signingKey.KeyID())
+		}
+	}
+	return is.signingKey, is.trustedKeys
+}
+
+// reload loads the key files if one of them changed since they were last
+// loaded, and returns whether it did. The first file holds the signing key
+// and the others either private or public keys.
+func (is *issuer) reload() (bool, error) {
+	modTimes := make([]time.Time, len(is.paths))
+	changed := len(is.modTimes) != len(is.paths)
+	for i, path := range is.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = fi.ModTime()
+		changed = changed || !modTimes[i].Equal(is.modTimes[i])
+	}
+	if !changed {
+		return false, nil
+	}
+
+	signingKey, err := libtrust.LoadKeyFile(is.paths[0])
+	if err != nil {
+		return false, fmt.Errorf("unable to load signing key %s: %v", is.paths[0], err)
+	}
+	publicKeys := []libtrust.PublicKey{signingKey.PublicKey()}
+	for _, path := range is.paths[1:] {
+		var publicKey libtrust.PublicKey
+		if privateKey, err := libtrust.LoadKeyFile(path); err == nil {
+			publicKey = privateKey.PublicKey()
+		} else if publicKey, err = libtrust.LoadPublicKeyFile(path); err != nil {
+			return false, fmt.Errorf("unable to load key %s: %v", path, err)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	trustedKeys := make(map[string]libtrust.PublicKey, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		trustedKeys[publicKey.KeyID()] = publicKey
+	}
+
+	is.signingKey = signingKey
+	is.trustedKeys = trustedKeys
+	is.modTimes = modTimes
+	return true, nil
+}
+
+// jwks returns the JSON Web Key Set of the public keys.
+func (is *issuer) jwks() ([]byte, error) {
+	_, trustedKeys := is.keys()
+
+	keys := make([]libtrust.PublicKey, 0, len(trustedKeys))
+	for _, key := range trustedKeys {
+		keys = append(keys, key)
+	}
+	sort.Sort(publicKeysByID(keys))
+
+	return json.Marshal(struct {
+		Keys []libtrust.PublicKey `json:"keys"`
+	}{Keys: keys})
+}
+
+type publicKeysByID []libtrust.PublicKey
+
+func (keys publicKeysByID) Len() int           { return len(keys) }
+func (keys publicKeysByID) Less(i, j int) bool { return keys[i].KeyID() < keys[j].KeyID() }
+func (keys publicKeysByID) Swap(i, j int)      { keys[i], keys[j] = keys[j], keys[i] }
+
+// createJWT creates and signs a JSON Web Token for the given subject and
+// audience with the granted access, expiring after the given duration.
+func (is *issuer) createJWT(subject, audience string, grantedAccessList []auth.Access, expiration time.Duration) (string, error) {
+	// Make a set of access entries to put in the token's claimset.
+	resourceActionSets := make(map[auth.Resource]map[string]struct{}, len(grantedAccessList))
+	var resources []auth.Resource
+	for _, access := range grantedAccessList {
+		actionSet, exists := resourceActionSets[access.Resource]
+		if !exists {
+			actionSet = map[string]struct{}{}
+			resourceActionSets[access.Resource] = actionSet
+			resources = append(resources, access.Resource)
+		}
+		actionSet[access.Action] = struct{}{}
+	}
+
+	accessEntries := make([]*token.ResourceActions, 0, len(resourceActionSets))
+	for _, resource := range resources {
+		actions := make([]string, 0, len(resourceActionSets[resource]))
+		for action := range resourceActionSets[resource] {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+
+		accessEntries = append(accessEntries, &token.ResourceActions{
+			Type:    resource.Type,
+			Name:    resource.Name,
+			Actions: actions,
+		})
+	}
+
+	randomBytes := make([]byte, 15)
+	if _, err := io.ReadFull(rand.Reader, randomBytes); err != nil {
+		return "", err
+	}
+	randomID := base64.URLEncoding.EncodeToString(randomBytes)
+
+	signingKey, _ := is.keys()
+
+	var alg string
+	switch signingKey.KeyType() {
+	case "RSA":
+		alg = "RS256"
+	case "EC":
+		alg = "ES256"
+	default:
+		return "", fmt.Errorf("unsupported signing key type %q", signingKey.KeyType())
+	}
+
+	// the key ID is enough for registries trusting the certificate of the
+	// key, or the published key set
+	joseHeader := token.Header{
+		Type:       "JWT",
+		SigningAlg: alg,
+		KeyID:      signingKey.KeyID(),
+	}
+
+	now := time.Now()
+	claimSet := token.ClaimSet{
+		Issuer:     is.name,
+		Subject:    subject,
+		Audience:   audience,
+		Expiration: now.Add(expiration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      randomID,
+
+		Access: accessEntries,
+	}
+
+	joseHeaderBytes, err := json.Marshal(joseHeader)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode jose header: %s", err)
+	}
+	claimSetBytes, err := json.Marshal(claimSet)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode claim set: %s", err)
+	}
+
+	encodingToSign := fmt.Sprintf("%s.%s", joseBase64Encode(joseHeaderBytes), joseBase64Encode(claimSetBytes))
+
+	signatureBytes, _, err := signingKey.Sign(strings.NewReader(encodingToSign), crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign jwt payload: %s", err)
+	}
+
+	return fmt.Sprintf("%s.%s", encodingToSign, joseBase64Encode(signatureBytes)), nil
+}
+
+// verifyJWT parses a token signed by one of the keys of the issuer and
+// verifies it was intended for the audience.
+func (is *issuer) verifyJWT(rawToken, audience string) (*token.Token, error) {
+	t, err := token.NewToken(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty pool of roots, rather than the system roots, so that only
+	// the keys of the issuer are trusted
+	_, trustedKeys := is.keys()
+	err = t.Verify(token.VerifyOptions{
+		TrustedIssuers:    []string{is.name},
+		AcceptedAudiences: []string{audience},
+		Roots:             x509.NewCertPool(),
+		TrustedKeys:       trustedKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func joseBase64Encode(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
@@ -0,0 +1,232 @@
+package tokenserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/registry/auth"
+)
+
+// defaultLDAPTimeout bounds the time of a bind against the LDAP server.
+const defaultLDAPTimeout = 10 * time.Second
+
+// ldapBackend authenticates users with an LDAP simple bind of the DN built
+// from the name of the user. It speaks just enough of LDAPv3 to bind, so
+// that the directory stays the authority on passwords.
+// It can not tell whether a user exists without their password, so its users
+// are not issued refresh tokens.
+type ldapBackend struct {
+	addr    string
+	bindDN  string // with a %s for the escaped name of the user
+	tls     *tls.Config
+	timeout time.Duration
+}
+
+func newLDAPBackend(options map[string]interface{}) (auth.CredentialAuthenticator, error) {
+	addr, ok := options["addr"].(string)
+	if !ok {
+		return nil, fmt.Errorf(`"addr" must be set for the ldap token server backend`)
+	}
+	bindDN, ok := options["binddn"].(string)
+	if !ok || strings.Count(bindDN, "%s") != 1 {
+		return nil, fmt.Errorf(`"binddn" must be set to a DN with a single %%s for the ldap token server backend`)
+	}
+
+	b := &ldapBackend{
+		addr:    addr,
+		bindDN:  bindDN,
+		timeout: defaultLDAPTimeout,
+	}
+	if useTLS, ok := options["tls"].(bool); ok && useTLS {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldap address %q: %v", addr, err)
+		}
+		insecure, _ := options["insecureskipverify"].(bool)
+		b.tls = &tls.Config{ServerName: host, InsecureSkipVerify: insecure}
+	}
+	if timeout, present := options["timeout"]; present {
+		d, err := time.ParseDuration(fmt.Sprint(timeout))
+		if err != nil {
+			return nil, fmt.Errorf(`"timeout" must be a duration for the ldap token server backend: %v`, err)
+		}
+		b.timeout = d
+	}
+	return b, nil
+}
+
+// LDAP result codes, see RFC 4511 section 4.1.9.
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+func (b *ldapBackend) AuthenticateUser(username, password string) error {
+	// an empty password would be an unauthenticated bind, which servers
+	// accept without checking anything
+	if username == "" || password == "" {
+		return auth.ErrAuthenticationFailure
+	}
+
+	conn, err := net.DialTimeout("tcp", b.addr, b.timeout)
+	if err != nil {
+		return fmt.Errorf("ldap: %v", err)
+	}
+	if b.tls != nil {
+		conn = tls.Client(conn, b.tls)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(b.timeout))
+
+	dn := fmt.Sprintf(b.bindDN, escapeDN(username))
+	if _, err := conn.Write(ldapBindRequest(1, dn, password)); err != nil {
+		return fmt.Errorf("ldap: %v", err)
+	}
+
+	resultCode, err := readLDAPBindResponse(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("ldap: %v", err)
+	}
+
+	// unbind, the server closes the connection without answering
+	conn.Write(berTLV(0x30, append(berTLV(0x02, []byte{2}), 0x42, 0x00)))
+
+	switch resultCode {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return auth.ErrAuthenticationFailure
+	default:
+		return fmt.Errorf("ldap: bind of %q failed with result code %d", dn, resultCode)
+	}
+}
+
+// ldapBindRequest encodes the LDAPMessage of a simple bind request.
+func ldapBindRequest(messageID byte, dn, password string) []byte {
+	var bind []byte
+	bind = append(bind, berTLV(0x02, []byte{3})...) // version
+	bind = append(bind, berTLV(0x04, []byte(dn))...)
+	bind = append(bind, berTLV(0x80, []byte(password))...) // simple authentication
+
+	var message []byte
+	message = append(message, berTLV(0x02, []byte{messageID})...)
+	message = append(message, berTLV(0x60, bind)...) // [APPLICATION 0] BindRequest
+	return berTLV(0x30, message)
+}
+
+// readLDAPBindResponse reads the LDAPMessage of a bind response and returns
+// its result code.
+func readLDAPBindResponse(r *bufio.Reader) (int, error) {
+	tag, message, err := readBERTLV(r)
+	if err != nil {
+		return 0, err
+	}
+	if tag != 0x30 {
+		return 0, fmt.Errorf("unexpected message tag %#x", tag)
+	}
+
+	rd := bufio.NewReader(bytes.NewReader(message))
+	if tag, _, err = readBERTLV(rd); err != nil || tag != 0x02 {
+		return 0, errors.New("malformed message id")
+	}
+	tag, response, err := readBERTLV(rd)
+	if err != nil || tag != 0x61 { // [APPLICATION 1] BindResponse
+		return 0, errors.New("malformed bind response")
+	}
+
+	tag, resultCode, err := readBERTLV(bufio.NewReader(bytes.NewReader(response)))
+	if err != nil || tag != 0x0a || len(resultCode) == 0 {
+		return 0, errors.New("malformed result code")
+	}
+	code := 0
+	for _, b := range resultCode {
+		code = code<<8 | int(b)
+	}
+	return code, nil
+}
+
+// berTLV encodes a BER element of the given tag.
+func berTLV(tag byte, content []byte) []byte {
+	b := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	default:
+		// long form, with the length in as few bytes as possible
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		b = append(b, 0x80|byte(len(length)))
+		b = append(b, length...)
+	}
+	return append(b, content...)
+}
+
+// maxBERLength bounds the length of the elements read from LDAP servers, bind
+// responses being much shorter.
+const maxBERLength = 1 << 16
+
+// readBERTLV reads a BER element, with a definite length.
+func readBERTLV(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, fmt.Errorf("unsupported length of %d bytes", n)
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxBERLength {
+		return 0, nil, fmt.Errorf("element of %d bytes is too long", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// escapeDN escapes a value for an attribute of a DN, see RFC 4514 section
+// 2.4.
+func escapeDN(value string) string {
+	var b []byte
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b = append(b, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			b = append(b, []byte(fmt.Sprintf("\\%02x", c))...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,119 @@
+package tokenserver
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/docker/distribution/registry/auth"
+)
+
+// serveLDAP answers simple binds, accepting the password "secret" for the DN
+// "uid=frodo,ou=people,dc=example,dc=org".
+func serveLDAP(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			tag, message, err := readBERTLV(bufio.NewReader(conn))
+			if err != nil || tag != 0x30 {
+				t.Errorf("unexpected message: %#x %v", tag, err)
+				return
+			}
+
+			rd := bufio.NewReader(bytes.NewReader(message))
+			readBERTLV(rd) // message id
+			tag, request, err := readBERTLV(rd)
+			if err != nil || tag != 0x60 {
+				t.Errorf("unexpected bind request: %#x %v", tag, err)
+				return
+			}
+
+			rd = bufio.NewReader(bytes.NewReader(request))
+			readBERTLV(rd) // version
+			_, dn, _ := readBERTLV(rd)
+			_, password, _ := readBERTLV(rd)
+
+			resultCode := byte(ldapInvalidCredentials)
+			if string(dn) == "uid=frodo,ou=people,dc=example,dc=org" && string(password) == "secret" {
+				resultCode = ldapSuccess
+			}
+
+			var response []byte
+			response = append(response, berTLV(0x0a, []byte{resultCode})...)
+			response = append(response, berTLV(0x04, nil)...)
+			response = append(response, berTLV(0x04, nil)...)
+			var reply []byte
+			reply = append(reply, berTLV(0x02, []byte{1})...)
+			reply = append(reply, berTLV(0x61, response)...)
+			conn.Write(berTLV(0x30, reply))
+		}(conn)
+	}
+}
+
+func TestLDAPBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	defer l.Close()
+	go serveLDAP(t, l)
+
+	backend, err := GetBackend("ldap", map[string]interface{}{
+		"addr":   l.Addr().String(),
+		"binddn": "uid=%s,ou=people,dc=example,dc=org",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating backend: %v", err)
+	}
+
+	if err := backend.AuthenticateUser("frodo", "secret"); err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	for _, credentials := range [][2]string{
+		{"frodo", "baggins"},
+		{"frodo", ""},
+		{"frodo,ou=people", "secret"},
+	} {
+		if err := backend.AuthenticateUser(credentials[0], credentials[1]); err != auth.ErrAuthenticationFailure {
+			t.Fatalf("expected %v to fail authentication, got %v", credentials, err)
+		}
+	}
+
+	if _, err := GetBackend("ldap", map[string]interface{}{"addr": l.Addr().String(), "binddn": "uid=frodo"}); err == nil {
+		t.Fatalf("expected a bind DN without a %%s to be rejected")
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for value, expected := range map[string]string{
+		"frodo":        "frodo",
+		"a,b+c":        `a\,b\+c`,
+		"#frodo ":      `\#frodo\ `,
+		`back\slash=1`: `back\\slash\=1`,
+		"nul\x00":      `nul\00`,
+	} {
+		if escaped := escapeDN(value); escaped != expected {
+			t.Errorf("expected %q to be escaped as %q, got %q", value, expected, escaped)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	for length, expected := range map[int][]byte{
+		0x7f:     {0x04, 0x7f},
+		0x80:     {0x04, 0x81, 0x80},
+		0x100:    {0x04, 0x82, 0x01, 0x00},
+		0x10000:  {0x04, 0x83, 0x01, 0x00, 0x00},
+		0x123456: {0x04, 0x83, 0x12, 0x34, 0x56},
+	} {
+		encoded := berTLV(0x04, make([]byte, length))
+		if !bytes.Equal(encoded[:len(expected)], expected) || len(encoded) != len(expected)+length {
+			t.Errorf("unexpected encoding of a length of %#x: %x", length, encoded[:len(expected)])
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tokenserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/robot"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+// accessGranter is implemented by the credential backends whose users are
+// granted the access of their own scopes, rather than the access of the ACL.
+type accessGranter interface {
+	// grantedAccess returns the requested access granted to the user, and
+	// false if the user does not belong to the backend.
+	grantedAccess(user string, requestedAccessList []auth.Access) ([]auth.Access, bool, error)
+}
+
+// robotBackend authenticates the robot accounts kept in the storage of the
+// registries, whose user names are prefixed by robot.UserPrefix, and grants
+// them the scopes of their account:
+//
+//	type: robot
+//	options:
+//	  driver: filesystem
+//	  parameters:
+//	    rootdirectory: /var/lib/registry
+type robotBackend struct {
+	accounts distribution.RobotAccountService
+}
+
+var _ accessGranter = &robotBackend{}
+
+func newRobotBackend(options map[string]interface{}) (auth.CredentialAuthenticator, error) {
+	driverName, ok := options["driver"].(string)
+	if !ok {
+		return nil, fmt.Errorf(`"driver" must be set for the robot token server backend`)
+	}
+	parameters, err := stringMap(options["parameters"])
+	if err != nil {
+		return nil, fmt.Errorf(`"parameters" of the robot token server backend: %v`, err)
+	}
+
+	d, err := factory.Create(driverName, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("robot backend: %v", err)
+	}
+	return &robotBackend{accounts: storage.NewRobotAccountService(d, "", 0)}, nil
+}
+
+func (b *robotBackend) AuthenticateUser(username, password string) error {
+	if !strings.HasPrefix(username, robot.UserPrefix) {
+		return auth.ErrAuthenticationFailure
+	}
+
+	_, err := b.accounts.Authenticate(context.Background(), strings.TrimPrefix(username, robot.UserPrefix), password)
+	if err == distribution.ErrRobotAuthenticationFailure {
+		return auth.ErrAuthenticationFailure
+	}
+	return err
+}
+
+// UserExists returns true if the robot account exists and has not expired.
+func (b *robotBackend) UserExists(username string) (bool, error) {
+	if !strings.HasPrefix(username, robot.UserPrefix) {
+		return false, nil
+	}
+
+	account, err := b.accounts.Get(context.Background(), strings.TrimPrefix(username, robot.UserPrefix))
+	switch err.(type) {
+	case nil:
+		return !account.Expired(time.Now()), nil
+	case distribution.ErrRobotAccountUnknown:
+		return false, nil
+	}
+	return false, err
+}
+
+// grantedAccess reads the account again, so that refreshed tokens follow the
+// updates, expiry and revocation of the account.
+func (b *robotBackend) grantedAccess(user string, requestedAccessList []auth.Access) ([]auth.Access, bool, error) {
+	if !strings.HasPrefix(user, robot.UserPrefix) {
+		return nil, false, nil
+	}
+
+	account, err := b.accounts.Get(context.Background(), strings.TrimPrefix(user, robot.UserPrefix))
+	switch err.(type) {
+	case nil:
+	case distribution.ErrRobotAccountUnknown:
+		return nil, true, nil
+	default:
+		return nil, true, err
+	}
+	if account.Expired(time.Now()) {
+		return nil, true, nil
+	}
+
+	granted := make([]auth.Access, 0, len(requestedAccessList))
+	for _, access := range requestedAccessList {
+		if robot.Allowed(account, access) {
+			granted = append(granted, access)
+		}
+	}
+	return granted, true, nil
+}
+
+// stringMap converts the map of a YAML option to a map with string keys.
+func stringMap(value interface{}) (map[string]interface{}, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return value, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			m[key] = v
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("must be a map, not %T", value)
+}
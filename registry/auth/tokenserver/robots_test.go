@@ -0,0 +1,105 @@
+package tokenserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/filesystem"
+)
+
+func TestRobotBackend(t *testing.T) {
+	ts := newTestTokenServer(t)
+	defer ts.Close()
+
+	d := filesystem.New(filesystem.DriverParameters{
+		RootDirectory: filepath.Join(ts.dir, "storage"),
+		MaxThreads:    100,
+	})
+	accounts := storage.NewRobotAccountService(d, "test", 0)
+	secret, err := accounts.Create(context.Background(), distribution.RobotAccount{
+		Name:   "ci",
+		Scopes: []distribution.RobotScope{{Repository: "ci/*", Actions: []string{"pull"}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating robot account: %v", err)
+	}
+
+	getToken := func(username, password string) (int, getTokenResponse) {
+		u := ts.URL + "/token?" + url.Values{
+			"service":       []string{testService},
+			"scope":         []string{"repository:ci/app:pull,push", "repository:library:pull", "registry:admin:*"},
+			"offline_token": []string{"true"},
+		}.Encode()
+		req, _ := http.NewRequest("GET", u, nil)
+		req.SetBasicAuth(username, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error getting token: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response getTokenResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+		}
+		return resp.StatusCode, response
+	}
+
+	if status, _ := getToken("robot$ci", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong secret to be unauthorized, got %d", status)
+	}
+
+	status, response := getToken("robot$ci", secret)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status getting token: %d", status)
+	}
+	parsed, err := ts.verify(t, response.Token, testService)
+	if err != nil {
+		t.Fatalf("unexpected error verifying token: %v", err)
+	}
+	if parsed.Claims.Subject != "robot$ci" {
+		t.Fatalf("unexpected subject: %q", parsed.Claims.Subject)
+	}
+	// robot accounts are granted their scopes, not the ACL
+	checkAccess(t, parsed, []*token.ResourceActions{
+		{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+	})
+
+	refresh := func() int {
+		resp, err := http.PostForm(ts.URL+"/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {response.RefreshToken},
+			"service":       {testService},
+			"client_id":     {"test"},
+			"scope":         {"repository:ci/app:pull"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error refreshing token: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := refresh(); status != http.StatusOK {
+		t.Fatalf("unexpected status refreshing token: %d", status)
+	}
+
+	if err := accounts.Revoke(context.Background(), "ci"); err != nil {
+		t.Fatalf("unexpected error revoking robot account: %v", err)
+	}
+	if status, _ := getToken("robot$ci", secret); status != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked account to be unauthorized, got %d", status)
+	}
+	// refreshing checks the account again
+	if status := refresh(); status != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token of a revoked account to be unauthorized, got %d", status)
+	}
+}
@@ -0,0 +1,120 @@
+package tokenserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/acl"
+)
+
+// resolveScopeSpecifiers converts a list of scope specifiers from a token
+// request's `scope` query parameters into a list of standard access objects.
+func resolveScopeSpecifiers(ctx context.Context, scopeSpecs []string) []auth.Access {
+	var requestedAccessList []auth.Access
+	requestedAccessSet := make(map[auth.Access]struct{}, 2*len(scopeSpecs))
+
+	for _, scopeSpecifier := range scopeSpecs {
+		if scopeSpecifier == "" {
+			continue
+		}
+
+		// There should be 3 parts, separated by a `:` character, but the
+		// name may hold a port, as in repository:host:5000/name:pull.
+		i, j := strings.Index(scopeSpecifier, ":"), strings.LastIndex(scopeSpecifier, ":")
+		if i < 0 || i == j {
+			context.GetLogger(ctx).Infof("ignoring unsupported scope format %s", scopeSpecifier)
+			continue
+		}
+
+		resourceType, resourceName, actions := scopeSpecifier[:i], scopeSpecifier[i+1:j], scopeSpecifier[j+1:]
+
+		// Actions should be a comma-separated list of actions.
+		for _, action := range strings.Split(actions, ",") {
+			requestedAccess := auth.Access{
+				Resource: auth.Resource{
+					Type: resourceType,
+					Name: resourceName,
+				},
+				Action: action,
+			}
+
+			if _, exists := requestedAccessSet[requestedAccess]; !exists {
+				requestedAccessSet[requestedAccess] = struct{}{}
+				requestedAccessList = append(requestedAccessList, requestedAccess)
+			}
+		}
+	}
+
+	return requestedAccessList
+}
+
+// resolveScopeList converts a scope list from a token request's `scope`
+// form field into a list of standard access objects.
+func resolveScopeList(ctx context.Context, scopeList string) []auth.Access {
+	return resolveScopeSpecifiers(ctx, strings.Split(scopeList, " "))
+}
+
+// toScopeList converts a list of access to a scope list string.
+func toScopeList(access []auth.Access) string {
+	var s []string
+	for _, a := range access {
+		s = append(s, fmt.Sprintf("%s:%s:%s", a.Type, a.Name, a.Action))
+	}
+	return strings.Join(s, " ")
+}
+
+// requiredAction returns the action of the ACL granting the requested
+// access, or an empty string if the access is never granted. The registry
+// requests the "*" action of repositories for every destructive operation,
+// so only admins are granted it.
+func requiredAction(access auth.Access) string {
+	switch access.Type {
+	case "repository":
+		switch access.Action {
+		case "pull":
+			return acl.ActionPull
+		case "push":
+			return acl.ActionPush
+		case "*":
+			return acl.ActionAdmin
+		}
+	case "registry":
+		switch {
+		case access.Name == "catalog" && access.Action == "*":
+			return acl.ActionAdmin
+		case access.Name == "admin" && access.Action == "*":
+			// the admin api of the registry
+			return acl.ActionAdmin
+		}
+	}
+	return ""
+}
+
+// grantedAccess returns the requested access granted to the user. Users of
+// the backends granting their own scopes are granted these scopes. Without an
+// ACL, the other users are granted every requested access.
+func (s *Server) grantedAccess(user string, requestedAccessList []auth.Access) ([]auth.Access, error) {
+	for _, backend := range s.backends {
+		if granter, ok := backend.(accessGranter); ok {
+			if granted, ok, err := granter.grantedAccess(user, requestedAccessList); ok || err != nil {
+				return granted, err
+			}
+		}
+	}
+
+	if s.acl == nil {
+		return requestedAccessList, nil
+	}
+
+	a := s.acl.ACL()
+	grantedAccessList := make([]auth.Access, 0, len(requestedAccessList))
+	for _, access := range requestedAccessList {
+		action := requiredAction(access)
+		if action != "" && a.Allowed(user, access.Name, action) {
+			grantedAccessList = append(grantedAccessList, access)
+		}
+	}
+	return grantedAccessList, nil
+}
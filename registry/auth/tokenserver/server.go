@@ -0,0 +1,422 @@
+// Package tokenserver implements a token server issuing the JSON Web Tokens
+// accepted by the token access controller. Users authenticate against
+// pluggable credential backends, and an ACL selects the requested scopes
+// granted to them. The server implements the token flows of the Docker
+// registry token specification, including the OAuth2 password and refresh
+// token grants, and publishes its public keys as a JSON Web Key Set.
+package tokenserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/acl"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultExpiration        = 5 * time.Minute
+	defaultRefreshExpiration = 24 * time.Hour
+	defaultReloadInterval    = 5 * time.Second
+)
+
+// Options configures a token server.
+type Options struct {
+	// Issuer is the issuer of the tokens, which registries must trust.
+	Issuer string
+
+	// Realm is the realm of the basic authentication challenges, the
+	// issuer by default.
+	Realm string
+
+	// Expiration is the lifetime of the tokens, 5 minutes by default.
+	Expiration time.Duration
+
+	// RefreshExpiration is the lifetime of the refresh tokens, 24 hours by
+	// default.
+	RefreshExpiration time.Duration
+
+	// Keys lists the files of the keys. The first one signs the tokens,
+	// and all of them are published.
+	Keys []string
+
+	// ACL is the file of the ACL selecting the granted scopes. Without an
+	// ACL, users are granted every requested scope.
+	ACL string
+
+	// ReloadInterval is the minimum time between two checks of the key and
+	// ACL files for changes, 5 seconds by default.
+	ReloadInterval time.Duration
+
+	// Backends lists the credential backends, tried in order.
+	Backends []BackendOptions
+}
+
+// BackendOptions configures a credential backend.
+type BackendOptions struct {
+	Type    string
+	Options map[string]interface{}
+}
+
+// Server serves tokens on /token, and the public keys of the issuer as a JSON
+// Web Key Set on /jwks.
+type Server struct {
+	context.Context
+
+	issuer            *issuer
+	realm             string
+	expiration        time.Duration
+	refreshExpiration time.Duration
+	backends          []auth.CredentialAuthenticator
+	acl               *acl.File
+	router            *mux.Router
+}
+
+// New creates a token server from the options.
+func New(ctx context.Context, options Options) (*Server, error) {
+	if options.Issuer == "" {
+		return nil, errors.New("token server requires an issuer")
+	}
+	if len(options.Backends) == 0 {
+		return nil, errors.New("token server requires at least one credential backend")
+	}
+
+	s := &Server{
+		Context:           ctx,
+		realm:             options.Realm,
+		expiration:        options.Expiration,
+		refreshExpiration: options.RefreshExpiration,
+	}
+	if s.realm == "" {
+		s.realm = options.Issuer
+	}
+	if s.expiration <= 0 {
+		s.expiration = defaultExpiration
+	}
+	if s.refreshExpiration <= 0 {
+		s.refreshExpiration = defaultRefreshExpiration
+	}
+	interval := options.ReloadInterval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+
+	var err error
+	if s.issuer, err = newIssuer(options.Issuer, options.Keys, interval); err != nil {
+		return nil, err
+	}
+
+	for _, backend := range options.Backends {
+		b, err := GetBackend(backend.Type, backend.Options)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure token server backend %q: %v", backend.Type, err)
+		}
+		s.backends = append(s.backends, b)
+	}
+
+	if options.ACL != "" {
+		if s.acl, err = acl.OpenFile(options.ACL, interval); err != nil {
+			return nil, fmt.Errorf("unable to load token server acl: %v", err)
+		}
+	}
+
+	s.router = mux.NewRouter()
+	s.router.Path("/token").Methods("GET").Handler(s.handlerWithContext(s.getToken))
+	s.router.Path("/token").Methods("POST").Handler(s.handlerWithContext(s.postToken))
+	s.router.Path("/jwks").Methods("GET").Handler(s.handlerWithContext(s.getJWKS))
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// handlerWithContext wraps the given context-aware handler by setting up the
+// request context from the context of the server.
+func (s *Server) handlerWithContext(handler func(context.Context, http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithRequest(s, r)
+		logger := context.GetRequestLogger(ctx)
+		ctx = context.WithLogger(ctx, logger)
+
+		handler(ctx, w, r)
+	})
+}
+
+func handleError(ctx context.Context, err error, w http.ResponseWriter) {
+	ctx, w = context.WithResponseWriter(ctx, w)
+
+	if serveErr := errcode.ServeJSON(w, err); serveErr != nil {
+		context.GetResponseLogger(ctx).Errorf("error sending error response: %v", serveErr)
+		return
+	}
+
+	context.GetResponseLogger(ctx).Info("application error")
+}
+
+// authenticate checks the credentials against the backends in turn, and
+// returns the backend which authenticated the user.
+func (s *Server) authenticate(ctx context.Context, username, password string) (auth.CredentialAuthenticator, error) {
+	for _, backend := range s.backends {
+		err := backend.AuthenticateUser(username, password)
+		if err == nil {
+			return backend, nil
+		}
+		if err != auth.ErrAuthenticationFailure {
+			context.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
+		}
+	}
+	return nil, auth.ErrAuthenticationFailure
+}
+
+// userExists checks that a backend still knows the user, so that refresh
+// tokens stop working once their user is removed.
+func (s *Server) userExists(ctx context.Context, username string) bool {
+	for _, backend := range s.backends {
+		lookup, ok := backend.(auth.UserLookup)
+		if !ok {
+			continue
+		}
+		exists, err := lookup.UserExists(username)
+		if err != nil {
+			context.GetLogger(ctx).Errorf("error looking up user %q: %v", username, err)
+			continue
+		}
+		if exists {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshAudience is the audience of the refresh tokens of a service, which
+// no registry accepts as access tokens.
+func refreshAudience(service string) string {
+	return "refresh:" + service
+}
+
+// issue creates a token granting the subject the access requested to the
+// service, along with a refresh token if requested.
+func (s *Server) issue(ctx context.Context, subject, service string, requestedAccessList []auth.Access, offline bool) (token, refreshToken string, granted []auth.Access, err error) {
+	ctx = context.WithValue(ctx, "acctSubject", subject)
+	ctx = context.WithLogger(ctx, context.GetLogger(ctx, "acctSubject"))
+
+	context.GetLogger(ctx).Info("authenticated client")
+
+	ctx = context.WithValue(ctx, "requestedAccess", requestedAccessList)
+	ctx = context.WithLogger(ctx, context.GetLogger(ctx, "requestedAccess"))
+
+	if granted, err = s.grantedAccess(subject, requestedAccessList); err != nil {
+		return "", "", nil, err
+	}
+	ctx = context.WithValue(ctx, "grantedAccess", granted)
+	ctx = context.WithLogger(ctx, context.GetLogger(ctx, "grantedAccess"))
+
+	if token, err = s.issuer.createJWT(subject, service, granted, s.expiration); err != nil {
+		return "", "", nil, err
+	}
+	if offline {
+		if refreshToken, err = s.issuer.createJWT(subject, refreshAudience(service), nil, s.refreshExpiration); err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	context.GetLogger(ctx).Info("authorized client")
+	return token, refreshToken, granted, nil
+}
+
+type getTokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	IssuedAt     string `json:"issued_at,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// getToken handles authenticating the request with basic auth and
+// authorizing access to the requested scopes.
+func (s *Server) getToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	service := params.Get("service")
+	var offline bool
+	if offlineStr := params.Get("offline_token"); offlineStr != "" {
+		var err error
+		offline, err = strconv.ParseBool(offlineStr)
+		if err != nil {
+			handleError(ctx, ErrorCodeBadTokenOption.WithDetail(err), w)
+			return
+		}
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.realm))
+		handleError(ctx, errcode.ErrorCodeUnauthorized.WithDetail("invalid credentials"), w)
+		return
+	}
+	backend, err := s.authenticate(ctx, username, password)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.realm))
+		handleError(ctx, errcode.ErrorCodeUnauthorized.WithDetail("invalid credentials"), w)
+		return
+	}
+	if _, ok := backend.(auth.UserLookup); !ok {
+		// the user could not be checked when refreshing
+		offline = false
+	}
+
+	requestedAccessList := resolveScopeSpecifiers(ctx, params["scope"])
+	token, refreshToken, _, err := s.issue(ctx, username, service, requestedAccessList, offline)
+	if err != nil {
+		handleError(ctx, err, w)
+		return
+	}
+
+	response := getTokenResponse{
+		Token:        token,
+		AccessToken:  token,
+		ExpiresIn:    int(s.expiration.Seconds()),
+		IssuedAt:     time.Now().UTC().Format(time.RFC3339),
+		RefreshToken: refreshToken,
+	}
+
+	ctx, w = context.WithResponseWriter(ctx, w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+
+	context.GetResponseLogger(ctx).Info("get token complete")
+}
+
+type postTokenResponse struct {
+	Token        string `json:"access_token"`
+	Scope        string `json:"scope,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	IssuedAt     string `json:"issued_at,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// postToken handles the OAuth2 password and refresh token grants.
+func (s *Server) postToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	grantType := r.PostFormValue("grant_type")
+	if grantType == "" {
+		handleError(ctx, ErrorCodeMissingRequiredField.WithDetail("missing grant_type value"), w)
+		return
+	}
+
+	service := r.PostFormValue("service")
+	if service == "" {
+		handleError(ctx, ErrorCodeMissingRequiredField.WithDetail("missing service value"), w)
+		return
+	}
+
+	clientID := r.PostFormValue("client_id")
+	if clientID == "" {
+		handleError(ctx, ErrorCodeMissingRequiredField.WithDetail("missing client_id value"), w)
+		return
+	}
+
+	var offline bool
+	switch r.PostFormValue("access_type") {
+	case "", "online":
+	case "offline":
+		offline = true
+	default:
+		handleError(ctx, ErrorCodeUnsupportedValue.WithDetail("unknown access_type value"), w)
+		return
+	}
+
+	requestedAccessList := resolveScopeList(ctx, r.PostFormValue("scope"))
+
+	var subject string
+	var rToken string
+	switch grantType {
+	case "refresh_token":
+		rToken = r.PostFormValue("refresh_token")
+		if rToken == "" {
+			handleError(ctx, ErrorCodeUnsupportedValue.WithDetail("missing refresh_token value"), w)
+			return
+		}
+		t, err := s.issuer.verifyJWT(rToken, refreshAudience(service))
+		if err != nil {
+			handleError(ctx, errcode.ErrorCodeUnauthorized.WithDetail("invalid refresh token"), w)
+			return
+		}
+		subject = t.Claims.Subject
+		if !s.userExists(ctx, subject) {
+			handleError(ctx, errcode.ErrorCodeUnauthorized.WithDetail("invalid refresh token"), w)
+			return
+		}
+		// the refresh token stays valid, only a new one is not issued
+		offline = false
+	case "password":
+		subject = r.PostFormValue("username")
+		if subject == "" {
+			handleError(ctx, ErrorCodeUnsupportedValue.WithDetail("missing username value"), w)
+			return
+		}
+		password := r.PostFormValue("password")
+		if password == "" {
+			handleError(ctx, ErrorCodeUnsupportedValue.WithDetail("missing password value"), w)
+			return
+		}
+		backend, err := s.authenticate(ctx, subject, password)
+		if err != nil {
+			handleError(ctx, errcode.ErrorCodeUnauthorized.WithDetail("invalid credentials"), w)
+			return
+		}
+		if _, ok := backend.(auth.UserLookup); !ok {
+			// the user could not be checked when refreshing
+			offline = false
+		}
+	default:
+		handleError(ctx, ErrorCodeUnsupportedValue.WithDetail("unknown grant_type value"), w)
+		return
+	}
+
+	token, refreshToken, granted, err := s.issue(ctx, subject, service, requestedAccessList, offline)
+	if err != nil {
+		handleError(ctx, err, w)
+		return
+	}
+	if refreshToken != "" {
+		rToken = refreshToken
+	}
+
+	response := postTokenResponse{
+		Token:        token,
+		ExpiresIn:    int(s.expiration.Seconds()),
+		IssuedAt:     time.Now().UTC().Format(time.RFC3339),
+		Scope:        toScopeList(granted),
+		RefreshToken: rToken,
+	}
+
+	ctx, w = context.WithResponseWriter(ctx, w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+
+	context.GetResponseLogger(ctx).Info("post token complete")
+}
+
+// getJWKS serves the public keys of the issuer, so that registries can pick
+// up rotated keys without restarting.
+func (s *Server) getJWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	jwks, err := s.issuer.jwks()
+	if err != nil {
+		handleError(ctx, err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(jwks)
+}
@@ -0,0 +1,308 @@
+package tokenserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/docker/libtrust"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testService = "registry.example.com"
+
+type testTokenServer struct {
+	*httptest.Server
+	dir string
+}
+
+func newTestTokenServer(t *testing.T) *testTokenServer {
+	dir, err := ioutil.TempDir("", "tokenserver-test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+
+	for _, name := range []string{"signing.pem", "previous.pem"} {
+		key, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			t.Fatalf("error generating key: %v", err)
+		}
+		if err := libtrust.SaveKey(filepath.Join(dir, name), key); err != nil {
+			t.Fatalf("error saving key: %v", err)
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("baggins"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "users.yml"), "users:\n  frodo: "+string(hash)+"\n")
+	writeTestFile(t, filepath.Join(dir, "acl.yml"), `
+rules:
+  - users: [frodo]
+    repositories: ["ci/*"]
+    actions: [pull, push]
+  - users: ["*"]
+    repositories: [library]
+    actions: [pull]
+`)
+
+	s, err := New(context.Background(), Options{
+		Issuer:         "test-issuer",
+		Keys:           []string{filepath.Join(dir, "signing.pem"), filepath.Join(dir, "previous.pem")},
+		ACL:            filepath.Join(dir, "acl.yml"),
+		ReloadInterval: time.Nanosecond,
+		Backends: []BackendOptions{
+			{Type: "static", Options: map[string]interface{}{"path": filepath.Join(dir, "users.yml")}},
+			{Type: "robot", Options: map[string]interface{}{
+				"driver":     "filesystem",
+				"parameters": map[interface{}]interface{}{"rootdirectory": filepath.Join(dir, "storage")},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating token server: %v", err)
+	}
+
+	return &testTokenServer{Server: httptest.NewServer(s), dir: dir}
+}
+
+func (ts *testTokenServer) Close() {
+	ts.Server.Close()
+	os.RemoveAll(ts.dir)
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+}
+
+// verify verifies the token against the published keys of the server.
+func (ts *testTokenServer) verify(t *testing.T, rawToken, audience string) (*token.Token, error) {
+	resp, err := http.Get(ts.URL + "/jwks")
+	if err != nil {
+		t.Fatalf("unexpected error getting the key set: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading the key set: %v", err)
+	}
+	keys, err := libtrust.UnmarshalPublicKeyJWKSet(body)
+	if err != nil {
+		t.Fatalf("unexpected error parsing the key set: %v", err)
+	}
+	trustedKeys := make(map[string]libtrust.PublicKey)
+	for _, key := range keys {
+		trustedKeys[key.KeyID()] = key
+	}
+
+	parsed, err := token.NewToken(rawToken)
+	if err != nil {
+		t.Fatalf("unexpected error parsing token: %v", err)
+	}
+	return parsed, parsed.Verify(token.VerifyOptions{
+		TrustedIssuers:    []string{"test-issuer"},
+		AcceptedAudiences: []string{audience},
+		TrustedKeys:       trustedKeys,
+	})
+}
+
+func checkAccess(t *testing.T, parsed *token.Token, expected []*token.ResourceActions) {
+	if !reflect.DeepEqual(parsed.Claims.Access, expected) {
+		var actual []token.ResourceActions
+		for _, access := range parsed.Claims.Access {
+			actual = append(actual, *access)
+		}
+		t.Fatalf("unexpected access: %v", actual)
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	ts := newTestTokenServer(t)
+	defer ts.Close()
+
+	u := ts.URL + "/token?" + url.Values{
+		"service":       []string{testService},
+		"scope":         []string{"repository:ci/app:pull,push", "repository:other:pull", "repository:library:push,pull"},
+		"offline_token": []string{"true"},
+	}.Encode()
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("unexpected error getting token: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("expected a basic challenge, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	req, _ := http.NewRequest("GET", u, nil)
+	req.SetBasicAuth("frodo", "sam")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error getting token: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected invalid credentials to be unauthorized, got %d", resp.StatusCode)
+	}
+
+	req.SetBasicAuth("frodo", "baggins")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error getting token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status getting token: %d", resp.StatusCode)
+	}
+
+	var response getTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if response.Token == "" || response.Token != response.AccessToken || response.RefreshToken == "" {
+		t.Fatalf("unexpected response: %#v", response)
+	}
+
+	parsed, err := ts.verify(t, response.Token, testService)
+	if err != nil {
+		t.Fatalf("unexpected error verifying token: %v", err)
+	}
+	if parsed.Claims.Subject != "frodo" {
+		t.Fatalf("unexpected subject: %q", parsed.Claims.Subject)
+	}
+	checkAccess(t, parsed, []*token.ResourceActions{
+		{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+		{Type: "repository", Name: "library", Actions: []string{"pull"}},
+	})
+
+	// the refresh token is no access token for registries
+	if _, err := ts.verify(t, response.RefreshToken, testService); err == nil {
+		t.Fatalf("expected the refresh token to be rejected as an access token")
+	}
+}
+
+func TestPostToken(t *testing.T) {
+	ts := newTestTokenServer(t)
+	defer ts.Close()
+
+	post := func(form url.Values) (int, postTokenResponse) {
+		form.Set("service", testService)
+		form.Set("client_id", "test")
+		resp, err := http.PostForm(ts.URL+"/token", form)
+		if err != nil {
+			t.Fatalf("unexpected error posting token request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response postTokenResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+		}
+		return resp.StatusCode, response
+	}
+
+	status, _ := post(url.Values{"grant_type": {"password"}, "username": {"frodo"}, "password": {"sam"}})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected invalid credentials to be unauthorized, got %d", status)
+	}
+	status, _ = post(url.Values{"grant_type": {"client_credentials"}})
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected an unknown grant type to be rejected, got %d", status)
+	}
+
+	status, response := post(url.Values{
+		"grant_type":  {"password"},
+		"username":    {"frodo"},
+		"password":    {"baggins"},
+		"access_type": {"offline"},
+		"scope":       {"repository:ci/app:pull repository:other:pull"},
+	})
+	if status != http.StatusOK || response.RefreshToken == "" || response.Scope != "repository:ci/app:pull" {
+		t.Fatalf("unexpected response: %d %#v", status, response)
+	}
+
+	refreshToken := response.RefreshToken
+	status, response = post(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"scope":         {"repository:ci/app:push"},
+	})
+	if status != http.StatusOK || response.RefreshToken != refreshToken || response.Scope != "repository:ci/app:push" {
+		t.Fatalf("unexpected response: %d %#v", status, response)
+	}
+	parsed, err := ts.verify(t, response.Token, testService)
+	if err != nil {
+		t.Fatalf("unexpected error verifying token: %v", err)
+	}
+	if parsed.Claims.Subject != "frodo" {
+		t.Fatalf("unexpected subject: %q", parsed.Claims.Subject)
+	}
+
+	status, _ = post(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {response.Token}})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("expected an access token to be rejected as a refresh token, got %d", status)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ts := newTestTokenServer(t)
+	defer ts.Close()
+
+	keyIDs := func() []string {
+		resp, err := http.Get(ts.URL + "/jwks")
+		if err != nil {
+			t.Fatalf("unexpected error getting the key set: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		keys, err := libtrust.UnmarshalPublicKeyJWKSet(body)
+		if err != nil {
+			t.Fatalf("unexpected error parsing the key set: %v", err)
+		}
+		var ids []string
+		for _, key := range keys {
+			ids = append(ids, key.KeyID())
+		}
+		return ids
+	}
+
+	before := keyIDs()
+	if len(before) != 2 {
+		t.Fatalf("expected 2 published keys, got %v", before)
+	}
+
+	// rotate the signing key, the previous key stays published
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	path := filepath.Join(ts.dir, "signing.pem")
+	if err := libtrust.SaveKey(path, key); err != nil {
+		t.Fatalf("error saving key: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("could not change the times of %s: %v", path, err)
+	}
+
+	after := keyIDs()
+	if len(after) != 2 || (after[0] != key.KeyID() && after[1] != key.KeyID()) {
+		t.Fatalf("expected the rotated key to be published, got %v", after)
+	}
+}
@@ -22,9 +22,28 @@ import (
 	"github.com/docker/distribution/registry/storage/cache/memory"
 )
 
-// Registry provides an interface for calling Repositories, which returns a catalog of repositories.
+// Registry provides an interface for calling Repositories, which returns a catalog of repositories,
+// and CatalogInfo, which returns the info of the repositories in the catalog.
 type Registry interface {
 	Repositories(ctx context.Context, repos []string, last string) (n int, err error)
+	CatalogInfo(ctx context.Context, infos []distribution.ImageInfo, last string, options CatalogInfoOptions) (n int, err error)
+}
+
+// CatalogInfoOptions filters and orders the repositories listed by CatalogInfo.
+// Zero values leave the choice to the registry.
+type CatalogInfoOptions struct {
+	// Prefix only includes repositories with names starting with it.
+	Prefix string
+
+	// Contains only includes repositories with names containing it.
+	Contains string
+
+	// Sort is the sort key, one of name, lastModified, downloadCount or
+	// createTime.
+	Sort string
+
+	// Order is the sort order, asc or desc.
+	Order string
 }
 
 // checkHTTPRedirect is a callback that can manipulate redirected HTTP
@@ -131,6 +150,59 @@ func (r *registry) Repositories(ctx context.Context, entries []string, last stri
 	return numFilled, returnErr
 }
 
+// CatalogInfo returns the info of the repositories in the catalog, filtered and sorted according to options.  The
+// 'infos' slice will be filled up to the size of the slice, starting after the repository named 'last'.  The number of
+// entries will be returned along with io.EOF if there are no more entries
+func (r *registry) CatalogInfo(ctx context.Context, infos []distribution.ImageInfo, last string, options CatalogInfoOptions) (int, error) {
+	var numFilled int
+	var returnErr error
+
+	values := buildCatalogValues(len(infos), last)
+	for key, value := range map[string]string{
+		"prefix":   options.Prefix,
+		"contains": options.Contains,
+		"sort":     options.Sort,
+		"order":    options.Order,
+	} {
+		if value != "" {
+			values.Add(key, value)
+		}
+	}
+
+	u, err := r.ub.BuildCatalogInfoURL(values)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := r.client.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if SuccessStatus(resp.StatusCode) {
+		var ctlg struct {
+			ImageInfos []distribution.ImageInfo `json:"imageInfos"`
+		}
+		decoder := json.NewDecoder(resp.Body)
+
+		if err := decoder.Decode(&ctlg); err != nil {
+			return 0, err
+		}
+
+		numFilled = copy(infos, ctlg.ImageInfos)
+
+		link := resp.Header.Get("Link")
+		if link == "" {
+			returnErr = io.EOF
+		}
+	} else {
+		return 0, HandleErrorResponse(resp)
+	}
+
+	return numFilled, returnErr
+}
+
 // NewRepository creates a new Repository for the given repository name and base URL.
 func NewRepository(ctx context.Context, name reference.Named, baseURL string, transport http.RoundTripper) (distribution.Repository, error) {
 	ub, err := v2.NewURLBuilderFromString(baseURL, false)
@@ -235,32 +307,36 @@ func (c *caches) GetTagList(ctx context.Context) ([]string, error) {
 	return nil, nil
 }
 
-func (c *caches) SaveTagInfo(ctx context.Context, tag string, content []byte) error {
+func (c *caches) SaveTagInfo(ctx context.Context, info distribution.TagInfo) error {
 	return nil
 }
 
-func (c *caches) GetTagInfo(ctx context.Context, tag string) ([]byte, error) {
-	return nil, nil
+func (c *caches) DeleteTagInfo(ctx context.Context, tag string) error {
+	return nil
+}
+
+func (c *caches) GetTagInfo(ctx context.Context, tag string) (distribution.TagInfo, error) {
+	return distribution.TagInfo{}, nil
 }
 
-func (c *caches) GetImageItemList(ctx context.Context) ([]string, error) {
+func (c *caches) GetImageItemList(ctx context.Context) ([]distribution.ItemInfo, error) {
 	return nil, nil
 }
 
-func (c *caches) GetTagItemList(ctx context.Context, tag string) ([]string, error) {
+func (c *caches) GetTagItemList(ctx context.Context, tag string) ([]distribution.ItemInfo, error) {
 	return nil, nil
 }
 
-func (c *caches) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error {
-	return nil
+func (c *caches) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, nil
 }
 
 func (c *caches) GetImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error {
 	return nil
 }
 
-func (c *caches) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error {
-	return nil
+func (c *caches) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, nil
 }
 
 func (c *caches) GetTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error {
@@ -287,19 +363,11 @@ func (c *caches) InitItem(ctx context.Context, tag string) error {
 	return nil
 
 }
-func (c *caches) SaveImageInfo(ctx context.Context, content []byte) error {
-	return nil
-}
-
-func (c *caches) GetImageInfo(ctx context.Context) ([]byte, error) {
-	return nil, nil
-}
-
-func (c *caches) SaveCatalogInfo(ctx context.Context, content []byte) error {
-	return nil
+func (c *caches) GetImageInfo(ctx context.Context) (distribution.ImageInfo, error) {
+	return distribution.ImageInfo{}, nil
 }
 
-func (c *caches) GetCatalogInfo(ctx context.Context) ([]byte, error) {
+func (c *caches) GetCatalogInfo(ctx context.Context) ([]distribution.ImageInfo, error) {
 	return nil, nil
 }
 
@@ -1062,6 +1062,48 @@ func TestCatalogInParts(t *testing.T) {
 	}
 }
 
+func TestCatalogInfoInParts(t *testing.T) {
+	var m testutil.RequestResponseMap
+	addTestCatalog(
+		"/v2/cataloginfo?n=2&prefix=foo%2F&sort=downloadCount",
+		[]byte("{\"imageInfos\":[{\"name\":\"foo/baz\",\"downloadCount\":8}, {\"name\":\"foo/bar\",\"downloadCount\":5}]}"),
+		"</v2/cataloginfo?last=foo%2Fbar&n=2&prefix=foo%2F&sort=downloadCount>", &m)
+	addTestCatalog(
+		"/v2/cataloginfo?last=foo%2Fbar&n=2&prefix=foo%2F&sort=downloadCount",
+		[]byte("{\"imageInfos\":[{\"name\":\"foo/qux\",\"downloadCount\":1}]}"),
+		"", &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	entries := make([]distribution.ImageInfo, 2)
+
+	r, err := NewRegistry(context.Background(), e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	options := CatalogInfoOptions{Prefix: "foo/", Sort: "downloadCount"}
+	numFilled, err := r.CatalogInfo(ctx, entries, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if numFilled != 2 || entries[0].Name != "foo/baz" || entries[0].DownloadCount != 8 {
+		t.Fatalf("Got wrong repository infos: %#v", entries[:numFilled])
+	}
+
+	numFilled, err = r.CatalogInfo(ctx, entries, entries[numFilled-1].Name, options)
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if numFilled != 1 || entries[0].Name != "foo/qux" {
+		t.Fatalf("Got wrong repository infos: %#v", entries[:numFilled])
+	}
+}
+
 func TestSanitizeLocation(t *testing.T) {
 	for _, testcase := range []struct {
 		description string
@@ -186,6 +186,63 @@ func TestCatalogAPI(t *testing.T) {
 	}
 }
 
+// TestSearchAPI tests the /v2/_search endpoint
+func TestSearchAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+
+	index := env.app.registry.MetadataIndex()
+	for _, info := range []distribution.TagInfo{
+		{Name: "foo/nginx", Tag: "latest"},
+		{Name: "foo/nginx-exporter", Tag: "v1"},
+		{Name: "bar/web", Tag: "latest", Labels: map[string]string{"server": "nginx"}},
+		{Name: "bar/redis", Tag: "latest"},
+	} {
+		if err := index.PutTagInfo(env.ctx, info); err != nil {
+			t.Fatalf("unexpected error indexing tag info: %v", err)
+		}
+	}
+
+	searchURL, err := env.builder.BuildSearchURL(url.Values{"q": []string{"nginx"}})
+	if err != nil {
+		t.Fatalf("unexpected error building search url: %v", err)
+	}
+
+	resp, err := http.Get(searchURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "issuing search api check", resp, http.StatusOK)
+
+	var results searchAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("error decoding search results: %v", err)
+	}
+
+	var names []string
+	for _, result := range results.Results {
+		names = append(names, result.Name)
+	}
+
+	if expected := []string{"foo/nginx", "foo/nginx-exporter", "bar/web"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected search results: %v != %v", names, expected)
+	}
+
+	searchURL, err = env.builder.BuildSearchURL(url.Values{"q": []string{""}})
+	if err != nil {
+		t.Fatalf("unexpected error building search url: %v", err)
+	}
+
+	resp, err = http.Get(searchURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "issuing empty search", resp, http.StatusBadRequest)
+}
+
 func checkLink(t *testing.T, urlStr string, numEntries int, last string) url.Values {
 	re := regexp.MustCompile("<(/v2/_catalog.*)>; rel=\"next\"")
 	matches := re.FindStringSubmatch(urlStr)
@@ -2397,7 +2454,7 @@ func TestCheckContextNotifier(t *testing.T) {
 				w.WriteHeader(200)
 			}),
 		}
-	}))
+	}, true))
 
 	resp, err := http.Get(env.server.URL + "/unittest/reponame/")
 	if err != nil {
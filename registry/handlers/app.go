@@ -3,15 +3,18 @@ package handlers
 import (
 	cryptorand "crypto/rand"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -25,15 +28,21 @@ import (
 	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/robot"
+	"github.com/docker/distribution/registry/jobs"
+	"github.com/docker/distribution/registry/loghook"
 	registrymiddleware "github.com/docker/distribution/registry/middleware/registry"
 	repositorymiddleware "github.com/docker/distribution/registry/middleware/repository"
 	"github.com/docker/distribution/registry/proxy"
+	"github.com/docker/distribution/registry/replication"
 	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/cache"
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
 	rediscache "github.com/docker/distribution/registry/storage/cache/redis"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+	"github.com/docker/distribution/uuid"
 	"github.com/docker/distribution/version"
 	"github.com/docker/libtrust"
 	"github.com/garyburd/redigo/redis"
@@ -48,7 +57,21 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
-const defaultUpdateCatalogCacheInterval = 5 * time.Minute
+// defaultCatalogCacheInterval is the default time in between rebuilds of the
+// catalog cache
+const defaultCatalogCacheInterval = 5 * time.Minute
+
+// defaultProxyExpiryInterval is the default time in between removals of the
+// expired content of a pull through cache
+const defaultProxyExpiryInterval = time.Minute
+
+// jobLocksRoot is the directory of the lock files electing the instance
+// running each job, when they are kept by the storage driver.
+const jobLocksRoot = "/docker/registry/v2/_jobs/locks"
+
+// replicationRoot is the directory of the queues of manifests waiting to be
+// replicated to each target.
+const replicationRoot = "/docker/registry/v2/_replication"
 
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
@@ -86,8 +109,49 @@ type App struct {
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
 
+	// gc collects the unreferenced blobs while the registry is running, if
+	// configured. sweeping is set while it removes blobs, during which
+	// writes are refused. The writes in flight, and those of the other
+	// registries sharing the storage, are refused when linking blobs, see
+	// storage.EnableSweepFence. gcHTTPSweep allows the collections started
+	// on the debug server to remove blobs.
+	gc            *storage.GarbageCollector
+	gcGracePeriod time.Duration
+	gcHTTPSweep   bool
+	sweeping      int32
+
+	// retention prunes the tags falling out of the retention rules, if
+	// configured.
+	retention *storage.Retention
+
+	// robots manages the robot accounts, if enabled.
+	robots distribution.RobotAccountService
+
 	// isEnhanced is true if this registry enabled with enhanced function
 	isEnhanced bool
+
+	// jobs runs the periodic maintenance jobs, such as the catalog cache
+	// rebuild or the upload purge.
+	jobs *jobs.Scheduler
+
+	// contentExpirer removes the expired content of a pull through cache.
+	contentExpirer proxy.ContentExpirer
+
+	// tagRules protect tags from being moved or deleted.
+	tagRules []storage.TagRule
+
+	// replicator copies the pushed manifests to other registries.
+	replicator *replication.Replicator
+
+	// warmUp builds the caches in the background when the registry starts,
+	// if enabled. Until it finishes, requests are served without the caches.
+	warmUp *warmUp
+
+	// downloadTotals sorts the catalog info by download count.
+	downloadTotals downloadTotals
+
+	// logHooks deliver log entries to the configured sinks until Shutdown.
+	logHooks []*loghook.Hook
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -98,7 +162,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		Config:     config,
 		Context:    ctx,
 		router:     v2.RouterWithPrefix(config.HTTP.Prefix),
-		isCache:    config.Proxy.RemoteURL != "",
+		isCache:    config.Proxy.Enabled(),
 		isEnhanced: !config.Enhanced.Disable,
 	}
 
@@ -111,10 +175,14 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlob, blobDispatcher, true)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher, true)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher, true)
+	// Register the admin api
+	app.register(v2.RouteNameRobots, robotsDispatcher, true)
+	app.register(v2.RouteNameQuotas, quotasDispatcher, true)
 	// Register the enhanced api
 	if app.isEnhanced {
 		app.register(v2.RouteNameCatalog, catalogDispatcher, config.Enhanced.Auth)
 		app.register(v2.RouteNameCatalogInfo, cataloginfoDispatcher, config.Enhanced.Auth)
+		app.register(v2.RouteNameSearch, searchDispatcher, config.Enhanced.Auth)
 		app.register(v2.RouteNameTagInfo, taginfoDispatcher, config.Enhanced.Auth)
 		app.register(v2.RouteNameImageInfo, imageinfoDispatcher, config.Enhanced.Auth)
 		app.register(v2.RouteNameImageItem, imageItemDispatcher, config.Enhanced.Auth)
@@ -139,14 +207,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		panic(err)
 	}
 
-	purgeConfig := uploadPurgeDefaultConfig()
 	if mc, ok := config.Storage["maintenance"]; ok {
-		if v, ok := mc["uploadpurging"]; ok {
-			purgeConfig, ok = v.(map[interface{}]interface{})
-			if !ok {
-				panic("uploadpurging config key must contain additional keys")
-			}
-		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[interface{}]interface{})
 			if !ok {
@@ -161,8 +222,6 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, ctxu.GetLogger(app), purgeConfig)
-
 	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
@@ -200,6 +259,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	if app.isCache {
 		options = append(options, storage.DisableDigestResumption)
+	} else {
+		options = append(options, storage.EnableSweepFence)
 	}
 
 	// configure deletion
@@ -229,6 +290,78 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.EnableRedirect)
 	}
 
+	// configure the metadata index
+	if cc, ok := config.Storage["cache"]; ok {
+		switch cc["metadataindex"] {
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for metadata index")
+			}
+			options = append(options, storage.MetadataIndex(rediscache.NewRedisMetadataIndex(app.redis)))
+			ctxu.GetLogger(app).Infof("using redis metadata index")
+		case nil, "", "storage":
+		default:
+			ctxu.GetLogger(app).Warnf("unknown metadata index type %q, using storage", cc["metadataindex"])
+		}
+
+		switch cc["downloadcounter"] {
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for download counter")
+			}
+			options = append(options, storage.DownloadCounter(rediscache.NewRedisDownloadCounter(app.redis)))
+			ctxu.GetLogger(app).Infof("using redis download counter")
+		case nil, "", "storage":
+			if v, ok := cc["downloadflushinterval"]; ok {
+				intervalStr, ok := v.(string)
+				if !ok {
+					panic(fmt.Sprintf("invalid type for downloadflushinterval config: %#v", v))
+				}
+				interval, err := time.ParseDuration(intervalStr)
+				if err != nil {
+					panic(fmt.Sprintf("cannot parse downloadflushinterval: %v", err))
+				}
+				options = append(options, storage.DownloadCounter(storage.NewDownloadCounter(app, app.driver, "", interval)))
+			}
+		default:
+			ctxu.GetLogger(app).Warnf("unknown download counter type %q, using storage", cc["downloadcounter"])
+		}
+	}
+
+	// configure the item size limit
+	if cc, ok := config.Storage["cache"]; ok {
+		if v, ok := cc["itemmaxsize"]; ok {
+			size, ok := v.(int)
+			if !ok {
+				panic(fmt.Sprintf("invalid type for itemmaxsize config: %#v", v))
+			}
+			options = append(options, storage.ItemMaxSize(int64(size)))
+		}
+	}
+
+	// configure the storage quotas
+	if config.Quota.Enabled {
+		var quotas []distribution.Quota
+		for _, limit := range config.Quota.Limits {
+			quotas = append(quotas, distribution.Quota{Prefix: limit.Prefix, Limit: limit.Limit})
+		}
+		options = append(options, storage.Quotas(storage.NewQuotaService(app.driver, "", quotas)))
+		ctxu.GetLogger(app).Infof("enforcing storage quotas")
+	}
+
+	// configure the tag protection rules
+	if len(config.TagProtection.Rules) > 0 {
+		app.tagRules, err = tagRulesFromConfig(config.TagProtection.Rules)
+		if err != nil {
+			panic(err.Error())
+		}
+		if config.Proxy.Enabled() {
+			ctxu.GetLogger(app).Warnf("tag protection is not supported by a proxy cache, ignoring rules")
+			app.tagRules = nil
+		}
+		options = append(options, storage.TagRules(app.tagRules))
+	}
+
 	// configure storage caches
 	if cc, ok := config.Storage["cache"]; ok {
 		v, ok := cc["blobdescriptor"]
@@ -272,6 +405,11 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	// finish the repository removals interrupted by a previous crash
+	if err := storage.ResumeRepositoryRemovals(app, app.registry); err != nil {
+		ctxu.GetLogger(app).Errorf("error resuming repository removals: %v", err)
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
@@ -288,22 +426,46 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		ctxu.GetLogger(app).Debugf("configured %q access controller", authType)
 	}
 
+	// configure the robot accounts
+	if config.Robots.Enabled {
+		app.robots = storage.NewRobotAccountService(app.driver, "", config.Robots.LastUsedInterval)
+		if app.accessController != nil {
+			realm := config.Robots.Realm
+			if realm == "" {
+				realm = "registry"
+			}
+			app.accessController = robot.NewAccessController(app.accessController, app.robots, realm)
+			ctxu.GetLogger(app).Infof("authenticating robot accounts")
+		} else {
+			ctxu.GetLogger(app).Warnf("robot accounts require an access controller, every client is authorized")
+		}
+	}
+
 	// configure as a pull through cache
-	if config.Proxy.RemoteURL != "" {
+	if config.Proxy.Enabled() {
 		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
 		if err != nil {
 			panic(err.Error())
 		}
+		app.contentExpirer, _ = app.registry.(proxy.ContentExpirer)
 		app.isCache = true
-		ctxu.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		for _, upstream := range config.Proxy.Upstreams {
+			ctxu.GetLogger(app).Infof("Registry configured as a proxy cache of %s to %s", upstream.Match, upstream.RemoteURL)
+		}
+		if config.Proxy.RemoteURL != "" {
+			ctxu.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		}
 	}
 
-	if app.Config.Enhanced.StartCheck {
-		err := app.updateCache()
-		if err != nil {
-			panic(err)
-		}
+	if app.isEnhanced && app.Config.Enhanced.StartCheck {
+		app.startWarmUp(app.Config.Enhanced.WarmUpWorkers)
+	}
+
+	if app.replicator != nil {
+		app.replicator.Start(app.registry, app.jobLocker(maintenanceConfig(config, "jobs")))
 	}
+
+	app.configureJobs(config)
 	return app
 }
 
@@ -323,20 +485,6 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		healthRegistry = healthRegistries[0]
 	}
 
-	if app.isEnhanced {
-		interval := defaultUpdateCatalogCacheInterval
-		catalogCacheUpdater := func() error {
-			ctxu.GetLogger(app).Infof("Start to create catalog cache.")
-			repos := make([]string, cachedMaxEntries)
-			_, err := app.createCatalogCache(repos)
-			if err != nil {
-				return err
-			}
-			return nil
-		}
-		healthRegistry.RegisterPeriodicFunc("catalog_cache_"+app.Config.Storage.Type(), interval, catalogCacheUpdater)
-	}
-
 	if app.Config.Health.StorageDriver.Enabled {
 		interval := app.Config.Health.StorageDriver.Interval
 		if interval == 0 {
@@ -419,69 +567,6 @@ func (app *App) createCatalogCache(repos []string) (int, error) {
 	return filled, blobCache.CacheCatalog(app, content)
 }
 
-func (app *App) updateCache() error {
-	repos := make([]string, cachedMaxEntries)
-
-	filled, err := app.createCatalogCache(repos)
-	if err != nil {
-		return err
-	}
-	imageinfos := make([]imageinfoAPIResponse, filled)
-
-	for i := 0; i < filled; i++ {
-		imagename := repos[i]
-		nameRef, err := reference.WithName(imagename)
-		repository, err := app.registry.Repository(app.Context, nameRef)
-		if err != nil {
-			ctxu.GetLogger(app).Errorf(err.Error())
-			continue
-		}
-		ctx := &Context{
-			App:        app,
-			Context:    app.Context,
-			Repository: repository,
-		}
-		if err != nil {
-			ctxu.GetLogger(app).Errorf(err.Error())
-			continue
-		}
-		tagserivce := repository.Tags(ctx)
-		cacheservice := repository.Caches(ctx)
-		tags, err := tagserivce.All(ctx)
-		if err != nil {
-			ctxu.GetLogger(app).Errorf(err.Error())
-			continue
-		}
-		cnum := make(chan int, len(tags))
-		for _, tag := range tags {
-			go func() {
-				imh := &imageManifestHandler{
-					Context: ctx,
-					Tag:     tag,
-				}
-				createAndSaveTagInfo(imh, imagename)
-				cnum <- 1
-			}()
-		}
-		for i := 0; i < len(tags); i++ {
-			<-cnum
-		}
-		imageinfos[i], err = createAndSaveImageInfo(ctx, imagename)
-		if err != nil {
-			ctxu.GetLogger(app).Errorf(err.Error())
-			continue
-		}
-		cacheservice.CreateTagListCache(ctx)
-	}
-	catalogContent, err := json.Marshal(&cataloginfoAPIResponse{
-		ImageInfos: imageinfos,
-	})
-	blobCache := app.registry.BlobCache()
-	blobCache.CacheCatalogInfo(app, catalogContent)
-
-	return nil
-}
-
 // register a handler with the application, by route name. The handler will be
 // passed through the application filters and context will be constructed at
 // request time.
@@ -517,6 +602,15 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 		sinks = append(sinks, endpoint)
 	}
 
+	if len(configuration.Replication.Policies) > 0 {
+		replicator, err := replication.New(app, app.driver, replicationRoot, configuration.Replication.Policies)
+		if err != nil {
+			panic(err)
+		}
+		app.replicator = replicator
+		sinks = append(sinks, replicator.Sink())
+	}
+
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
 	// replacing broadcaster with a rabbitmq implementation. It's recommended
 	// that the registry instances also act as the workers to keep deployment
@@ -634,23 +728,58 @@ func (app *App) configureLogHook(configuration *configuration.Configuration) {
 	logger := entry.Logger
 
 	for _, configHook := range configuration.Log.Hooks {
-		if !configHook.Disabled {
-			switch configHook.Type {
-			case "mail":
-				hook := &logHook{}
-				hook.LevelsParam = configHook.Levels
-				hook.Mail = &mailer{
-					Addr:     configHook.MailOptions.SMTP.Addr,
-					Username: configHook.MailOptions.SMTP.Username,
-					Password: configHook.MailOptions.SMTP.Password,
-					Insecure: configHook.MailOptions.SMTP.Insecure,
-					From:     configHook.MailOptions.From,
-					To:       configHook.MailOptions.To,
-				}
-				logger.Hooks.Add(hook)
-			default:
-			}
+		if configHook.Disabled {
+			continue
+		}
+
+		options := map[string]interface{}(configHook.Options)
+		if options == nil && configHook.Type == "mail" {
+			// configured in code rather than parsed
+			options = mailHookOptions(configHook.MailOptions)
 		}
+		hook, err := loghook.NewHook(configHook.Type, options, loghook.Options{
+			Levels:      configHook.Levels,
+			QueueSize:   configHook.QueueSize,
+			RateLimit:   configHook.RateLimit,
+			DedupWindow: configHook.DedupWindow,
+			Digest:      configHook.Digest,
+		})
+		if _, ok := err.(loghook.ErrUnknownType); ok {
+			// a hook type of another build of the registry
+			ctxu.GetLogger(app).Errorf("skipping %s log hook: %v", configHook.Type, err)
+			continue
+		}
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure %s log hook: %v", configHook.Type, err))
+		}
+		logger.Hooks.Add(hook)
+		app.logHooks = append(app.logHooks, hook)
+	}
+}
+
+// Shutdown delivers the entries queued by the log hooks and stops them.
+// Entries logged afterwards are dropped by the hooks.
+func (app *App) Shutdown() {
+	for _, hook := range app.logHooks {
+		hook.Close()
+	}
+}
+
+// mailHookOptions returns the options of a mail hook.
+func mailHookOptions(mail configuration.MailOptions) map[string]interface{} {
+	to := make([]interface{}, len(mail.To))
+	for i, addr := range mail.To {
+		to[i] = addr
+	}
+	return map[string]interface{}{
+		"smtp": map[string]interface{}{
+			"addr":     mail.SMTP.Addr,
+			"username": mail.SMTP.Username,
+			"password": mail.SMTP.Password,
+			"insecure": mail.SMTP.Insecure,
+		},
+		"from": mail.From,
+		"to":   to,
 	}
 }
 
@@ -853,6 +982,14 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	repo := getName(context)
 
 	if app.accessController == nil {
+		if isAdminRoute(r) {
+			// the admin api manages credentials, and is never served to
+			// unauthenticated clients.
+			if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail("the admin api requires an access controller")); err != nil {
+				ctxu.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
+			return fmt.Errorf("forbidden: admin api without access controller")
+		}
 		return nil // access controller is not enabled.
 	}
 
@@ -880,9 +1017,20 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			return fmt.Errorf("forbidden: no repository name")
 		}
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendAdminAccessRecord(accessRecords, r)
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
+	if err != nil && repo == "" && isListingRoute(r) {
+		// clients which may not list the whole catalog can still list the
+		// repositories granted to them, if the access controller tells them.
+		if provider, ok := app.accessController.(auth.GrantsProvider); ok {
+			if grantsCtx, grants, grantsErr := provider.Grants(context.Context); grantsErr == nil {
+				ctxu.GetLogger(context).Debugf("listing filtered to granted repositories: %v", err)
+				ctx, err = auth.WithGrants(grantsCtx, grants), nil
+			}
+		}
+	}
 	if err != nil {
 		switch err := err.(type) {
 		case auth.Challenge:
@@ -892,6 +1040,11 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			if err := errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized.WithDetail(accessRecords)); err != nil {
 				ctxu.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 			}
+		case auth.ErrAccessDenied:
+			ctxu.GetLogger(context).Infof("access denied: %v", err)
+			if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail(err.Access)); err != nil {
+				ctxu.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
 		default:
 			// This condition is a potential security problem either in
 			// the configuration or whatever is backing the access
@@ -926,7 +1079,7 @@ func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listene
 func (app *App) nameRequired(r *http.Request) bool {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
-	return route == nil || (routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameCatalogInfo)
+	return route == nil || (routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameCatalogInfo && routeName != v2.RouteNameSearch && !isAdminRoute(r))
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -978,10 +1131,7 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 
 // Add the access record for the catalog if it's our current route
 func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
-	route := mux.CurrentRoute(r)
-	routeName := route.GetName()
-
-	if routeName == v2.RouteNameCatalog {
+	if isListingRoute(r) {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -996,6 +1146,54 @@ func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []a
 	return accessRecords
 }
 
+// Add the access record for the admin api if it's our current route
+func appendAdminAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	if isAdminRoute(r) {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "admin",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// isAdminRoute returns true if the route belongs to the admin api, which is
+// authorized with the admin access record.
+func isAdminRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+
+	switch route.GetName() {
+	case v2.RouteNameRobots, v2.RouteNameQuotas:
+		return true
+	}
+	return false
+}
+
+// isListingRoute returns true if the route lists the repositories of the
+// catalog. Such routes are authorized with the catalog access record, or
+// filtered down to the repositories granted to the client.
+func isListingRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+
+	switch route.GetName() {
+	case v2.RouteNameCatalog, v2.RouteNameCatalogInfo, v2.RouteNameSearch:
+		return true
+	}
+	return false
+}
+
 // applyRegistryMiddleware wraps a registry instance with the configured middlewares
 func applyRegistryMiddleware(ctx context.Context, registry distribution.Namespace, middlewares []configuration.Middleware) (distribution.Namespace, error) {
 	for _, mw := range middlewares {
@@ -1033,6 +1231,175 @@ func applyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []co
 	return driver, nil
 }
 
+// maintenanceConfig returns the configuration of the storage maintenance
+// key, or nil if it is not configured.
+func maintenanceConfig(config *configuration.Configuration, key string) map[interface{}]interface{} {
+	mc, ok := config.Storage["maintenance"]
+	if !ok {
+		return nil
+	}
+	v, ok := mc[key]
+	if !ok {
+		return nil
+	}
+	keyConfig, ok := v.(map[interface{}]interface{})
+	if !ok {
+		panic(fmt.Sprintf("%s config key must contain additional keys", key))
+	}
+	return keyConfig
+}
+
+// maintenanceDuration returns the duration of key in config, or def if it is
+// not set. bad is called with the reason if it cannot be parsed.
+func maintenanceDuration(config map[interface{}]interface{}, key string, def time.Duration, bad func(string)) time.Duration {
+	v, ok := config[key]
+	if !ok {
+		return def
+	}
+	str, ok := v.(string)
+	if !ok {
+		bad(fmt.Sprintf("%s is not a string", key))
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		bad(fmt.Sprintf("Cannot parse %s: %s", key, err.Error()))
+	}
+	return d
+}
+
+// maintenanceEnabled returns the value of the enabled key of config, or def
+// if it is not set. bad is called with the reason if it is not a boolean.
+func maintenanceEnabled(config map[interface{}]interface{}, def bool, bad func(string)) bool {
+	v, ok := config["enabled"]
+	if !ok {
+		return def
+	}
+	enabled, ok := v.(bool)
+	if !ok {
+		bad("enabled is not a boolean")
+	}
+	return enabled
+}
+
+func badJobsConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse jobs configuration: %s", reason))
+}
+
+// configureJobs registers the maintenance jobs of the app and starts them.
+func (app *App) configureJobs(config *configuration.Configuration) {
+	app.jobs = jobs.New(app, app.jobLocker(maintenanceConfig(config, "jobs")))
+
+	purgeConfig := maintenanceConfig(config, "uploadpurging")
+	if purgeConfig == nil {
+		purgeConfig = uploadPurgeDefaultConfig()
+	}
+	if job, ok := uploadPurgeJob(app.driver, purgeConfig); ok {
+		app.addJob(job)
+	}
+
+	if app.isEnhanced {
+		if job, ok := app.catalogCacheJob(maintenanceConfig(config, "catalogcache")); ok {
+			app.addJob(job)
+		}
+	}
+
+	if app.contentExpirer != nil {
+		app.addJob(app.proxyExpiryJob(maintenanceConfig(config, "proxyexpiry")))
+	} else if !app.isCache {
+		if gcConfig := maintenanceConfig(config, "gc"); gcConfig != nil {
+			if job, ok := app.garbageCollectionJob(gcConfig); ok {
+				app.addJob(job)
+			}
+		}
+	}
+
+	if len(config.Retention.Rules) > 0 {
+		if app.isCache {
+			ctxu.GetLogger(app).Warnf("retention is not supported by a proxy cache, ignoring rules")
+		} else {
+			app.addJob(app.retentionJob(config.Retention))
+		}
+	}
+
+	app.jobs.Start()
+}
+
+func (app *App) addJob(job jobs.Job) {
+	if err := app.jobs.Add(job); err != nil {
+		panic(err)
+	}
+}
+
+// jobLocker returns the locker electing the instance which runs each job,
+// configured by the lock key of config: "storage" (the default) keeps lock
+// files in the storage driver, "redis" keeps them in redis, and "none" runs
+// every job on every instance.
+func (app *App) jobLocker(config map[interface{}]interface{}) jobs.Locker {
+	lock := "storage"
+	if v, ok := config["lock"]; ok {
+		if lock, ok = v.(string); !ok {
+			badJobsConfig("lock is not a string")
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	owner := hostname + "-" + uuid.Generate().String()
+
+	switch lock {
+	case "storage":
+		return jobs.NewDriverLocker(app.driver, jobLocksRoot, owner)
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for job locks")
+		}
+		return jobs.NewRedisLocker(app.redis, owner)
+	case "none":
+		return nil
+	default:
+		badJobsConfig(fmt.Sprintf("unknown lock %q", lock))
+	}
+	return nil
+}
+
+// catalogCacheJob returns the job rebuilding the catalog cache, unless it is
+// disabled.
+func (app *App) catalogCacheJob(config map[interface{}]interface{}) (jobs.Job, bool) {
+	bad := func(reason string) {
+		panic(fmt.Sprintf("Unable to parse catalog cache configuration: %s", reason))
+	}
+	if !maintenanceEnabled(config, true, bad) {
+		return jobs.Job{}, false
+	}
+
+	interval := maintenanceDuration(config, "interval", defaultCatalogCacheInterval, bad)
+	return jobs.Job{
+		Name:     "catalogcache",
+		Interval: interval,
+		Jitter:   maintenanceDuration(config, "jitter", interval, bad),
+		Func: func(ctx ctxu.Context) error {
+			repos := make([]string, cachedMaxEntries)
+			_, err := app.createCatalogCache(repos)
+			return err
+		},
+	}, true
+}
+
+// proxyExpiryJob returns the job removing the expired content of a pull
+// through cache.
+func (app *App) proxyExpiryJob(config map[interface{}]interface{}) jobs.Job {
+	bad := func(reason string) {
+		panic(fmt.Sprintf("Unable to parse proxy expiry configuration: %s", reason))
+	}
+
+	interval := maintenanceDuration(config, "interval", defaultProxyExpiryInterval, bad)
+	return jobs.Job{
+		Name:     "proxyexpiry",
+		Interval: interval,
+		Jitter:   maintenanceDuration(config, "jitter", interval, bad),
+		Func:     app.contentExpirer.ExpireCachedContent,
+	}
+}
+
 // uploadPurgeDefaultConfig provides a default configuration for upload
 // purging to be used in the absence of configuration in the
 // confifuration file
@@ -1049,11 +1416,11 @@ func badPurgeUploadConfig(reason string) {
 	panic(fmt.Sprintf("Unable to parse upload purge configuration: %s", reason))
 }
 
-// startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log ctxu.Logger, config map[interface{}]interface{}) {
+// uploadPurgeJob returns the job which checks upload directories for old
+// files and deletes them, unless it is disabled.
+func uploadPurgeJob(storageDriver storagedriver.StorageDriver, config map[interface{}]interface{}) (jobs.Job, bool) {
 	if config["enabled"] == false {
-		return
+		return jobs.Job{}, false
 	}
 
 	var purgeAgeDuration time.Duration
@@ -1099,16 +1466,230 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("dryrun missing")
 	}
 
-	go func() {
-		rand.Seed(time.Now().Unix())
-		jitter := time.Duration(rand.Int()%60) * time.Minute
-		log.Infof("Starting upload purge in %s", jitter)
-		time.Sleep(jitter)
+	return jobs.Job{
+		Name:     "uploadpurge",
+		Interval: intervalDuration,
+		Jitter:   maintenanceDuration(config, "jitter", time.Hour, badPurgeUploadConfig),
+		Func: func(ctx ctxu.Context) error {
+			_, errs := storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			if len(errs) > 0 {
+				return fmt.Errorf("%d errors purging uploads, first: %v", len(errs), errs[0])
+			}
+			return nil
+		},
+	}, true
+}
+
+// isReadOnly returns true if the registry refuses writes, either because it
+// is configured in read-only mode or because blobs are being swept.
+func (app *App) isReadOnly() bool {
+	return app.readOnly || atomic.LoadInt32(&app.sweeping) == 1
+}
 
-		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
-			log.Infof("Starting upload purge in %s", intervalDuration)
-			time.Sleep(intervalDuration)
+// sweepMarkerTTL is the time after which the sweep marker of a registry
+// expires unless it is refreshed, so that a registry dying during a sweep
+// does not prevent the others from linking blobs for longer.
+const sweepMarkerTTL = time.Minute
+
+// errGCHTTPSweep is returned when a collection removing blobs is started on
+// the debug server without httpsweep.
+var errGCHTTPSweep = errors.New("removing blobs through the debug server is not enabled")
+
+func badGarbageCollectionConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse garbage collection configuration: %s", reason))
+}
+
+// garbageCollectionJob returns the job which removes the blobs no longer
+// referenced by any repository, unless it is disabled.
+func (app *App) garbageCollectionJob(config map[interface{}]interface{}) (jobs.Job, bool) {
+	if !maintenanceEnabled(config, false, badGarbageCollectionConfig) {
+		return jobs.Job{}, false
+	}
+
+	interval := maintenanceDuration(config, "interval", 24*time.Hour, badGarbageCollectionConfig)
+	jitter := maintenanceDuration(config, "jitter", time.Hour, badGarbageCollectionConfig)
+	app.gcGracePeriod = maintenanceDuration(config, "graceperiod", 2*time.Hour, badGarbageCollectionConfig)
+
+	var dryRun bool
+	if v, ok := config["dryrun"]; ok {
+		if dryRun, ok = v.(bool); !ok {
+			badGarbageCollectionConfig("cannot parse dryrun")
 		}
-	}()
+	}
+	if v, ok := config["httpsweep"]; ok {
+		if app.gcHTTPSweep, ok = v.(bool); !ok {
+			badGarbageCollectionConfig("cannot parse httpsweep")
+		}
+	}
+
+	app.gc = storage.NewGarbageCollector(app.driver, app.registry)
+	opts := app.garbageCollectionOptions(dryRun, app.gcGracePeriod)
+
+	return jobs.Job{
+		Name:     "gc",
+		Interval: interval,
+		Jitter:   jitter,
+		// runs started on the debug server remove blobs like the
+		// collections of /debug/gc, which httpsweep must allow
+		Guard: func() error {
+			if !dryRun && !app.gcHTTPSweep {
+				return errGCHTTPSweep
+			}
+			return nil
+		},
+		Func: func(ctx ctxu.Context) error {
+			report, err := app.gc.Collect(ctx, opts)
+			if err != nil {
+				return err
+			}
+			ctxu.GetLogger(ctx).Infof("garbage collection: %d blobs marked, %d eligible for deletion (%d bytes), %d deleted",
+				report.Marked, len(report.Candidates), report.ReclaimableBytes, report.Deleted)
+			return nil
+		},
+	}, true
+}
+
+// retentionJob returns the job which prunes the tags falling out of the
+// retention rules.
+func (app *App) retentionJob(config configuration.Retention) jobs.Job {
+	rules, err := retentionRulesFromConfig(config.Rules)
+	if err != nil {
+		panic(err.Error())
+	}
+	app.retention = storage.NewRetention(app.driver, app.registry, rules)
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	opts := storage.RetentionOptions{
+		DryRun:       config.DryRun,
+		UpdateCaches: app.isEnhanced,
+	}
+
+	return jobs.Job{
+		Name:     "retention",
+		Interval: interval,
+		Jitter:   time.Hour,
+		Func: func(ctx ctxu.Context) error {
+			report, err := app.retention.Apply(ctx, opts)
+			if err != nil {
+				return err
+			}
+			ctxu.GetLogger(ctx).Infof("retention: %d repositories, %d tags kept, %d pruned, %d manifests deleted",
+				report.Repositories, report.Kept, len(report.Pruned), report.Deleted)
+			return nil
+		},
+	}
+}
+
+// retentionRulesFromConfig validates the configured retention rules.
+func retentionRulesFromConfig(configured []configuration.RetentionRule) ([]storage.RetentionRule, error) {
+	var rules []storage.RetentionRule
+	for _, c := range configured {
+		for _, pattern := range c.KeepTags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid retention pattern %q", pattern)
+			}
+		}
+		if c.KeepLast < 0 || c.KeepDays < 0 || c.KeepDays > cache.DownloadHistoryDays {
+			return nil, fmt.Errorf("invalid retention rule of %q: keeplast must not be negative and keepdays must be between 0 and %d",
+				c.Prefix, cache.DownloadHistoryDays)
+		}
+		if c.KeepLast == 0 && c.KeepDays == 0 && len(c.KeepTags) == 0 {
+			return nil, fmt.Errorf("invalid retention rule of %q: it would prune every tag", c.Prefix)
+		}
+		rules = append(rules, storage.RetentionRule{
+			Prefix:   c.Prefix,
+			KeepLast: c.KeepLast,
+			KeepDays: c.KeepDays,
+			KeepTags: c.KeepTags,
+		})
+	}
+	return rules, nil
+}
+
+// JobsHandler returns the handler of the status of the maintenance jobs,
+// meant to be served on the debug server.
+func (app *App) JobsHandler() http.Handler {
+	return app.jobs
+}
+
+// tagRulesFromConfig compiles the configured tag protection rules.
+func tagRulesFromConfig(configured []configuration.TagRule) ([]storage.TagRule, error) {
+	var rules []storage.TagRule
+	for _, c := range configured {
+		for _, pattern := range append(append([]string{}, c.Repositories...), c.Tags...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid tag protection pattern %q", pattern)
+			}
+		}
+		rule := storage.TagRule{
+			Repositories: c.Repositories,
+			Tags:         c.Tags,
+			Immutable:    c.Immutable,
+			NoDelete:     c.NoDelete,
+		}
+		if c.TagRegexp != "" {
+			re, err := regexp.Compile(c.TagRegexp)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag protection regexp %q: %v", c.TagRegexp, err)
+			}
+			rule.TagRegexp = re
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ReplicationHandler returns the handler of the status of the replication
+// to each target, meant to be served on the debug server.
+func (app *App) ReplicationHandler() http.Handler {
+	if app.replicator == nil {
+		return http.NotFoundHandler()
+	}
+	return app.replicator
+}
+
+// garbageCollectionOptions returns the options of a collection run by the
+// app, which refuses writes while blobs are swept. The sweep is published in
+// the storage before the blobs are marked a second time, and the marker is
+// refreshed until the sweep completes.
+func (app *App) garbageCollectionOptions(dryRun bool, gracePeriod time.Duration) storage.GCOptions {
+	var stop chan struct{}
+	return storage.GCOptions{
+		DryRun:      dryRun,
+		GracePeriod: gracePeriod,
+		BeforeSweep: func() error {
+			startedAt := time.Now().UTC()
+			if err := storage.PublishSweep(app, app.driver, startedAt, sweepMarkerTTL); err != nil {
+				return err
+			}
+			atomic.StoreInt32(&app.sweeping, 1)
+
+			stop = make(chan struct{})
+			go func(stop chan struct{}) {
+				ticker := time.NewTicker(sweepMarkerTTL / 3)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if err := storage.PublishSweep(app, app.driver, startedAt, sweepMarkerTTL); err != nil {
+							ctxu.GetLogger(app).Errorf("error refreshing the sweep marker: %v", err)
+						}
+					case <-stop:
+						return
+					}
+				}
+			}(stop)
+			return nil
+		},
+		AfterSweep: func() {
+			close(stop)
+			if err := storage.ClearSweep(app, app.driver); err != nil {
+				ctxu.GetLogger(app).Errorf("error clearing the sweep marker: %v", err)
+			}
+			atomic.StoreInt32(&app.sweeping, 0)
+		},
+	}
 }
@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/configuration"
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/api/errcode"
@@ -116,7 +121,7 @@ func TestAppDispatcher(t *testing.T) {
 			},
 		},
 	} {
-		app.register(testcase.endpoint, varCheckingDispatcher(unflatten(testcase.vars)))
+		app.register(testcase.endpoint, varCheckingDispatcher(unflatten(testcase.vars)), true)
 		route := router.GetRoute(testcase.endpoint).Host(serverURL.Host)
 		u, err := route.URL(testcase.vars...)
 
@@ -272,3 +277,34 @@ func TestAppendAccessRecords(t *testing.T) {
 	}
 
 }
+
+func TestConfigureLogHook(t *testing.T) {
+	// the hooks are added to a logger of the test only
+	app := &App{Context: context.WithLogger(context.Background(), logrus.NewEntry(logrus.New()))}
+
+	dir, err := ioutil.TempDir("", "loghook")
+	if err != nil {
+		t.Fatalf("unexpected error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "errors.log")
+
+	// an unknown hook type is skipped rather than failing the startup
+	config := configuration.Configuration{}
+	config.Log.Hooks = []configuration.LogHook{
+		{Type: "unknown", Levels: []string{"error"}},
+		{Type: "file", Levels: []string{"error"}, Options: configuration.Parameters{"path": path}},
+	}
+	app.configureLogHook(&config)
+	if len(app.logHooks) != 1 {
+		t.Fatalf("expected the file hook only, got %d hooks", len(app.logHooks))
+	}
+
+	// the entries queued are delivered on shutdown
+	context.GetLogger(app).Error("delivered on shutdown")
+	app.Shutdown()
+	content, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(content), "delivered on shutdown") {
+		t.Fatalf("expected the entry to be delivered on shutdown: %q, %v", content, err)
+	}
+}
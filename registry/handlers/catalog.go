@@ -10,7 +10,9 @@ import (
 
 	"strings"
 
+	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/auth"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/gorilla/handlers"
 )
@@ -53,7 +55,8 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		maxEntries = maximumReturnedEntries
 	}
 
-	if ch.isEnhanced && !strings.EqualFold(cached, "0") {
+	// The cached catalog is not used until the caches are warmed up.
+	if ch.isEnhanced && !strings.EqualFold(cached, "0") && !ch.warmingUp() {
 		cacheservice := ch.App.registry.BlobCache()
 		content, err := cacheservice.GetCatalog(ch)
 
@@ -64,7 +67,7 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 				return
 			}
-			cacherepos := c.Repositories
+			cacherepos := filterPullable(ch, c.Repositories)
 			var start, end int
 			if len(cacherepos) <= cachedMaxEntries || maxEntries < cachedMaxEntries {
 				if len(cacherepos) < maxEntries {
@@ -98,7 +101,7 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 
 	repos := make([]string, maxEntries)
 
-	filled, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
+	filled, err := ch.pullableRepositories(repos, lastEntry)
 	_, pathNotFound := err.(driver.PathNotFoundError)
 
 	if err == io.EOF || pathNotFound {
@@ -127,6 +130,67 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pullableRepositories fills repos with the repositories following last
+// which the client may pull, returning io.EOF if there are no more of them.
+func (ch *catalogHandler) pullableRepositories(repos []string, last string) (int, error) {
+	if auth.GetGrants(ch) == nil {
+		return ch.App.registry.Repositories(ch.Context, repos, last)
+	}
+
+	filled := 0
+	batch := make([]string, len(repos))
+	for {
+		n, err := ch.App.registry.Repositories(ch.Context, batch, last)
+		for _, name := range batch[:n] {
+			if !pullAllowed(ch, name) {
+				continue
+			}
+			if filled == len(repos) {
+				// more repositories can be pulled
+				return filled, nil
+			}
+			repos[filled] = name
+			filled++
+		}
+
+		if err != nil {
+			return filled, err
+		}
+		if n == 0 {
+			return filled, io.EOF
+		}
+		last = batch[n-1]
+	}
+}
+
+// pullAllowed returns whether the client may pull the repository. Clients
+// authorized for the whole catalog may pull every repository.
+func pullAllowed(ctx context.Context, name string) bool {
+	grants := auth.GetGrants(ctx)
+	return grants == nil || grants.Allowed(auth.Access{
+		Resource: auth.Resource{
+			Type: "repository",
+			Name: name,
+		},
+		Action: "pull",
+	})
+}
+
+// filterPullable returns the repositories the client may pull.
+func filterPullable(ctx context.Context, repos []string) []string {
+	if auth.GetGrants(ctx) == nil {
+		return repos
+	}
+
+	pullable := make([]string, 0, len(repos))
+	for _, name := range repos {
+		if pullAllowed(ctx, name) {
+			pullable = append(pullable, name)
+		}
+	}
+	return pullable
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
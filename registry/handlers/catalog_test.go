@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// repositoryGrants allows pulling the listed repositories.
+type repositoryGrants map[string]bool
+
+func (g repositoryGrants) Allowed(access auth.Access) bool {
+	return access.Type == "repository" && access.Action == "pull" && g[access.Name]
+}
+
+func TestFilterPullable(t *testing.T) {
+	repos := []string{"bar", "foo/bar", "foo/baz"}
+
+	ctx := context.Background()
+	if filtered := filterPullable(ctx, repos); !reflect.DeepEqual(filtered, repos) {
+		t.Fatalf("unexpected repositories without grants: %v != %v", filtered, repos)
+	}
+
+	ctx = auth.WithGrants(ctx, repositoryGrants{"foo/bar": true, "qux": true})
+	expected := []string{"foo/bar"}
+	if filtered := filterPullable(ctx, repos); !reflect.DeepEqual(filtered, expected) {
+		t.Fatalf("unexpected repositories with grants: %v != %v", filtered, expected)
+	}
+
+	if pullAllowed(ctx, "foo/baz") {
+		t.Fatal("expected foo/baz not to be pullable")
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage"
+)
+
+// GarbageCollectionHandler returns the handler of the garbage collection
+// report, meant to be served on the debug server. GET returns the report of
+// the last collection, and POST runs a collection and returns its report.
+// Collections started through the handler are dry runs unless the dryrun
+// query parameter is false, which is refused unless httpsweep is configured,
+// and gracePeriod may override the configured grace period.
+func (app *App) GarbageCollectionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.gc == nil {
+			http.Error(w, "garbage collection is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var report storage.GCReport
+		switch r.Method {
+		case "GET":
+			var ok bool
+			report, ok = app.gc.LastReport()
+			if !ok {
+				http.Error(w, "no garbage collection has completed yet", http.StatusNotFound)
+				return
+			}
+		case "POST":
+			dryRun := true
+			if v := r.FormValue("dryrun"); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					http.Error(w, "invalid dryrun parameter: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				dryRun = b
+			}
+			if !dryRun && !app.gcHTTPSweep {
+				http.Error(w, errGCHTTPSweep.Error(), http.StatusForbidden)
+				return
+			}
+
+			var gracePeriod time.Duration
+			if v := r.FormValue("graceperiod"); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					http.Error(w, "invalid graceperiod parameter: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				gracePeriod = d
+			} else {
+				gracePeriod = app.gcGracePeriod
+			}
+
+			var err error
+			report, err = app.gc.Collect(app, app.garbageCollectionOptions(dryRun, gracePeriod))
+			if err != nil {
+				ctxu.GetLogger(app).Errorf("error collecting garbage: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			ctxu.GetLogger(app).Errorf("error encoding garbage collection report: %v", err)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage"
+)
+
+func TestGarbageCollectionHandler(t *testing.T) {
+	env := newTestEnv(t, false)
+	handler := env.app.GarbageCollectionHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/gc", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without garbage collection, got %d", w.Code)
+	}
+
+	env.app.gc = storage.NewGarbageCollector(env.app.driver, env.app.registry)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/gc", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before the first collection, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/gc", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status running a collection: %d %s", w.Code, w.Body.String())
+	}
+	var report storage.GCReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("error decoding report: %v", err)
+	}
+	if !report.DryRun {
+		t.Fatal("expected collections started through the handler to be dry runs by default")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/gc", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status getting the last report: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/gc?dryrun=false", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 removing blobs without httpsweep, got %d", w.Code)
+	}
+
+	env.app.gcHTTPSweep = true
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/gc?dryrun=false", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status removing blobs: %d %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("error decoding report: %v", err)
+	}
+	if report.DryRun {
+		t.Fatal("expected the collection to remove blobs with httpsweep")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/gc?graceperiod=soon", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid grace period, got %d", w.Code)
+	}
+
+	if env.app.isReadOnly() {
+		t.Fatal("expected the registry to accept writes after the collection")
+	}
+}
@@ -46,7 +46,7 @@ func imageManifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 		"HEAD": http.HandlerFunc(imageManifestHandler.GetImageManifest),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		mhandler["PUT"] = http.HandlerFunc(imageManifestHandler.PutImageManifest)
 		mhandler["DELETE"] = http.HandlerFunc(imageManifestHandler.DeleteImageManifest)
 	}
@@ -182,11 +182,6 @@ func (imh *imageManifestHandler) GetImageManifest(w http.ResponseWriter, r *http
 	if imh.Tag != "" && imh.isEnhanced {
 		name := getName(imh)
 		updateDownloadCount(imh, name, imh.Tag)
-		imageInfo, err := createAndSaveImageInfo(imh.Context, name)
-		if err == nil {
-			updateCatalogInfo(imh.Context, imageInfo)
-		}
-
 	}
 
 	w.Header().Set("Content-Type", ct)
@@ -362,6 +357,10 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
 			return
 		}
+		if err == distribution.ErrSweepInProgress {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnavailable.WithDetail(err.Error()))
+			return
+		}
 		if err == distribution.ErrAccessDenied {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied)
 			return
@@ -384,6 +383,8 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 					}
 				}
 			}
+		case distribution.ErrQuotaExceeded:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeQuotaExceeded.WithDetail(err.Quota))
 		case errcode.Error:
 			imh.Errors = append(imh.Errors, err)
 		default:
@@ -398,6 +399,10 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
 		if err != nil {
+			if err, ok := err.(distribution.ErrTagImmutable); ok {
+				imh.Errors = append(imh.Errors, v2.ErrorCodeTagImmutable.WithDetail(err))
+				return
+			}
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
 		}
@@ -432,15 +437,6 @@ func (imh *imageManifestHandler) PutImageManifest(w http.ResponseWriter, r *http
 
 		createAndSaveTagInfo(imh, name)
 		ctxu.GetLogger(imh).Infof("Finish create tag info")
-
-		imageInfo, err := createAndSaveImageInfo(imh.Context, name)
-		ctxu.GetLogger(imh).Infof("Finish create image info")
-
-		if err == nil {
-			updateCatalogInfo(imh.Context, imageInfo)
-			ctxu.GetLogger(imh).Infof("Finish update cataloginfo cache")
-
-		}
 		ctxu.GetLogger(imh).Infof("Finish create all cache")
 
 	}
@@ -461,6 +457,10 @@ func (imh *imageManifestHandler) DeleteImageManifest(w http.ResponseWriter, r *h
 	}
 
 	err = manifests.Delete(imh, imh.Digest)
+	if err, ok := err.(distribution.ErrTagProtected); ok {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeTagProtected.WithDetail(err))
+		return
+	}
 	if err != nil {
 		switch err {
 		case digest.ErrDigestUnsupported:
@@ -494,10 +494,7 @@ func (imh *imageManifestHandler) DeleteImageManifest(w http.ResponseWriter, r *h
 		if imh.isEnhanced {
 			cacheservice.DeleteTagFromTagListCache(imh, tag)
 			cacheservice.DeleteAllTagItems(imh, tag)
-			imageInfo, err := createAndSaveImageInfo(imh.Context, imh.Repository.Named().Name())
-			if err == nil {
-				updateCatalogInfo(imh.Context, imageInfo)
-			}
+			cacheservice.DeleteTagInfo(imh, tag)
 		}
 
 	}
@@ -2,13 +2,21 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage/cache"
 	"github.com/gorilla/handlers"
 )
 
@@ -25,10 +33,15 @@ func imageinfoDispatcher(ctx *Context, r *http.Request) http.Handler {
 	imageinfoHandler := &infoHandler{
 		Context: ctx,
 	}
-	return handlers.MethodHandler{
-		"GET":    http.HandlerFunc(imageinfoHandler.GetImageInfo),
-		"DELETE": http.HandlerFunc(imageinfoHandler.DeleteImageRepository),
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(imageinfoHandler.GetImageInfo),
+	}
+
+	if !ctx.isReadOnly() {
+		mhandler["DELETE"] = http.HandlerFunc(imageinfoHandler.DeleteImageRepository)
 	}
+
+	return mhandler
 }
 
 func taginfoDispatcher(ctx *Context, r *http.Request) http.Handler {
@@ -46,45 +59,235 @@ type infoHandler struct {
 }
 
 type taginfoAPIResponse struct {
-	Name          string    `json:"name"`
-	Tag           string    `json:"tag"`
-	CreateTime    time.Time `json:"createTime"`
-	DownloadCount int       `json:"downloadCount"`
-	Size          int64     `json:"size"`
+	Name            string                      `json:"name"`
+	Tag             string                      `json:"tag"`
+	CreateTime      time.Time                   `json:"createTime"`
+	DownloadCount   int                         `json:"downloadCount"`
+	RecentDownloads []dailyDownloadsAPIResponse `json:"recentDownloads,omitempty"`
+	Size            int64                       `json:"size"`
+	Labels          map[string]string           `json:"labels,omitempty"`
+
+	Digest    digest.Digest                `json:"digest,omitempty"`
+	MediaType string                       `json:"mediaType,omitempty"`
+	Image     *distribution.ImageDetails   `json:"image,omitempty"`
+	Platforms []distribution.PlatformImage `json:"platforms,omitempty"`
+
+	// Immutable and Protected report the tag protection rules matching the
+	// tag.
+	Immutable bool `json:"immutable,omitempty"`
+	Protected bool `json:"protected,omitempty"`
 }
 
 type imageinfoAPIResponse struct {
-	Name          string               `json:"name"`
-	Tags          []taginfoAPIResponse `json:"tags"`
-	Size          int                  `json:"size"`
-	DownloadCount int                  `json:"downloadCount"`
-	LastModified  time.Time            `json:"lastModified"`
-	CreateTime    time.Time            `json:"createTime"`
+	Name            string                      `json:"name"`
+	Tags            []taginfoAPIResponse        `json:"tags"`
+	Size            int                         `json:"size"`
+	DownloadCount   int                         `json:"downloadCount"`
+	RecentDownloads []dailyDownloadsAPIResponse `json:"recentDownloads,omitempty"`
+	LastModified    time.Time                   `json:"lastModified"`
+	CreateTime      time.Time                   `json:"createTime"`
+
+	// Usage and Quota are only reported when quotas are enabled.
+	Usage *int64                   `json:"usage,omitempty"`
+	Quota *distribution.QuotaUsage `json:"quota,omitempty"`
+
+	// TagRules are the tag protection rules applying to the repository.
+	TagRules []tagRuleAPIResponse `json:"tagRules,omitempty"`
+}
+
+type tagRuleAPIResponse struct {
+	Repositories []string `json:"repositories,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	TagRegexp    string   `json:"tagRegexp,omitempty"`
+	Immutable    bool     `json:"immutable"`
+	NoDelete     bool     `json:"noDelete"`
+}
+
+type dailyDownloadsAPIResponse struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
 }
 
 type cataloginfoAPIResponse struct {
 	ImageInfos []imageinfoAPIResponse `json:"imageInfos"`
 }
 
-var catalogLock sync.RWMutex
+func newTaginfoAPIResponse(info distribution.TagInfo) taginfoAPIResponse {
+	return taginfoAPIResponse{
+		Name:          info.Name,
+		Tag:           info.Tag,
+		CreateTime:    info.CreateTime,
+		DownloadCount: info.DownloadCount,
+		Size:          info.Size,
+		Labels:        info.Labels,
+		Digest:        info.Digest,
+		MediaType:     info.MediaType,
+		Image:         info.Image,
+		Platforms:     info.Platforms,
+	}
+}
+
+func newImageinfoAPIResponse(info distribution.ImageInfo) imageinfoAPIResponse {
+	tags := make([]taginfoAPIResponse, len(info.Tags))
+	for i, tag := range info.Tags {
+		tags[i] = newTaginfoAPIResponse(tag)
+	}
+	return imageinfoAPIResponse{
+		Name:          info.Name,
+		Tags:          tags,
+		Size:          info.Size,
+		DownloadCount: info.DownloadCount,
+		LastModified:  info.LastModified,
+		CreateTime:    info.CreateTime,
+	}
+}
+
+func newDailyDownloadsAPIResponse(stats distribution.DownloadStats) []dailyDownloadsAPIResponse {
+	days := cache.RecentDownloadDays(time.Now())
+	daily := make([]dailyDownloadsAPIResponse, len(days))
+	for i, day := range days {
+		daily[i] = dailyDownloadsAPIResponse{
+			Date:  day,
+			Count: stats.Daily[day],
+		}
+	}
+	return daily
+}
+
+// taginfoResponse adds the pulls kept by the download counter to the count
+// recorded in the tag info. The recent time series is only included if
+// requested.
+func (ih *infoHandler) taginfoResponse(info distribution.TagInfo, recent bool) (taginfoAPIResponse, error) {
+	response := newTaginfoAPIResponse(info)
+	stats, err := ih.registry.DownloadCounter().TagDownloads(ih, info.Name, info.Tag)
+	if err != nil {
+		return response, err
+	}
+	response.DownloadCount += stats.Total
+	if recent {
+		response.RecentDownloads = newDailyDownloadsAPIResponse(stats)
+	}
+	ih.addTagProtection(&response)
+	return response, nil
+}
+
+// imageinfoResponse adds the pulls kept by the download counter to the
+// counts recorded in the image info and its tags. The recent time series of
+// the repository is only included if requested.
+func (ih *infoHandler) imageinfoResponse(info distribution.ImageInfo, recent bool) (imageinfoAPIResponse, error) {
+	response := newImageinfoAPIResponse(info)
+	return response, ih.addDownloads(&response, recent)
+}
+
+// addDownloads adds the pulls kept by the download counter to the counts of
+// the response.
+func (ih *infoHandler) addDownloads(response *imageinfoAPIResponse, recent bool) error {
+	counter := ih.registry.DownloadCounter()
+	stats, err := counter.RepositoryDownloads(ih, response.Name)
+	if err != nil {
+		return err
+	}
+	response.DownloadCount += stats.Total
+	if recent {
+		response.RecentDownloads = newDailyDownloadsAPIResponse(stats)
+	}
+
+	for i := range response.Tags {
+		tag := &response.Tags[i]
+		stats, err := counter.TagDownloads(ih, tag.Name, tag.Tag)
+		if err != nil {
+			return err
+		}
+		tag.DownloadCount += stats.Total
+	}
+	return nil
+}
+
+// addQuota adds the bytes stored by the repository, and the quota applying
+// to it, to the response.
+func (ih *infoHandler) addQuota(response *imageinfoAPIResponse) error {
+	quotas := ih.registry.Quotas()
+	if quotas == nil {
+		return nil
+	}
+
+	usage, err := quotas.Usage(ih, response.Name)
+	if err != nil {
+		return err
+	}
+	response.Usage = &usage
+
+	quota, ok, err := quotas.Quota(ih, response.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		response.Quota = &quota
+	}
+	return nil
+}
+
+// addTagRules adds the tag protection rules applying to the repository to
+// the response, and marks its protected tags.
+func (ih *infoHandler) addTagRules(response *imageinfoAPIResponse) {
+	for _, rule := range ih.tagRules {
+		if !rule.AppliesTo(response.Name) {
+			continue
+		}
+		r := tagRuleAPIResponse{
+			Repositories: rule.Repositories,
+			Tags:         rule.Tags,
+			Immutable:    rule.Immutable,
+			NoDelete:     rule.NoDelete,
+		}
+		if rule.TagRegexp != nil {
+			r.TagRegexp = rule.TagRegexp.String()
+		}
+		response.TagRules = append(response.TagRules, r)
+	}
+
+	for i := range response.Tags {
+		ih.addTagProtection(&response.Tags[i])
+	}
+}
+
+// addTagProtection marks the tag of the response if a tag protection rule
+// matches it.
+func (ih *infoHandler) addTagProtection(response *taginfoAPIResponse) {
+	for _, rule := range ih.tagRules {
+		if rule.Protects(response.Name, response.Tag) {
+			response.Immutable = response.Immutable || rule.Immutable
+			response.Protected = response.Protected || rule.NoDelete
+		}
+	}
+}
 
 func (ih *infoHandler) GetImageInfo(w http.ResponseWriter, r *http.Request) {
 	cacheservice := ih.Repository.Caches(ih)
-	content, err := cacheservice.GetImageInfo(ih)
+	imageinfo, err := cacheservice.GetImageInfo(ih)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	enc := json.NewEncoder(w)
 
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			ih.Errors = append(ih.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": ih.Repository.Named().Name()}))
+		default:
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
 		return
 	}
-	var imageinfo imageinfoAPIResponse
-	err = json.Unmarshal(content, &imageinfo)
+	response, err := ih.imageinfoResponse(imageinfo, true)
 	if err != nil {
 		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
-	if err := enc.Encode(&imageinfo); err != nil {
+	if err := ih.addQuota(&response); err != nil {
+		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	ih.addTagRules(&response)
+	if err := enc.Encode(&response); err != nil {
 		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
@@ -96,108 +299,95 @@ func (ih *infoHandler) DeleteImageRepository(w http.ResponseWriter, r *http.Requ
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	err := cacheservice.DeleteImageRepository(ih)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			ih.Errors = append(ih.Errors, v2.ErrorCodeNameUnknown.WithDetail(err))
+		case distribution.ErrTagProtected:
+			ih.Errors = append(ih.Errors, v2.ErrorCodeTagProtected.WithDetail(err))
+		default:
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
 		return
 	}
-	name := ih.Repository.Named().Name()
-	deleteImageFromCatalogInfo(ih.Context, name)
-	cacheservice.DeleteImageFromCatalogCache(ih, name)
 	w.WriteHeader(http.StatusAccepted)
 
 }
 
-// UpdateDownloadCount for pull image, when pulling image the downloadCount will add 1
+// updateDownloadCount counts a pull of the tag. The tag info is recorded
+// first if the tag has not been indexed yet.
 func updateDownloadCount(imh *imageManifestHandler, name, tag string) error {
 	cacheservice := imh.Repository.Caches(imh)
-	taginfocontent, err := cacheservice.GetTagInfo(imh, tag)
-	var taginfo taginfoAPIResponse
-	if err != nil {
-		if taginfo, err = createAndSaveTagInfo(imh, name); err != nil {
-			return err
-		}
-	} else {
-		if err = json.Unmarshal(taginfocontent, &taginfo); err != nil {
-			return err
-		}
-		if taginfo.DownloadCount < 1 {
-			taginfo.DownloadCount = 1
-		} else {
-			taginfo.DownloadCount++
-		}
-		if taginfocontent, err = json.Marshal(taginfo); err != nil {
-			return err
-		}
-		if err = cacheservice.SaveTagInfo(imh, tag, taginfocontent); err != nil {
+	if _, err := cacheservice.GetTagInfo(imh, tag); err != nil {
+		if _, err := createAndSaveTagInfo(imh, name); err != nil {
 			return err
 		}
 	}
-	return nil
+	return imh.registry.DownloadCounter().Increment(imh, name, tag, time.Now())
 }
 
-// CreateAndSaveImageInfo for push image use
-// When info file exist, the downloadCount will add 1, if not exist, will create a new file
-func createAndSaveImageInfo(ctx *Context, name string) (imageinfoAPIResponse, error) {
-	tagservice := ctx.Repository.Tags(ctx)
-	cacheservice := ctx.Repository.Caches(ctx)
-	taglist, err := tagservice.All(ctx)
+func (ih *infoHandler) GetCatalogInfo(w http.ResponseWriter, r *http.Request) {
+	query, err := parseCatalogInfoQuery(r.URL.Query())
 	if err != nil {
-		return imageinfoAPIResponse{}, err
+		ih.Errors = append(ih.Errors, v2.ErrorCodeQueryParameterInvalid.WithDetail(err.Error()))
+		return
 	}
-	tags := make([]taginfoAPIResponse, len(taglist))
-	var lastModified time.Time
-	downloadCount := 0
-	for i, tagname := range taglist {
-		content, err := cacheservice.GetTagInfo(ctx, tagname)
-		if err != nil {
-			continue
+
+	index := ih.registry.MetadataIndex()
+	imageinfos, err := index.ImageInfos(ih)
+	if err != nil {
+		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	responses := make([]imageinfoAPIResponse, 0, len(imageinfos))
+	for _, imageinfo := range imageinfos {
+		if query.matches(imageinfo.Name) && pullAllowed(ih, imageinfo.Name) {
+			responses = append(responses, newImageinfoAPIResponse(imageinfo))
 		}
-		var taginfo taginfoAPIResponse
-		if err = json.Unmarshal(content, &taginfo); err != nil {
-			continue
+	}
+
+	// download counts are only known once added from the download counter,
+	// so the cached totals of every repository are added to sort on them.
+	var totals map[string]int
+	if query.sortBy == "downloadCount" {
+		names := make([]string, len(imageinfos))
+		for i, imageinfo := range imageinfos {
+			names[i] = imageinfo.Name
 		}
-		if lastModified.Before(taginfo.CreateTime) {
-			lastModified = taginfo.CreateTime
+		totals, err = ih.App.downloadTotals.get(ih, ih.registry.DownloadCounter(), names)
+		if err != nil {
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
 		}
-		downloadCount += taginfo.DownloadCount
-		tags[i] = taginfo
-	}
-	imageinfo := imageinfoAPIResponse{
-		Name:          name,
-		Tags:          tags,
-		Size:          len(taglist),
-		DownloadCount: downloadCount,
-		LastModified:  lastModified,
-		CreateTime:    lastModified,
-	}
-	infocontent, err := cacheservice.GetImageInfo(ctx)
-	if err == nil {
-		var existinfo imageinfoAPIResponse
-		if err = json.Unmarshal(infocontent, &existinfo); err == nil && !existinfo.CreateTime.IsZero() {
-			imageinfo.CreateTime = existinfo.CreateTime
+		for i := range responses {
+			responses[i].DownloadCount += totals[responses[i].Name]
 		}
 	}
 
-	jsonContent, _ := json.Marshal(imageinfo)
-	cacheservice.SaveImageInfo(ctx, jsonContent)
+	query.sort(responses)
+	page, moreEntries := query.page(responses)
 
-	return imageinfo, nil
-}
+	// Add a link header if there are more entries to retrieve
+	if moreEntries {
+		urlStr, err := createInfoLinkEntry(r.URL.String(), query.maxEntries, query.cursor(page[len(page)-1]))
+		if err != nil {
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
 
-func (ih *infoHandler) GetCatalogInfo(w http.ResponseWriter, r *http.Request) {
-	blobCache := ih.registry.BlobCache()
-	content, err := blobCache.GetCatalogInfo(ih)
-	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-		return
+	for i := range page {
+		page[i].DownloadCount -= totals[page[i].Name]
+		if err := ih.addDownloads(&page[i], false); err != nil {
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
 	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	enc := json.NewEncoder(w)
-	var response cataloginfoAPIResponse
-	err = json.Unmarshal(content, &response)
-	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-		return
-	}
-	if err := enc.Encode(&response); err != nil {
+	if err := enc.Encode(&cataloginfoAPIResponse{ImageInfos: page}); err != nil {
 		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
@@ -206,17 +396,31 @@ func (ih *infoHandler) GetCatalogInfo(w http.ResponseWriter, r *http.Request) {
 func (ih *infoHandler) GetTaginfo(w http.ResponseWriter, r *http.Request) {
 	tag := getTag(ih)
 	cacheservice := ih.Repository.Caches(ih)
-	content, err := cacheservice.GetTagInfo(ih, tag)
-	var response taginfoAPIResponse
+	taginfo, err := cacheservice.GetTagInfo(ih, tag)
+	if _, ok := err.(distribution.ErrTagUnknown); ok && ih.warmingUp() {
+		// The tag info may not have been built yet.
+		taginfo, err = createAndSaveTagInfo(&imageManifestHandler{Context: ih.Context, Tag: tag}, ih.Repository.Named().Name())
+	} else if err == nil && taginfo.Digest == "" && !ih.isReadOnly() {
+		// The tag info was built before the image details were recorded.
+		if rebuilt, err := createAndSaveTagInfo(&imageManifestHandler{Context: ih.Context, Tag: tag}, ih.Repository.Named().Name()); err == nil {
+			taginfo = rebuilt
+		} else {
+			ctxu.GetLogger(ih).Warnf("error rebuilding tag info of %s: %v", tag, err)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	// Add a link header if there are more entries to retrieve
 	enc := json.NewEncoder(w)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		switch err := err.(type) {
+		case distribution.ErrTagUnknown:
+			ih.Errors = append(ih.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		default:
+			ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
 		return
 	}
-	err = json.Unmarshal(content, &response)
+	response, err := ih.taginfoResponse(taginfo, true)
 	if err != nil {
 		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -228,132 +432,210 @@ func (ih *infoHandler) GetTaginfo(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func createAndSaveTagInfo(imh *imageManifestHandler, name string) (taginfoAPIResponse, error) {
-	schema2manifest, convertedManifest, err := getTagManifests(imh)
+// createAndSaveTagInfo builds the tag info of imh.Tag from its manifest and
+// image configuration, and saves it in the metadata index.
+func createAndSaveTagInfo(imh *imageManifestHandler, name string) (distribution.TagInfo, error) {
+	desc, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
 	if err != nil {
-		return taginfoAPIResponse{}, err
-	}
-	var scheme1manifest *schema1.SignedManifest
-	if convertedManifest != nil {
-		scheme1manifest, _ = convertedManifest.(*schema1.SignedManifest)
-	} else {
-		scheme1manifest, _ = schema2manifest.(*schema1.SignedManifest)
-	}
-
-	type v1Compatibility struct {
-		ID              string    `json:"id"`
-		Parent          string    `json:"parent,omitempty"`
-		Comment         string    `json:"comment,omitempty"`
-		Created         time.Time `json:"created"`
-		Size            int64     `json:"size"`
-		ContainerConfig struct {
-			Cmd []string
-		} `json:"container_config,omitempty"`
-		Author    string `json:"author,omitempty"`
-		ThrowAway bool   `json:"throwaway,omitempty"`
-	}
-	var createTime time.Time
-	for _, history := range scheme1manifest.History {
-		var historyinfo v1Compatibility
-		json.Unmarshal([]byte(history.V1Compatibility), &historyinfo)
-		if createTime.Before(historyinfo.Created) {
-			createTime = historyinfo.Created
-		}
+		return distribution.TagInfo{}, err
 	}
-	blobs := imh.Repository.Blobs(imh)
-	var size int64
-	for _, d := range schema2manifest.References() {
-		desc, err := blobs.Stat(imh, d.Digest)
-		if err != nil {
-			return taginfoAPIResponse{}, err
-		}
-		size += desc.Size
+	manifests, err := imh.Repository.Manifests(imh)
+	if err != nil {
+		return distribution.TagInfo{}, err
 	}
-	response := taginfoAPIResponse{
-		Name:          name,
-		Tag:           imh.Tag,
-		DownloadCount: 0,
-		CreateTime:    createTime,
-		Size:          size,
+	manifest, err := manifests.Get(imh, desc.Digest, distribution.WithTag(imh.Tag))
+	if err != nil {
+		return distribution.TagInfo{}, err
 	}
-	cacheservice := imh.Repository.Caches(imh)
-	infocontent, err := cacheservice.GetTagInfo(imh, imh.Tag)
-	if err == nil {
-		var existinfo taginfoAPIResponse
-		if err = json.Unmarshal(infocontent, &existinfo); err == nil && existinfo.DownloadCount > 0 {
-			response.DownloadCount = existinfo.DownloadCount
-		}
+
+	taginfo := distribution.TagInfo{
+		Name:   name,
+		Tag:    imh.Tag,
+		Digest: desc.Digest,
 	}
-	jsonContent, err := json.Marshal(response)
-	cacheservice.SaveTagInfo(imh, imh.Tag, jsonContent)
-	return response, nil
+	taginfo.MediaType, _, _ = manifest.Payload()
 
-}
-func deleteImageFromCatalogInfo(ctx *Context, name string) error {
-	catalogLock.Lock()
-	defer catalogLock.Unlock()
-	cacheService := ctx.Repository.Caches(ctx)
-	content, err := cacheService.GetCatalogInfo(ctx)
+	if manifestList, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		err = addPlatformImages(imh, manifests, manifestList, &taginfo)
+	} else {
+		var details distribution.ImageDetails
+		details, taginfo.CreateTime, taginfo.Size, err = imageDetails(imh, manifest)
+		taginfo.Image = &details
+		taginfo.Labels = details.Labels
+	}
 	if err != nil {
-		return err
+		return distribution.TagInfo{}, err
 	}
-	var cataloginfo cataloginfoAPIResponse
-	if err = json.Unmarshal(content, &cataloginfo); err != nil {
-		return err
+
+	cacheservice := imh.Repository.Caches(imh)
+	existinfo, err := cacheservice.GetTagInfo(imh, imh.Tag)
+	if err == nil && existinfo.DownloadCount > 0 {
+		taginfo.DownloadCount = existinfo.DownloadCount
 	}
-	flag := -1
-	for i, image := range cataloginfo.ImageInfos {
-		if image.Name == name {
-			flag = i
-			break
-		}
+	if err := cacheservice.SaveTagInfo(imh, taginfo); err != nil {
+		return distribution.TagInfo{}, err
 	}
-	if flag > -1 {
-		cataloginfo.ImageInfos = append(cataloginfo.ImageInfos[0:flag], cataloginfo.ImageInfos[flag+1:]...)
+	return taginfo, nil
+}
+
+// addPlatformImages describes the image of each platform of the manifest
+// list in the tag info. The tag is as recent as its most recent image, and
+// its size counts the blobs shared by several platforms once. Its labels are
+// the labels of the image of the default platform.
+func addPlatformImages(imh *imageManifestHandler, manifests distribution.ManifestService, manifestList *manifestlist.DeserializedManifestList, taginfo *distribution.TagInfo) error {
+	blobs := imh.Repository.Blobs(imh)
+	sizes := make(map[digest.Digest]int64)
+	for _, descriptor := range manifestList.Manifests {
+		manifest, err := manifests.Get(imh, descriptor.Digest)
+		if err != nil {
+			return err
+		}
+		details, createTime, size, err := imageDetails(imh, manifest)
+		if err != nil {
+			return err
+		}
+		if details.Architecture == "" {
+			details.Architecture = descriptor.Platform.Architecture
+		}
+		if details.OS == "" {
+			details.OS = descriptor.Platform.OS
+		}
+
+		taginfo.Platforms = append(taginfo.Platforms, distribution.PlatformImage{
+			Digest:       descriptor.Digest,
+			MediaType:    descriptor.MediaType,
+			Variant:      descriptor.Platform.Variant,
+			CreateTime:   createTime,
+			Size:         size,
+			ImageDetails: details,
+		})
+		if taginfo.CreateTime.Before(createTime) {
+			taginfo.CreateTime = createTime
+		}
+		if descriptor.Platform.Architecture == defaultArch && descriptor.Platform.OS == defaultOS {
+			taginfo.Labels = details.Labels
+		}
+
+		for _, reference := range manifest.References() {
+			if _, ok := sizes[reference.Digest]; ok {
+				continue
+			}
+			size, err := blobSize(imh, blobs, reference)
+			if err != nil {
+				return err
+			}
+			sizes[reference.Digest] = size
+		}
 	}
-	if content, err = json.Marshal(cataloginfo); err != nil {
-		return err
+
+	for _, size := range sizes {
+		taginfo.Size += size
 	}
-	return cacheService.SaveCatalogInfo(ctx, content)
+	return nil
 }
 
-func updateCatalogInfo(ctx *Context, imageInfo imageinfoAPIResponse) error {
-	catalogLock.Lock()
-	defer catalogLock.Unlock()
-	cacheService := ctx.Repository.Caches(ctx)
-	content, err := cacheService.GetCatalogInfo(ctx)
+// blobSize returns the size of a blob referenced by a manifest. Foreign
+// layers are not stored by registries, so the size declared by the manifest
+// is used for them.
+func blobSize(ctx ctxu.Context, blobs distribution.BlobStatter, reference distribution.Descriptor) (int64, error) {
+	if reference.MediaType == schema2.MediaTypeForeignLayer {
+		return reference.Size, nil
+	}
+	desc, err := blobs.Stat(ctx, reference.Digest)
 	if err != nil {
-		return err
-	}
-	var cataloginfo cataloginfoAPIResponse
-	if err = json.Unmarshal(content, &cataloginfo); err != nil {
-		return err
+		return 0, err
 	}
-	begin, end := 0, len(cataloginfo.ImageInfos)-1
-	imageName := imageInfo.Name
-	for begin < end {
-		middle := begin + (end-begin)/2
-		ret := strings.Compare(cataloginfo.ImageInfos[middle].Name, imageName)
-		if ret > 0 {
-			end = middle - 1
-		} else if ret < 0 {
-			begin = middle + 1
-		} else {
-			return nil
+	return desc.Size, nil
+}
+
+// imageConfig holds the fields of an image configuration recorded in the
+// tag info. The v1Compatibility entries of schema1 manifests have the same
+// layout.
+type imageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       struct {
+		Entrypoint   []string
+		Cmd          []string
+		ExposedPorts map[string]struct{}
+		Env          []string
+		Labels       map[string]string
+	} `json:"config"`
+}
+
+// imageDetails describes the image of a schema1 or schema2 manifest, and
+// returns its creation time and the total size of the blobs it references.
+func imageDetails(imh *imageManifestHandler, manifest distribution.Manifest) (distribution.ImageDetails, time.Time, int64, error) {
+	blobs := imh.Repository.Blobs(imh)
+
+	var config imageConfig
+	var layers []digest.Digest
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		content, err := blobs.Get(imh, m.Config.Digest)
+		if err != nil {
+			return distribution.ImageDetails{}, time.Time{}, 0, err
 		}
+		if err := json.Unmarshal(content, &config); err != nil {
+			return distribution.ImageDetails{}, time.Time{}, 0, err
+		}
+		for _, layer := range m.Layers {
+			layers = append(layers, layer.Digest)
+		}
+	case *schema1.SignedManifest:
+		for i, history := range m.History {
+			var historyinfo imageConfig
+			json.Unmarshal([]byte(history.V1Compatibility), &historyinfo)
+			// the first entry holds the configuration of the image itself
+			if i == 0 {
+				config = historyinfo
+			}
+			if config.Created.Before(historyinfo.Created) {
+				config.Created = historyinfo.Created
+			}
+		}
+		// schema1 lists the layers from the top layer down
+		for i := len(m.FSLayers) - 1; i >= 0; i-- {
+			layers = append(layers, m.FSLayers[i].BlobSum)
+		}
+	default:
+		return distribution.ImageDetails{}, time.Time{}, 0, fmt.Errorf("unsupported manifest type %T", manifest)
 	}
-	flag := begin
-	if strings.Compare(cataloginfo.ImageInfos[begin].Name, imageName) < 0 {
-		flag++
-	}
-	if strings.Compare(cataloginfo.ImageInfos[begin].Name, imageName) == 0 {
-		cataloginfo.ImageInfos[begin] = imageInfo
-	} else {
-		tmp := append([]imageinfoAPIResponse{}, cataloginfo.ImageInfos[flag:]...)
-		cataloginfo.ImageInfos = append(append(cataloginfo.ImageInfos[:flag], imageInfo), tmp...)
-	}
-	if content, err = json.Marshal(cataloginfo); err != nil {
-		return err
+
+	sizes := make(map[digest.Digest]int64)
+	var size int64
+	for _, reference := range manifest.References() {
+		if _, ok := sizes[reference.Digest]; !ok {
+			blobSize, err := blobSize(imh, blobs, reference)
+			if err != nil {
+				return distribution.ImageDetails{}, time.Time{}, 0, err
+			}
+			sizes[reference.Digest] = blobSize
+		}
+		size += sizes[reference.Digest]
 	}
-	return cacheService.SaveCatalogInfo(ctx, content)
+
+	details := distribution.ImageDetails{
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		Labels:       config.Config.Labels,
+		Entrypoint:   config.Config.Entrypoint,
+		Cmd:          config.Config.Cmd,
+		LayerCount:   len(layers),
+	}
+	for port := range config.Config.ExposedPorts {
+		details.ExposedPorts = append(details.ExposedPorts, port)
+	}
+	sort.Strings(details.ExposedPorts)
+	for _, env := range config.Config.Env {
+		details.EnvKeys = append(details.EnvKeys, strings.SplitN(env, "=", 2)[0])
+	}
+	for _, layer := range layers {
+		details.Layers = append(details.Layers, distribution.LayerInfo{
+			Digest: layer,
+			Size:   sizes[layer],
+		})
+	}
+	return details, config.Created, size, nil
 }
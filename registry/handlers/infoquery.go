@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+)
+
+// catalogInfoSortKeys lists the supported values of the sort parameter of
+// cataloginfo, along with their default order.
+var catalogInfoSortKeys = map[string]string{
+	"name":          "asc",
+	"lastModified":  "desc",
+	"downloadCount": "desc",
+	"createTime":    "desc",
+}
+
+// catalogInfoQuery holds the filtering, sorting and pagination parameters of
+// a cataloginfo request.
+type catalogInfoQuery struct {
+	// maxEntries limits the number of entries of the response. Zero means
+	// all entries are returned.
+	maxEntries int
+	prefix     string
+	contains   string
+	sortBy     string
+	descending bool
+
+	// after is the last entry of the previous page, as encoded in the
+	// cursor of the last parameter. Only its name and sort key are set.
+	after *imageinfoAPIResponse
+}
+
+func parseCatalogInfoQuery(q url.Values) (catalogInfoQuery, error) {
+	query := catalogInfoQuery{
+		prefix:   q.Get("prefix"),
+		contains: q.Get("contains"),
+		sortBy:   q.Get("sort"),
+	}
+
+	if n := q.Get("n"); n != "" {
+		maxEntries, err := strconv.Atoi(n)
+		if err != nil || maxEntries <= 0 {
+			return query, fmt.Errorf("n must be a positive integer: %q", n)
+		}
+		query.maxEntries = maxEntries
+	}
+
+	if query.sortBy == "" {
+		query.sortBy = "name"
+	}
+	order, ok := catalogInfoSortKeys[query.sortBy]
+	if !ok {
+		return query, fmt.Errorf("unsupported sort key: %q", query.sortBy)
+	}
+
+	if o := q.Get("order"); o != "" {
+		order = o
+	}
+	switch order {
+	case "asc":
+	case "desc":
+		query.descending = true
+	default:
+		return query, fmt.Errorf("unsupported order: %q", order)
+	}
+
+	if last := q.Get("last"); last != "" {
+		after, err := parseCatalogInfoCursor(query.sortBy, last)
+		if err != nil {
+			return query, err
+		}
+		query.after = &after
+	}
+
+	return query, nil
+}
+
+// parseCatalogInfoCursor parses the value of the last parameter. When
+// sorting by name, it is the name of the last entry. For the other sort
+// keys, it is the sort key of the last entry, a comma, and its name, which
+// cannot contain a comma.
+func parseCatalogInfoCursor(sortBy, last string) (imageinfoAPIResponse, error) {
+	if sortBy == "name" {
+		return imageinfoAPIResponse{Name: last}, nil
+	}
+
+	i := strings.Index(last, ",")
+	if i < 0 {
+		return imageinfoAPIResponse{}, fmt.Errorf("last must be the cursor of a link header when sorting by %s: %q", sortBy, last)
+	}
+	entry := imageinfoAPIResponse{Name: last[i+1:]}
+	value := last[:i]
+
+	var err error
+	switch sortBy {
+	case "lastModified":
+		entry.LastModified, err = time.Parse(time.RFC3339Nano, value)
+	case "createTime":
+		entry.CreateTime, err = time.Parse(time.RFC3339Nano, value)
+	case "downloadCount":
+		entry.DownloadCount, err = strconv.Atoi(value)
+	}
+	if err != nil {
+		return imageinfoAPIResponse{}, fmt.Errorf("invalid %s in last: %q", sortBy, last)
+	}
+	return entry, nil
+}
+
+// cursor returns the value of the last parameter resuming the listing after
+// the entry.
+func (q catalogInfoQuery) cursor(entry imageinfoAPIResponse) string {
+	var value string
+	switch q.sortBy {
+	case "name":
+		return entry.Name
+	case "lastModified":
+		value = entry.LastModified.Format(time.RFC3339Nano)
+	case "createTime":
+		value = entry.CreateTime.Format(time.RFC3339Nano)
+	case "downloadCount":
+		value = strconv.Itoa(entry.DownloadCount)
+	}
+	return value + "," + entry.Name
+}
+
+// matches reports whether the repository passes the name filters.
+func (q catalogInfoQuery) matches(name string) bool {
+	return strings.HasPrefix(name, q.prefix) && strings.Contains(name, q.contains)
+}
+
+// sort orders the entries by the sort key, breaking ties by name.
+func (q catalogInfoQuery) sort(entries []imageinfoAPIResponse) {
+	var s sort.Interface = imageinfosByKey{
+		entries: entries,
+		key:     q.sortBy,
+	}
+	if q.descending {
+		s = sort.Reverse(s)
+	}
+	sort.Sort(s)
+}
+
+// page returns the sorted entries following the last entry, limited to the
+// maximum number of entries, and whether more entries remain. The listing
+// resumes at the position the last entry had, even if it is no longer
+// listed.
+func (q catalogInfoQuery) page(entries []imageinfoAPIResponse) ([]imageinfoAPIResponse, bool) {
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(entries), func(i int) bool {
+			if q.descending {
+				return lessByKey(q.sortBy, entries[i], *q.after)
+			}
+			return lessByKey(q.sortBy, *q.after, entries[i])
+		})
+	}
+
+	end := len(entries)
+	if q.maxEntries > 0 && start+q.maxEntries < end {
+		end = start + q.maxEntries
+	}
+	return entries[start:end], end < len(entries)
+}
+
+type imageinfosByKey struct {
+	entries []imageinfoAPIResponse
+	key     string
+}
+
+func (s imageinfosByKey) Len() int      { return len(s.entries) }
+func (s imageinfosByKey) Swap(i, j int) { s.entries[i], s.entries[j] = s.entries[j], s.entries[i] }
+
+func (s imageinfosByKey) Less(i, j int) bool {
+	return lessByKey(s.key, s.entries[i], s.entries[j])
+}
+
+// lessByKey orders entries by the sort key, breaking ties by name.
+func lessByKey(key string, a, b imageinfoAPIResponse) bool {
+	switch key {
+	case "lastModified":
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.Before(b.LastModified)
+		}
+	case "downloadCount":
+		if a.DownloadCount != b.DownloadCount {
+			return a.DownloadCount < b.DownloadCount
+		}
+	case "createTime":
+		if !a.CreateTime.Equal(b.CreateTime) {
+			return a.CreateTime.Before(b.CreateTime)
+		}
+	}
+	return a.Name < b.Name
+}
+
+// createInfoLinkEntry uses the original URL from the request to create the
+// link header of a paginated info listing, keeping the filtering and sorting
+// parameters of the request.
+func createInfoLinkEntry(origURL string, maxEntries int, cursor string) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", cursor)
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	urlStr := fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String())
+
+	return urlStr, nil
+}
+
+// downloadTotalsTTL is how long the download totals of the repositories are
+// reused to sort the catalog info by download count.
+const downloadTotalsTTL = time.Minute
+
+// downloadTotals caches the pulls counted for every repository, so that
+// sorting the catalog info by download count does not read the counters of
+// every repository on each request. The counts of the listed entries are
+// still read from the download counter.
+type downloadTotals struct {
+	mu         sync.Mutex
+	totals     map[string]int
+	readAt     time.Time
+	refreshing bool
+}
+
+// get returns the pulls counted for the named repositories. Once the totals
+// are older than downloadTotalsTTL, one request reads them again while the
+// others keep using the previous totals. The map returned must not be
+// modified.
+func (d *downloadTotals) get(ctx context.Context, counter distribution.DownloadCounter, names []string) (map[string]int, error) {
+	d.mu.Lock()
+	totals := d.totals
+	if totals != nil && (d.refreshing || time.Since(d.readAt) < downloadTotalsTTL) {
+		d.mu.Unlock()
+		return d.complete(ctx, counter, totals, names)
+	}
+	d.refreshing = true
+	d.mu.Unlock()
+
+	fresh, err := d.complete(ctx, counter, nil, names)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.refreshing = false
+	if err != nil {
+		return nil, err
+	}
+	d.totals, d.readAt = fresh, time.Now()
+	return fresh, nil
+}
+
+// complete reads the totals of the repositories missing from totals, such
+// as the repositories created since they were read.
+func (d *downloadTotals) complete(ctx context.Context, counter distribution.DownloadCounter, totals map[string]int, names []string) (map[string]int, error) {
+	var completed map[string]int
+	for _, name := range names {
+		if _, ok := totals[name]; ok {
+			continue
+		}
+		if completed == nil {
+			completed = make(map[string]int, len(totals)+len(names))
+			for name, total := range totals {
+				completed[name] = total
+			}
+		}
+		stats, err := counter.RepositoryDownloads(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		completed[name] = stats.Total
+	}
+	if completed == nil {
+		return totals, nil
+	}
+	return completed, nil
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+)
+
+func TestCatalogInfoQuery(t *testing.T) {
+	created := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	entries := func() []imageinfoAPIResponse {
+		return []imageinfoAPIResponse{
+			{Name: "foo/bar", DownloadCount: 5, CreateTime: created.Add(2 * time.Hour)},
+			{Name: "bar", DownloadCount: 9, CreateTime: created},
+			{Name: "foo/baz", DownloadCount: 5, CreateTime: created.Add(time.Hour)},
+			{Name: "foo/qux", DownloadCount: 1, CreateTime: created.Add(3 * time.Hour)},
+		}
+	}
+
+	for _, testcase := range []struct {
+		query    string
+		expected []string
+		more     bool
+	}{
+		{
+			query:    "",
+			expected: []string{"bar", "foo/bar", "foo/baz", "foo/qux"},
+		},
+		{
+			query:    "n=2",
+			expected: []string{"bar", "foo/bar"},
+			more:     true,
+		},
+		{
+			query:    "n=2&last=foo%2Fbar",
+			expected: []string{"foo/baz", "foo/qux"},
+		},
+		{
+			// the last entry no longer exists
+			query:    "n=2&last=foo%2Fba",
+			expected: []string{"foo/bar", "foo/baz"},
+			more:     true,
+		},
+		{
+			query:    "prefix=foo%2F&order=desc",
+			expected: []string{"foo/qux", "foo/baz", "foo/bar"},
+		},
+		{
+			query:    "contains=ba",
+			expected: []string{"bar", "foo/bar", "foo/baz"},
+		},
+		{
+			query:    "sort=downloadCount",
+			expected: []string{"bar", "foo/baz", "foo/bar", "foo/qux"},
+		},
+		{
+			query:    "sort=downloadCount&n=1&last=5%2Cfoo%2Fbaz",
+			expected: []string{"foo/bar"},
+			more:     true,
+		},
+		{
+			// the last entry no longer exists
+			query:    "sort=downloadCount&n=2&last=6%2Cfoo%2Fzzz",
+			expected: []string{"foo/baz", "foo/bar"},
+			more:     true,
+		},
+		{
+			query:    "sort=createTime&order=asc&last=" + url.QueryEscape(created.Add(time.Hour).Format(time.RFC3339Nano)+",foo/baz"),
+			expected: []string{"foo/bar", "foo/qux"},
+		},
+		{
+			query:    "sort=createTime&order=asc",
+			expected: []string{"bar", "foo/baz", "foo/bar", "foo/qux"},
+		},
+	} {
+		values, err := url.ParseQuery(testcase.query)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", testcase.query, err)
+		}
+
+		query, err := parseCatalogInfoQuery(values)
+		if err != nil {
+			t.Fatalf("unexpected error parsing catalog info query %q: %v", testcase.query, err)
+		}
+
+		var filtered []imageinfoAPIResponse
+		for _, entry := range entries() {
+			if query.matches(entry.Name) {
+				filtered = append(filtered, entry)
+			}
+		}
+		query.sort(filtered)
+		page, more := query.page(filtered)
+
+		if len(page) > 0 {
+			// the cursor of an entry resumes the listing after it
+			after, err := parseCatalogInfoCursor(query.sortBy, query.cursor(page[0]))
+			if err != nil {
+				t.Fatalf("unexpected error parsing the cursor of %q: %v", testcase.query, err)
+			}
+			query.after, query.maxEntries = &after, 0
+			if next, _ := query.page(filtered); len(next) != len(filtered)-indexOf(filtered, page[0].Name)-1 {
+				t.Fatalf("unexpected page after the cursor of %q: %v", testcase.query, next)
+			}
+		}
+
+		names := make([]string, len(page))
+		for i, entry := range page {
+			names[i] = entry.Name
+		}
+
+		if !reflect.DeepEqual(names, testcase.expected) || more != testcase.more {
+			t.Fatalf("unexpected page for %q: %v (more: %v) != %v (more: %v)", testcase.query, names, more, testcase.expected, testcase.more)
+		}
+	}
+
+	for _, invalid := range []string{"n=0", "n=foo", "sort=size", "order=up", "sort=createTime&last=foo", "sort=downloadCount&last=x%2Cfoo"} {
+		values, _ := url.ParseQuery(invalid)
+		if _, err := parseCatalogInfoQuery(values); err == nil {
+			t.Fatalf("expected error parsing catalog info query %q", invalid)
+		}
+	}
+}
+
+// indexOf returns the position of the named entry.
+func indexOf(entries []imageinfoAPIResponse, name string) int {
+	for i, entry := range entries {
+		if entry.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestCreateInfoLinkEntry(t *testing.T) {
+	link, err := createInfoLinkEntry("/v2/cataloginfo?n=2&sort=downloadCount&prefix=foo", 2, "5,foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error creating link entry: %v", err)
+	}
+
+	expected := `</v2/cataloginfo?last=5%2Cfoo%2Fbar&n=2&prefix=foo&sort=downloadCount>; rel="next"`
+	if link != expected {
+		t.Fatalf("unexpected link entry: %s != %s", link, expected)
+	}
+}
+
+type countingDownloadCounter struct {
+	distribution.DownloadCounter
+	reads int
+}
+
+func (c *countingDownloadCounter) RepositoryDownloads(ctx context.Context, name string) (distribution.DownloadStats, error) {
+	c.reads++
+	return distribution.DownloadStats{Total: len(name)}, nil
+}
+
+func TestDownloadTotals(t *testing.T) {
+	ctx := context.Background()
+	counter := &countingDownloadCounter{}
+	var d downloadTotals
+
+	totals, err := d.get(ctx, counter, []string{"foo", "foo/bar"})
+	if err != nil {
+		t.Fatalf("unexpected error getting download totals: %v", err)
+	}
+	if totals["foo"] != 3 || totals["foo/bar"] != 7 || counter.reads != 2 {
+		t.Fatalf("unexpected totals: %v (%d reads)", totals, counter.reads)
+	}
+
+	// the totals are reused, and only new repositories are read
+	totals, err = d.get(ctx, counter, []string{"foo", "foo/bar", "baz"})
+	if err != nil {
+		t.Fatalf("unexpected error getting download totals: %v", err)
+	}
+	if totals["baz"] != 3 || counter.reads != 3 {
+		t.Fatalf("unexpected totals: %v (%d reads)", totals, counter.reads)
+	}
+
+	d.readAt = time.Now().Add(-downloadTotalsTTL)
+	if _, err := d.get(ctx, counter, []string{"foo", "foo/bar", "baz"}); err != nil {
+		t.Fatalf("unexpected error getting download totals: %v", err)
+	}
+	if counter.reads != 6 {
+		t.Fatalf("expected the stale totals to be read again, got %d reads", counter.reads)
+	}
+}
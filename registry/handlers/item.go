@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/docker/distribution"
@@ -15,11 +16,18 @@ func imageItemDispatcher(ctx *Context, r *http.Request) http.Handler {
 		Context: ctx,
 	}
 
-	return handlers.MethodHandler{
-		"GET":    http.HandlerFunc(imageItemHandler.GetImageItem),
-		"POST":   http.HandlerFunc(imageItemHandler.SaveImageItem),
-		"DELETE": http.HandlerFunc(imageItemHandler.DeleteImageItem),
+	mhandler := handlers.MethodHandler{
+		"GET":  http.HandlerFunc(imageItemHandler.GetImageItem),
+		"HEAD": http.HandlerFunc(imageItemHandler.GetImageItem),
+	}
+
+	if !ctx.isReadOnly() {
+		mhandler["POST"] = http.HandlerFunc(imageItemHandler.SaveImageItem)
+		mhandler["PUT"] = http.HandlerFunc(imageItemHandler.SaveImageItem)
+		mhandler["DELETE"] = http.HandlerFunc(imageItemHandler.DeleteImageItem)
 	}
+
+	return mhandler
 }
 
 func imageItemListDispatcher(ctx *Context, r *http.Request) http.Handler {
@@ -47,11 +55,18 @@ func tagItemDispatcher(ctx *Context, r *http.Request) http.Handler {
 		Context: ctx,
 	}
 
-	return handlers.MethodHandler{
-		"GET":    http.HandlerFunc(tagItemHandler.GetTagItem),
-		"POST":   http.HandlerFunc(tagItemHandler.SaveTagItem),
-		"DELETE": http.HandlerFunc(tagItemHandler.DeleteTagItem),
+	mhandler := handlers.MethodHandler{
+		"GET":  http.HandlerFunc(tagItemHandler.GetTagItem),
+		"HEAD": http.HandlerFunc(tagItemHandler.GetTagItem),
+	}
+
+	if !ctx.isReadOnly() {
+		mhandler["POST"] = http.HandlerFunc(tagItemHandler.SaveTagItem)
+		mhandler["PUT"] = http.HandlerFunc(tagItemHandler.SaveTagItem)
+		mhandler["DELETE"] = http.HandlerFunc(tagItemHandler.DeleteTagItem)
 	}
+
+	return mhandler
 }
 
 type itemHandler struct {
@@ -97,13 +112,13 @@ func (ih *itemHandler) GetTagItemNameList(w http.ResponseWriter, r *http.Request
 	cacheservice := ih.Repository.Caches(ih)
 	names, err := cacheservice.GetTagItemList(ih, tag)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	// Add a link header if there are more entries to retrieve
-	enc := json.NewEncoder(w)
 	if err != nil {
-		ih.Errors = append(ih.Errors, v2.ErrorCodeItemUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
+
+	// Add a link header if there are more entries to retrieve
+	enc := json.NewEncoder(w)
 	if err := enc.Encode(&names); err != nil {
 		ih.Errors = append(ih.Errors, v2.ErrorCodeItemUnknown.WithDetail(err))
 		return
@@ -116,7 +131,7 @@ func (ih *itemHandler) GetImageItem(w http.ResponseWriter, r *http.Request) {
 	cacheservice := ih.Repository.Caches(ih)
 	err := cacheservice.GetImageItem(ih, w, r, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
 }
@@ -127,7 +142,7 @@ func (ih *itemHandler) GetTagItem(w http.ResponseWriter, r *http.Request) {
 	cacheservice := ih.Repository.Caches(ih)
 	err := cacheservice.GetTagItem(ih, w, r, tag, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
 }
@@ -135,22 +150,26 @@ func (ih *itemHandler) GetTagItem(w http.ResponseWriter, r *http.Request) {
 func (ih *itemHandler) SaveImageItem(w http.ResponseWriter, r *http.Request) {
 	item := getItem(ih)
 	cacheservice := ih.Repository.Caches(ih)
-	err := cacheservice.SaveImageItem(ih, w, r, item)
+	desc, err := cacheservice.SaveImageItem(ih, w, r, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest))
 }
 
 func (ih *itemHandler) SaveTagItem(w http.ResponseWriter, r *http.Request) {
 	item := getItem(ih)
 	tag := getTag(ih)
 	cacheservice := ih.Repository.Caches(ih)
-	err := cacheservice.SaveTagItem(ih, w, r, tag, item)
+	desc, err := cacheservice.SaveTagItem(ih, w, r, tag, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest))
 }
 
 func (ih *itemHandler) DeleteImageItem(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +177,7 @@ func (ih *itemHandler) DeleteImageItem(w http.ResponseWriter, r *http.Request) {
 	cacheservice := ih.Repository.Caches(ih)
 	err := cacheservice.DeleteImageItem(ih, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
@@ -170,8 +189,30 @@ func (ih *itemHandler) DeleteTagItem(w http.ResponseWriter, r *http.Request) {
 	cacheservice := ih.Repository.Caches(ih)
 	err := cacheservice.DeleteTagItem(ih, tag, item)
 	if err != nil {
-		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		ih.appendItemError(err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// appendItemError appends the error code matching the error of an item
+// operation.
+func (ih *itemHandler) appendItemError(err error) {
+	switch err := err.(type) {
+	case distribution.ErrItemUnknown, distribution.ErrItemRepositoryUnknown:
+		ih.Errors = append(ih.Errors, v2.ErrorCodeItemUnknown.WithDetail(err))
+	case distribution.ErrItemTooLarge:
+		ih.Errors = append(ih.Errors, v2.ErrorCodeItemTooLarge.WithDetail(err))
+	case distribution.ErrItemInvalid:
+		ih.Errors = append(ih.Errors, v2.ErrorCodeItemInvalid.WithDetail(err))
+	case distribution.ErrItemPreconditionFailed:
+		ih.Errors = append(ih.Errors, v2.ErrorCodeItemPreconditionFailed.WithDetail(err))
+	default:
+		if err == distribution.ErrBlobUnknown {
+			// the content of the item has been garbage collected
+			ih.Errors = append(ih.Errors, v2.ErrorCodeItemUnknown.WithDetail(err))
+			return
+		}
+		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAppJobs(t *testing.T) {
+	env := newTestEnv(t, false)
+
+	var names []string
+	for _, status := range env.app.jobs.Status() {
+		names = append(names, status.Name)
+	}
+	if len(names) != 2 || names[0] != "catalogcache" || names[1] != "uploadpurge" {
+		t.Fatalf("unexpected jobs: %v", names)
+	}
+
+	handler := env.app.JobsHandler()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/jobs?job=catalogcache", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status running the catalog cache job: %d %s", w.Code, w.Body.String())
+	}
+
+	status := env.app.jobs.Status()
+	if len(status[0].Runs) != 1 || status[0].Runs[0].Error != "" {
+		t.Fatalf("unexpected runs of the catalog cache job: %#v", status[0].Runs)
+	}
+
+	// collections removing blobs are refused like those of /debug/gc
+	job, ok := env.app.garbageCollectionJob(map[interface{}]interface{}{"enabled": true})
+	if !ok {
+		t.Fatal("expected the garbage collection job to be enabled")
+	}
+	env.app.addJob(job)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug/jobs?job=gc", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 running the garbage collection job without httpsweep, got %d", w.Code)
+	}
+}
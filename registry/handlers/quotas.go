@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+)
+
+// quotasDispatcher serves the storage quotas on the admin api, which is
+// authorized with the registry:admin:* access.
+func quotasDispatcher(ctx *Context, r *http.Request) http.Handler {
+	return ctx.App.QuotasHandler()
+}
+
+// QuotasHandler returns the handler of the storage quotas, which must only be
+// served behind an admin access check. GET lists the quotas with their usage. PUT sets the
+// limit of the quota of the prefix parameter, and DELETE removes it, so that
+// the configured quota applies again. POST recounts the usage of the
+// repositories covered by the prefix parameter from the blobs linked into
+// them. Every method returns the quotas with their usage.
+func (app *App) QuotasHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quotas := app.registry.Quotas()
+		if quotas == nil {
+			http.Error(w, "quotas are not enabled", http.StatusNotFound)
+			return
+		}
+
+		prefix := r.FormValue("prefix")
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			limit, err := strconv.ParseInt(r.FormValue("limit"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid limit parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := quotas.SetQuota(app, distribution.Quota{Prefix: prefix, Limit: limit}); err != nil {
+				ctxu.GetLogger(app).Errorf("error setting quota of %q: %v", prefix, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			if err := quotas.DeleteQuota(app, prefix); err != nil {
+				ctxu.GetLogger(app).Errorf("error deleting quota of %q: %v", prefix, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "POST":
+			if err := app.recountQuotas(quotas, prefix); err != nil {
+				ctxu.GetLogger(app).Errorf("error recounting usage of %q: %v", prefix, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		usages, err := quotas.Quotas(app)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(usages); err != nil {
+			ctxu.GetLogger(app).Errorf("error encoding quotas: %v", err)
+		}
+	})
+}
+
+// recountQuotas recounts the usage of the repositories covered by prefix.
+func (app *App) recountQuotas(quotas distribution.QuotaService, prefix string) error {
+	enumerator, ok := app.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to enumerate repositories")
+	}
+
+	quota := distribution.Quota{Prefix: prefix}
+	return enumerator.Enumerate(app, func(name string) error {
+		if !quota.Covers(name) {
+			return nil
+		}
+		_, err := quotas.Recount(app, name)
+		return err
+	})
+}
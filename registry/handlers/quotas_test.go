@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/testutil"
+)
+
+func TestQuotas(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+		Quota: configuration.Quota{
+			Enabled: true,
+			Limits: []configuration.QuotaLimit{
+				{Prefix: "foo", Limit: 1},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	name, _ := reference.ParseNamed("foo/app")
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	resp, err := doPushLayer(t, env.builder, name, digest.Digest(layerDigest), uploadURLBase, layerFile)
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	checkResponse(t, "pushing layer over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing layer over quota", resp, v2.ErrorCodeQuotaExceeded)
+
+	// the admin api is refused without an access controller
+	resp, err = http.Get(env.server.URL + "/v2/_quotas")
+	if err != nil {
+		t.Fatalf("unexpected error listing quotas: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "listing quotas without an access controller", resp, http.StatusForbidden)
+
+	quotas := func(method, query string) {
+		req, _ := http.NewRequest(method, "/v2/_quotas?"+query, nil)
+		recorder := httptest.NewRecorder()
+		env.app.QuotasHandler().ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("unexpected status %s quota: %d %s", method, recorder.Code, recorder.Body.String())
+		}
+	}
+	quotas("PUT", "prefix=foo&limit=1073741824")
+
+	dgst := createRepository(env, t, "foo/app", "latest")
+
+	resp, err = http.Get(env.server.URL + "/v2/foo/app/imageinfo")
+	if err != nil {
+		t.Fatalf("unexpected error getting image info: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting image info", resp, http.StatusOK)
+
+	var info imageinfoAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("unexpected error decoding image info: %v", err)
+	}
+	if info.Usage == nil || *info.Usage == 0 {
+		t.Fatalf("expected the usage of the repository in the image info: %#v", info)
+	}
+	if info.Quota == nil || info.Quota.Prefix != "foo" || info.Quota.Limit != 1<<30 || info.Quota.Usage != *info.Usage {
+		t.Fatalf("unexpected quota in the image info: %#v", info.Quota)
+	}
+
+	// the configured quota applies again once the override is removed
+	quotas("DELETE", "prefix=foo")
+
+	layerFile, layerDigest, err = testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	uploadURLBase, _ = startPushLayer(t, env, name)
+	resp, err = doPushLayer(t, env.builder, name, digest.Digest(layerDigest), uploadURLBase, layerFile)
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	checkResponse(t, "pushing layer over configured quota", resp, http.StatusForbidden)
+
+	// a new manifest referencing the layers already stored is denied too
+	repo, err := env.app.registry.Repository(env.ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	manifests, err := repo.Manifests(env.ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifests: %v", err)
+	}
+	m, err := manifests.Get(env.ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	unsigned := m.(*schema1.SignedManifest).Manifest
+	unsigned.Tag = "other"
+	signed, err := schema1.Sign(&unsigned, env.pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+	tagRef, _ := reference.WithTag(name, "other")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp = putManifest(t, "putting manifest over quota", manifestURL, "", signed)
+	checkResponse(t, "putting manifest over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "putting manifest over quota", resp, v2.ErrorCodeQuotaExceeded)
+}
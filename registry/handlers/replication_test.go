@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/replication"
+)
+
+func TestReplication(t *testing.T) {
+	target := newTestEnv(t, false)
+	defer target.server.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+		Replication: configuration.Replication{
+			Policies: []configuration.ReplicationPolicy{
+				{
+					Name:         "target",
+					URL:          target.server.URL,
+					Repositories: []string{"foo/app"},
+					Tags:         []string{"v*"},
+					Backoff:      10 * time.Millisecond,
+				},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	source := newTestEnvWithConfig(t, &config)
+	defer source.server.Close()
+
+	dgst := createRepository(source, t, "foo/app", "v1")
+	createRepository(source, t, "foo/app", "latest")
+	createRepository(source, t, "foo/other", "v1")
+
+	repository := func(name string) (reference.Named, func() bool) {
+		named, _ := reference.ParseNamed(name)
+		return named, func() bool {
+			repo, err := target.app.registry.Repository(target.ctx, named)
+			if err != nil {
+				t.Fatalf("unexpected error getting repository: %v", err)
+			}
+			tags, _ := repo.Tags(target.ctx).All(target.ctx)
+			return len(tags) > 0
+		}
+	}
+
+	_, replicated := repository("foo/app")
+	for deadline := time.Now().Add(5 * time.Second); !replicated(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("foo/app:v1 was not replicated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	named, _ := repository("foo/app")
+	repo, _ := target.app.registry.Repository(target.ctx, named)
+	desc, err := repo.Tags(target.ctx).Get(target.ctx, "v1")
+	if err != nil || desc.Digest != dgst {
+		t.Fatalf("expected v1 to be replicated as %s: %v, %v", dgst, desc.Digest, err)
+	}
+	if _, err := repo.Tags(target.ctx).Get(target.ctx, "latest"); err == nil {
+		t.Fatalf("expected a tag not matching the policy not to be replicated")
+	}
+	if _, replicated := repository("foo/other"); replicated() {
+		t.Fatalf("expected a repository not matching the policy not to be replicated")
+	}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/replication", nil)
+	source.app.ReplicationHandler().ServeHTTP(recorder, req)
+	var statuses []replication.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("unexpected error decoding status: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "target" || statuses[0].Replicated != 1 || statuses[0].Pending != 0 {
+		t.Fatalf("unexpected replication status: %#v", statuses)
+	}
+}
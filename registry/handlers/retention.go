@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage"
+)
+
+// RetentionHandler returns the handler of the retention report, meant to be
+// served on the debug server. GET returns the report of the last run, and
+// POST runs the retention rules and returns its report. Runs started through
+// the handler are dry runs unless the dryrun query parameter is false.
+func (app *App) RetentionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.retention == nil {
+			http.Error(w, "retention is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var report storage.RetentionReport
+		switch r.Method {
+		case "GET":
+			var ok bool
+			report, ok = app.retention.LastReport()
+			if !ok {
+				http.Error(w, "no retention run has completed yet", http.StatusNotFound)
+				return
+			}
+		case "POST":
+			dryRun := true
+			if v := r.FormValue("dryrun"); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					http.Error(w, "invalid dryrun parameter: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				dryRun = b
+			}
+
+			var err error
+			report, err = app.retention.Apply(app, storage.RetentionOptions{
+				DryRun:       dryRun,
+				UpdateCaches: app.isEnhanced,
+			})
+			if err != nil {
+				ctxu.GetLogger(app).Errorf("error applying retention: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			ctxu.GetLogger(app).Errorf("error encoding retention report: %v", err)
+		}
+	})
+}
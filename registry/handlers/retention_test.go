@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+)
+
+func TestRetentionHandler(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+		},
+		Retention: configuration.Retention{
+			Rules: []configuration.RetentionRule{
+				{Prefix: "ci", KeepLast: 1},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	createRepository(env, t, "ci/app", "t1")
+	createRepository(env, t, "ci/app", "t2")
+	createRepository(env, t, "other/app", "t1")
+
+	retention := func(method, query string) storage.RetentionReport {
+		recorder := httptest.NewRecorder()
+		env.app.RetentionHandler().ServeHTTP(recorder, httptest.NewRequest(method, "/debug/retention?"+query, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("unexpected status %s retention: %d %s", method, recorder.Code, recorder.Body.String())
+		}
+		var report storage.RetentionReport
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("error decoding report: %v", err)
+		}
+		return report
+	}
+	tagList := func(name string) []string {
+		named, _ := reference.ParseNamed(name)
+		tagsURL, err := env.builder.BuildTagsURL(named)
+		checkErr(t, err, "building tags url")
+		resp, err := http.Get(tagsURL)
+		checkErr(t, err, "getting tags")
+		defer resp.Body.Close()
+		checkResponse(t, "getting tags", resp, http.StatusOK)
+
+		var tl tagsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&tl); err != nil {
+			t.Fatalf("error decoding tags: %v", err)
+		}
+		return tl.Tags
+	}
+
+	report := retention("POST", "")
+	if !report.DryRun || report.Repositories != 1 || report.Kept != 1 || len(report.Pruned) != 1 || len(report.Manifests) != 1 {
+		t.Fatalf("unexpected dry run report: %#v", report)
+	}
+	if tags := tagList("ci/app"); len(tags) != 2 {
+		t.Fatalf("dry run pruned tags: %v", tags)
+	}
+
+	report = retention("POST", "dryrun=false")
+	if report.DryRun || len(report.Pruned) != 1 || report.Deleted != 1 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	if last := retention("GET", ""); last.DryRun || len(last.Pruned) != 1 {
+		t.Fatalf("unexpected last report: %#v", last)
+	}
+
+	// the cached tag list no longer holds the pruned tag
+	tags := tagList("ci/app")
+	if len(tags) != 1 || tags[0] == report.Pruned[0].Tag {
+		t.Fatalf("unexpected tags after pruning %s: %v", report.Pruned[0].Tag, tags)
+	}
+	if tags := tagList("other/app"); len(tags) != 1 {
+		t.Fatalf("unexpected tags of a repository covered by no rule: %v", tags)
+	}
+
+	if _, err := retentionRulesFromConfig([]configuration.RetentionRule{{Prefix: "ci"}}); err == nil {
+		t.Fatal("expected a rule keeping no tag to be refused")
+	}
+	if _, err := retentionRulesFromConfig([]configuration.RetentionRule{{Prefix: "ci", KeepDays: 365}}); err == nil {
+		t.Fatal("expected keepdays beyond the download history to be refused")
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth/robot"
+)
+
+// robotAccountSecret is a robot account with its secret, which is only
+// returned when it is generated.
+type robotAccountSecret struct {
+	distribution.RobotAccount
+
+	// Username is the user name of the account in basic auth credentials.
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
+// robotsDispatcher serves the robot accounts on the admin api, which is
+// authorized with the registry:admin:* access.
+func robotsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	return ctx.App.RobotsHandler()
+}
+
+// RobotsHandler returns the handler of the robot accounts, which must only be
+// served behind an admin access check. GET lists the accounts, or returns the account of the
+// name parameter. POST creates the account of the JSON body and returns its
+// secret; with the regenerate parameter, it generates a new secret for the
+// named account instead. PUT replaces the scopes and expiry of the account of
+// the JSON body, and DELETE revokes the account of the name parameter.
+func (app *App) RobotsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.robots == nil {
+			http.Error(w, "robot accounts are not enabled", http.StatusNotFound)
+			return
+		}
+
+		var response interface{}
+		status := http.StatusOK
+		switch r.Method {
+		case "GET":
+			var err error
+			if name := r.FormValue("name"); name != "" {
+				response, err = app.robots.Get(app, name)
+			} else {
+				response, err = app.robots.List(app)
+			}
+			if err != nil {
+				app.serveRobotError(w, err)
+				return
+			}
+		case "POST":
+			var account distribution.RobotAccount
+			var secret string
+			var err error
+			if name := r.FormValue("regenerate"); name != "" {
+				secret, err = app.robots.Regenerate(app, name)
+				if err == nil {
+					account, err = app.robots.Get(app, name)
+				}
+			} else {
+				if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+					http.Error(w, "invalid robot account: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				secret, err = app.robots.Create(app, account)
+				if err == nil {
+					account, err = app.robots.Get(app, account.Name)
+				}
+				status = http.StatusCreated
+			}
+			if err != nil {
+				app.serveRobotError(w, err)
+				return
+			}
+			response = robotAccountSecret{
+				RobotAccount: account,
+				Username:     robot.UserPrefix + account.Name,
+				Secret:       secret,
+			}
+		case "PUT":
+			var account distribution.RobotAccount
+			if err := json.NewDecoder(r.Body).Decode(&account); err != nil {
+				http.Error(w, "invalid robot account: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			err := app.robots.Update(app, account)
+			if err == nil {
+				response, err = app.robots.Get(app, account.Name)
+			}
+			if err != nil {
+				app.serveRobotError(w, err)
+				return
+			}
+		case "DELETE":
+			if err := app.robots.Revoke(app, r.FormValue("name")); err != nil {
+				app.serveRobotError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			ctxu.GetLogger(app).Errorf("error encoding robot accounts: %v", err)
+		}
+	})
+}
+
+// serveRobotError answers the errors of the robot account service, logging
+// the unexpected ones.
+func (app *App) serveRobotError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case distribution.ErrRobotAccountUnknown:
+		status = http.StatusNotFound
+	case distribution.ErrRobotAccountExists:
+		status = http.StatusConflict
+	case distribution.ErrRobotAccountInvalid:
+		status = http.StatusBadRequest
+	default:
+		ctxu.GetLogger(app).Errorf("error managing robot accounts: %v", err)
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestRobotAccounts(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+		Robots: configuration.Robots{
+			Enabled: true,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	robotsAs := func(username, password, method, query, body string, expected int) *bytes.Buffer {
+		req, _ := http.NewRequest(method, env.server.URL+"/v2/_robots?"+query, bytes.NewBufferString(body))
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error %s robot accounts: %v", method, err)
+		}
+		defer resp.Body.Close()
+		var content bytes.Buffer
+		content.ReadFrom(resp.Body)
+		if resp.StatusCode != expected {
+			t.Fatalf("unexpected status %s robot accounts: %d %s", method, resp.StatusCode, content.String())
+		}
+		return &content
+	}
+	robots := func(method, query, body string, expected int) *bytes.Buffer {
+		return robotsAs("frodo", "baggins", method, query, body, expected)
+	}
+
+	// the admin api requires authentication
+	robotsAs("", "", "GET", "", "", http.StatusUnauthorized)
+
+	var created robotAccountSecret
+	content := robots("POST", "", `{"name": "ci", "scopes": [{"repository": "ci/*", "actions": ["pull"]}]}`, http.StatusCreated)
+	if err := json.NewDecoder(content).Decode(&created); err != nil {
+		t.Fatalf("unexpected error decoding account: %v", err)
+	}
+	if created.Username != "robot$ci" || created.Secret == "" {
+		t.Fatalf("unexpected account: %#v", created)
+	}
+	robots("POST", "", `{"name": "ci", "scopes": [{"repository": "ci/*", "actions": ["pull"]}]}`, http.StatusConflict)
+	robots("POST", "", `{"name": "ci", "scopes": [{"repository": "ci/*", "actions": ["fly"]}]}`, http.StatusBadRequest)
+	robots("GET", "name=unknown", "", http.StatusNotFound)
+
+	const unknownBlob = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	get := func(path, username, password string) int {
+		req, _ := http.NewRequest("GET", env.server.URL+path, nil)
+		req.SetBasicAuth(username, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error getting %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get("/v2/ci/app/blobs/"+unknownBlob, created.Username, created.Secret); status != http.StatusNotFound {
+		t.Fatalf("expected the robot account to be authorized, got %d", status)
+	}
+	if status := get("/v2/other/blobs/"+unknownBlob, created.Username, created.Secret); status != http.StatusForbidden {
+		t.Fatalf("expected a repository outside the scopes to be forbidden, got %d", status)
+	}
+	if status := get("/v2/ci/app/blobs/"+unknownBlob, created.Username, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong secret to be unauthorized, got %d", status)
+	}
+	// robot accounts are never granted the admin api
+	robotsAs(created.Username, created.Secret, "GET", "", "", http.StatusForbidden)
+	// other credentials are left to the configured access controller
+	if status := get("/v2/other/blobs/"+unknownBlob, "frodo", "baggins"); status != http.StatusNotFound {
+		t.Fatalf("expected other users to be authorized by the access controller, got %d", status)
+	}
+
+	var listed []robotAccountSecret
+	if err := json.NewDecoder(robots("GET", "", "", http.StatusOK)).Decode(&listed); err != nil {
+		t.Fatalf("unexpected error decoding accounts: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Name != "ci" || listed[0].Secret != "" || listed[0].LastUsedAt.IsZero() {
+		t.Fatalf("unexpected accounts: %#v", listed)
+	}
+
+	robots("PUT", "", `{"name": "ci", "scopes": [{"repository": "other", "actions": ["pull"]}]}`, http.StatusOK)
+	if status := get("/v2/other/blobs/"+unknownBlob, created.Username, created.Secret); status != http.StatusNotFound {
+		t.Fatalf("expected the updated scopes to apply, got %d", status)
+	}
+
+	var regenerated robotAccountSecret
+	if err := json.NewDecoder(robots("POST", "regenerate=ci", "", http.StatusOK)).Decode(&regenerated); err != nil {
+		t.Fatalf("unexpected error decoding account: %v", err)
+	}
+	if status := get("/v2/other/blobs/"+unknownBlob, created.Username, created.Secret); status != http.StatusUnauthorized {
+		t.Fatalf("expected the previous secret to be unauthorized, got %d", status)
+	}
+
+	robots("DELETE", "name=ci", "", http.StatusNoContent)
+	if status := get("/v2/other/blobs/"+unknownBlob, created.Username, regenerated.Secret); status != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked account to be unauthorized, got %d", status)
+	}
+	robots("DELETE", "name=ci", "", http.StatusNotFound)
+}
+
+func TestRobotAccountsRequireAccessController(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+		Robots: configuration.Robots{
+			Enabled: true,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	resp, err := http.Get(env.server.URL + "/v2/_robots")
+	if err != nil {
+		t.Fatalf("unexpected error listing robot accounts: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the admin api to be refused without an access controller, got %d", resp.StatusCode)
+	}
+}
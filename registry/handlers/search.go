@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// Scores given to a query term for the best place it matches in a
+// repository. A repository only matches if all terms of the query match.
+const (
+	scoreNameExact       = 100
+	scoreComponentExact  = 80
+	scoreNamePrefix      = 60
+	scoreComponentPrefix = 40
+	scoreNameSubstring   = 20
+	scoreTagExact        = 30
+	scoreTagSubstring    = 10
+	scoreLabel           = 10
+)
+
+func searchDispatcher(ctx *Context, r *http.Request) http.Handler {
+	searchHandler := &searchHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(searchHandler.Search),
+	}
+}
+
+type searchHandler struct {
+	*Context
+}
+
+type searchAPIResponse struct {
+	Query   string                    `json:"query"`
+	Results []searchResultAPIResponse `json:"results"`
+}
+
+type searchResultAPIResponse struct {
+	Name          string    `json:"name"`
+	Score         int       `json:"score"`
+	Tags          []string  `json:"tags,omitempty"`
+	Size          int       `json:"size"`
+	DownloadCount int       `json:"downloadCount"`
+	LastModified  time.Time `json:"lastModified"`
+}
+
+// Search looks up the query terms in the repository names, tags and labels
+// of the cached catalog and returns the matching repositories, best matches
+// first.
+func (sh *searchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		sh.Errors = append(sh.Errors, v2.ErrorCodeQueryParameterInvalid.WithDetail("q must not be empty"))
+		return
+	}
+
+	maxEntries := maximumReturnedEntries
+	if n := q.Get("n"); n != "" {
+		var err error
+		maxEntries, err = strconv.Atoi(n)
+		if err != nil || maxEntries <= 0 {
+			sh.Errors = append(sh.Errors, v2.ErrorCodeQueryParameterInvalid.WithDetail("n must be a positive integer"))
+			return
+		}
+	}
+
+	repos, err := sh.catalog()
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	infoHandler := &infoHandler{Context: sh.Context}
+	index := sh.registry.MetadataIndex()
+	results := []searchResultAPIResponse{}
+	for _, name := range filterPullable(sh, repos) {
+		tags := sh.tags(name)
+
+		imageinfo, err := index.GetImageInfo(sh, name)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+				sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+			imageinfo = distribution.ImageInfo{Name: name}
+		}
+
+		result, ok := scoreRepository(terms, name, tags, imageinfo.Tags)
+		if !ok {
+			continue
+		}
+
+		response := newImageinfoAPIResponse(imageinfo)
+		response.Tags = nil
+		if err := infoHandler.addDownloads(&response, false); err != nil {
+			sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		result.Size = response.Size
+		result.DownloadCount = response.DownloadCount
+		result.LastModified = response.LastModified
+		results = append(results, result)
+	}
+
+	sort.Sort(searchResultsByRank(results))
+	if len(results) > maxEntries {
+		results = results[:maxEntries]
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(searchAPIResponse{
+		Query:   query,
+		Results: results,
+	}); err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// catalog returns the repositories of the cached catalog, falling back to
+// the repositories known to the metadata index if the catalog has not been
+// cached.
+func (sh *searchHandler) catalog() ([]string, error) {
+	content, err := sh.registry.BlobCache().GetCatalog(sh)
+	if err == nil {
+		var c catalog
+		if err := json.Unmarshal(content, &c); err == nil {
+			return c.Repositories, nil
+		}
+	}
+
+	ctxu.GetLogger(sh).Debugf("catalog cache not available, searching the metadata index: %v", err)
+	imageinfos, err := sh.registry.MetadataIndex().ImageInfos(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]string, len(imageinfos))
+	for i, imageinfo := range imageinfos {
+		repos[i] = imageinfo.Name
+	}
+	return repos, nil
+}
+
+// tags returns the cached tag list of the repository, or nil if it has not
+// been cached.
+func (sh *searchHandler) tags(name string) []string {
+	content, err := sh.registry.BlobCache().GetTagList(sh, name)
+	if err != nil {
+		return nil
+	}
+
+	var tl tagsAPIResponse
+	if err := json.Unmarshal(content, &tl); err != nil {
+		return nil
+	}
+	return tl.Tags
+}
+
+// scoreRepository ranks the repository against the lower case query terms.
+// Tags which are not in the cached tag list are taken from the tag infos.
+// The result holds the matching tags, and is only valid if every term
+// matched.
+func scoreRepository(terms []string, name string, tags []string, taginfos []distribution.TagInfo) (searchResultAPIResponse, bool) {
+	result := searchResultAPIResponse{
+		Name: name,
+	}
+
+	lowerName := strings.ToLower(name)
+	components := strings.Split(lowerName, "/")
+	matchedTags := map[string]struct{}{}
+
+	for _, term := range terms {
+		best := 0
+		match := func(score int) {
+			if score > best {
+				best = score
+			}
+		}
+
+		switch {
+		case lowerName == term:
+			match(scoreNameExact)
+		case strings.HasPrefix(lowerName, term):
+			match(scoreNamePrefix)
+		case strings.Contains(lowerName, term):
+			match(scoreNameSubstring)
+		}
+
+		for _, component := range components {
+			if component == term {
+				match(scoreComponentExact)
+			} else if strings.HasPrefix(component, term) {
+				match(scoreComponentPrefix)
+			}
+		}
+
+		matchTag := func(tag string) {
+			lowerTag := strings.ToLower(tag)
+			if lowerTag == term {
+				match(scoreTagExact)
+			} else if strings.Contains(lowerTag, term) {
+				match(scoreTagSubstring)
+			} else {
+				return
+			}
+			matchedTags[tag] = struct{}{}
+		}
+
+		for _, tag := range tags {
+			matchTag(tag)
+		}
+
+		for _, taginfo := range taginfos {
+			matchTag(taginfo.Tag)
+			for key, value := range taginfo.Labels {
+				if strings.Contains(strings.ToLower(key), term) || strings.Contains(strings.ToLower(value), term) {
+					match(scoreLabel)
+				}
+			}
+		}
+
+		if best == 0 {
+			return result, false
+		}
+		result.Score += best
+	}
+
+	for tag := range matchedTags {
+		result.Tags = append(result.Tags, tag)
+	}
+	sort.Strings(result.Tags)
+
+	return result, true
+}
+
+// searchResultsByRank orders results by descending score, then by
+// descending download count and finally by name.
+type searchResultsByRank []searchResultAPIResponse
+
+func (s searchResultsByRank) Len() int      { return len(s) }
+func (s searchResultsByRank) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s searchResultsByRank) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score > s[j].Score
+	}
+	if s[i].DownloadCount != s[j].DownloadCount {
+		return s[i].DownloadCount > s[j].DownloadCount
+	}
+	return s[i].Name < s[j].Name
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution"
+)
+
+func TestScoreRepository(t *testing.T) {
+	taginfos := []distribution.TagInfo{
+		{Name: "library/nginx", Tag: "1.11-alpine", Labels: map[string]string{"maintainer": "NGINX Docker Maintainers"}},
+	}
+
+	for _, testcase := range []struct {
+		terms   []string
+		name    string
+		tags    []string
+		matches bool
+		score   int
+		hitTags []string
+	}{
+		{terms: []string{"library/nginx"}, name: "library/nginx", matches: true, score: scoreNameExact},
+		{terms: []string{"nginx"}, name: "library/nginx", matches: true, score: scoreComponentExact},
+		{terms: []string{"lib"}, name: "library/nginx", matches: true, score: scoreNamePrefix},
+		{terms: []string{"ngi"}, name: "library/nginx", matches: true, score: scoreComponentPrefix},
+		{terms: []string{"rary"}, name: "library/nginx", matches: true, score: scoreNameSubstring},
+		{
+			terms:   []string{"nginx", "latest"},
+			name:    "library/nginx",
+			tags:    []string{"latest", "1.11"},
+			matches: true,
+			score:   scoreComponentExact + scoreTagExact,
+			hitTags: []string{"latest"},
+		},
+		{
+			terms:   []string{"alpine"},
+			name:    "library/nginx",
+			tags:    []string{"latest"},
+			matches: true,
+			score:   scoreTagSubstring,
+			hitTags: []string{"1.11-alpine"},
+		},
+		{terms: []string{"maintainers"}, name: "library/nginx", matches: true, score: scoreLabel},
+		{terms: []string{"nginx", "redis"}, name: "library/nginx", matches: false},
+	} {
+		result, ok := scoreRepository(testcase.terms, testcase.name, testcase.tags, taginfos)
+		if ok != testcase.matches {
+			t.Fatalf("unexpected match of %v against %s: %v != %v", testcase.terms, testcase.name, ok, testcase.matches)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if result.Score != testcase.score {
+			t.Fatalf("unexpected score of %v against %s: %d != %d", testcase.terms, testcase.name, result.Score, testcase.score)
+		}
+
+		if !reflect.DeepEqual(result.Tags, testcase.hitTags) {
+			t.Fatalf("unexpected matching tags of %v against %s: %v != %v", testcase.terms, testcase.name, result.Tags, testcase.hitTags)
+		}
+	}
+}
+
+func TestSearchResultsByRank(t *testing.T) {
+	results := []searchResultAPIResponse{
+		{Name: "b", Score: 20, DownloadCount: 1},
+		{Name: "c", Score: 80},
+		{Name: "a", Score: 20, DownloadCount: 1},
+		{Name: "d", Score: 20, DownloadCount: 7},
+	}
+	sort.Sort(searchResultsByRank(results))
+
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+
+	if expected := []string{"c", "d", "a", "b"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected ranking: %v != %v", names, expected)
+	}
+}
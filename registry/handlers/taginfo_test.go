@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/testutil"
+)
+
+// pushSchema2Image pushes an image with a random layer and the given
+// configuration to the tag, returning the digest of its manifest.
+func pushSchema2Image(t *testing.T, env *testEnv, name reference.Named, tag string, config []byte) digest.Digest {
+	configDigest := digest.FromBytes(config)
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, configDigest, uploadURLBase, bytes.NewReader(config))
+
+	rs, dgstStr, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	layerDigest := digest.Digest(dgstStr)
+	uploadURLBase, _ = startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, layerDigest, uploadURLBase, rs)
+	layerSize, _ := rs.Seek(0, 2)
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+			MediaType: schema2.MediaTypeConfig,
+		},
+		Layers: []distribution.Descriptor{
+			{Digest: layerDigest, Size: layerSize, MediaType: schema2.MediaTypeLayer},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create manifest: %v", err)
+	}
+	_, payload, _ := m.Payload()
+
+	tagRef, _ := reference.WithTag(name, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting schema2 manifest", manifestURL, schema2.MediaTypeManifest, m)
+	checkResponse(t, "putting schema2 manifest", resp, http.StatusCreated)
+	return digest.FromBytes(payload)
+}
+
+func getTaginfo(t *testing.T, env *testEnv, name, tag string) taginfoAPIResponse {
+	resp, err := http.Get(env.server.URL + "/v2/" + name + "/taginfo/" + tag)
+	if err != nil {
+		t.Fatalf("unexpected error getting tag info: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting tag info", resp, http.StatusOK)
+
+	var info taginfoAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("unexpected error decoding tag info: %v", err)
+	}
+	return info
+}
+
+func TestTaginfoImageDetails(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	name, _ := reference.ParseNamed("foo/multi")
+	amd64 := pushSchema2Image(t, env, name, "amd64", []byte(`{
+		"architecture": "amd64",
+		"os": "linux",
+		"created": "2016-10-31T22:22:55Z",
+		"config": {
+			"Entrypoint": ["/bin/app"],
+			"Cmd": ["serve"],
+			"ExposedPorts": {"8080/tcp": {}, "443/tcp": {}},
+			"Env": ["PATH=/usr/bin", "SECRET=hidden"],
+			"Labels": {"team": "core"}
+		},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`))
+	arm64 := pushSchema2Image(t, env, name, "arm64", []byte(`{
+		"architecture": "arm64",
+		"os": "linux",
+		"created": "2016-11-01T10:00:00Z",
+		"config": {"Labels": {"team": "arm"}},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`))
+
+	info := getTaginfo(t, env, "foo/multi", "amd64")
+	if info.Digest != amd64 || info.MediaType != schema2.MediaTypeManifest || info.Image == nil {
+		t.Fatalf("unexpected tag info: %#v", info)
+	}
+	image := info.Image
+	if image.Architecture != "amd64" || image.OS != "linux" ||
+		!reflect.DeepEqual(image.Entrypoint, []string{"/bin/app"}) ||
+		!reflect.DeepEqual(image.Cmd, []string{"serve"}) ||
+		!reflect.DeepEqual(image.ExposedPorts, []string{"443/tcp", "8080/tcp"}) ||
+		!reflect.DeepEqual(image.EnvKeys, []string{"PATH", "SECRET"}) ||
+		image.Labels["team"] != "core" || info.Labels["team"] != "core" {
+		t.Fatalf("unexpected image details: %#v", image)
+	}
+	if image.LayerCount != 1 || len(image.Layers) != 1 || image.Layers[0].Size == 0 {
+		t.Fatalf("unexpected layers: %#v", image.Layers)
+	}
+	if info.CreateTime.Year() != 2016 || info.Size != image.Layers[0].Size {
+		t.Fatalf("unexpected creation time or size: %v %d", info.CreateTime, info.Size)
+	}
+
+	// a manifest list records an entry per platform
+	manifestList, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+		{
+			Descriptor: distribution.Descriptor{Digest: amd64, MediaType: schema2.MediaTypeManifest},
+			Platform:   manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"},
+		},
+		{
+			Descriptor: distribution.Descriptor{Digest: arm64, MediaType: schema2.MediaTypeManifest},
+			Platform:   manifestlist.PlatformSpec{Architecture: "arm64", OS: "linux", Variant: "v8"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create manifest list: %v", err)
+	}
+	tagRef, _ := reference.WithTag(name, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "putting manifest list", manifestURL, manifestlist.MediaTypeManifestList, manifestList)
+	checkResponse(t, "putting manifest list", resp, http.StatusCreated)
+
+	info = getTaginfo(t, env, "foo/multi", "latest")
+	if info.MediaType != manifestlist.MediaTypeManifestList || info.Image != nil || len(info.Platforms) != 2 {
+		t.Fatalf("unexpected manifest list tag info: %#v", info)
+	}
+	if p := info.Platforms[0]; p.Digest != amd64 || p.Architecture != "amd64" || p.LayerCount != 1 {
+		t.Fatalf("unexpected amd64 platform: %#v", p)
+	}
+	if p := info.Platforms[1]; p.Digest != arm64 || p.Architecture != "arm64" || p.Variant != "v8" || p.Labels["team"] != "arm" {
+		t.Fatalf("unexpected arm64 platform: %#v", p)
+	}
+	// the labels are those of the default platform and the tag is as recent
+	// as its most recent image
+	if info.Labels["team"] != "core" || info.CreateTime.Month() != 11 {
+		t.Fatalf("unexpected labels or creation time: %v %v", info.Labels, info.CreateTime)
+	}
+	if info.Size != info.Platforms[0].Size+info.Platforms[1].Size {
+		t.Fatalf("unexpected size %d", info.Size)
+	}
+}
+
+type unknownBlobStatter struct{}
+
+func (unknownBlobStatter) Stat(ctx ctxu.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, distribution.ErrBlobUnknown
+}
+
+func TestBlobSizeForeignLayer(t *testing.T) {
+	foreign := distribution.Descriptor{
+		Digest:    digest.FromBytes([]byte("foreign")),
+		Size:      42,
+		MediaType: schema2.MediaTypeForeignLayer,
+	}
+	size, err := blobSize(ctxu.Background(), unknownBlobStatter{}, foreign)
+	if err != nil || size != 42 {
+		t.Fatalf("expected the declared size of a foreign layer: %d, %v", size, err)
+	}
+
+	foreign.MediaType = schema2.MediaTypeLayer
+	if _, err := blobSize(ctxu.Background(), unknownBlobStatter{}, foreign); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the layers to be looked up: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/v2"
+)
+
+func TestTagProtection(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+		},
+		TagProtection: configuration.TagProtection{
+			Rules: []configuration.TagRule{
+				{Repositories: []string{"foo/*"}, Tags: []string{"v*"}, Immutable: true, NoDelete: true},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.server.Close()
+
+	name, _ := reference.ParseNamed("foo/app")
+	dgst := createRepository(env, t, "foo/app", "v1")
+
+	// pushing another manifest to the tag is refused
+	repo, err := env.app.registry.Repository(env.ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	manifests, err := repo.Manifests(env.ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifests: %v", err)
+	}
+	m, err := manifests.Get(env.ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	unsigned := m.(*schema1.SignedManifest).Manifest
+	unsigned.History[0].V1Compatibility = `{"id":"changed"}`
+	signed, err := schema1.Sign(&unsigned, env.pk)
+	if err != nil {
+		t.Fatalf("unexpected error signing manifest: %v", err)
+	}
+	tagRef, _ := reference.WithTag(name, "v1")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp := putManifest(t, "moving immutable tag", manifestURL, "", signed)
+	checkResponse(t, "moving immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "moving immutable tag", resp, v2.ErrorCodeTagImmutable)
+
+	// deleting its manifest is refused
+	digestRef, _ := reference.WithDigest(name, dgst)
+	manifestDigestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = httpDelete(manifestDigestURL)
+	checkErr(t, err, "deleting manifest")
+	checkResponse(t, "deleting protected manifest", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "deleting protected manifest", resp, v2.ErrorCodeTagProtected)
+
+	resp, err = http.Get(env.server.URL + "/v2/foo/app/imageinfo")
+	if err != nil {
+		t.Fatalf("unexpected error getting image info: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting image info", resp, http.StatusOK)
+
+	var info imageinfoAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("unexpected error decoding image info: %v", err)
+	}
+	if len(info.TagRules) != 1 || !info.TagRules[0].Immutable || !info.TagRules[0].NoDelete {
+		t.Fatalf("unexpected tag rules in the image info: %#v", info.TagRules)
+	}
+	for _, tag := range info.Tags {
+		if tag.Tag == "v1" && (!tag.Immutable || !tag.Protected) {
+			t.Fatalf("expected v1 to be reported as protected: %#v", tag)
+		}
+	}
+}
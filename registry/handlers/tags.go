@@ -41,7 +41,8 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	cached := q.Get("cache")
 	var err error
 	var tags []string
-	if th.isEnhanced && !strings.EqualFold(cached, "0") {
+	// The cached tag list is not used until the caches are warmed up.
+	if th.isEnhanced && !strings.EqualFold(cached, "0") && !th.warmingUp() {
 		cacheService := th.Repository.Caches(th)
 		tags, err = cacheService.GetTagList(th)
 		_, pathNotFound := err.(driver.PathNotFoundError)
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ctxu "github.com/docker/distribution/context"
+	"github.com/docker/distribution/health"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// defaultWarmUpWorkers is the default number of tags whose caches are built
+// concurrently by the warm-up.
+const defaultWarmUpWorkers = 8
+
+// warmUpCheckpoint is the progress of the warm-up, kept in the cache area so
+// a restarted registry resumes where the previous one stopped.
+type warmUpCheckpoint struct {
+	// Last is the last repository, in catalog order, whose caches are warm.
+	Last string `json:"last"`
+}
+
+// warmUp builds the tag list and tag info caches of every repository in the
+// background when the registry starts.
+type warmUp struct {
+	workers int
+
+	// running is set until the warm-up finishes.
+	running int32
+
+	mu     sync.Mutex
+	total  int
+	warmed int
+}
+
+// startWarmUp starts warming up the caches in the background. Requests are
+// served from the uncached paths until it finishes.
+func (app *App) startWarmUp(workers int) {
+	if workers <= 0 {
+		workers = defaultWarmUpWorkers
+	}
+	app.warmUp = &warmUp{
+		workers: workers,
+		running: 1,
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&app.warmUp.running, 0)
+
+		start := time.Now()
+		if err := app.warmUpCaches(); err != nil {
+			ctxu.GetLogger(app).Errorf("error warming up caches: %v", err)
+			return
+		}
+		ctxu.GetLogger(app).Infof("caches warmed up in %s", time.Since(start))
+	}()
+}
+
+// warmingUp returns true while the caches are being warmed up.
+func (app *App) warmingUp() bool {
+	return app.warmUp != nil && atomic.LoadInt32(&app.warmUp.running) == 1
+}
+
+// RegisterReadinessChecks registers the checks telling whether the registry
+// is ready to serve at full speed. Like RegisterHealthChecks, it should only
+// be called once per registry process.
+func (app *App) RegisterReadinessChecks(readinessRegistries ...*health.Registry) {
+	if len(readinessRegistries) > 1 {
+		panic("RegisterReadinessChecks called with more than one registry")
+	}
+	readinessRegistry := health.ReadinessRegistry
+	if len(readinessRegistries) == 1 {
+		readinessRegistry = readinessRegistries[0]
+	}
+
+	if app.warmUp != nil {
+		readinessRegistry.RegisterFunc("cache_warmup", func() error {
+			if !app.warmingUp() {
+				return nil
+			}
+			app.warmUp.mu.Lock()
+			defer app.warmUp.mu.Unlock()
+			return fmt.Errorf("warming up caches: %d of %d repositories", app.warmUp.warmed, app.warmUp.total)
+		})
+	}
+}
+
+// warmUpCaches rebuilds the catalog cache, then the caches of each
+// repository in catalog order, resuming after the last repository recorded
+// in the checkpoint.
+func (app *App) warmUpCaches() error {
+	blobCache := app.registry.BlobCache()
+
+	repos := make([]string, cachedMaxEntries)
+	filled, err := app.createCatalogCache(repos)
+	if err != nil {
+		return err
+	}
+	repos = repos[:filled]
+
+	var checkpoint warmUpCheckpoint
+	content, err := blobCache.GetWarmUpCheckpoint(app)
+	switch err.(type) {
+	case nil:
+		if err := json.Unmarshal(content, &checkpoint); err != nil {
+			ctxu.GetLogger(app).Warnf("ignoring invalid warm-up checkpoint: %v", err)
+		}
+	case storagedriver.PathNotFoundError:
+	default:
+		return err
+	}
+
+	// Skip the repositories up to the checkpoint. If it is no longer in the
+	// catalog, every repository is warmed up again.
+	warmed := 0
+	for i, name := range repos {
+		if name == checkpoint.Last {
+			warmed = i + 1
+			break
+		}
+	}
+
+	app.warmUp.mu.Lock()
+	app.warmUp.total = len(repos)
+	app.warmUp.warmed = warmed
+	app.warmUp.mu.Unlock()
+
+	for _, name := range repos[warmed:] {
+		if err := app.warmUpRepository(name); err != nil {
+			ctxu.GetLogger(app).Errorf("error warming up caches of %s: %v", name, err)
+		}
+
+		content, err := json.Marshal(warmUpCheckpoint{Last: name})
+		if err != nil {
+			return err
+		}
+		if err := blobCache.PutWarmUpCheckpoint(app, content); err != nil {
+			return err
+		}
+		app.warmUp.progress()
+	}
+
+	// The next start warms up every repository again.
+	return blobCache.DeleteWarmUpCheckpoint(app)
+}
+
+func (wu *warmUp) progress() {
+	wu.mu.Lock()
+	defer wu.mu.Unlock()
+
+	wu.warmed++
+}
+
+// warmUpRepository builds the tag infos and the tag list cache of the named
+// repository, building the tag infos with the warm-up workers.
+func (app *App) warmUpRepository(name string) error {
+	nameRef, err := reference.WithName(name)
+	if err != nil {
+		return err
+	}
+	repository, err := app.registry.Repository(app.Context, nameRef)
+	if err != nil {
+		return err
+	}
+	ctx := &Context{
+		App:        app,
+		Context:    app.Context,
+		Repository: repository,
+	}
+	tags, err := repository.Tags(ctx).All(ctx)
+	if err != nil {
+		return err
+	}
+
+	tagc := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < app.warmUp.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tag := range tagc {
+				imh := &imageManifestHandler{
+					Context: ctx,
+					Tag:     tag,
+				}
+				if _, err := createAndSaveTagInfo(imh, name); err != nil {
+					ctxu.GetLogger(app).Errorf("error warming up tag info of %s:%s: %v", name, tag, err)
+				}
+			}
+		}()
+	}
+	for _, tag := range tags {
+		tagc <- tag
+	}
+	close(tagc)
+	wg.Wait()
+
+	return repository.Caches(ctx).CreateTagListCache(ctx)
+}
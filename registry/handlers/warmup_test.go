@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/health"
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestWarmUpResumesFromCheckpoint(t *testing.T) {
+	env := newTestEnv(t, false)
+	createRepository(env, t, "foo/a", "latest")
+	createRepository(env, t, "foo/b", "latest")
+
+	caches := func(name string) distribution.CacheService {
+		named, _ := reference.ParseNamed(name)
+		repository, err := env.app.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository: %v", err)
+		}
+		return repository.Caches(env.ctx)
+	}
+	for _, name := range []string{"foo/a", "foo/b"} {
+		if err := caches(name).DeleteTagInfo(env.ctx, "latest"); err != nil {
+			t.Fatalf("unexpected error deleting tag info of %s: %v", name, err)
+		}
+	}
+
+	// foo/a was warmed up before the registry restarted.
+	blobCache := env.app.registry.BlobCache()
+	content, _ := json.Marshal(warmUpCheckpoint{Last: "foo/a"})
+	if err := blobCache.PutWarmUpCheckpoint(env.ctx, content); err != nil {
+		t.Fatalf("unexpected error writing checkpoint: %v", err)
+	}
+
+	env.app.warmUp = &warmUp{workers: 2, running: 1}
+	readiness := health.NewRegistry()
+	env.app.RegisterReadinessChecks(readiness)
+	if status := readiness.CheckStatus(); status["cache_warmup"] == "" {
+		t.Fatalf("expected the registry not to be ready while warming up: %v", status)
+	}
+
+	// Tag infos are built on demand until the warm-up finishes.
+	resp, err := http.Get(env.server.URL + "/v2/foo/a/taginfo/latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting tag info: %v", err)
+	}
+	checkResponse(t, "getting tag info while warming up", resp, http.StatusOK)
+	if err := caches("foo/a").DeleteTagInfo(env.ctx, "latest"); err != nil {
+		t.Fatalf("unexpected error deleting tag info: %v", err)
+	}
+
+	if err := env.app.warmUpCaches(); err != nil {
+		t.Fatalf("unexpected error warming up caches: %v", err)
+	}
+	env.app.warmUp.running = 0
+
+	if _, err := caches("foo/a").GetTagInfo(env.ctx, "latest"); err == nil {
+		t.Fatalf("expected the repository before the checkpoint to be skipped")
+	}
+	if _, err := caches("foo/b").GetTagInfo(env.ctx, "latest"); err != nil {
+		t.Fatalf("expected the tag info after the checkpoint to be built: %v", err)
+	}
+	if _, err := blobCache.GetWarmUpCheckpoint(env.ctx); err == nil {
+		t.Fatalf("expected the checkpoint to be removed once warmed up")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("unexpected error getting checkpoint: %v", err)
+	}
+	if status := readiness.CheckStatus(); len(status) != 0 {
+		t.Fatalf("expected the registry to be ready once warmed up: %v", status)
+	}
+}
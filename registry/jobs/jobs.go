@@ -0,0 +1,370 @@
+// Package jobs runs the periodic maintenance jobs of a registry, such as the
+// catalog cache rebuild or the upload purge.
+//
+// Each job runs on its own schedule, after a random jitter, and never
+// concurrently with itself. When several registry instances share the same
+// storage, a Locker elects the instance which runs each job, so the work is
+// not repeated by every replica.
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// historySize is the number of runs remembered for each job.
+const historySize = 20
+
+var (
+	// ErrJobUnknown is returned when a job is not registered.
+	ErrJobUnknown = errors.New("unknown job")
+
+	// ErrJobRunning is returned when a job is started while it is already
+	// running.
+	ErrJobRunning = errors.New("job is already running")
+
+	// ErrJobLocked is returned when a job is started while another instance
+	// holds its lock.
+	ErrJobLocked = errors.New("job is locked by another instance")
+)
+
+// Func is the work done by a job.
+type Func func(ctx context.Context) error
+
+// Job describes a named periodic job.
+type Job struct {
+	// Name identifies the job. It is also the name of its lock.
+	Name string
+
+	// Interval is the time between two runs of the job.
+	Interval time.Duration
+
+	// Jitter is the upper bound of the random delay before the first run,
+	// which spreads the load of registries started at the same time.
+	Jitter time.Duration
+
+	// Func is called on every run.
+	Func Func
+
+	// Guard, if set, is called before a run started by RunNow, which is
+	// refused with the error it returns.
+	Guard func() error
+}
+
+// Run records one execution of a job.
+type Run struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+
+	// Skipped is true if another instance holds the lock of the job.
+	Skipped bool `json:"skipped,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Status describes a job and its recent runs, most recent first.
+type Status struct {
+	Name     string        `json:"name"`
+	Interval time.Duration `json:"interval"`
+	Running  bool          `json:"running"`
+	NextRun  time.Time     `json:"nextRun,omitempty"`
+	Runs     []Run         `json:"runs"`
+}
+
+// Locker elects the instance which runs a job when several registries share
+// the same storage.
+type Locker interface {
+	// Acquire takes or renews the lock named name for ttl and reports
+	// whether this instance holds it.
+	Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lock named name if this instance holds it.
+	Release(ctx context.Context, name string) error
+}
+
+type job struct {
+	Job
+
+	running bool
+	nextRun time.Time
+	history []Run
+}
+
+// Scheduler runs the registered jobs periodically.
+type Scheduler struct {
+	sync.Mutex
+
+	ctx    context.Context
+	locker Locker
+	jobs   map[string]*job
+
+	started bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New returns a scheduler electing the instance which runs each job with
+// locker. A nil locker runs every job on this instance.
+func New(ctx context.Context, locker Locker) *Scheduler {
+	return &Scheduler{
+		ctx:    ctx,
+		locker: locker,
+		jobs:   make(map[string]*job),
+	}
+}
+
+// Add registers a job. Jobs added after Start are scheduled immediately.
+func (s *Scheduler) Add(j Job) error {
+	if j.Name == "" {
+		return fmt.Errorf("job name must not be empty")
+	}
+	if j.Interval <= 0 {
+		return fmt.Errorf("job %s: interval must be positive", j.Name)
+	}
+	if j.Func == nil {
+		return fmt.Errorf("job %s: func must not be nil", j.Name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.jobs[j.Name]; ok {
+		return fmt.Errorf("job %s already registered", j.Name)
+	}
+	jb := &job{Job: j}
+	s.jobs[j.Name] = jb
+	if s.started {
+		s.schedule(jb)
+	}
+	return nil
+}
+
+// Start schedules every registered job.
+func (s *Scheduler) Start() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+	s.stop = make(chan struct{})
+	for _, jb := range s.jobs {
+		s.schedule(jb)
+	}
+}
+
+// Stop waits for the running jobs to finish, stops scheduling new runs and
+// releases the locks held by this instance.
+func (s *Scheduler) Stop() {
+	s.Lock()
+	if !s.started {
+		s.Unlock()
+		return
+	}
+	s.started = false
+	close(s.stop)
+	s.Unlock()
+
+	s.wg.Wait()
+
+	if s.locker == nil {
+		return
+	}
+	for _, name := range s.names() {
+		if err := s.locker.Release(s.ctx, name); err != nil {
+			context.GetLogger(s.ctx).Errorf("error releasing lock of job %s: %v", name, err)
+		}
+	}
+}
+
+// schedule starts the loop running jb. It must be called with the scheduler
+// locked.
+func (s *Scheduler) schedule(jb *job) {
+	var delay time.Duration
+	if jb.Jitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(jb.Jitter)))
+	}
+	jb.nextRun = time.Now().Add(delay)
+	context.GetLogger(s.ctx).Infof("Starting job %s in %s", jb.Name, delay)
+
+	stop := s.stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-stop:
+				return
+			}
+
+			s.run(jb, false)
+
+			s.Lock()
+			jb.nextRun = time.Now().Add(jb.Interval)
+			s.Unlock()
+			timer.Reset(jb.Interval)
+		}
+	}()
+}
+
+// RunNow runs the named job immediately and returns the record of the run.
+// The run is refused if the guard of the job fails, or with ErrJobLocked if
+// another instance holds its lock.
+func (s *Scheduler) RunNow(name string) (Run, error) {
+	s.Lock()
+	jb, ok := s.jobs[name]
+	s.Unlock()
+	if !ok {
+		return Run{}, ErrJobUnknown
+	}
+	if jb.Guard != nil {
+		if err := jb.Guard(); err != nil {
+			return Run{}, err
+		}
+	}
+	return s.run(jb, true)
+}
+
+// run executes jb unless it is already running or another instance holds
+// its lock. Runs started by hand are then refused, rather than recorded as
+// skipped.
+func (s *Scheduler) run(jb *job, manual bool) (Run, error) {
+	s.Lock()
+	if jb.running {
+		s.Unlock()
+		return Run{}, ErrJobRunning
+	}
+	jb.running = true
+	s.Unlock()
+
+	log := context.GetLoggerWithField(s.ctx, "job", jb.Name)
+	run := Run{StartedAt: time.Now()}
+
+	leader := true
+	if s.locker != nil {
+		var err error
+		// Hold the lock across two intervals, so the leader keeps it while
+		// it is alive and another instance takes over if it goes away.
+		leader, err = s.locker.Acquire(s.ctx, jb.Name, 2*jb.Interval)
+		if err != nil {
+			log.Errorf("error acquiring lock of job: %v", err)
+			run.Error = err.Error()
+			leader = false
+		}
+	}
+
+	if !leader && manual && run.Error == "" {
+		s.Lock()
+		jb.running = false
+		s.Unlock()
+		return Run{}, ErrJobLocked
+	}
+
+	if leader {
+		log.Infof("running job")
+		if err := jb.Func(s.ctx); err != nil {
+			log.Errorf("job failed: %v", err)
+			run.Error = err.Error()
+		}
+	} else if run.Error == "" {
+		log.Debugf("job skipped, lock held by another instance")
+		run.Skipped = true
+	}
+	run.FinishedAt = time.Now()
+
+	s.Lock()
+	jb.running = false
+	jb.history = append([]Run{run}, jb.history...)
+	if len(jb.history) > historySize {
+		jb.history = jb.history[:historySize]
+	}
+	s.Unlock()
+
+	return run, nil
+}
+
+func (s *Scheduler) names() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Status returns the status of every job, sorted by name.
+func (s *Scheduler) Status() []Status {
+	names := s.names()
+
+	s.Lock()
+	defer s.Unlock()
+
+	statuses := make([]Status, 0, len(names))
+	for _, name := range names {
+		jb := s.jobs[name]
+		st := Status{
+			Name:     jb.Name,
+			Interval: jb.Interval,
+			Running:  jb.running,
+			Runs:     append([]Run{}, jb.history...),
+		}
+		if s.started {
+			st.NextRun = jb.nextRun
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// ServeHTTP serves the status of the jobs, and is meant to be exposed on the
+// debug server. GET returns the status of every job, and POST with a job
+// query parameter runs that job immediately, as RunNow, and returns the
+// record of the run.
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	switch r.Method {
+	case "GET":
+		v = s.Status()
+	case "POST":
+		run, err := s.RunNow(r.FormValue("job"))
+		switch err {
+		case nil:
+		case ErrJobUnknown:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case ErrJobRunning, ErrJobLocked:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			// the guard of the job refused the run
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		v = run
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		context.GetLogger(s.ctx).Errorf("error encoding job status: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestSchedulerRunsJobs(t *testing.T) {
+	var runs int32
+	s := New(context.Background(), nil)
+	err := s.Add(Job{
+		Name:     "counter",
+		Interval: 10 * time.Millisecond,
+		Func: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding job: %v", err)
+	}
+	if err := s.Add(Job{Name: "counter", Interval: time.Second, Func: func(context.Context) error { return nil }}); err == nil {
+		t.Fatalf("expected an error adding a job twice")
+	}
+
+	s.Start()
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	n := atomic.LoadInt32(&runs)
+	if n < 2 {
+		t.Fatalf("expected the job to run several times, ran %d times", n)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != n {
+		t.Fatalf("job ran after the scheduler was stopped")
+	}
+
+	status := s.Status()
+	if len(status) != 1 || status[0].Name != "counter" {
+		t.Fatalf("unexpected status: %#v", status)
+	}
+	if len(status[0].Runs) != int(n) {
+		t.Fatalf("expected %d runs in history, got %d", n, len(status[0].Runs))
+	}
+}
+
+func TestSchedulerSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := New(context.Background(), nil)
+	s.Add(Job{
+		Name:     "slow",
+		Interval: time.Hour,
+		Func: func(ctx context.Context) error {
+			close(started)
+			<-release
+			return errors.New("failed")
+		},
+	})
+
+	done := make(chan Run)
+	go func() {
+		run, _ := s.RunNow("slow")
+		done <- run
+	}()
+	<-started
+
+	if _, err := s.RunNow("slow"); err != ErrJobRunning {
+		t.Fatalf("expected ErrJobRunning, got %v", err)
+	}
+	if status := s.Status(); !status[0].Running {
+		t.Fatalf("expected the job to be reported as running")
+	}
+
+	close(release)
+	run := <-done
+	if run.Error != "failed" {
+		t.Fatalf("expected the error of the job to be recorded, got %q", run.Error)
+	}
+	if _, err := s.RunNow("unknown"); err != ErrJobUnknown {
+		t.Fatalf("expected ErrJobUnknown, got %v", err)
+	}
+}
+
+func TestDriverLockerElectsOneInstance(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	first := NewDriverLocker(d, "/locks", "first")
+	second := NewDriverLocker(d, "/locks", "second")
+
+	if held, err := first.Acquire(ctx, "job", time.Hour); err != nil || !held {
+		t.Fatalf("expected the first instance to acquire the lock: %v, %v", held, err)
+	}
+	if held, err := first.Acquire(ctx, "job", time.Hour); err != nil || !held {
+		t.Fatalf("expected the first instance to renew the lock: %v, %v", held, err)
+	}
+	if held, err := second.Acquire(ctx, "job", time.Hour); err != nil || held {
+		t.Fatalf("expected the second instance not to acquire the lock: %v, %v", held, err)
+	}
+
+	// The second instance takes over once the lock expires.
+	if _, err := first.Acquire(ctx, "job", -time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if held, err := second.Acquire(ctx, "job", time.Hour); err != nil || !held {
+		t.Fatalf("expected the second instance to acquire the expired lock: %v, %v", held, err)
+	}
+
+	// Only the owner releases a lock.
+	if err := first.Release(ctx, "job"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if held, _ := first.Acquire(ctx, "job", time.Hour); held {
+		t.Fatalf("lock released by an instance which did not hold it")
+	}
+	if err := second.Release(ctx, "job"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if held, _ := first.Acquire(ctx, "job", time.Hour); !held {
+		t.Fatalf("expected the lock to be free once released")
+	}
+}
+
+func TestSchedulerSkipsWithoutLock(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	if held, _ := NewDriverLocker(d, "/locks", "other").Acquire(ctx, "job", time.Hour); !held {
+		t.Fatalf("expected to acquire the lock")
+	}
+
+	var runs int32
+	s := New(ctx, NewDriverLocker(d, "/locks", "self"))
+	s.Add(Job{
+		Name:     "job",
+		Interval: 10 * time.Millisecond,
+		Func: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	})
+	s.Start()
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("job ran %d times without holding its lock", n)
+	}
+	status := s.Status()
+	if len(status[0].Runs) == 0 || !status[0].Runs[0].Skipped {
+		t.Fatalf("expected skipped runs in history: %#v", status[0].Runs)
+	}
+
+	// Runs started by hand are refused while another instance holds the
+	// lock.
+	if _, err := s.RunNow("job"); err != ErrJobLocked {
+		t.Fatalf("expected ErrJobLocked, got %v", err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("job ran %d times without holding its lock", n)
+	}
+
+	if err := NewDriverLocker(d, "/locks", "other").Release(ctx, "job"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.RunNow("job"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("expected the job to run once, ran %d times", n)
+	}
+}
+
+func TestSchedulerGuard(t *testing.T) {
+	var runs int32
+	s := New(context.Background(), nil)
+	s.Add(Job{
+		Name:     "job",
+		Interval: time.Hour,
+		Func: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+		Guard: func() error { return errors.New("not allowed") },
+	})
+
+	req, _ := http.NewRequest("POST", "/debug/jobs?job=job", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status running a guarded job: %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("guarded job ran %d times", n)
+	}
+}
+
+func TestSchedulerServeHTTP(t *testing.T) {
+	s := New(context.Background(), nil)
+	s.Add(Job{
+		Name:     "job",
+		Interval: time.Hour,
+		Func:     func(ctx context.Context) error { return nil },
+	})
+
+	req, _ := http.NewRequest("POST", "/debug/jobs?job=job", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status running job: %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/debug/jobs?job=unknown", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status running unknown job: %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/jobs", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	var status []Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if len(status) != 1 || len(status[0].Runs) != 1 {
+		t.Fatalf("unexpected status: %#v", status)
+	}
+}
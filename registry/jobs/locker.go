@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/garyburd/redigo/redis"
+)
+
+// lockFile is the content of the lock files written by a driver locker.
+type lockFile struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type driverLocker struct {
+	driver driver.StorageDriver
+	root   string
+	owner  string
+}
+
+// NewDriverLocker returns a locker keeping a lock file per job under root in
+// the storage driver, identifying this instance as owner. Storage drivers
+// offer no atomic compare-and-swap, so two instances racing for an expired
+// lock may both hold it for a run; use a redis locker where this matters.
+func NewDriverLocker(d driver.StorageDriver, root, owner string) Locker {
+	return &driverLocker{
+		driver: d,
+		root:   root,
+		owner:  owner,
+	}
+}
+
+func (dl *driverLocker) path(name string) string {
+	return path.Join(dl.root, name)
+}
+
+func (dl *driverLocker) read(ctx context.Context, name string) (lockFile, bool, error) {
+	var lock lockFile
+	content, err := dl.driver.GetContent(ctx, dl.path(name))
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return lock, false, nil
+		}
+		return lock, false, err
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		// A corrupted lock file is not held by anyone.
+		context.GetLogger(ctx).Warnf("ignoring invalid lock file %s: %v", dl.path(name), err)
+		return lock, false, nil
+	}
+	return lock, true, nil
+}
+
+func (dl *driverLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	lock, ok, err := dl.read(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if ok && lock.Owner != dl.owner && time.Now().Before(lock.ExpiresAt) {
+		return false, nil
+	}
+
+	content, err := json.Marshal(lockFile{
+		Owner:     dl.owner,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := dl.driver.PutContent(ctx, dl.path(name), content); err != nil {
+		return false, err
+	}
+
+	// Read the lock back, in case another instance wrote it concurrently.
+	lock, ok, err = dl.read(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return ok && lock.Owner == dl.owner, nil
+}
+
+func (dl *driverLocker) Release(ctx context.Context, name string) error {
+	lock, ok, err := dl.read(ctx, name)
+	if err != nil || !ok || lock.Owner != dl.owner {
+		return err
+	}
+	err = dl.driver.Delete(ctx, dl.path(name))
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// redisAcquireScript takes the lock if it is free or renews it if it is held
+// by the caller.
+var redisAcquireScript = redis.NewScript(1, `
+local owner = redis.call("GET", KEYS[1])
+if owner == false or owner == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// redisReleaseScript deletes the lock if it is held by the caller.
+var redisReleaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLocker struct {
+	pool  *redis.Pool
+	owner string
+}
+
+// NewRedisLocker returns a locker keeping its locks in redis, identifying
+// this instance as owner.
+func NewRedisLocker(pool *redis.Pool, owner string) Locker {
+	return &redisLocker{
+		pool:  pool,
+		owner: owner,
+	}
+}
+
+func (rl *redisLocker) key(name string) string {
+	return "jobs::lock::" + name
+}
+
+func (rl *redisLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	conn := rl.pool.Get()
+	defer conn.Close()
+
+	held, err := redis.Int(redisAcquireScript.Do(conn, rl.key(name), rl.owner, int64(ttl/time.Millisecond)))
+	if err != nil {
+		return false, err
+	}
+	return held == 1, nil
+}
+
+func (rl *redisLocker) Release(ctx context.Context, name string) error {
+	conn := rl.pool.Get()
+	defer conn.Close()
+
+	_, err := redisReleaseScript.Do(conn, rl.key(name), rl.owner)
+	return err
+}
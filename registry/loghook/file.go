@@ -0,0 +1,41 @@
+package loghook
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// fileSink appends the entries to a file as JSON lines. The file is opened
+// for every batch, so that it can be rotated.
+type fileSink struct {
+	path string
+}
+
+// newFileSink creates a file sink. The path option is required.
+func newFileSink(options map[string]interface{}) (Sink, error) {
+	path, err := stringOption("file", options, "path", true)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{path: path}, nil
+}
+
+func (s *fileSink) Send(entries []*Entry) error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func init() {
+	Register("file", InitFunc(newFileSink))
+}
@@ -0,0 +1,317 @@
+// Package loghook delivers log entries to sinks, such as webhooks, syslog,
+// files or digest emails, without blocking the logging calls.
+package loghook
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultQueueSize   = 1000
+	defaultRateLimit   = 100
+	defaultDedupWindow = time.Minute
+)
+
+// Entry is a log entry delivered to a sink.
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+
+	// Repeated is the number of times the message was logged again within
+	// the deduplication window, after the entry delivered first. The entry
+	// reporting the repeats is the last of them.
+	Repeated int `json:"repeated,omitempty"`
+}
+
+// Sink delivers batches of log entries.
+type Sink interface {
+	Send(entries []*Entry) error
+}
+
+// digester is implemented by the sinks delivering digests, whose entries are
+// batched unless configured otherwise.
+type digester interface {
+	defaultDigest() time.Duration
+}
+
+// Options configures the delivery of log entries to a sink.
+type Options struct {
+	// Levels are the levels of the entries delivered.
+	Levels []string
+
+	// QueueSize is the number of entries queued for delivery, beyond which
+	// entries are dropped. Default 1000.
+	QueueSize int
+
+	// RateLimit is the maximum number of entries delivered per minute,
+	// beyond which entries are dropped. Zero selects the default of 100,
+	// and a negative limit lifts it.
+	RateLimit int
+
+	// DedupWindow is the time during which the entries repeating the level
+	// and message of a delivered entry are only counted. Zero selects the
+	// default of one minute, and a negative window disables deduplication.
+	DedupWindow time.Duration
+
+	// Digest is the time during which entries are batched before being
+	// delivered. Zero delivers entries as they come, or once per minute for
+	// sinks delivering digests.
+	Digest time.Duration
+}
+
+// Hook implements logrus.Hook. Entries are queued and delivered to the sink
+// by a goroutine of the hook, so that logging never waits for the sink. The
+// number of entries dropped, because the queue was full or the rate limit
+// was reached, is reported to the sink with the next delivery.
+type Hook struct {
+	name    string
+	sink    Sink
+	levels  []logrus.Level
+	options Options
+
+	queue   chan *Entry
+	dropped uint64 // accessed atomically
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	// the state of the delivery goroutine
+	batch       []*Entry
+	seen        map[string]*dedupRecord
+	tokens      float64
+	refilled    time.Time
+	rateDropped uint64
+	flushed     time.Time
+}
+
+// dedupRecord counts the repeats of a delivered entry.
+type dedupRecord struct {
+	first   time.Time
+	last    *Entry
+	repeats int
+}
+
+// NewHook returns a hook delivering the entries of the given levels to a
+// sink of the named type, configured with its options.
+func NewHook(name string, sinkOptions map[string]interface{}, options Options) (*Hook, error) {
+	sink, err := GetSink(name, sinkOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var levels []logrus.Level
+	for _, v := range options.Levels {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level of %s log hook: %v", name, err)
+		}
+		levels = append(levels, level)
+	}
+
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultQueueSize
+	}
+	if options.RateLimit == 0 {
+		options.RateLimit = defaultRateLimit
+	}
+	if options.DedupWindow == 0 {
+		options.DedupWindow = defaultDedupWindow
+	}
+	if d, ok := sink.(digester); ok && options.Digest == 0 {
+		options.Digest = d.defaultDigest()
+	}
+
+	now := time.Now()
+	hook := &Hook{
+		name:     name,
+		sink:     sink,
+		levels:   levels,
+		options:  options,
+		queue:    make(chan *Entry, options.QueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		seen:     make(map[string]*dedupRecord),
+		tokens:   float64(options.RateLimit),
+		refilled: now,
+		flushed:  now,
+	}
+	go hook.run()
+	return hook, nil
+}
+
+// Levels returns the levels of the entries delivered by the hook.
+func (hook *Hook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+// Fire queues the entry for delivery, or drops it if the queue is full.
+func (hook *Hook) Fire(entry *logrus.Entry) error {
+	e := &Entry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(entry.Data) > 0 {
+		e.Data = make(map[string]interface{}, len(entry.Data))
+		for k, v := range entry.Data {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			e.Data[k] = v
+		}
+	}
+
+	select {
+	case hook.queue <- e:
+	default:
+		atomic.AddUint64(&hook.dropped, 1)
+	}
+	return nil
+}
+
+// Close delivers the queued entries and stops the hook.
+func (hook *Hook) Close() {
+	hook.closeOnce.Do(func() {
+		close(hook.done)
+	})
+	<-hook.stopped
+}
+
+// run delivers the queued entries until the hook is closed.
+func (hook *Hook) run() {
+	defer close(hook.stopped)
+
+	tick := time.Second
+	for _, d := range []time.Duration{hook.options.DedupWindow, hook.options.Digest} {
+		if d > 0 && d < tick {
+			tick = d
+		}
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-hook.queue:
+			hook.add(e, time.Now())
+			if hook.options.Digest <= 0 {
+				hook.drain()
+				hook.flush(time.Now())
+			}
+		case now := <-ticker.C:
+			hook.expire(now, false)
+			if now.Sub(hook.flushed) >= hook.options.Digest {
+				hook.flush(now)
+			}
+		case <-hook.done:
+			hook.drain()
+			now := time.Now()
+			hook.expire(now, true)
+			hook.flush(now)
+			return
+		}
+	}
+}
+
+// drain adds the entries waiting in the queue.
+func (hook *Hook) drain() {
+	for {
+		select {
+		case e := <-hook.queue:
+			hook.add(e, time.Now())
+		default:
+			return
+		}
+	}
+}
+
+// add deduplicates the entry and admits it into the batch.
+func (hook *Hook) add(e *Entry, now time.Time) {
+	if hook.options.DedupWindow <= 0 {
+		hook.admit(e, now)
+		return
+	}
+
+	key := e.Level + "\x00" + e.Message
+	if record, ok := hook.seen[key]; ok {
+		if now.Sub(record.first) < hook.options.DedupWindow {
+			record.repeats++
+			record.last = e
+			return
+		}
+		hook.report(record, now)
+	}
+	hook.seen[key] = &dedupRecord{first: now}
+	hook.admit(e, now)
+}
+
+// expire ends the deduplication windows which elapsed, or all of them,
+// reporting their repeats.
+func (hook *Hook) expire(now time.Time, all bool) {
+	for key, record := range hook.seen {
+		if all || now.Sub(record.first) >= hook.options.DedupWindow {
+			hook.report(record, now)
+			delete(hook.seen, key)
+		}
+	}
+}
+
+// report admits the last repeat of an entry with the number of repeats.
+func (hook *Hook) report(record *dedupRecord, now time.Time) {
+	if record.repeats == 0 {
+		return
+	}
+	e := *record.last
+	e.Repeated = record.repeats
+	hook.admit(&e, now)
+}
+
+// admit adds the entry to the batch, unless the rate limit was reached.
+func (hook *Hook) admit(e *Entry, now time.Time) {
+	if limit := float64(hook.options.RateLimit); limit > 0 {
+		hook.tokens += now.Sub(hook.refilled).Minutes() * limit
+		if hook.tokens > limit {
+			hook.tokens = limit
+		}
+		hook.refilled = now
+		if hook.tokens < 1 {
+			hook.rateDropped++
+			return
+		}
+		hook.tokens--
+	}
+	hook.batch = append(hook.batch, e)
+}
+
+// flush delivers the batch, along with the number of entries dropped since
+// the last delivery.
+func (hook *Hook) flush(now time.Time) {
+	hook.flushed = now
+
+	if dropped := atomic.SwapUint64(&hook.dropped, 0) + hook.rateDropped; dropped > 0 {
+		hook.rateDropped = 0
+		hook.batch = append(hook.batch, &Entry{
+			Time:    now,
+			Level:   logrus.WarnLevel.String(),
+			Message: fmt.Sprintf("%d log entries dropped by the %s log hook", dropped, hook.name),
+		})
+	}
+	if len(hook.batch) == 0 {
+		return
+	}
+
+	// errors are not logged, since they would come back to the hook
+	if err := hook.sink.Send(hook.batch); err != nil {
+		fmt.Fprintf(os.Stderr, "error delivering %d log entries to %s log hook: %v\n", len(hook.batch), hook.name, err)
+	}
+	hook.batch = nil
+}
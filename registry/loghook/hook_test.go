@@ -0,0 +1,253 @@
+package loghook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// testSink records the batches it is sent, and blocks while it is held.
+type testSink struct {
+	mu      sync.Mutex
+	batches [][]*Entry
+	hold    sync.Mutex
+}
+
+func (s *testSink) Send(entries []*Entry) error {
+	s.hold.Lock()
+	s.hold.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, entries)
+	return nil
+}
+
+func (s *testSink) entries() []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []*Entry
+	for _, batch := range s.batches {
+		entries = append(entries, batch...)
+	}
+	return entries
+}
+
+func init() {
+	Register("test", func(options map[string]interface{}) (Sink, error) {
+		return options["sink"].(*testSink), nil
+	})
+}
+
+func newTestHook(t *testing.T, options Options) (*Hook, *testSink, *logrus.Logger) {
+	sink := &testSink{}
+	hook, err := NewHook("test", map[string]interface{}{"sink": sink}, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating hook: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	return hook, sink, logger
+}
+
+func TestHookDedup(t *testing.T) {
+	hook, sink, logger := newTestHook(t, Options{
+		Levels:      []string{"error"},
+		DedupWindow: time.Hour,
+	})
+
+	for i := 0; i < 5; i++ {
+		logger.WithField("attempt", i).Error("storage unavailable")
+	}
+	logger.Error("other failure")
+	logger.Warn("not hooked")
+	hook.Close()
+
+	entries := sink.entries()
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if entries[0].Message != "storage unavailable" || entries[0].Repeated != 0 || entries[0].Data["attempt"] != 0 {
+		t.Fatalf("unexpected first entry: %#v", entries[0])
+	}
+	if entries[1].Message != "other failure" {
+		t.Fatalf("unexpected second entry: %#v", entries[1])
+	}
+	// the repeats are reported once the window ends, with the last of them
+	if entries[2].Message != "storage unavailable" || entries[2].Repeated != 4 || entries[2].Data["attempt"] != 4 {
+		t.Fatalf("unexpected repeat entry: %#v", entries[2])
+	}
+}
+
+func TestHookRateLimit(t *testing.T) {
+	hook, sink, logger := newTestHook(t, Options{
+		Levels:      []string{"error"},
+		RateLimit:   3,
+		DedupWindow: -1,
+	})
+
+	for i := 0; i < 10; i++ {
+		logger.Errorf("failure %d", i)
+	}
+	hook.Close()
+
+	entries := sink.entries()
+	if len(entries) != 4 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if last := entries[3]; last.Level != "warning" || !strings.HasPrefix(last.Message, "7 log entries dropped") {
+		t.Fatalf("expected the dropped entries to be reported: %#v", last)
+	}
+}
+
+func TestHookQueue(t *testing.T) {
+	hook, sink, logger := newTestHook(t, Options{
+		Levels:      []string{"error"},
+		QueueSize:   2,
+		RateLimit:   -1,
+		DedupWindow: -1,
+	})
+
+	// logging does not wait for a blocked sink
+	sink.hold.Lock()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			logger.Errorf("failure %d", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("logging blocked on the sink")
+	}
+	sink.hold.Unlock()
+	hook.Close()
+
+	entries := sink.entries()
+	if len(entries) < 2 || len(entries) > 5 {
+		t.Fatalf("unexpected number of entries: %d", len(entries))
+	}
+	last := entries[len(entries)-1]
+	var dropped int
+	if _, err := fmt.Sscanf(last.Message, "%d log entries dropped", &dropped); err != nil || dropped+len(entries)-1 != 100 {
+		t.Fatalf("expected the dropped entries to be reported: %#v", last)
+	}
+}
+
+func TestHookDigest(t *testing.T) {
+	hook, sink, logger := newTestHook(t, Options{
+		Levels: []string{"error"},
+		Digest: time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		logger.Errorf("failure %d", i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if entries := sink.entries(); len(entries) != 0 {
+		t.Fatalf("expected the entries to be batched, got %v", entries)
+	}
+	hook.Close()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 3 {
+		t.Fatalf("expected one digest of 3 entries, got %v", sink.batches)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.Header)
+		}
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected error decoding payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	sink, err := GetSink("webhook", map[string]interface{}{
+		"url":     server.URL,
+		"headers": map[interface{}]interface{}{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	if err := sink.Send([]*Entry{{Level: "error", Message: "failure", Repeated: 2}}); err != nil {
+		t.Fatalf("unexpected error sending entries: %v", err)
+	}
+	payload := <-received
+	if len(payload.Entries) != 1 || payload.Entries[0].Message != "failure" || payload.Entries[0].Repeated != 2 {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+
+	if _, err := GetSink("webhook", map[string]interface{}{"url": "ftp://example.com"}); err == nil {
+		t.Fatalf("expected a url which is not http to be rejected")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loghook-test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hook.log")
+	sink, err := GetSink("file", map[string]interface{}{"path": path})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	for _, message := range []string{"first", "second"} {
+		if err := sink.Send([]*Entry{{Level: "error", Message: message}}); err != nil {
+			t.Fatalf("unexpected error sending entries: %v", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"message":"second"`) {
+		t.Fatalf("unexpected file content: %s", content)
+	}
+}
+
+func TestMailSinkOptions(t *testing.T) {
+	options := map[string]interface{}{
+		"smtp": map[interface{}]interface{}{"addr": "smtp.example.com:25"},
+		"from": "registry@example.com",
+		"to":   []interface{}{"errors@example.com"},
+	}
+	sink, err := GetSink("mail", options)
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	if d, ok := sink.(digester); !ok || d.defaultDigest() != defaultMailDigest {
+		t.Fatalf("expected mail to be sent as digests")
+	}
+
+	delete(options, "to")
+	if _, err := GetSink("mail", options); err == nil {
+		t.Fatalf("expected a mail hook without recipients to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package loghook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// defaultMailDigest is the time during which mail hooks batch entries into
+// one email, unless configured otherwise.
+const defaultMailDigest = time.Minute
+
+// mailer provides fields of email configuration for sending.
+type mailer struct {
+	Addr, Username, Password, From string
+	Insecure                       bool
+	To                             []string
+}
+
+// sendMail allows users to send email, only if mail parameters is configured correctly.
+func (mail *mailer) sendMail(subject, message string) error {
+	addr := strings.Split(mail.Addr, ":")
+	if len(addr) != 2 {
+		return errors.New("Invalid Mail Address")
+	}
+	host := addr[0]
+	msg := []byte("To:" + strings.Join(mail.To, ";") +
+		"\r\nFrom: " + mail.From +
+		"\r\nSubject: " + subject +
+		"\r\nContent-Type: text/plain\r\n\r\n" +
+		message)
+	auth := smtp.PlainAuth(
+		"",
+		mail.Username,
+		mail.Password,
+		host,
+	)
+	err := smtp.SendMail(
+		mail.Addr,
+		auth,
+		mail.From,
+		mail.To,
+		[]byte(msg),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// mailSink sends the entries of a batch in one digest email.
+type mailSink struct {
+	mailer *mailer
+}
+
+// newMailSink creates a mail sink from the smtp, from and to options.
+func newMailSink(options map[string]interface{}) (Sink, error) {
+	mail := &mailer{}
+
+	if v, present := options["smtp"]; present {
+		smtpOptions, ok := stringMap(v)
+		if !ok {
+			return nil, fmt.Errorf("mail log hook requires a map of smtp options: %q", "smtp")
+		}
+		var err error
+		if mail.Addr, err = stringOption("mail", smtpOptions, "addr", true); err != nil {
+			return nil, err
+		}
+		if mail.Username, err = stringOption("mail", smtpOptions, "username", false); err != nil {
+			return nil, err
+		}
+		if mail.Password, err = stringOption("mail", smtpOptions, "password", false); err != nil {
+			return nil, err
+		}
+		mail.Insecure, _ = smtpOptions["insecure"].(bool)
+	}
+	if len(strings.Split(mail.Addr, ":")) != 2 {
+		return nil, fmt.Errorf("mail log hook requires a valid smtp address: %q", mail.Addr)
+	}
+
+	var err error
+	if mail.From, err = stringOption("mail", options, "from", true); err != nil {
+		return nil, err
+	}
+	to, _ := options["to"].([]interface{})
+	for _, v := range to {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("mail log hook requires a list of addresses: %q", "to")
+		}
+		mail.To = append(mail.To, s)
+	}
+	if len(mail.To) == 0 {
+		return nil, fmt.Errorf("mail log hook requires a list of addresses: %q", "to")
+	}
+
+	return &mailSink{mailer: mail}, nil
+}
+
+func (s *mailSink) defaultDigest() time.Duration {
+	return defaultMailDigest
+}
+
+var mailBody = template.Must(template.New("mail body").Parse(`
+{{range .}}
+{{.Time.Format "2006-01-02T15:04:05Z07:00"}} [{{.Level}}] {{.Message}}{{if .Repeated}} (repeated {{.Repeated}} times){{end}}
+{{range $key, $value := .Data}}	{{$key}}: {{$value}}
+{{end}}{{end}}`))
+
+func (s *mailSink) Send(entries []*Entry) error {
+	host := strings.Split(s.mailer.Addr, ":")[0]
+
+	var subject string
+	if len(entries) == 1 {
+		subject = fmt.Sprintf("[%s] %s: %s", entries[0].Level, host, entries[0].Message)
+	} else {
+		// the digest is tagged with its most severe level
+		level := entries[0].Level
+		for _, e := range entries[1:] {
+			if l, err := logrus.ParseLevel(e.Level); err == nil {
+				if max, err := logrus.ParseLevel(level); err != nil || l < max {
+					level = e.Level
+				}
+			}
+		}
+		subject = fmt.Sprintf("[%s] %s: %d log entries", level, host, len(entries))
+	}
+
+	b := bytes.NewBuffer(make([]byte, 0))
+	if err := mailBody.Execute(b, entries); err != nil {
+		return err
+	}
+
+	return s.mailer.sendMail(subject, b.String())
+}
+
+func init() {
+	Register("mail", InitFunc(newMailSink))
+}
@@ -0,0 +1,117 @@
+package loghook
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// InitFunc is the type of a Sink factory function and is used to register
+// the constructors of the different sink types.
+type InitFunc func(options map[string]interface{}) (Sink, error)
+
+// sinks is initialized on declaration, before the init functions of the
+// files registering sinks run.
+var sinks = make(map[string]InitFunc)
+
+// Register is used to register an InitFunc for a sink type with the given
+// name.
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := sinks[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	sinks[name] = initFunc
+
+	return nil
+}
+
+// ErrUnknownType is returned when no sink type is registered with the name.
+type ErrUnknownType struct {
+	Name string
+}
+
+func (e ErrUnknownType) Error() string {
+	return fmt.Sprintf("no log hook type registered with name: %s", e.Name)
+}
+
+// GetSink constructs a sink of the named type with the given options.
+func GetSink(name string, options map[string]interface{}) (Sink, error) {
+	if initFunc, exists := sinks[name]; exists {
+		return initFunc(options)
+	}
+
+	return nil, ErrUnknownType{Name: name}
+}
+
+// stringOption returns the string option of the given key, or an error if
+// it is required but missing.
+func stringOption(hook string, options map[string]interface{}, key string, required bool) (string, error) {
+	v, present := options[key]
+	if !present {
+		if required {
+			return "", fmt.Errorf("%s log hook requires a valid option string: %q", hook, key)
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok || (required && s == "") {
+		return "", fmt.Errorf("%s log hook requires a valid option string: %q", hook, key)
+	}
+	return s, nil
+}
+
+// durationOption returns the duration option of the given key, or the
+// default if it is missing.
+func durationOption(hook string, options map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	v, present := options[key]
+	if !present {
+		return def, nil
+	}
+	d, err := time.ParseDuration(fmt.Sprint(v))
+	if err != nil {
+		return 0, fmt.Errorf("%s log hook requires a valid duration: %q: %v", hook, key, err)
+	}
+	return d, nil
+}
+
+// stringMap converts the maps decoded from the configuration, whose keys may
+// be of any type, to maps of strings.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			converted[key] = v
+		}
+		return converted, true
+	}
+	return nil, false
+}
+
+// formatEntry formats an entry on one line, with its data.
+func formatEntry(e *Entry) string {
+	line := e.Message
+	for _, k := range sortedKeys(e.Data) {
+		line += fmt.Sprintf(" %s=%v", k, e.Data[k])
+	}
+	if e.Repeated > 0 {
+		line += fmt.Sprintf(" (repeated %d times)", e.Repeated)
+	}
+	return line
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+// +build !windows,!plan9,!nacl
+
+package loghook
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink writes the entries to syslog, with the severity of their
+// level.
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// newSyslogSink creates a syslog sink. The network and addr options select a
+// remote syslog server, the local one by default, and the tag option tags
+// the messages, "registry" by default.
+func newSyslogSink(options map[string]interface{}) (Sink, error) {
+	network, err := stringOption("syslog", options, "network", false)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := stringOption("syslog", options, "addr", false)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := stringOption("syslog", options, "tag", false)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		tag = "registry"
+	}
+
+	writer, err := syslog.Dial(network, addr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to syslog: %v", err)
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Send(entries []*Entry) error {
+	for _, e := range entries {
+		line := formatEntry(e)
+
+		var err error
+		switch e.Level {
+		case "panic":
+			err = s.writer.Emerg(line)
+		case "fatal":
+			err = s.writer.Crit(line)
+		case "error":
+			err = s.writer.Err(line)
+		case "warning":
+			err = s.writer.Warning(line)
+		case "info":
+			err = s.writer.Info(line)
+		default:
+			err = s.writer.Debug(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register("syslog", InitFunc(newSyslogSink))
+}
@@ -0,0 +1,88 @@
+package loghook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookSink posts the entries as JSON to a URL.
+type webhookSink struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+}
+
+// webhookPayload is the body posted by webhooks.
+type webhookPayload struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// newWebhookSink creates a webhook sink. The url option is required, headers
+// are added to the requests and timeout limits them, 10s by default.
+func newWebhookSink(options map[string]interface{}) (Sink, error) {
+	rawURL, err := stringOption("webhook", options, "url", true)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("webhook log hook requires an http or https url: %q", rawURL)
+	}
+
+	timeout, err := durationOption("webhook", options, "timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	if v, present := options["headers"]; present {
+		m, ok := stringMap(v)
+		if !ok {
+			return nil, fmt.Errorf("webhook log hook requires a map of headers: %q", "headers")
+		}
+		for k, v := range m {
+			headers.Set(k, fmt.Sprint(v))
+		}
+	}
+
+	return &webhookSink{
+		url:     rawURL,
+		headers: headers,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *webhookSink) Send(entries []*Entry) error {
+	body, err := json.Marshal(webhookPayload{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status posting to %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func init() {
+	Register("webhook", InitFunc(newWebhookSink))
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBreakerThreshold is the default number of consecutive failed
+	// requests after which an upstream is considered unavailable.
+	defaultBreakerThreshold = 5
+
+	// defaultBreakerTimeout is the default time an unavailable upstream is
+	// not contacted for, before a request is let through to probe it.
+	defaultBreakerTimeout = 30 * time.Second
+)
+
+// upstreamUnavailableError is returned for the requests to an upstream
+// which could not be reached, failed with a server error, or was not sent
+// because the upstream is considered unavailable.
+type upstreamUnavailableError struct {
+	reason string
+}
+
+func (e upstreamUnavailableError) Error() string {
+	return fmt.Sprintf("upstream unavailable: %s", e.reason)
+}
+
+// isUnavailable returns true if err was caused by an unavailable upstream.
+func isUnavailable(err error) bool {
+	switch err := err.(type) {
+	case upstreamUnavailableError:
+		return true
+	case *url.Error:
+		return isUnavailable(err.Err)
+	default:
+		return false
+	}
+}
+
+// breaker is a circuit breaker tracking the health of an upstream. Once
+// threshold consecutive requests have failed, it opens and requests fail
+// without contacting the upstream. After timeout, a single request is let
+// through: the breaker closes again if it succeeds.
+type breaker struct {
+	threshold int
+	timeout   time.Duration
+
+	// onRecover is called in a new goroutine when the breaker closes again.
+	onRecover func()
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker() *breaker {
+	return &breaker{
+		threshold: defaultBreakerThreshold,
+		timeout:   defaultBreakerTimeout,
+	}
+}
+
+// open returns true if the breaker is open.
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold
+}
+
+// allow returns true if a request can be sent to the upstream.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.timeout {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// release lets another request probe the upstream, without recording an
+// outcome.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// record records the outcome of a request sent to the upstream.
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		recovered := b.failures >= b.threshold
+		b.failures = 0
+		if recovered && b.onRecover != nil {
+			go b.onRecover()
+		}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// breakerTransport sends requests to an upstream through its breaker.
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *breaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, upstreamUnavailableError{reason: "circuit breaker open"}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			// The request was canceled by the client of the registry, which
+			// tells nothing about the upstream.
+			t.breaker.release()
+			return nil, err
+		}
+		t.breaker.record(false)
+		return nil, upstreamUnavailableError{reason: err.Error()}
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		resp.Body.Close()
+		t.breaker.record(false)
+		return nil, upstreamUnavailableError{reason: resp.Status}
+	}
+
+	t.breaker.record(true)
+	return resp, nil
+}
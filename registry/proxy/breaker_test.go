@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+)
+
+func TestBreaker(t *testing.T) {
+	var failing, requests int32 = 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	recovered := make(chan struct{}, 1)
+	b := newBreaker()
+	b.threshold = 2
+	b.timeout = 20 * time.Millisecond
+	b.onRecover = func() {
+		recovered <- struct{}{}
+	}
+	client := &http.Client{Transport: &breakerTransport{base: http.DefaultTransport, breaker: b}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(server.URL); !isUnavailable(err) {
+			t.Fatalf("expected the upstream to be unavailable, got %v", err)
+		}
+	}
+	if !b.open() {
+		t.Fatalf("expected the breaker to open after %d failures", b.threshold)
+	}
+
+	if _, err := client.Get(server.URL); !isUnavailable(err) {
+		t.Fatalf("expected the upstream to be unavailable, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected the open breaker not to contact the upstream, got %d requests", n)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(2 * b.timeout)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the probe to reach the upstream, got %v", err)
+	}
+	resp.Body.Close()
+	if b.open() {
+		t.Fatalf("expected the breaker to close once the upstream recovered")
+	}
+	select {
+	case <-recovered:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the recovery to be notified")
+	}
+}
+
+func TestProxyTagServiceServesStale(t *testing.T) {
+	localTags := &mockTagStore{mapping: map[string]distribution.Descriptor{
+		"latest": {Size: 1},
+	}}
+	var stale []string
+	pt := proxyTagService{
+		localTags:      localTags,
+		remoteTags:     unavailableTags{},
+		authChallenger: &mockChallenger{},
+		stale: func(tag string) {
+			stale = append(stale, tag)
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := context.WithResponseWriter(context.Background(), recorder)
+	desc, err := pt.Get(ctx, "latest")
+	if err != nil || desc.Size != 1 {
+		t.Fatalf("expected the local tag to be served: %v, %v", desc, err)
+	}
+	if recorder.Header().Get("Warning") != staleWarning {
+		t.Fatalf("expected a stale warning, got %q", recorder.Header().Get("Warning"))
+	}
+	if len(stale) != 1 || stale[0] != "latest" {
+		t.Fatalf("expected the tag to be revalidated later, got %v", stale)
+	}
+
+	// A tag unknown to an available upstream is not stale.
+	pt.remoteTags = &mockTagStore{mapping: map[string]distribution.Descriptor{}}
+	recorder = httptest.NewRecorder()
+	ctx, _ = context.WithResponseWriter(context.Background(), recorder)
+	if _, err := pt.Get(ctx, "latest"); err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+	if recorder.Header().Get("Warning") != "" || len(stale) != 1 {
+		t.Fatalf("unexpected stale response for a tag unknown upstream")
+	}
+}
+
+// unavailableTags is the tag service of an unavailable upstream.
+type unavailableTags struct {
+	distribution.TagService
+}
+
+func (unavailableTags) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, upstreamUnavailableError{reason: "503 Service Unavailable"}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/client/auth"
+)
+
+// remote is a repository on an upstream.
+type remote struct {
+	authChallenger authChallenger
+	blobs          distribution.BlobService
+	manifests      distribution.ManifestService
+	tags           distribution.TagService
+}
+
+// remotes are the repositories on the upstreams mirroring a repository, in
+// the order they are tried.
+type remotes []remote
+
+// try calls f with each remote in turn until it succeeds, and returns the
+// error of the last remote otherwise.
+func (rs remotes) try(ctx context.Context, f func(r remote) error) error {
+	var err error
+	for _, r := range rs {
+		if err = r.authChallenger.tryEstablishChallenges(ctx); err != nil {
+			context.GetLogger(ctx).Warnf("error establishing challenges with upstream: %v", err)
+			continue
+		}
+		if err = f(r); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// fallback returns the remote trying each of the remotes in turn.
+func (rs remotes) fallback() remote {
+	return remote{
+		authChallenger: fallbackChallenger{},
+		blobs:          &fallbackBlobs{BlobService: rs[0].blobs, remotes: rs},
+		manifests:      &fallbackManifests{ManifestService: rs[0].manifests, remotes: rs},
+		tags:           &fallbackTags{TagService: rs[0].tags, remotes: rs},
+	}
+}
+
+// fallbackChallenger is the challenger of the proxy services of repositories
+// mirrored from several upstreams. The challenges of each upstream are
+// established by the fallback services before it is contacted.
+type fallbackChallenger struct{}
+
+func (fallbackChallenger) tryEstablishChallenges(context.Context) error {
+	return nil
+}
+
+func (fallbackChallenger) challengeManager() auth.ChallengeManager {
+	return nil
+}
+
+func (fallbackChallenger) credentialStore() auth.CredentialStore {
+	return nil
+}
+
+// fallbackBlobs reads blobs from the first upstream which has them. The
+// other operations are sent to the first upstream.
+type fallbackBlobs struct {
+	distribution.BlobService
+	remotes remotes
+}
+
+func (fb *fallbackBlobs) Stat(ctx context.Context, dgst digest.Digest) (desc distribution.Descriptor, err error) {
+	err = fb.remotes.try(ctx, func(r remote) error {
+		desc, err = r.blobs.Stat(ctx, dgst)
+		return err
+	})
+	return desc, err
+}
+
+func (fb *fallbackBlobs) Get(ctx context.Context, dgst digest.Digest) (p []byte, err error) {
+	err = fb.remotes.try(ctx, func(r remote) error {
+		p, err = r.blobs.Get(ctx, dgst)
+		return err
+	})
+	return p, err
+}
+
+func (fb *fallbackBlobs) Open(ctx context.Context, dgst digest.Digest) (rsc distribution.ReadSeekCloser, err error) {
+	err = fb.remotes.try(ctx, func(r remote) error {
+		rsc, err = r.blobs.Open(ctx, dgst)
+		return err
+	})
+	return rsc, err
+}
+
+// fallbackManifests reads manifests from the first upstream which has them.
+// The other operations are sent to the first upstream.
+type fallbackManifests struct {
+	distribution.ManifestService
+	remotes remotes
+}
+
+func (fm *fallbackManifests) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	var exists bool
+	err := fm.remotes.try(ctx, func(r remote) error {
+		var err error
+		exists, err = r.manifests.Exists(ctx, dgst)
+		if err == nil && !exists {
+			return distribution.ErrManifestUnknownRevision{Revision: dgst}
+		}
+		return err
+	})
+	if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (fm *fallbackManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (m distribution.Manifest, err error) {
+	err = fm.remotes.try(ctx, func(r remote) error {
+		m, err = r.manifests.Get(ctx, dgst, options...)
+		return err
+	})
+	return m, err
+}
+
+// fallbackTags reads tags from the first upstream which has them. The other
+// operations are sent to the first upstream.
+type fallbackTags struct {
+	distribution.TagService
+	remotes remotes
+}
+
+func (ft *fallbackTags) Get(ctx context.Context, tag string) (desc distribution.Descriptor, err error) {
+	err = ft.remotes.try(ctx, func(r remote) error {
+		desc, err = r.tags.Get(ctx, tag)
+		return err
+	})
+	return desc, err
+}
+
+func (ft *fallbackTags) All(ctx context.Context) (tags []string, err error) {
+	err = ft.remotes.try(ctx, func(r remote) error {
+		tags, err = r.tags.All(ctx)
+		return err
+	})
+	return tags, err
+}
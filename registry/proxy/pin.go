@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/reference"
+)
+
+// pin is cached content which is never expired nor evicted: the
+// repositories matching a pattern, or the manifest of a tag and the blobs
+// it references.
+type pin struct {
+	match string
+	tag   string
+}
+
+// pinsFromConfig parses the pinned entries of the configuration.
+func pinsFromConfig(patterns []string) ([]pin, error) {
+	var pins []pin
+	for _, pattern := range patterns {
+		p := pin{match: pattern}
+		if i := strings.LastIndex(pattern, ":"); i > strings.LastIndex(pattern, "/") {
+			p.match, p.tag = pattern[:i], pattern[i+1:]
+			if !reference.TagRegexp.MatchString(p.tag) || strings.HasSuffix(p.match, "*") {
+				return nil, fmt.Errorf("invalid pinned tag %q", pattern)
+			}
+		}
+		if p.match == "*" {
+			p.match = ""
+		}
+		if strings.Contains(strings.TrimSuffix(p.match, "/*"), "*") {
+			return nil, fmt.Errorf("invalid pinned pattern %q", pattern)
+		}
+		pins = append(pins, p)
+	}
+	return pins, nil
+}
+
+// pinned returns true if the content of a scheduler entry is pinned. The
+// scheduler calls it unlocked, as it may read the local storage.
+func (pr *proxyingRegistry) pinned(ref reference.Reference) bool {
+	r, ok := ref.(reference.Canonical)
+	if !ok {
+		return false
+	}
+
+	for _, p := range pr.pins {
+		if !matchName(p.match, r.Name()) {
+			continue
+		}
+		if p.tag == "" {
+			return true
+		}
+		referenced, err := pr.referencedByTag(r, p.tag)
+		if err != nil {
+			context.GetLogger(pr.ctx).Errorf("error checking whether %s is pinned by tag %s: %v", r, p.tag, err)
+			// Keep the content until the tag can be checked.
+			return true
+		}
+		if referenced {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedByTag returns true if the content is the manifest the tag
+// points to in the local repository, or is referenced by it.
+func (pr *proxyingRegistry) referencedByTag(r reference.Canonical, tag string) (bool, error) {
+	repo, err := pr.embedded.Repository(pr.ctx, r)
+	if err != nil {
+		return false, err
+	}
+	desc, err := repo.Tags(pr.ctx).Get(pr.ctx, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	manifests, err := repo.Manifests(pr.ctx)
+	if err != nil {
+		return false, err
+	}
+	return references(pr.ctx, manifests, desc.Digest, r.Digest())
+}
+
+// references returns true if target is the manifest dgst or is referenced
+// by it, following the manifests of manifest lists.
+func references(ctx context.Context, manifests distribution.ManifestService, dgst, target digest.Digest) (bool, error) {
+	if dgst == target {
+		return true, nil
+	}
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			// The manifest was not pulled, so nothing it references is cached.
+			return false, nil
+		}
+		return false, err
+	}
+	_, isList := m.(*manifestlist.DeserializedManifestList)
+	for _, desc := range m.References() {
+		if desc.Digest == target {
+			return true, nil
+		}
+		if !isList {
+			continue
+		}
+		if ok, err := references(ctx, manifests, desc.Digest, target); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
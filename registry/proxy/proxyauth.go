@@ -3,11 +3,11 @@ package proxy
 import (
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/docker/distribution/registry/client/auth"
 )
 
-const tokenURL = "https://auth.docker.io/token"
 const challengeHeader = "Docker-Distribution-Api-Version"
 
 type userpass struct {
@@ -15,36 +15,66 @@ type userpass struct {
 	password string
 }
 
+// credentials answers the challenges of an upstream. Every upstream has its
+// own credentials, keyed by the hosts of the realms of its challenges, so
+// that they are only presented to the token servers the upstream redirects
+// to, and to the upstream itself.
 type credentials struct {
+	upstream userpass
+
+	mu    sync.RWMutex
 	creds map[string]userpass
 }
 
-func (c credentials) Basic(u *url.URL) (string, string) {
-	up := c.creds[u.String()]
+func (c *credentials) Basic(u *url.URL) (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
+	up := c.creds[u.Host]
 	return up.username, up.password
 }
 
-func (c credentials) RefreshToken(u *url.URL, service string) string {
+func (c *credentials) RefreshToken(u *url.URL, service string) string {
 	return ""
 }
 
-func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
+func (c *credentials) SetRefreshToken(u *url.URL, service, token string) {
 }
 
-// configureAuth stores credentials for challenge responses
-func configureAuth(username, password string) (auth.CredentialStore, error) {
-	creds := map[string]userpass{
-		tokenURL: {
-			username: username,
-			password: password,
-		},
+// addRealms presents the credentials of the upstream to the realms of its
+// challenges.
+func (c *credentials) addRealms(challenges []auth.Challenge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, challenge := range challenges {
+		realm, err := url.Parse(challenge.Parameters["realm"])
+		if err != nil || realm.Host == "" {
+			continue
+		}
+		c.creds[realm.Host] = c.upstream
 	}
-	return credentials{creds: creds}, nil
 }
 
-func ping(manager auth.ChallengeManager, endpoint, versionHeader string) error {
-	resp, err := http.Get(endpoint)
+// configureAuth stores credentials for challenge responses. Until the
+// challenges of the upstream are known, they are only presented to the
+// upstream host.
+func configureAuth(remoteURL url.URL, username, password string) (*credentials, error) {
+	up := userpass{
+		username: username,
+		password: password,
+	}
+	return &credentials{
+		upstream: up,
+		creds: map[string]userpass{
+			remoteURL.Host: up,
+		},
+	}, nil
+}
+
+func ping(transport http.RoundTripper, manager auth.ChallengeManager, endpoint, versionHeader string) error {
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(endpoint)
 	if err != nil {
 		return err
 	}
@@ -14,13 +14,14 @@ import (
 	"github.com/docker/distribution/registry/proxy/scheduler"
 )
 
-// todo(richardscothern): from cache control header or config file
-const blobTTL = time.Duration(24 * 7 * time.Hour)
+// defaultBlobTTL is the default time blobs are cached for
+const defaultBlobTTL = time.Duration(24 * 7 * time.Hour)
 
 type proxyBlobStore struct {
 	localStore     distribution.BlobStore
 	remoteStore    distribution.BlobService
 	scheduler      *scheduler.TTLExpirationScheduler
+	ttl            time.Duration
 	repositoryName reference.Named
 	authChallenger authChallenger
 }
@@ -75,6 +76,9 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 
 	if err == nil {
 		proxyMetrics.BlobPush(uint64(localDesc.Size))
+		if blobRef, err := reference.WithDigest(pbs.repositoryName, dgst); err == nil {
+			pbs.scheduler.Touch(blobRef)
+		}
 		return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 	}
 
@@ -82,7 +86,7 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 
 }
 
-func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) error {
+func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	defer func() {
 		mu.Lock()
 		delete(inflight, dgst)
@@ -95,20 +99,20 @@ func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) e
 
 	bw, err = pbs.localStore.Create(ctx)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
 
 	desc, err = pbs.copyContent(ctx, dgst, bw)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
 
 	_, err = bw.Commit(ctx, desc)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
 
-	return nil
+	return desc, nil
 }
 
 func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -137,7 +141,8 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	mu.Unlock()
 
 	go func(dgst digest.Digest) {
-		if err := pbs.storeLocal(ctx, dgst); err != nil {
+		desc, err := pbs.storeLocal(ctx, dgst)
+		if err != nil {
 			context.GetLogger(ctx).Errorf("Error committing to storage: %s", err.Error())
 		}
 
@@ -147,7 +152,7 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 			return
 		}
 
-		pbs.scheduler.AddBlob(blobRef, repositoryTTL)
+		pbs.scheduler.AddBlob(blobRef, pbs.ttl, desc.Size)
 	}(dgst)
 
 	_, err = pbs.copyContent(ctx, dgst, w)
@@ -10,8 +10,8 @@ import (
 	"github.com/docker/distribution/registry/proxy/scheduler"
 )
 
-// todo(richardscothern): from cache control header or config
-const repositoryTTL = time.Duration(24 * 7 * time.Hour)
+// defaultManifestTTL is the default time manifests are cached for
+const defaultManifestTTL = time.Duration(24 * 7 * time.Hour)
 
 type proxyManifestStore struct {
 	ctx             context.Context
@@ -19,6 +19,7 @@ type proxyManifestStore struct {
 	remoteManifests distribution.ManifestService
 	repositoryName  reference.Named
 	scheduler       *scheduler.TTLExpirationScheduler
+	ttl             time.Duration
 	authChallenger  authChallenger
 }
 
@@ -53,6 +54,8 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 		fromRemote = true
+	} else if repoManifest, err := reference.WithDigest(pms.repositoryName, dgst); err == nil {
+		pms.scheduler.Touch(repoManifest)
 	}
 
 	_, payload, err := manifest.Payload()
@@ -76,9 +79,7 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 
-		pms.scheduler.AddManifest(repoBlob, repositoryTTL)
-		// Ensure the manifest blob is cleaned up
-		//pms.scheduler.AddBlob(blobRef, repositoryTTL)
+		pms.scheduler.AddManifest(repoBlob, pms.ttl, int64(len(payload)))
 
 	}
 
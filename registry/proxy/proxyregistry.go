@@ -5,34 +5,75 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/reference"
-	"github.com/docker/distribution/registry/client"
 	"github.com/docker/distribution/registry/client/auth"
-	"github.com/docker/distribution/registry/client/transport"
 	"github.com/docker/distribution/registry/proxy/scheduler"
 	"github.com/docker/distribution/registry/storage"
 	"github.com/docker/distribution/registry/storage/driver"
 )
 
-// proxyingRegistry fetches content from a remote registry and caches it locally
+// proxyingRegistry fetches content from remote registries and caches it locally
 type proxyingRegistry struct {
-	embedded       distribution.Namespace // provides local registry functionality
-	scheduler      *scheduler.TTLExpirationScheduler
-	remoteURL      url.URL
-	authChallenger authChallenger
+	ctx       context.Context
+	embedded  distribution.Namespace // provides local registry functionality
+	scheduler *scheduler.TTLExpirationScheduler
+	upstreams []*upstream
+
+	manifestTTL, blobTTL         time.Duration
+	maxBytes, maxRepositoryBytes int64
+	pins                         []pin
+
+	// stale are the tags served while their upstream was unavailable,
+	// revalidated once an upstream recovers.
+	staleMu sync.Mutex
+	stale   map[string]staleTag
 }
 
-// NewRegistryPullThroughCache creates a registry acting as a pull through cache
+// staleTag is a tag served while its upstream was unavailable.
+type staleTag struct {
+	name reference.Named
+	tag  string
+}
+
+// NewRegistryPullThroughCache creates a registry acting as a pull through
+// cache of the upstreams of config. Repositories which are not mirrored from
+// any upstream are served by registry.
 func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
-	remoteURL, err := url.Parse(config.RemoteURL)
+	upstreams, err := upstreamsFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	pins, err := pinsFromConfig(config.Pinned)
 	if err != nil {
 		return nil, err
 	}
 
+	pr := &proxyingRegistry{
+		ctx:                ctx,
+		embedded:           registry,
+		upstreams:          upstreams,
+		manifestTTL:        defaultManifestTTL,
+		blobTTL:            defaultBlobTTL,
+		maxBytes:           config.Eviction.MaxBytes,
+		maxRepositoryBytes: config.Eviction.MaxRepositoryBytes,
+		pins:               pins,
+		stale:              make(map[string]staleTag),
+	}
+	for _, u := range upstreams {
+		u.breaker.onRecover = pr.revalidate
+	}
+	if config.TTL.Manifests > 0 {
+		pr.manifestTTL = config.TTL.Manifests
+	}
+	if config.TTL.Blobs > 0 {
+		pr.blobTTL = config.TTL.Blobs
+	}
+
 	v := storage.NewVacuum(ctx, driver)
 	s := scheduler.New(ctx, driver, "/scheduler-state.json")
 	s.OnBlobExpire(func(ref reference.Reference) error {
@@ -86,26 +127,71 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		return nil
 	})
 
+	s.Pinned(pr.pinned)
+
+	// Cached content is expired by the caller, see ContentExpirer.
+	s.DisableTimers()
 	err = s.Start()
 	if err != nil {
 		return nil, err
 	}
 
-	cs, err := configureAuth(config.Username, config.Password)
-	if err != nil {
-		return nil, err
+	pr.scheduler = s
+	return pr, nil
+}
+
+// ContentExpirer is implemented by the namespaces returned by
+// NewRegistryPullThroughCache. The content cached by a pull through cache
+// is only removed once its TTL has expired or it is evicted, and
+// ExpireCachedContent is called, which is expected to be done periodically.
+type ContentExpirer interface {
+	ExpireCachedContent(ctx context.Context) error
+}
+
+// ExpireCachedContent removes the cached blobs and manifests whose TTL has
+// expired, then evicts the least recently pulled ones until the cache fits
+// in its size limits.
+func (pr *proxyingRegistry) ExpireCachedContent(ctx context.Context) error {
+	if err := pr.scheduler.Expire(); err != nil {
+		return err
 	}
+	return pr.scheduler.Evict(pr.maxBytes, pr.maxRepositoryBytes)
+}
 
-	return &proxyingRegistry{
-		embedded:  registry,
-		scheduler: s,
-		remoteURL: *remoteURL,
-		authChallenger: &remoteAuthChallenger{
-			remoteURL: *remoteURL,
-			cm:        auth.NewSimpleChallengeManager(),
-			cs:        cs,
-		},
-	}, nil
+// markStale records a tag served while its upstream was unavailable.
+func (pr *proxyingRegistry) markStale(name reference.Named, tag string) {
+	pr.staleMu.Lock()
+	defer pr.staleMu.Unlock()
+
+	pr.stale[name.Name()+":"+tag] = staleTag{name: name, tag: tag}
+}
+
+// revalidate gets the stale tags from their upstream again, updating the
+// local tags. The tags whose upstream is still unavailable stay stale.
+func (pr *proxyingRegistry) revalidate() {
+	pr.staleMu.Lock()
+	stale := pr.stale
+	pr.stale = make(map[string]staleTag)
+	pr.staleMu.Unlock()
+
+	for key, st := range stale {
+		repo, err := pr.Repository(pr.ctx, st.name)
+		if err != nil {
+			context.GetLogger(pr.ctx).Errorf("error revalidating %s: %v", key, err)
+			continue
+		}
+		if _, err := repo.Tags(pr.ctx).Get(pr.ctx, st.tag); err != nil {
+			context.GetLogger(pr.ctx).Errorf("error revalidating %s: %v", key, err)
+			continue
+		}
+		pr.staleMu.Lock()
+		_, stillStale := pr.stale[key]
+		pr.staleMu.Unlock()
+		if stillStale {
+			continue
+		}
+		context.GetLogger(pr.ctx).Infof("revalidated stale tag %s", key)
+	}
 }
 
 func (pr *proxyingRegistry) Scope() distribution.Scope {
@@ -117,52 +203,65 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 }
 
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
-	c := pr.authChallenger
-
-	tr := transport.NewTransport(http.DefaultTransport,
-		auth.NewAuthorizer(c.challengeManager(), auth.NewTokenHandler(http.DefaultTransport, c.credentialStore(), name.Name(), "pull")))
-
 	localRepo, err := pr.embedded.Repository(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
-	if err != nil {
-		return nil, err
+
+	var rs remotes
+	for _, u := range pr.upstreams {
+		if !u.matches(name.Name()) {
+			continue
+		}
+		r, err := u.repository(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	if len(rs) == 0 {
+		// Repositories which are not mirrored are hosted locally.
+		return localRepo, nil
 	}
 
-	remoteRepo, err := client.NewRepository(ctx, name, pr.remoteURL.String(), tr)
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
 	if err != nil {
 		return nil, err
 	}
 
-	remoteManifests, err := remoteRepo.Manifests(ctx)
-	if err != nil {
-		return nil, err
+	remote := rs[0]
+	if len(rs) > 1 {
+		remote = rs.fallback()
 	}
 
 	return &proxiedRepository{
 		blobStore: &proxyBlobStore{
 			localStore:     localRepo.Blobs(ctx),
-			remoteStore:    remoteRepo.Blobs(ctx),
+			remoteStore:    remote.blobs,
 			scheduler:      pr.scheduler,
+			ttl:            pr.blobTTL,
 			repositoryName: name,
-			authChallenger: pr.authChallenger,
+			authChallenger: remote.authChallenger,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
 			localManifests:  localManifests, // Options?
-			remoteManifests: remoteManifests,
+			remoteManifests: remote.manifests,
 			ctx:             ctx,
 			scheduler:       pr.scheduler,
-			authChallenger:  pr.authChallenger,
+			ttl:             pr.manifestTTL,
+			authChallenger:  remote.authChallenger,
 		},
 		name: name,
 		tags: &proxyTagService{
 			localTags:      localRepo.Tags(ctx),
-			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
+			remoteTags:     remote.tags,
+			authChallenger: remote.authChallenger,
+			stale: func(tag string) {
+				pr.markStale(name, tag)
+			},
 		},
+		caches: localRepo.Caches(ctx),
 	}, nil
 }
 
@@ -178,6 +277,18 @@ func (pr *proxyingRegistry) BlobCache() distribution.BlobCache {
 	return pr.embedded.BlobCache()
 }
 
+func (pr *proxyingRegistry) MetadataIndex() distribution.MetadataIndex {
+	return pr.embedded.MetadataIndex()
+}
+
+func (pr *proxyingRegistry) DownloadCounter() distribution.DownloadCounter {
+	return pr.embedded.DownloadCounter()
+}
+
+func (pr *proxyingRegistry) Quotas() distribution.QuotaService {
+	return pr.embedded.Quotas()
+}
+
 // authChallenger encapsulates a request to the upstream to establish credential challenges
 type authChallenger interface {
 	tryEstablishChallenges(context.Context) error
@@ -187,9 +298,10 @@ type authChallenger interface {
 
 type remoteAuthChallenger struct {
 	remoteURL url.URL
+	transport http.RoundTripper
 	sync.Mutex
 	cm auth.ChallengeManager
-	cs auth.CredentialStore
+	cs *credentials
 }
 
 func (r *remoteAuthChallenger) credentialStore() auth.CredentialStore {
@@ -217,9 +329,14 @@ func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error
 	}
 
 	// establish challenge type with upstream
-	if err := ping(r.cm, remoteURL.String(), challengeHeader); err != nil {
+	if err := ping(r.transport, r.cm, remoteURL.String(), challengeHeader); err != nil {
+		return err
+	}
+	challenges, err = r.cm.GetChallenges(r.remoteURL)
+	if err != nil {
 		return err
 	}
+	r.cs.addRealms(challenges)
 
 	context.GetLogger(ctx).Infof("Challenge established with upstream : %s %s", remoteURL, r.cm)
 	return nil
@@ -5,11 +5,19 @@ import (
 	"github.com/docker/distribution/context"
 )
 
+// staleWarning is the Warning header of the responses served from the local
+// tags because the upstream is unavailable.
+const staleWarning = `110 - "Response is Stale"`
+
 // proxyTagService supports local and remote lookup of tags.
 type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+
+	// stale is called when a local tag is served because the upstream is
+	// unavailable, so it can be revalidated once the upstream recovers.
+	stale func(tag string)
 }
 
 var _ distribution.TagService = proxyTagService{}
@@ -18,10 +26,11 @@ var _ distribution.TagService = proxyTagService{}
 // tag service first and then caching it locally.  If the remote is unavailable
 // the local association is returned
 func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
-	err := pt.authChallenger.tryEstablishChallenges(ctx)
-	if err == nil {
-		desc, err := pt.remoteTags.Get(ctx, tag)
-		if err == nil {
+	remoteErr := pt.authChallenger.tryEstablishChallenges(ctx)
+	if remoteErr == nil {
+		var desc distribution.Descriptor
+		desc, remoteErr = pt.remoteTags.Get(ctx, tag)
+		if remoteErr == nil {
 			err := pt.localTags.Tag(ctx, tag, desc)
 			if err != nil {
 				return distribution.Descriptor{}, err
@@ -34,9 +43,24 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Des
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
+	if isUnavailable(remoteErr) {
+		context.GetLogger(ctx).Warnf("serving stale tag %s: %v", tag, remoteErr)
+		setStaleWarning(ctx)
+		if pt.stale != nil {
+			pt.stale(tag)
+		}
+	}
 	return desc, nil
 }
 
+// setStaleWarning warns the client of the request that the response may be
+// out of date.
+func setStaleWarning(ctx context.Context) {
+	if w, err := context.GetResponseWriter(ctx); err == nil {
+		w.Header().Set("Warning", staleWarning)
+	}
+}
+
 func (pt proxyTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
 	return distribution.ErrUnsupported
 }
@@ -50,14 +74,20 @@ func (pt proxyTagService) Untag(ctx context.Context, tag string) error {
 }
 
 func (pt proxyTagService) All(ctx context.Context) ([]string, error) {
-	err := pt.authChallenger.tryEstablishChallenges(ctx)
-	if err == nil {
-		tags, err := pt.remoteTags.All(ctx)
-		if err == nil {
-			return tags, err
+	remoteErr := pt.authChallenger.tryEstablishChallenges(ctx)
+	if remoteErr == nil {
+		var tags []string
+		tags, remoteErr = pt.remoteTags.All(ctx)
+		if remoteErr == nil {
+			return tags, nil
 		}
 	}
-	return pt.localTags.All(ctx)
+
+	tags, err := pt.localTags.All(ctx)
+	if err == nil && isUnavailable(remoteErr) {
+		setStaleWarning(ctx)
+	}
+	return tags, err
 }
 
 func (pt proxyTagService) Lookup(ctx context.Context, digest distribution.Descriptor) ([]string, error) {
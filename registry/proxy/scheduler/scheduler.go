@@ -3,6 +3,8 @@ package scheduler
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +16,9 @@ import (
 // onTTLExpiryFunc is called when a repository's TTL expires
 type expiryFunc func(reference.Reference) error
 
+// pinnedFunc returns true if a reference must never be expired or evicted
+type pinnedFunc func(reference.Reference) bool
+
 const (
 	entryTypeBlob = iota
 	entryTypeManifest
@@ -27,6 +32,13 @@ type schedulerEntry struct {
 	Expiry    time.Time `json:"ExpiryData"`
 	EntryType int       `json:"EntryType"`
 
+	// Size is the size of the content in bytes, used by Evict
+	Size int64 `json:"Size,omitempty"`
+
+	// LastAccess is the last time the content was pulled. Entries restored
+	// from a state written without it are evicted first.
+	LastAccess time.Time `json:"LastAccess"`
+
 	timer *time.Timer
 }
 
@@ -40,6 +52,7 @@ func New(ctx context.Context, driver driver.StorageDriver, path string) *TTLExpi
 		stopped:         true,
 		doneChan:        make(chan struct{}),
 		saveTimer:       time.NewTicker(indexSaveFrequency),
+		timers:          true,
 	}
 }
 
@@ -58,12 +71,176 @@ type TTLExpirationScheduler struct {
 
 	onBlobExpire     expiryFunc
 	onManifestExpire expiryFunc
+	pinned           pinnedFunc
 
 	indexDirty bool
 	saveTimer  *time.Ticker
 	doneChan   chan struct{}
+
+	// timers is false if entries only expire when Expire is called
+	timers bool
+}
+
+// DisableTimers makes entries expire only when Expire is called, so
+// expiration can be driven by an external job. It must be called before
+// Start.
+func (ttles *TTLExpirationScheduler) DisableTimers() {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.timers = false
+}
+
+// Expire runs the expiry functions of the entries whose TTL has expired and
+// removes them from the scheduler.
+func (ttles *TTLExpirationScheduler) Expire() error {
+	ttles.Lock()
+	if ttles.stopped {
+		ttles.Unlock()
+		return fmt.Errorf("scheduler not started")
+	}
+
+	now := time.Now()
+	var expired []*schedulerEntry
+	for _, entry := range ttles.entries {
+		if !entry.Expiry.After(now) {
+			expired = append(expired, entry)
+		}
+	}
+	pinned := ttles.pinned
+	ttles.Unlock()
+
+	evictable := unpinned(pinned, expired)
+
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	for _, entry := range expired {
+		if evictable[entry] && ttles.entries[entry.Key] == entry {
+			ttles.expire(entry)
+		}
+	}
+	return nil
+}
+
+// Evict removes the least recently pulled entries until the content of each
+// repository fits in maxRepositoryBytes and the content of every repository
+// fits in maxBytes. A limit of 0 is unlimited. Pinned entries are never
+// evicted but count towards the limits, as do the entries added while the
+// pins are checked.
+func (ttles *TTLExpirationScheduler) Evict(maxBytes, maxRepositoryBytes int64) error {
+	ttles.Lock()
+	if ttles.stopped {
+		ttles.Unlock()
+		return fmt.Errorf("scheduler not started")
+	}
+
+	entries := make([]*schedulerEntry, 0, len(ttles.entries))
+	for _, entry := range ttles.entries {
+		entries = append(entries, entry)
+	}
+	pinned := ttles.pinned
+	ttles.Unlock()
+
+	evictable := unpinned(pinned, entries)
+
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	var total int64
+	repositories := make(map[string]int64)
+	var candidates entriesByLastAccess
+	for _, entry := range ttles.entries {
+		name := repositoryName(entry.Key)
+		total += entry.Size
+		repositories[name] += entry.Size
+		if evictable[entry] {
+			candidates = append(candidates, entry)
+		}
+	}
+	sort.Sort(candidates)
+
+	var remaining entriesByLastAccess
+	for _, entry := range candidates {
+		name := repositoryName(entry.Key)
+		if maxRepositoryBytes > 0 && repositories[name] > maxRepositoryBytes {
+			context.GetLogger(ttles.ctx).Infof("Evicting %s: repository size %d exceeds %d bytes", entry.Key, repositories[name], maxRepositoryBytes)
+			repositories[name] -= entry.Size
+			total -= entry.Size
+			ttles.expire(entry)
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+
+	for _, entry := range remaining {
+		if maxBytes <= 0 || total <= maxBytes {
+			break
+		}
+		context.GetLogger(ttles.ctx).Infof("Evicting %s: cache size %d exceeds %d bytes", entry.Key, total, maxBytes)
+		total -= entry.Size
+		ttles.expire(entry)
+	}
+	return nil
+}
+
+// Touch records that the content of an entry was pulled, making it the last
+// to be evicted.
+func (ttles *TTLExpirationScheduler) Touch(ref reference.Canonical) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	if entry, ok := ttles.entries[ref.String()]; ok {
+		entry.LastAccess = time.Now()
+		ttles.indexDirty = true
+	}
+}
+
+// Pinned sets the function deciding which entries are never expired nor
+// evicted.
+func (ttles *TTLExpirationScheduler) Pinned(f pinnedFunc) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.pinned = f
+}
+
+// unpinned returns the entries which are not pinned. Checking the pins may
+// read the storage, so it must be called with the scheduler unlocked.
+func unpinned(pinned pinnedFunc, entries []*schedulerEntry) map[*schedulerEntry]bool {
+	evictable := make(map[*schedulerEntry]bool, len(entries))
+	for _, entry := range entries {
+		evictable[entry] = !isPinned(pinned, entry)
+	}
+	return evictable
 }
 
+func isPinned(pinned pinnedFunc, entry *schedulerEntry) bool {
+	if pinned == nil {
+		return false
+	}
+	ref, err := reference.Parse(entry.Key)
+	if err != nil {
+		return false
+	}
+	return pinned(ref)
+}
+
+// repositoryName returns the repository of a scheduler key.
+func repositoryName(key string) string {
+	if i := strings.LastIndex(key, "@"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// entriesByLastAccess sorts entries from the least recently pulled.
+type entriesByLastAccess []*schedulerEntry
+
+func (e entriesByLastAccess) Len() int           { return len(e) }
+func (e entriesByLastAccess) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByLastAccess) Less(i, j int) bool { return e[i].LastAccess.Before(e[j].LastAccess) }
+
 // OnBlobExpire is called when a scheduled blob's TTL expires
 func (ttles *TTLExpirationScheduler) OnBlobExpire(f expiryFunc) {
 	ttles.Lock()
@@ -80,8 +257,9 @@ func (ttles *TTLExpirationScheduler) OnManifestExpire(f expiryFunc) {
 	ttles.onManifestExpire = f
 }
 
-// AddBlob schedules a blob cleanup after ttl expires
-func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration) error {
+// AddBlob schedules a blob cleanup after ttl expires. size is the size of
+// the blob in bytes.
+func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration, size int64) error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
@@ -89,12 +267,13 @@ func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl ti
 		return fmt.Errorf("scheduler not started")
 	}
 
-	ttles.add(blobRef, ttl, entryTypeBlob)
+	ttles.add(blobRef, ttl, entryTypeBlob).Size = size
 	return nil
 }
 
-// AddManifest schedules a manifest cleanup after ttl expires
-func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical, ttl time.Duration) error {
+// AddManifest schedules a manifest cleanup after ttl expires. size is the
+// size of the manifest payload in bytes.
+func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical, ttl time.Duration, size int64) error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
@@ -102,7 +281,7 @@ func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical
 		return fmt.Errorf("scheduler not started")
 	}
 
-	ttles.add(manifestRef, ttl, entryTypeManifest)
+	ttles.add(manifestRef, ttl, entryTypeManifest).Size = size
 	return nil
 }
 
@@ -124,8 +303,10 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	ttles.stopped = false
 
 	// Start timer for each deserialized entry
-	for _, entry := range ttles.entries {
-		entry.timer = ttles.startTimer(entry, entry.Expiry.Sub(time.Now()))
+	if ttles.timers {
+		for _, entry := range ttles.entries {
+			entry.timer = ttles.startTimer(entry, entry.Expiry.Sub(time.Now()))
+		}
 	}
 
 	// Start a ticker to periodically save the entries index
@@ -156,51 +337,72 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	return nil
 }
 
-func (ttles *TTLExpirationScheduler) add(r reference.Reference, ttl time.Duration, eType int) {
+func (ttles *TTLExpirationScheduler) add(r reference.Reference, ttl time.Duration, eType int) *schedulerEntry {
+	now := time.Now()
 	entry := &schedulerEntry{
-		Key:       r.String(),
-		Expiry:    time.Now().Add(ttl),
-		EntryType: eType,
+		Key:        r.String(),
+		Expiry:     now.Add(ttl),
+		EntryType:  eType,
+		LastAccess: now,
 	}
 	context.GetLogger(ttles.ctx).Infof("Adding new scheduler entry for %s with ttl=%s", entry.Key, entry.Expiry.Sub(time.Now()))
 	if oldEntry, present := ttles.entries[entry.Key]; present && oldEntry.timer != nil {
 		oldEntry.timer.Stop()
 	}
 	ttles.entries[entry.Key] = entry
-	entry.timer = ttles.startTimer(entry, ttl)
+	if ttles.timers {
+		entry.timer = ttles.startTimer(entry, ttl)
+	}
 	ttles.indexDirty = true
+	return entry
 }
 
 func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.Duration) *time.Timer {
 	return time.AfterFunc(ttl, func() {
+		ttles.Lock()
+		pinned := ttles.pinned
+		ttles.Unlock()
+
+		if isPinned(pinned, entry) {
+			return
+		}
+
 		ttles.Lock()
 		defer ttles.Unlock()
 
-		var f expiryFunc
+		if ttles.entries[entry.Key] == entry {
+			ttles.expire(entry)
+		}
+	})
+}
 
-		switch entry.EntryType {
-		case entryTypeBlob:
-			f = ttles.onBlobExpire
-		case entryTypeManifest:
-			f = ttles.onManifestExpire
-		default:
-			f = func(reference.Reference) error {
-				return fmt.Errorf("scheduler entry type")
-			}
+// expire runs the expiry function of entry and removes it. It must be called
+// with the scheduler locked, once the entry is known not to be pinned.
+func (ttles *TTLExpirationScheduler) expire(entry *schedulerEntry) {
+	var f expiryFunc
+
+	switch entry.EntryType {
+	case entryTypeBlob:
+		f = ttles.onBlobExpire
+	case entryTypeManifest:
+		f = ttles.onManifestExpire
+	default:
+		f = func(reference.Reference) error {
+			return fmt.Errorf("scheduler entry type")
 		}
+	}
 
-		ref, err := reference.Parse(entry.Key)
-		if err == nil {
-			if err := f(ref); err != nil {
-				context.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
-			}
-		} else {
-			context.GetLogger(ttles.ctx).Errorf("Error unpacking reference: %s", err)
+	ref, err := reference.Parse(entry.Key)
+	if err == nil {
+		if err := f(ref); err != nil {
+			context.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
 		}
+	} else {
+		context.GetLogger(ttles.ctx).Errorf("Error unpacking reference: %s", err)
+	}
 
-		delete(ttles.entries, entry.Key)
-		ttles.indexDirty = true
-	})
+	delete(ttles.entries, entry.Key)
+	ttles.indexDirty = true
 }
 
 // Stop stops the scheduler.
@@ -213,7 +415,9 @@ func (ttles *TTLExpirationScheduler) Stop() {
 	}
 
 	for _, entry := range ttles.entries {
-		entry.timer.Stop()
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
 	}
 
 	close(ttles.doneChan)
@@ -73,6 +73,41 @@ func TestSchedule(t *testing.T) {
 	}
 }
 
+func TestExpireWithoutTimers(t *testing.T) {
+	ref1, ref2, _ := testRefs(t)
+	expired := map[string]bool{}
+
+	s := New(context.Background(), inmemory.New(), "/ttl")
+	s.onBlobExpire = func(ref reference.Reference) error {
+		expired[ref.String()] = true
+		return nil
+	}
+	s.DisableTimers()
+	err := s.Start()
+	if err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	defer s.Stop()
+
+	s.add(ref1, time.Millisecond, entryTypeBlob)
+	s.add(ref2, time.Hour, entryTypeBlob)
+
+	<-time.After(10 * time.Millisecond)
+	if len(expired) != 0 {
+		t.Fatalf("Entries expired without calling Expire: %#v", expired)
+	}
+
+	if err := s.Expire(); err != nil {
+		t.Fatalf("Error expiring entries: %s", err)
+	}
+	if len(expired) != 1 || !expired[ref1.String()] {
+		t.Fatalf("Unexpected expired entries: %#v", expired)
+	}
+	if _, ok := s.entries[ref2.String()]; !ok {
+		t.Fatalf("Unexpired entry removed")
+	}
+}
+
 func TestRestoreOld(t *testing.T) {
 	ref1, ref2, _ := testRefs(t)
 	remainingRepos := map[string]bool{
@@ -186,3 +221,106 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatalf("Scheduler started twice without error")
 	}
 }
+
+func TestEvict(t *testing.T) {
+	ref1, ref2, ref3 := testRefs(t)
+	other, err := reference.Parse("otherrepo@sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd")
+	if err != nil {
+		t.Fatalf("could not parse reference: %v", err)
+	}
+	evicted := map[string]bool{}
+
+	s := New(context.Background(), inmemory.New(), "/ttl")
+	s.onBlobExpire = func(ref reference.Reference) error {
+		evicted[ref.String()] = true
+		return nil
+	}
+	s.Pinned(func(ref reference.Reference) bool {
+		// pins are checked with the scheduler unlocked
+		s.Lock()
+		s.Unlock()
+		return ref.String() == ref3.String()
+	})
+	s.DisableTimers()
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	defer s.Stop()
+
+	// ref3 is pinned, ref1 is the least recently pulled.
+	now := time.Now()
+	for i, ref := range []reference.Reference{ref3, ref1, ref2, other} {
+		entry := s.add(ref, time.Hour, entryTypeBlob)
+		entry.Size = 10
+		entry.LastAccess = now.Add(time.Duration(i) * time.Minute)
+	}
+
+	if err := s.Evict(0, 0); err != nil {
+		t.Fatalf("Error evicting entries: %s", err)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("Entries evicted without limits: %#v", evicted)
+	}
+
+	if err := s.Evict(0, 20); err != nil {
+		t.Fatalf("Error evicting entries: %s", err)
+	}
+	if len(evicted) != 1 || !evicted[ref1.String()] {
+		t.Fatalf("Unexpected entries evicted for the repository limit: %#v", evicted)
+	}
+
+	if err := s.Evict(15, 0); err != nil {
+		t.Fatalf("Error evicting entries: %s", err)
+	}
+	if len(evicted) != 3 || !evicted[ref2.String()] || !evicted[other.String()] {
+		t.Fatalf("Unexpected entries evicted for the total limit: %#v", evicted)
+	}
+	if _, ok := s.entries[ref3.String()]; !ok {
+		t.Fatalf("Pinned entry evicted")
+	}
+
+	// Pinned entries do not expire either.
+	s.entries[ref3.String()].Expiry = now.Add(-time.Minute)
+	if err := s.Expire(); err != nil {
+		t.Fatalf("Error expiring entries: %s", err)
+	}
+	if _, ok := s.entries[ref3.String()]; !ok {
+		t.Fatalf("Pinned entry expired")
+	}
+}
+
+func TestTouchPersisted(t *testing.T) {
+	ref1, _, _ := testRefs(t)
+	fs := inmemory.New()
+
+	s := New(context.Background(), fs, "/ttl")
+	s.DisableTimers()
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	canonical := ref1.(reference.Canonical)
+	if err := s.AddBlob(canonical, time.Hour, 42); err != nil {
+		t.Fatalf("Error adding blob: %s", err)
+	}
+	s.entries[ref1.String()].LastAccess = time.Time{}
+	s.Touch(canonical)
+	lastAccess := s.entries[ref1.String()].LastAccess
+	if lastAccess.IsZero() {
+		t.Fatalf("Last access not updated")
+	}
+	s.Stop()
+
+	s2 := New(context.Background(), fs, "/ttl")
+	s2.DisableTimers()
+	if err := s2.Start(); err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	defer s2.Stop()
+	entry, ok := s2.entries[ref1.String()]
+	if !ok {
+		t.Fatalf("Entry not restored")
+	}
+	if entry.Size != 42 || !entry.LastAccess.Equal(lastAccess) {
+		t.Fatalf("Unexpected restored entry: %#v", entry)
+	}
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/transport"
+)
+
+// upstream is a remote registry mirrored for the repositories matching its
+// pattern.
+type upstream struct {
+	match   string
+	rewrite string
+
+	remoteURL      url.URL
+	transport      http.RoundTripper
+	breaker        *breaker
+	authChallenger authChallenger
+}
+
+// upstreamsFromConfig returns the upstreams of the configuration, in the
+// order they are tried.
+func upstreamsFromConfig(config configuration.Proxy) ([]*upstream, error) {
+	configs := append([]configuration.ProxyUpstream{}, config.Upstreams...)
+	if config.RemoteURL != "" {
+		configs = append(configs, configuration.ProxyUpstream{
+			RemoteURL: config.RemoteURL,
+			Username:  config.Username,
+			Password:  config.Password,
+		})
+	}
+
+	var upstreams []*upstream
+	for _, c := range configs {
+		u, err := newUpstream(c)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %v", c.RemoteURL, err)
+		}
+		if config.CircuitBreaker.Threshold > 0 {
+			u.breaker.threshold = config.CircuitBreaker.Threshold
+		}
+		if config.CircuitBreaker.Timeout > 0 {
+			u.breaker.timeout = config.CircuitBreaker.Timeout
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+func newUpstream(config configuration.ProxyUpstream) (*upstream, error) {
+	remoteURL, err := url.Parse(config.RemoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	match := config.Match
+	if match == "*" {
+		match = ""
+	}
+	if strings.Contains(strings.TrimSuffix(match, "/*"), "*") {
+		return nil, fmt.Errorf("invalid match pattern %q", config.Match)
+	}
+	if config.Rewrite != "" && config.Rewrite != "*" && !strings.HasSuffix(config.Rewrite, "/*") {
+		return nil, fmt.Errorf("invalid rewrite pattern %q", config.Rewrite)
+	}
+	if config.Rewrite != "" && !strings.HasSuffix(match, "/*") {
+		return nil, fmt.Errorf("rewrite requires a prefix match pattern")
+	}
+
+	base, err := upstreamTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	b := newBreaker()
+	transport := &breakerTransport{base: base, breaker: b}
+
+	cs, err := configureAuth(*remoteURL, config.Username, config.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upstream{
+		match:     match,
+		rewrite:   config.Rewrite,
+		remoteURL: *remoteURL,
+		transport: transport,
+		breaker:   b,
+		authChallenger: &remoteAuthChallenger{
+			remoteURL: *remoteURL,
+			transport: transport,
+			cm:        auth.NewSimpleChallengeManager(),
+			cs:        cs,
+		},
+	}, nil
+}
+
+// upstreamTransport returns the transport of the connections to the
+// upstream, with its TLS settings.
+func upstreamTransport(config configuration.ProxyUpstream) (http.RoundTripper, error) {
+	tlsConf := config.TLS
+	if tlsConf.CA == "" && tlsConf.Certificate == "" && !tlsConf.Insecure {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: tlsConf.Insecure,
+	}
+
+	if tlsConf.CA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(tlsConf.CA)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("could not load CA certificates from %s", tlsConf.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if tlsConf.Certificate != "" {
+		cert, err := tls.LoadX509KeyPair(tlsConf.Certificate, tlsConf.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}, nil
+}
+
+// prefix returns the prefix of a "prefix/*" pattern.
+func prefix(pattern string) string {
+	return strings.TrimSuffix(pattern, "*")
+}
+
+// matchName returns true if the named repository matches pattern, which is
+// empty to match every repository, a "prefix/*" pattern or a repository name.
+func matchName(pattern, name string) bool {
+	switch {
+	case pattern == "":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(name, prefix(pattern))
+	default:
+		return name == pattern
+	}
+}
+
+// matches returns true if the named repository is mirrored from the
+// upstream.
+func (u *upstream) matches(name string) bool {
+	return matchName(u.match, name)
+}
+
+// remoteName returns the name of the repository on the upstream.
+func (u *upstream) remoteName(name reference.Named) (reference.Named, error) {
+	if u.rewrite == "" {
+		return name, nil
+	}
+	return reference.WithName(prefix(u.rewrite) + strings.TrimPrefix(name.Name(), prefix(u.match)))
+}
+
+// repository returns the repository on the upstream mirrored as name.
+func (u *upstream) repository(ctx context.Context, name reference.Named) (remote, error) {
+	remoteName, err := u.remoteName(name)
+	if err != nil {
+		return remote{}, err
+	}
+
+	c := u.authChallenger
+	tr := transport.NewTransport(u.transport,
+		auth.NewAuthorizer(c.challengeManager(), auth.NewTokenHandler(u.transport, c.credentialStore(), remoteName.Name(), "pull")))
+
+	remoteRepo, err := client.NewRepository(ctx, remoteName, u.remoteURL.String(), tr)
+	if err != nil {
+		return remote{}, err
+	}
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		return remote{}, err
+	}
+
+	return remote{
+		authChallenger: c,
+		blobs:          remoteRepo.Blobs(ctx),
+		manifests:      remoteManifests,
+		tags:           remoteRepo.Tags(ctx),
+	}, nil
+}
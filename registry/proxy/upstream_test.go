@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/cache/memory"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestUpstreamMatch(t *testing.T) {
+	for _, testcase := range []struct {
+		match, rewrite string
+		name           string
+		matches        bool
+		remoteName     string
+	}{
+		{match: "", name: "foo/bar", matches: true, remoteName: "foo/bar"},
+		{match: "*", name: "foo", matches: true, remoteName: "foo"},
+		{match: "library/*", name: "library/ubuntu", matches: true, remoteName: "library/ubuntu"},
+		{match: "library/*", name: "libraryx/ubuntu", matches: false},
+		{match: "library/*", name: "local/ubuntu", matches: false},
+		{match: "team/app", name: "team/app", matches: true, remoteName: "team/app"},
+		{match: "team/app", name: "team/app2", matches: false},
+		{match: "gcr/*", rewrite: "*", name: "gcr/pause", matches: true, remoteName: "pause"},
+		{match: "gcr/*", rewrite: "google-containers/*", name: "gcr/a/b", matches: true, remoteName: "google-containers/a/b"},
+	} {
+		u, err := newUpstream(configuration.ProxyUpstream{
+			RemoteURL: "https://upstream.example.com",
+			Match:     testcase.match,
+			Rewrite:   testcase.rewrite,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating upstream %q: %v", testcase.match, err)
+		}
+		if u.matches(testcase.name) != testcase.matches {
+			t.Fatalf("expected %q matching %q to be %v", testcase.match, testcase.name, testcase.matches)
+		}
+		if !testcase.matches {
+			continue
+		}
+		name, _ := reference.ParseNamed(testcase.name)
+		remoteName, err := u.remoteName(name)
+		if err != nil {
+			t.Fatalf("unexpected error rewriting %q: %v", testcase.name, err)
+		}
+		if remoteName.Name() != testcase.remoteName {
+			t.Fatalf("expected %q to be mirrored from %q, got %q", testcase.name, testcase.remoteName, remoteName.Name())
+		}
+	}
+
+	for _, invalid := range []configuration.ProxyUpstream{
+		{Match: "lib*/x"},
+		{Match: "library/*", Rewrite: "other"},
+		{Match: "library/ubuntu", Rewrite: "*"},
+	} {
+		if _, err := newUpstream(invalid); err == nil {
+			t.Fatalf("expected an error creating upstream %#v", invalid)
+		}
+	}
+}
+
+func TestUpstreamRouting(t *testing.T) {
+	ctx := context.Background()
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New(), storage.BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider()))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	ns, err := NewRegistryPullThroughCache(ctx, localRegistry, inmemory.New(), configuration.Proxy{
+		Upstreams: []configuration.ProxyUpstream{
+			{RemoteURL: "https://hub.example.com", Match: "library/*"},
+			{RemoteURL: "https://gcr.example.com", Match: "gcr/*", Rewrite: "*"},
+			{RemoteURL: "https://mirror.example.com", Match: "library/*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+
+	repository := func(name string) distribution.Repository {
+		named, _ := reference.ParseNamed(name)
+		repo, err := ns.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository %s: %v", name, err)
+		}
+		return repo
+	}
+
+	if _, ok := repository("local/app").(*proxiedRepository); ok {
+		t.Fatalf("expected a repository which is not mirrored to be hosted locally")
+	}
+
+	proxied, ok := repository("gcr/pause").(*proxiedRepository)
+	if !ok {
+		t.Fatalf("expected gcr/pause to be mirrored")
+	}
+	if _, ok := proxied.tags.(*proxyTagService).remoteTags.(*fallbackTags); ok {
+		t.Fatalf("expected gcr/pause to be mirrored from a single upstream")
+	}
+
+	proxied, ok = repository("library/ubuntu").(*proxiedRepository)
+	if !ok {
+		t.Fatalf("expected library/ubuntu to be mirrored")
+	}
+	fallback, ok := proxied.tags.(*proxyTagService).remoteTags.(*fallbackTags)
+	if !ok || len(fallback.remotes) != 2 {
+		t.Fatalf("expected library/ubuntu to be mirrored from two upstreams")
+	}
+}
+
+func TestUpstreamFallback(t *testing.T) {
+	ctx := context.Background()
+	first := &mockChallenger{}
+	second := &mockChallenger{}
+	rs := remotes{
+		{
+			authChallenger: first,
+			tags: &mockTagStore{mapping: map[string]distribution.Descriptor{
+				"first": {Size: 1},
+			}},
+		},
+		{
+			authChallenger: second,
+			tags: &mockTagStore{mapping: map[string]distribution.Descriptor{
+				"first":  {Size: 2},
+				"second": {Size: 3},
+			}},
+		},
+	}
+	tags := rs.fallback().tags
+
+	desc, err := tags.Get(ctx, "first")
+	if err != nil || desc.Size != 1 {
+		t.Fatalf("expected the first upstream to be used: %v, %v", desc, err)
+	}
+	if second.count != 0 {
+		t.Fatalf("second upstream contacted although the first had the tag")
+	}
+
+	desc, err = tags.Get(ctx, "second")
+	if err != nil || desc.Size != 3 {
+		t.Fatalf("expected to fall back to the second upstream: %v, %v", desc, err)
+	}
+
+	if _, err := tags.Get(ctx, "unknown"); err == nil {
+		t.Fatalf("expected an error getting an unknown tag")
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("unexpected error getting an unknown tag: %v", err)
+	}
+}
+
+func TestPinned(t *testing.T) {
+	ctx := context.Background()
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New(), storage.BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider()))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	nameRef, _ := reference.ParseNamed("library/app")
+	repo, err := localRegistry.Repository(ctx, nameRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	layer, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeLayer, []byte("layer"))
+	if err != nil {
+		t.Fatalf("unexpected error putting layer: %v", err)
+	}
+	builder := schema2.NewManifestBuilder(repo.Blobs(ctx), []byte("{}"))
+	if err := builder.AppendReference(layer); err != nil {
+		t.Fatalf("unexpected error appending layer: %v", err)
+	}
+	m, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest: %v", err)
+	}
+	manifests, _ := repo.Manifests(ctx)
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "stable", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("unexpected error tagging: %v", err)
+	}
+
+	ns, err := NewRegistryPullThroughCache(ctx, localRegistry, inmemory.New(), configuration.Proxy{
+		RemoteURL: "https://hub.example.com",
+		Pinned:    []string{"team/*", "library/app:stable"},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+	pr := ns.(*proxyingRegistry)
+
+	canonical := func(name string, dgst digest.Digest) reference.Canonical {
+		named, _ := reference.ParseNamed(name)
+		ref, _ := reference.WithDigest(named, dgst)
+		return ref
+	}
+	other := digest.Digest("sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd")
+
+	for _, testcase := range []struct {
+		ref    reference.Canonical
+		pinned bool
+	}{
+		{canonical("team/app", other), true},
+		{canonical("library/app", dgst), true},
+		{canonical("library/app", layer.Digest), true},
+		{canonical("library/app", other), false},
+		{canonical("library/other", dgst), false},
+	} {
+		if pr.pinned(testcase.ref) != testcase.pinned {
+			t.Fatalf("expected %s pinned to be %v", testcase.ref, testcase.pinned)
+		}
+	}
+
+	if _, err := pinsFromConfig([]string{"library/*:latest"}); err == nil {
+		t.Fatalf("expected an error pinning a tag of a pattern")
+	}
+}
+
+func TestCredentialsRealms(t *testing.T) {
+	remoteURL, _ := url.Parse("https://registry.example.com")
+	cs, err := configureAuth(*remoteURL, "user", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error configuring auth: %v", err)
+	}
+
+	realm, _ := url.Parse("https://auth.example.com/token")
+	if username, _ := cs.Basic(realm); username != "" {
+		t.Fatalf("credentials presented to a realm before the challenges are known")
+	}
+	if username, password := cs.Basic(remoteURL); username != "user" || password != "secret" {
+		t.Fatalf("unexpected credentials for the upstream: %s:%s", username, password)
+	}
+
+	cs.addRealms([]auth.Challenge{
+		{Scheme: "bearer", Parameters: map[string]string{"realm": realm.String(), "service": "registry"}},
+	})
+	if username, password := cs.Basic(realm); username != "user" || password != "secret" {
+		t.Fatalf("unexpected credentials for the realm: %s:%s", username, password)
+	}
+	other, _ := url.Parse("https://elsewhere.example.com/token")
+	if username, _ := cs.Basic(other); username != "" {
+		t.Fatalf("credentials presented to a host outside the challenges")
+	}
+}
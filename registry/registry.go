@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"rsc.io/letsencrypt"
@@ -57,6 +60,13 @@ var ServeCmd = &cobra.Command{
 			log.Fatalln(err)
 		}
 
+		if config.HTTP.Debug.Addr != "" {
+			http.Handle("/debug/gc", registry.app.GarbageCollectionHandler())
+			http.Handle("/debug/jobs", registry.app.JobsHandler())
+			http.Handle("/debug/replication", registry.app.ReplicationHandler())
+			http.Handle("/debug/retention", registry.app.RetentionHandler())
+		}
+
 		if err = registry.ListenAndServe(); err != nil {
 			log.Fatalln(err)
 		}
@@ -87,7 +97,19 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	// TODO(aaronl): The global scope of the health checks means NewRegistry
 	// can only be called once per process.
 	app.RegisterHealthChecks()
+	app.RegisterReadinessChecks()
 	handler := configureReporting(app)
+	if config.TokenServer.Mount {
+		prefix := tokenServerPrefix(config)
+		if prefix == "/v2" || strings.HasPrefix(prefix, "/v2/") {
+			return nil, fmt.Errorf("token server prefix %q conflicts with the registry api", prefix)
+		}
+		ts, err := newTokenServer(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		handler = mountTokenServer(prefix, ts, handler)
+	}
 	handler = alive("/", handler)
 	handler = health.Handler(handler)
 	handler = panicHandler(handler)
@@ -181,7 +203,27 @@ func (registry *Registry) ListenAndServe() error {
 		context.GetLogger(registry.app).Infof("listening on %v", ln.Addr())
 	}
 
-	return registry.server.Serve(ln)
+	// Stop serving on SIGINT or SIGTERM, once the log hooks have delivered
+	// their queued entries.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- registry.server.Serve(ln)
+	}()
+
+	select {
+	case err := <-served:
+		registry.app.Shutdown()
+		return err
+	case sig := <-quit:
+		context.GetLogger(registry.app).Infof("stopping on %v", sig)
+		err := registry.server.Close()
+		registry.app.Shutdown()
+		return err
+	}
 }
 
 func configureReporting(app *handlers.App) http.Handler {
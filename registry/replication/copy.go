@@ -0,0 +1,146 @@
+package replication
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+)
+
+// permanentError is returned for the tasks which can never be replicated,
+// such as manifests deleted since they were pushed, or copies the target
+// rejects.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// replicate copies the manifest of a task, and everything it references,
+// from registry to the target.
+func replicate(ctx context.Context, registry distribution.Namespace, t *target, tk task) error {
+	name, err := reference.ParseNamed(tk.Repository)
+	if err != nil {
+		return permanentError{err}
+	}
+	local, err := registry.Repository(ctx, name)
+	if err != nil {
+		return err
+	}
+	remote, err := t.repository(ctx, name)
+	if err == nil {
+		err = copyManifest(ctx, local, remote, tk.Digest, tk.Tag)
+	}
+	if rejected(err) {
+		return permanentError{err}
+	}
+	return err
+}
+
+// rejected returns true if the error answers a request the target will never
+// accept: client errors are not retried, except for rate limiting.
+func rejected(err error) bool {
+	status := httpStatus(err)
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
+
+// httpStatus returns the HTTP status of an error returned by the target, or
+// zero if the error does not come from an HTTP response.
+func httpStatus(err error) int {
+	switch err := err.(type) {
+	case errcode.Errors:
+		if len(err) > 0 {
+			return httpStatus(err[0])
+		}
+	case errcode.Error:
+		return err.Code.Descriptor().HTTPStatusCode
+	case errcode.ErrorCode:
+		return err.Descriptor().HTTPStatusCode
+	case *client.UnexpectedHTTPResponseError:
+		return err.StatusCode
+	}
+	return 0
+}
+
+// copyManifest copies a manifest, after the manifests and blobs it
+// references, tagging it on the remote repository if tag is set.
+func copyManifest(ctx context.Context, local, remote distribution.Repository, dgst digest.Digest, tag string) error {
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := localManifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return permanentError{err}
+		}
+		return err
+	}
+
+	_, isList := m.(*manifestlist.DeserializedManifestList)
+	for _, desc := range m.References() {
+		if isList {
+			err = copyManifest(ctx, local, remote, desc.Digest, "")
+		} else {
+			err = copyBlob(ctx, local, remote, desc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	remoteManifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	var options []distribution.ManifestServiceOption
+	if tag != "" {
+		options = append(options, distribution.WithTag(tag))
+	}
+	_, err = remoteManifests.Put(ctx, m, options...)
+	return err
+}
+
+// copyBlob copies a blob the remote repository does not have yet.
+func copyBlob(ctx context.Context, local, remote distribution.Repository, desc distribution.Descriptor) error {
+	if desc.MediaType == schema2.MediaTypeForeignLayer {
+		// Foreign layers are not stored by registries.
+		return nil
+	}
+
+	remoteBlobs := remote.Blobs(ctx)
+	if _, err := remoteBlobs.Stat(ctx, desc.Digest); err == nil {
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	rc, err := local.Blobs(ctx).Open(ctx, desc.Digest)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return permanentError{err}
+		}
+		return err
+	}
+	defer rc.Close()
+
+	bw, err := remoteBlobs.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, rc); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	_, err = bw.Commit(ctx, desc)
+	return err
+}
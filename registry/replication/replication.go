@@ -0,0 +1,451 @@
+// Package replication copies the manifests pushed to the registry, and the
+// blobs they reference, to other registries.
+//
+// A Replicator is a notifications sink: every manifest push event matching
+// the policy of a target is recorded in a persistent queue in the storage
+// driver, which a worker per target drains in order, retrying the failed
+// copies until they succeed. Copies the target rejects, or which can never
+// succeed, are moved to the dead letters of the target instead. When several
+// registries share the storage, a lock elects the instance draining the queue
+// of each target.
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/registry/jobs"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/uuid"
+)
+
+const (
+	// defaultThreshold and defaultBackoff are the defaults of the endpoints
+	// of notifications.
+	defaultThreshold = 10
+	defaultBackoff   = time.Second
+
+	// pollInterval is the time between the listings of an empty queue, which
+	// other instances sharing the storage may write to, and between the
+	// attempts to take the lock of a target held by another instance.
+	pollInterval = 10 * time.Second
+
+	// lockTTL is the lifetime of the lock of a target, which is renewed
+	// while its queue is drained.
+	lockTTL = time.Minute
+
+	// deadLettersDir is the directory of the dead letters of the targets,
+	// under the root of the queues.
+	deadLettersDir = "_deadletters"
+)
+
+// task is a manifest waiting in the queue of a target.
+type task struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	Tag        string        `json:"tag,omitempty"`
+	EnqueuedAt time.Time     `json:"enqueuedAt"`
+}
+
+// deadLetter is a task which was not replicated, with the reason why.
+type deadLetter struct {
+	task
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// Status describes the replication to a target.
+type Status struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Pending is the number of manifests waiting to be replicated, and Lag
+	// the time the oldest of them has been waiting for.
+	Pending int           `json:"pending"`
+	Lag     time.Duration `json:"lag"`
+
+	Replicated  int64     `json:"replicated"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+
+	// DeadLettered is the number of tasks moved to the dead letters by this
+	// instance.
+	DeadLettered int64 `json:"deadLettered"`
+
+	// Failures is the number of consecutive failed attempts.
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Replicator replicates the manifests pushed to the registry to the targets
+// of its policies.
+type Replicator struct {
+	ctx     context.Context
+	driver  driver.StorageDriver
+	root    string
+	targets []*target
+
+	mu       sync.Mutex
+	registry distribution.Namespace
+	locker   jobs.Locker
+	sequence int64
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ notifications.Sink = &Replicator{}
+
+// New returns a replicator to the targets of policies, keeping its queues
+// under root in the storage driver. Events are queued as soon as it is
+// created, and replicated once it is started.
+func New(ctx context.Context, d driver.StorageDriver, root string, policies []configuration.ReplicationPolicy) (*Replicator, error) {
+	r := &Replicator{
+		ctx:    ctx,
+		driver: d,
+		root:   root,
+		stop:   make(chan struct{}),
+	}
+	for _, policy := range policies {
+		if policy.Disabled {
+			context.GetLogger(ctx).Infof("replication to %s disabled, skipping", policy.Name)
+			continue
+		}
+		t, err := newTarget(policy)
+		if err != nil {
+			return nil, fmt.Errorf("replication target %s: %v", policy.Name, err)
+		}
+		r.targets = append(r.targets, t)
+	}
+	return r, nil
+}
+
+// Start starts replicating the queued manifests, reading them from
+// registry. The queue of each target is drained by the instance holding its
+// lock in locker; without a locker, every instance drains every queue.
+func (r *Replicator) Start(registry distribution.Namespace, locker jobs.Locker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.registry = registry
+	r.locker = locker
+	for _, t := range r.targets {
+		r.wg.Add(1)
+		go r.run(t)
+	}
+}
+
+// Sink returns a sink queuing the events in memory and writing them to the
+// replicator in the background, retrying until they are persisted, so that
+// the broadcaster of the events never waits for the storage driver.
+func (r *Replicator) Sink() notifications.Sink {
+	return notifications.NewRetryingQueue(r, defaultThreshold, defaultBackoff)
+}
+
+// Write queues the manifest pushes among events for the targets whose
+// policy matches them, in the storage driver.
+func (r *Replicator) Write(events ...notifications.Event) error {
+	for _, event := range events {
+		if event.Action != notifications.EventActionPush || !isManifest(event.Target.MediaType) {
+			continue
+		}
+		tk := task{
+			Repository: event.Target.Repository,
+			Digest:     event.Target.Digest,
+			Tag:        event.Target.Tag,
+			EnqueuedAt: time.Now(),
+		}
+		for _, t := range r.targets {
+			if !t.matches(tk.Repository, tk.Tag) {
+				continue
+			}
+			if err := r.enqueue(t, tk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the replication. The queued manifests are replicated once a
+// replicator using the same storage is started.
+func (r *Replicator) Close() error {
+	select {
+	case <-r.stop:
+		return fmt.Errorf("replicator: already closed")
+	default:
+	}
+	close(r.stop)
+	r.wg.Wait()
+	return nil
+}
+
+// Status returns the status of the replication to each target.
+func (r *Replicator) Status() []Status {
+	var statuses []Status
+	for _, t := range r.targets {
+		statuses = append(statuses, t.Status())
+	}
+	return statuses
+}
+
+// ServeHTTP serves the status of the replication to each target.
+func (r *Replicator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(r.Status()); err != nil {
+		context.GetLogger(r.ctx).Errorf("error encoding replication status: %v", err)
+	}
+}
+
+func isManifest(mediaType string) bool {
+	switch mediaType {
+	case schema1.MediaTypeManifest, schema1.MediaTypeSignedManifest,
+		schema2.MediaTypeManifest, manifestlist.MediaTypeManifestList:
+		return true
+	default:
+		return false
+	}
+}
+
+// queue returns the directory of the queue of a target.
+func (r *Replicator) queue(t *target) string {
+	return path.Join(r.root, t.policy.Name)
+}
+
+// nextSequence returns the time a task is queued at in nanoseconds, made
+// unique for the tasks queued by this instance.
+func (r *Replicator) nextSequence(enqueuedAt time.Time) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sequence++
+	if n := enqueuedAt.UnixNano(); n > r.sequence {
+		r.sequence = n
+	}
+	return r.sequence
+}
+
+// enqueue writes the task in the queue of a target. Task files are named
+// after the time they were queued, so listing them returns the queue in
+// order.
+func (r *Replicator) enqueue(t *target, tk task) error {
+	content, err := json.Marshal(tk)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%s.json", r.nextSequence(tk.EnqueuedAt), uuid.Generate())
+	if err := r.driver.PutContent(r.ctx, path.Join(r.queue(t), name), content); err != nil {
+		return err
+	}
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deadLetters returns the directory of the dead letters of a target.
+func (r *Replicator) deadLetters(t *target) string {
+	return path.Join(r.root, deadLettersDir, t.policy.Name)
+}
+
+// lockName returns the name of the lock of a target.
+func lockName(t *target) string {
+	return "replication-" + t.policy.Name
+}
+
+// acquire takes or renews the lock of a target, and reports whether this
+// instance may drain its queue.
+func (r *Replicator) acquire(t *target) bool {
+	r.mu.Lock()
+	locker := r.locker
+	r.mu.Unlock()
+	if locker == nil {
+		return true
+	}
+
+	held, err := locker.Acquire(r.ctx, lockName(t), lockTTL)
+	if err != nil {
+		context.GetLogger(r.ctx).Errorf("replication to %s: error taking lock: %v", t.policy.Name, err)
+		return false
+	}
+	return held
+}
+
+// hold renews the lock of a target until the returned function is called,
+// so that a long copy keeps it.
+func (r *Replicator) hold(t *target) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.acquire(t)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// release gives up the lock of a target.
+func (r *Replicator) release(t *target) {
+	r.mu.Lock()
+	locker := r.locker
+	r.mu.Unlock()
+	if locker == nil {
+		return
+	}
+
+	if err := locker.Release(r.ctx, lockName(t)); err != nil {
+		context.GetLogger(r.ctx).Errorf("replication to %s: error releasing lock: %v", t.policy.Name, err)
+	}
+}
+
+// pending returns the paths of the tasks queued for a target, oldest first.
+func (r *Replicator) pending(t *target) ([]string, error) {
+	paths, err := r.driver.List(r.ctx, r.queue(t))
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// run drains the queue of a target until the replicator is closed.
+func (r *Replicator) run(t *target) {
+	defer r.wg.Done()
+	defer r.release(t)
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		if !r.acquire(t) {
+			// drained by another instance
+			t.idle()
+			select {
+			case <-time.After(pollInterval):
+				continue
+			case <-r.stop:
+				return
+			}
+		}
+
+		paths, err := r.pending(t)
+		if err != nil {
+			context.GetLogger(r.ctx).Errorf("replication to %s: error listing queue: %v", t.policy.Name, err)
+		}
+		if len(paths) == 0 {
+			t.idle()
+			select {
+			case <-t.wake:
+				continue
+			case <-time.After(pollInterval):
+				continue
+			case <-r.stop:
+				return
+			}
+		}
+
+		release := r.hold(t)
+		err = r.process(t, paths[0], len(paths))
+		release()
+		if err == nil {
+			continue
+		}
+
+		context.GetLogger(r.ctx).Errorf("replication to %s: %v", t.policy.Name, err)
+		if !t.proceed() {
+			select {
+			case <-time.After(t.policy.Backoff):
+			case <-r.stop:
+				return
+			}
+		}
+	}
+}
+
+// process replicates the task at p, removing it from the queue once it is
+// replicated, or moving it to the dead letters if it can never be.
+func (r *Replicator) process(t *target, p string, pending int) error {
+	content, err := r.driver.GetContent(r.ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			// Processed by another instance sharing the storage.
+			return nil
+		}
+		return err
+	}
+
+	var tk task
+	if err := json.Unmarshal(content, &tk); err != nil {
+		context.GetLogger(r.ctx).Errorf("replication to %s: removing invalid task %s: %v", t.policy.Name, p, err)
+		return r.driver.Delete(r.ctx, p)
+	}
+	t.queued(pending, tk.EnqueuedAt)
+
+	r.mu.Lock()
+	registry := r.registry
+	r.mu.Unlock()
+
+	err = replicate(r.ctx, registry, t, tk)
+	switch err := err.(type) {
+	case nil:
+		context.GetLogger(r.ctx).Infof("replicated %s@%s to %s", tk.Repository, tk.Digest, t.policy.Name)
+		t.success()
+	case permanentError:
+		context.GetLogger(r.ctx).Errorf("replication to %s: dead lettering %s@%s: %v", t.policy.Name, tk.Repository, tk.Digest, err)
+		if err := r.deadLetter(t, p, tk, err); err != nil {
+			return err
+		}
+		t.failure(err)
+		t.deadLettered()
+	default:
+		t.failure(err)
+		return err
+	}
+	return r.driver.Delete(r.ctx, p)
+}
+
+// deadLetter writes the task at p in the dead letters of the target, along
+// with the error which prevents its replication.
+func (r *Replicator) deadLetter(t *target, p string, tk task, cause error) error {
+	content, err := json.Marshal(deadLetter{
+		task:     tk,
+		Error:    cause.Error(),
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return r.driver.PutContent(r.ctx, path.Join(r.deadLetters(t), path.Base(p)), content)
+}
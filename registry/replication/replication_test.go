@@ -0,0 +1,197 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/jobs"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func pushEvent(repository, tag, mediaType string) notifications.Event {
+	var event notifications.Event
+	event.Action = notifications.EventActionPush
+	event.Target.Repository = repository
+	event.Target.Tag = tag
+	event.Target.MediaType = mediaType
+	event.Target.Digest = "sha256:aaaaeaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	return event
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	r, err := New(ctx, d, "/replication", []configuration.ReplicationPolicy{
+		{Name: "all", URL: "https://all.example.com"},
+		{Name: "releases", URL: "https://releases.example.com", Repositories: []string{"team/*"}, Tags: []string{"v*"}},
+		{Name: "disabled", URL: "https://disabled.example.com", Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	if len(r.targets) != 2 {
+		t.Fatalf("expected disabled targets to be skipped, got %d targets", len(r.targets))
+	}
+
+	err = r.Write(
+		pushEvent("team/app", "v1", schema2.MediaTypeManifest),
+		pushEvent("team/app", "latest", schema2.MediaTypeManifest),
+		pushEvent("team/app", "", schema2.MediaTypeManifest),
+		pushEvent("team/app/sub", "v1", schema2.MediaTypeManifest),
+		pushEvent("team/app", "", schema2.MediaTypeLayer),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		target *target
+		tags   []string
+	}{
+		{r.targets[0], []string{"v1", "latest", "", "v1"}},
+		{r.targets[1], []string{"v1"}},
+	} {
+		paths, err := r.pending(testcase.target)
+		if err != nil {
+			t.Fatalf("unexpected error listing queue: %v", err)
+		}
+		if len(paths) != len(testcase.tags) {
+			t.Fatalf("expected %d tasks queued for %s, got %d", len(testcase.tags), testcase.target.policy.Name, len(paths))
+		}
+		for i, p := range paths {
+			content, err := d.GetContent(ctx, p)
+			if err != nil {
+				t.Fatalf("unexpected error reading task: %v", err)
+			}
+			var tk task
+			if err := json.Unmarshal(content, &tk); err != nil {
+				t.Fatalf("unexpected error decoding task: %v", err)
+			}
+			if tk.Tag != testcase.tags[i] {
+				t.Fatalf("expected task %d of %s to be tag %q, got %q", i, testcase.target.policy.Name, testcase.tags[i], tk.Tag)
+			}
+		}
+	}
+
+	// The queue is kept by the storage driver.
+	r2, err := New(ctx, d, "/replication", []configuration.ReplicationPolicy{
+		{Name: "releases", URL: "https://releases.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	if paths, _ := r2.pending(r2.targets[0]); len(paths) != 1 {
+		t.Fatalf("expected the queue to survive the replicator, got %d tasks", len(paths))
+	}
+
+	if _, err := New(ctx, d, "/replication", []configuration.ReplicationPolicy{
+		{Name: "invalid", URL: "https://example.com", Tags: []string{"["}},
+	}); err == nil {
+		t.Fatalf("expected an error creating a replicator with an invalid pattern")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer remote.Close()
+
+	r, err := New(ctx, d, "/replication", []configuration.ReplicationPolicy{
+		{Name: "remote", URL: remote.URL},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	r.registry = registry
+	tg := r.targets[0]
+
+	// the manifest of the event was never pushed, so it can never be
+	// replicated
+	if err := r.Write(pushEvent("team/app", "v1", schema2.MediaTypeManifest)); err != nil {
+		t.Fatalf("unexpected error writing events: %v", err)
+	}
+	paths, err := r.pending(tg)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("unexpected queue: %v %v", paths, err)
+	}
+	if err := r.process(tg, paths[0], len(paths)); err != nil {
+		t.Fatalf("unexpected error processing task: %v", err)
+	}
+
+	if paths, _ := r.pending(tg); len(paths) != 0 {
+		t.Fatalf("expected the task to leave the queue, got %v", paths)
+	}
+	letters, err := d.List(ctx, r.deadLetters(tg))
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("unexpected dead letters: %v %v", letters, err)
+	}
+	content, err := d.GetContent(ctx, letters[0])
+	if err != nil {
+		t.Fatalf("unexpected error reading dead letter: %v", err)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(content, &letter); err != nil {
+		t.Fatalf("unexpected error decoding dead letter: %v", err)
+	}
+	if letter.Repository != "team/app" || letter.Tag != "v1" || letter.Error == "" {
+		t.Fatalf("unexpected dead letter: %#v", letter)
+	}
+	if status := tg.Status(); status.DeadLettered != 1 || status.Failures != 0 {
+		t.Fatalf("unexpected status: %#v", status)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	for _, testcase := range []struct {
+		err      error
+		rejected bool
+	}{
+		{errcode.Errors{errcode.ErrorCodeDenied.WithDetail(nil)}, true},
+		{errcode.ErrorCodeUnauthorized, true},
+		{&client.UnexpectedHTTPResponseError{StatusCode: http.StatusBadRequest}, true},
+		{errcode.ErrorCodeTooManyRequests, false},
+		{errcode.ErrorCodeUnknown, false},
+		{&client.UnexpectedHTTPStatusError{Status: "502 Bad Gateway"}, false},
+		{errors.New("connection refused"), false},
+	} {
+		if rejected(testcase.err) != testcase.rejected {
+			t.Errorf("expected rejected(%v) to be %v", testcase.err, testcase.rejected)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	policies := []configuration.ReplicationPolicy{{Name: "all", URL: "https://all.example.com"}}
+	first, _ := New(ctx, d, "/replication", policies)
+	second, _ := New(ctx, d, "/replication", policies)
+	first.locker = jobs.NewDriverLocker(d, "/locks", "first")
+	second.locker = jobs.NewDriverLocker(d, "/locks", "second")
+
+	if !first.acquire(first.targets[0]) {
+		t.Fatalf("expected the first instance to take the lock")
+	}
+	if second.acquire(second.targets[0]) {
+		t.Fatalf("expected the lock to be held by the first instance")
+	}
+	first.release(first.targets[0])
+	if !second.acquire(second.targets[0]) {
+		t.Fatalf("expected the second instance to take the released lock")
+	}
+}
@@ -0,0 +1,242 @@
+package replication
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/transport"
+)
+
+// target is a registry manifests are replicated to.
+type target struct {
+	policy    configuration.ReplicationPolicy
+	transport http.RoundTripper
+	cm        auth.ChallengeManager
+	cs        auth.CredentialStore
+
+	// wake is signaled when a task is queued.
+	wake chan struct{}
+
+	mu       sync.Mutex
+	status   Status
+	oldest   time.Time
+	lastFail time.Time
+}
+
+func newTarget(policy configuration.ReplicationPolicy) (*target, error) {
+	if policy.Name == "" {
+		return nil, fmt.Errorf("a name is required")
+	}
+	if strings.HasPrefix(policy.Name, "_") || strings.Contains(policy.Name, "/") {
+		return nil, fmt.Errorf("invalid name %q", policy.Name)
+	}
+	u, err := url.Parse(policy.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", policy.URL)
+	}
+	for _, pattern := range append(append([]string{}, policy.Repositories...), policy.Tags...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if policy.Threshold <= 0 {
+		policy.Threshold = defaultThreshold
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultBackoff
+	}
+
+	tr := http.DefaultTransport
+	if policy.Timeout > 0 {
+		tr = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   policy.Timeout,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout:   policy.Timeout,
+			ResponseHeaderTimeout: policy.Timeout,
+		}
+	}
+
+	return &target{
+		policy:    policy,
+		transport: tr,
+		cm:        auth.NewSimpleChallengeManager(),
+		cs: credentials{
+			username: policy.Username,
+			password: policy.Password,
+		},
+		wake: make(chan struct{}, 1),
+		status: Status{
+			Name: policy.Name,
+			URL:  policy.URL,
+		},
+	}, nil
+}
+
+// matches returns true if the push of tag to the named repository is
+// replicated to the target.
+func (t *target) matches(name, tag string) bool {
+	if !matchAny(t.policy.Repositories, name) {
+		return false
+	}
+	if len(t.policy.Tags) == 0 {
+		return true
+	}
+	return tag != "" && matchAny(t.policy.Tags, tag)
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// repository returns the named repository on the target.
+func (t *target) repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	if err := t.establishChallenges(); err != nil {
+		return nil, err
+	}
+
+	tr := transport.NewTransport(t.transport, auth.NewAuthorizer(t.cm,
+		auth.NewTokenHandler(t.transport, t.cs, name.Name(), "pull", "push"),
+		auth.NewBasicHandler(t.cs)))
+	return client.NewRepository(ctx, name, t.policy.URL, tr)
+}
+
+// establishChallenges pings the target to learn how to authenticate with
+// it, unless it is already known.
+func (t *target) establishChallenges() error {
+	u, err := url.Parse(t.policy.URL)
+	if err != nil {
+		return err
+	}
+	u.Path = "/v2/"
+
+	challenges, err := t.cm.GetChallenges(*u)
+	if err != nil {
+		return err
+	}
+	if len(challenges) > 0 {
+		return nil
+	}
+
+	resp, err := (&http.Client{Transport: t.transport}).Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return t.cm.AddResponse(resp)
+}
+
+// Status returns the status of the replication to the target.
+func (t *target) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	if status.Pending > 0 && !t.oldest.IsZero() {
+		status.Lag = time.Since(t.oldest)
+	}
+	return status
+}
+
+// idle records that the queue of the target is empty.
+func (t *target) idle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Pending = 0
+	t.oldest = time.Time{}
+}
+
+// queued records the length of the queue and the time its oldest task was
+// queued.
+func (t *target) queued(pending int, oldest time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Pending = pending
+	t.oldest = oldest
+}
+
+func (t *target) success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Replicated++
+	t.status.LastSuccess = time.Now()
+	t.status.Failures = 0
+	t.lastFail = time.Time{}
+}
+
+func (t *target) failure(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Failures++
+	t.status.LastError = err.Error()
+	t.status.LastErrorAt = time.Now()
+	t.lastFail = t.status.LastErrorAt
+}
+
+// deadLettered records a task moved to the dead letters, which forgets the
+// consecutive failures and keeps the last error.
+func (t *target) deadLettered() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.DeadLettered++
+	t.status.Failures = 0
+	t.lastFail = time.Time{}
+}
+
+// proceed returns true if the next attempt should proceed without backing
+// off, with the heuristics of the retrying sink of notifications.
+func (t *target) proceed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status.Failures < t.policy.Threshold ||
+		time.Now().After(t.lastFail.Add(t.policy.Backoff))
+}
+
+// credentials answers the challenges of a target with its username and
+// password.
+type credentials struct {
+	username string
+	password string
+}
+
+func (c credentials) Basic(u *url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(u *url.URL, service string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
+}
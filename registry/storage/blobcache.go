@@ -3,11 +3,9 @@ package storage
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"time"
-
+	"sort"
 	"strings"
 
 	"github.com/docker/distribution"
@@ -22,7 +20,8 @@ type itemNameList struct {
 }
 
 type blobCache struct {
-	driver driver.StorageDriver
+	driver   driver.StorageDriver
+	registry *registry
 }
 
 var _ distribution.BlobCache = &blobCache{}
@@ -43,61 +42,47 @@ func (bc *blobCache) GetCatalog(ctx context.Context) ([]byte, error) {
 	return bc.driver.GetContent(ctx, cp)
 }
 
-func (bc *blobCache) CacheTagList(ctx context.Context, content []byte, name string) error {
-	tp, err := pathFor(tagListCachePathSpec{
-		name: name,
-	})
+func (bc *blobCache) PutWarmUpCheckpoint(ctx context.Context, content []byte) error {
+	cp, err := pathFor(warmUpCheckpointPathSpec{})
 	if err != nil {
 		return err
 	}
-	return bc.driver.PutContent(ctx, tp, content)
+	return bc.driver.PutContent(ctx, cp, content)
 }
 
-func (bc *blobCache) GetTagList(ctx context.Context, name string) ([]byte, error) {
-	tp, err := pathFor(tagListCachePathSpec{
-		name: name,
-	})
+func (bc *blobCache) GetWarmUpCheckpoint(ctx context.Context) ([]byte, error) {
+	cp, err := pathFor(warmUpCheckpointPathSpec{})
 	if err != nil {
 		return nil, err
 	}
-	return bc.driver.GetContent(ctx, tp)
+	return bc.driver.GetContent(ctx, cp)
 }
 
-func (bc *blobCache) SaveTagInfo(ctx context.Context, content []byte, name string, tag string) error {
-	tp, err := pathFor(tagInfoCachePathSpec{
-		name: name,
-		tag:  tag,
-	})
+func (bc *blobCache) DeleteWarmUpCheckpoint(ctx context.Context) error {
+	cp, err := pathFor(warmUpCheckpointPathSpec{})
 	if err != nil {
 		return err
 	}
-	return bc.driver.PutContent(ctx, tp, content)
-}
-
-func (bc *blobCache) SaveImageInfo(ctx context.Context, content []byte, name string) error {
-	ip, err := pathFor(imageInfoCachePathSpec{
-		name: name,
-	})
-	if err != nil {
-		return err
+	err = bc.driver.Delete(ctx, cp)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
 	}
-	return bc.driver.PutContent(ctx, ip, content)
+	return err
 }
 
-func (bc *blobCache) GetImageInfo(ctx context.Context, name string) ([]byte, error) {
-	ip, err := pathFor(imageInfoCachePathSpec{
+func (bc *blobCache) CacheTagList(ctx context.Context, content []byte, name string) error {
+	tp, err := pathFor(tagListCachePathSpec{
 		name: name,
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return bc.driver.GetContent(ctx, ip)
+	return bc.driver.PutContent(ctx, tp, content)
 }
 
-func (bc *blobCache) GetTagInfo(ctx context.Context, name, tag string) ([]byte, error) {
-	tp, err := pathFor(tagInfoCachePathSpec{
+func (bc *blobCache) GetTagList(ctx context.Context, name string) ([]byte, error) {
+	tp, err := pathFor(tagListCachePathSpec{
 		name: name,
-		tag:  tag,
 	})
 	if err != nil {
 		return nil, err
@@ -105,218 +90,126 @@ func (bc *blobCache) GetTagInfo(ctx context.Context, name, tag string) ([]byte,
 	return bc.driver.GetContent(ctx, tp)
 }
 
-func (bc *blobCache) GetImageItemList(ctx context.Context, name string) ([]string, error) {
-	ilp, err := pathFor(imageItemInfoPathSpec{
-		name: name,
-	})
+func (bc *blobCache) GetImageItemList(ctx context.Context, name string) ([]distribution.ItemInfo, error) {
+	savepath, listpaths, err := imageItemListPaths(name)
 	if err != nil {
 		return nil, err
 	}
-	content, err := bc.driver.GetContent(ctx, ilp)
-	if err != nil {
-		listpath, err := pathFor(imageItemListPathSpec{
-			name: name,
-		})
-		if err != nil {
-			return nil, err
-		}
-		savepath, err := pathFor(imageItemInfoPathSpec{
-			name: name,
-		})
-		if err != nil {
-			return nil, err
-		}
-		err = updateItemList(ctx, bc, listpath, savepath)
-		if err != nil {
-			return nil, err
-		}
-		content, err = bc.driver.GetContent(ctx, ilp)
-		if err != nil {
-			return nil, err
-		}
-	}
-	var inl itemNameList
-	err = json.Unmarshal(content, &inl)
+	names, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
 		return nil, err
 	}
-	return inl.NameList, nil
-
-}
-func (bc *blobCache) GetTagItemList(ctx context.Context, name, tag string) ([]string, error) {
-	ilp, err := pathFor(tagItemInfoPathSpec{
-		name: name,
-		tag:  tag,
+	return bc.itemInfos(ctx, name, names, func(item string) (string, string, error) {
+		return imageItemPaths(name, item)
 	})
+}
+
+func (bc *blobCache) GetTagItemList(ctx context.Context, name, tag string) ([]distribution.ItemInfo, error) {
+	savepath, listpaths, err := tagItemListPaths(name, tag)
 	if err != nil {
 		return nil, err
 	}
-	content, err := bc.driver.GetContent(ctx, ilp)
-	if err != nil {
-		listpath, err := pathFor(tagItemListPathSpec{
-			name: name,
-			tag:  tag,
-		})
-		if err != nil {
-			return nil, err
-		}
-		savepath, err := pathFor(tagItemInfoPathSpec{
-			name: name,
-			tag:  tag,
-		})
-		if err != nil {
-			return nil, err
-		}
-		err = updateItemList(ctx, bc, listpath, savepath)
-		if err != nil {
-			return nil, err
-		}
-		content, err = bc.driver.GetContent(ctx, ilp)
-		if err != nil {
-			return nil, err
-		}
-	}
-	var inl itemNameList
-	err = json.Unmarshal(content, &inl)
+	names, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
 		return nil, err
 	}
-	return inl.NameList, nil
-
-}
-func (bc *blobCache) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) error {
-	isp, err := pathFor(imageItemSavePathSpec{
-		name: name,
-		item: item,
+	return bc.itemInfos(ctx, name, names, func(item string) (string, string, error) {
+		return tagItemPaths(name, tag, item)
 	})
+}
+
+func (bc *blobCache) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) (distribution.Descriptor, error) {
+	linkpath, legacypath, err := imageItemPaths(name, item)
 	if err != nil {
-		return err
-	}
-	fw, err := bc.driver.Writer(ctx, isp, false)
-	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	err = writeItem(ctx, w, r, fw)
+	desc, err := bc.putItem(ctx, w, r, name, item, linkpath, legacypath)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	listpath, err := pathFor(imageItemListPathSpec{
-		name: name,
-	})
+	savepath, listpaths, err := imageItemListPaths(name)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	savepath, err := pathFor(imageItemInfoPathSpec{
-		name: name,
-	})
+	iil, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
-		return err
+		return desc, updateItemList(ctx, bc, savepath, listpaths)
 	}
-	iil, err := bc.GetImageItemList(ctx, name)
-	if err != nil {
-		return updateItemList(ctx, bc, listpath, savepath)
-	}
-	return addItemIntoItemList(ctx, bc, name, item, listpath, savepath, iil)
+	return desc, addItemIntoItemList(ctx, bc, item, savepath, iil)
 }
 
-func (bc *blobCache) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) error {
-	sps, err := pathFor(tagItemSavePathSpec{
-		name: name,
-		tag:  tag,
-		item: item,
-	})
+func (bc *blobCache) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) (distribution.Descriptor, error) {
+	linkpath, legacypath, err := tagItemPaths(name, tag, item)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	fw, err := bc.driver.Writer(ctx, sps, false)
+	desc, err := bc.putItem(ctx, w, r, name, item, linkpath, legacypath)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	err = writeItem(ctx, w, r, fw)
+	savepath, listpaths, err := tagItemListPaths(name, tag)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
-	listpath, err := pathFor(tagItemListPathSpec{
-		name: name,
-		tag:  tag,
-	})
+	til, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
-		return err
+		return desc, updateItemList(ctx, bc, savepath, listpaths)
 	}
-	savepath, err := pathFor(tagItemInfoPathSpec{
-		name: name,
-		tag:  tag,
-	})
+	return desc, addItemIntoItemList(ctx, bc, item, savepath, til)
+}
+
+func (bc *blobCache) ServeImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item string) error {
+	linkpath, legacypath, err := imageItemPaths(name, item)
 	if err != nil {
 		return err
 	}
-	til, err := bc.GetTagItemList(ctx, name, tag)
+	link, err := bc.getItemLink(ctx, name, linkpath, legacypath)
 	if err != nil {
-		return updateItemList(ctx, bc, listpath, savepath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.ErrItemUnknown{Name: name, Item: item}
+		}
+		return err
 	}
-	return addItemIntoItemList(ctx, bc, name, item, listpath, savepath, til)
-
+	return bc.serveItem(ctx, w, r, name, link)
 }
 
-func (bc *blobCache) ServeItem(ctx context.Context, w http.ResponseWriter, r *http.Request, path, item string) error {
-	desc, err := bc.driver.Stat(ctx, path)
+func (bc *blobCache) ServeTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, tag, item string) error {
+	linkpath, legacypath, err := tagItemPaths(name, tag, item)
 	if err != nil {
 		return err
 	}
-	br, err := newFileReader(ctx, bc.driver, path, desc.Size())
+	link, err := bc.getItemLink(ctx, name, linkpath, legacypath)
 	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.ErrItemUnknown{Name: name, Tag: tag, Item: item}
+		}
 		return err
 	}
-	defer br.Close()
-
-	// w.Header().Set("ETag", fmt.Sprintf(`"%s"`, item)) // If-None-Match handled by ServeContent
-
-	// if w.Header().Get("Docker-Content-Digest") == "" {
-	// 	w.Header().Set("Docker-Content-Digest", item)
-	// }
-
-	if w.Header().Get("Content-Length") == "" {
-		// Set the content length if not already set.
-		w.Header().Set("Content-Length", fmt.Sprint(desc.Size()))
-	}
-	if w.Header().Get("Content-Type") == "" {
-		w.Header().Set("Content-Type", "application/octet-stream")
-	}
-
-	http.ServeContent(w, r, item, time.Time{}, br)
-	return nil
+	return bc.serveItem(ctx, w, r, name, link)
 }
 
 func (bc *blobCache) DeleteImageItem(ctx context.Context, name, item string) error {
-	isp, err := pathFor(imageItemSavePathSpec{
-		name: name,
-		item: item,
-	})
-	if err != nil {
-		return err
-	}
-	err = bc.driver.Delete(ctx, isp)
+	linkpath, legacypath, err := imageItemPaths(name, item)
 	if err != nil {
 		return err
 	}
-	listpath, err := pathFor(imageItemListPathSpec{
-		name: name,
-	})
-	if err != nil {
+	if err := bc.deleteItemLink(ctx, linkpath, legacypath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.ErrItemUnknown{Name: name, Item: item}
+		}
 		return err
 	}
-	savepath, err := pathFor(imageItemInfoPathSpec{
-		name: name,
-	})
+	savepath, listpaths, err := imageItemListPaths(name)
 	if err != nil {
 		return err
 	}
-	iil, err := bc.GetImageItemList(ctx, name)
+	iil, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
-		return updateItemList(ctx, bc, listpath, savepath)
+		return updateItemList(ctx, bc, savepath, listpaths)
 	}
-	return removeItemFromItemList(ctx, bc, name, item, listpath, savepath, iil)
+	return removeItemFromItemList(ctx, bc, item, savepath, iil)
 }
+
 func (bc *blobCache) DeleteAllImageItems(ctx context.Context, name string) error {
 	path, err := pathFor(itemSaveRootPathSpec{
 		name: name,
@@ -326,48 +219,48 @@ func (bc *blobCache) DeleteAllImageItems(ctx context.Context, name string) error
 	}
 	return bc.driver.Delete(ctx, path)
 }
+
 func (bc *blobCache) DeleteTagItem(ctx context.Context, name, tag, item string) error {
-	sps, err := pathFor(tagItemSavePathSpec{
-		name: name,
-		tag:  tag,
-		item: item,
-	})
-	if err != nil {
-		return err
-	}
-	err = bc.driver.Delete(ctx, sps)
+	linkpath, legacypath, err := tagItemPaths(name, tag, item)
 	if err != nil {
 		return err
 	}
-	listpath, err := pathFor(tagItemListPathSpec{
-		name: name,
-		tag:  tag,
-	})
-	if err != nil {
+	if err := bc.deleteItemLink(ctx, linkpath, legacypath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.ErrItemUnknown{Name: name, Tag: tag, Item: item}
+		}
 		return err
 	}
-	savepath, err := pathFor(tagItemInfoPathSpec{
-		name: name,
-		tag:  tag,
-	})
+	savepath, listpaths, err := tagItemListPaths(name, tag)
 	if err != nil {
 		return err
 	}
-	til, err := bc.GetTagItemList(ctx, name, tag)
+	til, err := getItemList(ctx, bc, savepath, listpaths)
 	if err != nil {
-		return updateItemList(ctx, bc, listpath, savepath)
+		return updateItemList(ctx, bc, savepath, listpaths)
 	}
-	return removeItemFromItemList(ctx, bc, name, item, listpath, savepath, til)
+	return removeItemFromItemList(ctx, bc, item, savepath, til)
 }
+
 func (bc *blobCache) DeleteAllTagItems(ctx context.Context, name, tag string) error {
-	path, err := pathFor(tagItemListPathSpec{
-		name: name,
-		tag:  tag,
+	savepath, listpaths, err := tagItemListPaths(name, tag)
+	if err != nil {
+		return err
+	}
+	for _, path := range listpaths {
+		if err := bc.driver.Delete(ctx, path); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	content, err := json.Marshal(itemNameList{
+		NameList: []string{},
 	})
 	if err != nil {
 		return err
 	}
-	return bc.driver.Delete(ctx, path)
+	return bc.driver.PutContent(ctx, savepath, content)
 }
 
 func (bc *blobCache) InitItem(ctx context.Context, name, tag string) error {
@@ -393,22 +286,6 @@ func (bc *blobCache) InitItem(ctx context.Context, name, tag string) error {
 	return nil
 }
 
-func (bc *blobCache) CacheCatalogInfo(ctx context.Context, content []byte) error {
-	path, err := pathFor(catalogInfoPathSpec{})
-	if err != nil {
-		return err
-	}
-	return bc.driver.PutContent(ctx, path, content)
-}
-
-func (bc *blobCache) GetCatalogInfo(ctx context.Context) ([]byte, error) {
-	path, err := pathFor(catalogInfoPathSpec{})
-	if err != nil {
-		return nil, err
-	}
-	return bc.driver.GetContent(ctx, path)
-}
-
 func (bc *blobCache) DeleteImageRepository(ctx context.Context, name string) error {
 	path, err := pathFor(imageRootPathSpec{
 		name: name,
@@ -434,23 +311,151 @@ func initItemInfo(ctx context.Context, bc *blobCache, path string) error {
 	return nil
 }
 
-func updateItemList(ctx context.Context, bc *blobCache, listpath, savepath string) error {
-	iil, err := bc.driver.List(ctx, listpath)
+// imageItemPaths returns the path of the link of a repository item, along
+// with the path the item was saved at before items were kept in the blob
+// store.
+func imageItemPaths(name, item string) (string, string, error) {
+	linkpath, err := pathFor(imageItemLinkPathSpec{
+		name: name,
+		item: item,
+	})
 	if err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			return distribution.ErrItemRepositoryUnknown{Name: listpath}
-		default:
-			return err
+		return "", "", err
+	}
+	legacypath, err := pathFor(imageItemSavePathSpec{
+		name: name,
+		item: item,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return linkpath, legacypath, nil
+}
+
+// tagItemPaths returns the path of the link of a tag item, along with its
+// legacy path.
+func tagItemPaths(name, tag, item string) (string, string, error) {
+	linkpath, err := pathFor(tagItemLinkPathSpec{
+		name: name,
+		tag:  tag,
+		item: item,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	legacypath, err := pathFor(tagItemSavePathSpec{
+		name: name,
+		tag:  tag,
+		item: item,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return linkpath, legacypath, nil
+}
+
+// imageItemListPaths returns the path of the item list of the repository,
+// along with the directories the list is built from. Items saved before
+// they were kept in the blob store remain listed until they are migrated.
+func imageItemListPaths(name string) (string, []string, error) {
+	savepath, err := pathFor(imageItemInfoPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	linkspath, err := pathFor(imageItemLinksPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	legacypath, err := pathFor(imageItemListPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return savepath, []string{linkspath, legacypath}, nil
+}
+
+// tagItemListPaths returns the path of the item list of the tag, along with
+// the directories the list is built from.
+func tagItemListPaths(name, tag string) (string, []string, error) {
+	savepath, err := pathFor(tagItemInfoPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	linkspath, err := pathFor(tagItemLinksPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	legacypath, err := pathFor(tagItemListPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return savepath, []string{linkspath, legacypath}, nil
+}
+
+func getItemList(ctx context.Context, bc *blobCache, savepath string, listpaths []string) ([]string, error) {
+	content, err := bc.driver.GetContent(ctx, savepath)
+	if err != nil {
+		err = updateItemList(ctx, bc, savepath, listpaths)
+		if err != nil {
+			return nil, err
+		}
+		content, err = bc.driver.GetContent(ctx, savepath)
+		if err != nil {
+			return nil, err
 		}
 	}
+	var inl itemNameList
+	err = json.Unmarshal(content, &inl)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return inl.NameList, nil
+}
+
+func updateItemList(ctx context.Context, bc *blobCache, savepath string, listpaths []string) error {
+	var (
+		names []string
+		found bool
+	)
+	seen := make(map[string]struct{})
+	for _, listpath := range listpaths {
+		iil, err := bc.driver.List(ctx, listpath)
+		if err != nil {
+			switch err := err.(type) {
+			case driver.PathNotFoundError:
+				continue
+			default:
+				return err
+			}
+		}
+		found = true
+		for _, itemname := range iil {
+			itemname = strings.TrimPrefix(itemname, listpath+"/")
+			if _, ok := seen[itemname]; ok {
+				continue
+			}
+			seen[itemname] = struct{}{}
+			names = append(names, itemname)
+		}
 	}
-	names := make([]string, len(iil))
-	for i, itemname := range iil {
-		names[i] = strings.TrimPrefix(itemname, listpath+"/")
+	if !found {
+		return distribution.ErrItemRepositoryUnknown{Name: listpaths[0]}
 	}
+	sort.Strings(names)
 	content, err := json.Marshal(itemNameList{
 		NameList: names,
 	})
@@ -460,7 +465,7 @@ func updateItemList(ctx context.Context, bc *blobCache, listpath, savepath strin
 	return bc.driver.PutContent(ctx, savepath, content)
 }
 
-func removeItemFromItemList(ctx context.Context, bc *blobCache, name, item, listpath, path string, nameList []string) error {
+func removeItemFromItemList(ctx context.Context, bc *blobCache, item, path string, nameList []string) error {
 	index := -1
 	for i, itemname := range nameList {
 		if strings.EqualFold(item, itemname) {
@@ -481,7 +486,7 @@ func removeItemFromItemList(ctx context.Context, bc *blobCache, name, item, list
 	return nil
 }
 
-func addItemIntoItemList(ctx context.Context, bc *blobCache, name, item, listpath, path string, nameList []string) error {
+func addItemIntoItemList(ctx context.Context, bc *blobCache, item, path string, nameList []string) error {
 	flag := false
 	for _, itemname := range nameList {
 		if strings.EqualFold(item, itemname) {
@@ -502,7 +507,7 @@ func addItemIntoItemList(ctx context.Context, bc *blobCache, name, item, listpat
 	return nil
 }
 
-func writeItem(ctx context.Context, responseWriter http.ResponseWriter, r *http.Request, destWriter driver.FileWriter) error {
+func writeItem(ctx context.Context, responseWriter http.ResponseWriter, r *http.Request, body io.Reader, destWriter io.Writer) error {
 	// Get a channel that tells us if the client disconnects
 	var clientClosed <-chan bool
 	if notifier, ok := responseWriter.(http.CloseNotifier); ok {
@@ -512,8 +517,7 @@ func writeItem(ctx context.Context, responseWriter http.ResponseWriter, r *http.
 	}
 
 	// Read in the data, if any.
-	copied, err := io.Copy(destWriter, r.Body)
-	destWriter.Commit()
+	copied, err := io.Copy(destWriter, body)
 	if clientClosed != nil && (err != nil || (r.ContentLength > 0 && copied < r.ContentLength)) {
 		// Didn't receive as much content as expected. Did the client
 		// disconnect during the request? If so, avoid returning a 400
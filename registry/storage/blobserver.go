@@ -57,7 +57,10 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 	defer br.Close()
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest)) // If-None-Match handled by ServeContent
-	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
+	if w.Header().Get("Cache-Control") == "" {
+		// Blobs are immutable, unless served under a mutable name.
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
+	}
 
 	if w.Header().Get("Docker-Content-Digest") == "" {
 		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
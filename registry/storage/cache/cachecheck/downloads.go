@@ -0,0 +1,145 @@
+package cachecheck
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache"
+)
+
+// CheckDownloadCounter takes a download counter implementation through a
+// common set of operations. If adding new tests, please add them here so new
+// implementations get the benefit. This should be used for unit tests.
+func CheckDownloadCounter(t *testing.T, counter distribution.DownloadCounter) {
+	ctx := context.Background()
+
+	checkDownloadCounterEmpty(t, ctx, counter)
+	checkDownloadCounterIncrement(t, ctx, counter)
+	checkDownloadCounterDelete(t, ctx, counter)
+}
+
+func checkDownloadCounterEmpty(t *testing.T, ctx context.Context, counter distribution.DownloadCounter) {
+	stats, err := counter.TagDownloads(ctx, "foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading empty counter: %v", err)
+	}
+
+	if stats.Total != 0 || len(stats.Daily) != 0 {
+		t.Fatalf("expected empty tag counter, got %#v", stats)
+	}
+
+	stats, err = counter.RepositoryDownloads(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error reading empty counter: %v", err)
+	}
+
+	if stats.Total != 0 || len(stats.Daily) != 0 {
+		t.Fatalf("expected empty repository counter, got %#v", stats)
+	}
+}
+
+func checkDownloadCounterIncrement(t *testing.T, ctx context.Context, counter distribution.DownloadCounter) {
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	expired := now.AddDate(0, 0, -cache.DownloadHistoryDays)
+
+	// concurrent increments must all be counted
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := counter.Increment(ctx, "foo/bar", "latest", now); err != nil {
+				t.Errorf("unexpected error incrementing counter: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, at := range []time.Time{yesterday, expired} {
+		if err := counter.Increment(ctx, "foo/bar", "latest", at); err != nil {
+			t.Fatalf("unexpected error incrementing counter: %v", err)
+		}
+	}
+
+	if err := counter.Increment(ctx, "foo/bar", "v1", now); err != nil {
+		t.Fatalf("unexpected error incrementing counter: %v", err)
+	}
+
+	stats, err := counter.TagDownloads(ctx, "foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 22 {
+		t.Fatalf("unexpected total for tag: %d != 22", stats.Total)
+	}
+
+	if stats.Daily[cache.DownloadDay(now)] != 20 || stats.Daily[cache.DownloadDay(yesterday)] != 1 {
+		t.Fatalf("unexpected daily counts for tag: %#v", stats.Daily)
+	}
+
+	if _, ok := stats.Daily[cache.DownloadDay(expired)]; ok {
+		t.Fatalf("expected expired day to be omitted: %#v", stats.Daily)
+	}
+
+	stats, err = counter.RepositoryDownloads(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 23 || stats.Daily[cache.DownloadDay(now)] != 21 {
+		t.Fatalf("unexpected counts for repository: %#v", stats)
+	}
+}
+
+func checkDownloadCounterDelete(t *testing.T, ctx context.Context, counter distribution.DownloadCounter) {
+	if err := counter.DeleteTag(ctx, "foo/bar", "latest"); err != nil {
+		t.Fatalf("unexpected error deleting tag counter: %v", err)
+	}
+
+	stats, err := counter.TagDownloads(ctx, "foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 0 {
+		t.Fatalf("expected deleted tag counter to be empty, got %#v", stats)
+	}
+
+	stats, err = counter.RepositoryDownloads(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 23 {
+		t.Fatalf("expected repository counter to be kept after deleting a tag, got %#v", stats)
+	}
+
+	if err := counter.DeleteRepository(ctx, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error deleting repository counters: %v", err)
+	}
+
+	for _, tag := range []string{"latest", "v1"} {
+		stats, err := counter.TagDownloads(ctx, "foo/bar", tag)
+		if err != nil {
+			t.Fatalf("unexpected error reading counter: %v", err)
+		}
+
+		if stats.Total != 0 {
+			t.Fatalf("expected tag counter to be deleted with repository, got %#v", stats)
+		}
+	}
+
+	stats, err = counter.RepositoryDownloads(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 0 {
+		t.Fatalf("expected deleted repository counter to be empty, got %#v", stats)
+	}
+}
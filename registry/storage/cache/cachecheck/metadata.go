@@ -0,0 +1,171 @@
+package cachecheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+)
+
+// CheckMetadataIndex takes a metadata index implementation through a common
+// set of operations. If adding new tests, please add them here so new
+// implementations get the benefit. This should be used for unit tests.
+func CheckMetadataIndex(t *testing.T, index distribution.MetadataIndex) {
+	ctx := context.Background()
+
+	checkMetadataIndexEmpty(t, ctx, index)
+	checkMetadataIndexPutAndRead(t, ctx, index)
+	checkMetadataIndexDelete(t, ctx, index)
+}
+
+func checkMetadataIndexEmpty(t *testing.T, ctx context.Context, index distribution.MetadataIndex) {
+	if _, err := index.GetTagInfo(ctx, "foo/bar", "latest"); err == nil {
+		t.Fatalf("expected unknown tag error with empty index")
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("expected unknown tag error with empty index: %v", err)
+	}
+
+	if _, err := index.GetImageInfo(ctx, "foo/bar"); err == nil {
+		t.Fatalf("expected unknown repository error with empty index")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("expected unknown repository error with empty index: %v", err)
+	}
+
+	if err := index.PutTagInfo(ctx, distribution.TagInfo{Name: "", Tag: "latest"}); err == nil {
+		t.Fatalf("expected error putting tag info with invalid name")
+	}
+
+	if err := index.PutTagInfo(ctx, distribution.TagInfo{Name: "foo/bar", Tag: "-invalid"}); err == nil {
+		t.Fatalf("expected error putting tag info with invalid tag")
+	}
+
+	infos, err := index.ImageInfos(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing empty index: %v", err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("expected empty catalog info, got %v", infos)
+	}
+}
+
+func checkMetadataIndexPutAndRead(t *testing.T, ctx context.Context, index distribution.MetadataIndex) {
+	created := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	records := []distribution.TagInfo{
+		{Name: "foo/bar", Tag: "latest", CreateTime: created, DownloadCount: 3, Size: 10},
+		{Name: "foo/bar", Tag: "v1", CreateTime: created.Add(time.Hour), DownloadCount: 2, Size: 20},
+		{Name: "bar", Tag: "latest", CreateTime: created, Size: 30},
+	}
+
+	for _, record := range records {
+		if err := index.PutTagInfo(ctx, record); err != nil {
+			t.Fatalf("unexpected error putting tag info: %v", err)
+		}
+	}
+
+	info, err := index.GetTagInfo(ctx, "foo/bar", "v1")
+	if err != nil {
+		t.Fatalf("unexpected error getting tag info: %v", err)
+	}
+
+	if info.Name != "foo/bar" || info.Tag != "v1" || info.Size != 20 || info.DownloadCount != 2 || !info.CreateTime.Equal(created.Add(time.Hour)) {
+		t.Fatalf("unexpected tag info: %#v", info)
+	}
+
+	tags, err := index.TagInfos(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error listing tag infos: %v", err)
+	}
+
+	if len(tags) != 2 {
+		t.Fatalf("expected 2 tag infos, got %d", len(tags))
+	}
+
+	imageInfo, err := index.GetImageInfo(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting image info: %v", err)
+	}
+
+	if imageInfo.Size != 2 || imageInfo.DownloadCount != 5 {
+		t.Fatalf("unexpected image info summary: %#v", imageInfo)
+	}
+
+	if !imageInfo.LastModified.Equal(created.Add(time.Hour)) || !imageInfo.CreateTime.Equal(created) {
+		t.Fatalf("unexpected image info times: %#v", imageInfo)
+	}
+
+	// updating a tag must preserve the creation time of the repository
+	records[0].CreateTime = created.Add(2 * time.Hour)
+	records[0].Size = 15
+	if err := index.PutTagInfo(ctx, records[0]); err != nil {
+		t.Fatalf("unexpected error putting tag info: %v", err)
+	}
+
+	imageInfo, err = index.GetImageInfo(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting image info: %v", err)
+	}
+
+	if !imageInfo.LastModified.Equal(created.Add(2*time.Hour)) || !imageInfo.CreateTime.Equal(created) {
+		t.Fatalf("unexpected image info times after update: %#v", imageInfo)
+	}
+
+	infos, err := index.ImageInfos(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing image infos: %v", err)
+	}
+
+	if len(infos) != 2 || infos[0].Name != "bar" || infos[1].Name != "foo/bar" {
+		t.Fatalf("unexpected catalog info: %#v", infos)
+	}
+}
+
+func checkMetadataIndexDelete(t *testing.T, ctx context.Context, index distribution.MetadataIndex) {
+	if err := index.DeleteTagInfo(ctx, "foo/bar", "latest"); err != nil {
+		t.Fatalf("unexpected error deleting tag info: %v", err)
+	}
+
+	if _, err := index.GetTagInfo(ctx, "foo/bar", "latest"); err == nil {
+		t.Fatalf("expected unknown tag error after delete")
+	}
+
+	if err := index.DeleteTagInfo(ctx, "foo/bar", "latest"); err == nil {
+		t.Fatalf("expected error deleting unknown tag info")
+	}
+
+	imageInfo, err := index.GetImageInfo(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting image info: %v", err)
+	}
+
+	if imageInfo.Size != 1 || imageInfo.DownloadCount != 2 {
+		t.Fatalf("unexpected image info summary after delete: %#v", imageInfo)
+	}
+
+	if err := index.DeleteImageInfo(ctx, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error deleting image info: %v", err)
+	}
+
+	if _, err := index.GetImageInfo(ctx, "foo/bar"); err == nil {
+		t.Fatalf("expected unknown repository error after delete")
+	}
+
+	tags, err := index.TagInfos(ctx, "foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error listing tag infos: %v", err)
+	}
+
+	if len(tags) != 0 {
+		t.Fatalf("expected no tag infos after delete, got %v", tags)
+	}
+
+	infos, err := index.ImageInfos(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing image infos: %v", err)
+	}
+
+	if len(infos) != 1 || infos[0].Name != "bar" {
+		t.Fatalf("unexpected catalog info after delete: %#v", infos)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/docker/distribution"
+)
+
+// DownloadHistoryDays is the number of days for which download counters keep
+// per-day counts, including the current day.
+const DownloadHistoryDays = 30
+
+// downloadDayFormat is the layout of the keys of DownloadStats.Daily.
+const downloadDayFormat = "2006-01-02"
+
+// DownloadDay returns the key under which pulls at the given time are counted
+// in DownloadStats.Daily.
+func DownloadDay(t time.Time) string {
+	return t.UTC().Format(downloadDayFormat)
+}
+
+// RecentDownloadDays returns the keys of the retained days ending with the day
+// of now, oldest first.
+func RecentDownloadDays(now time.Time) []string {
+	days := make([]string, DownloadHistoryDays)
+	for i := range days {
+		days[i] = DownloadDay(now.AddDate(0, 0, i-DownloadHistoryDays+1))
+	}
+	return days
+}
+
+// PruneDownloadStats removes the per-day counts of stats which fall outside of
+// the retained days.
+func PruneDownloadStats(stats *distribution.DownloadStats, now time.Time) {
+	oldest := RecentDownloadDays(now)[0]
+	for day := range stats.Daily {
+		if day < oldest {
+			delete(stats.Daily, day)
+		}
+	}
+}
+
+// MergeDownloadStats adds the counts of delta to stats.
+func MergeDownloadStats(stats *distribution.DownloadStats, delta distribution.DownloadStats) {
+	if stats.Daily == nil {
+		stats.Daily = make(map[string]int, len(delta.Daily))
+	}
+	stats.Total += delta.Total
+	for day, count := range delta.Daily {
+		stats.Daily[day] += count
+	}
+}
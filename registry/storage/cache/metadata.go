@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+)
+
+// MaxSummaryRefreshes bounds the attempts of metadata indexes to write the
+// summary of a repository while its tag records keep changing.
+const MaxSummaryRefreshes = 10
+
+// ValidateTagInfo provides a helper function to ensure that metadata
+// indexes have common criteria for admitting tag records.
+func ValidateTagInfo(info distribution.TagInfo) error {
+	named, err := reference.WithName(info.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := reference.WithTag(named, info.Tag); err != nil {
+		return err
+	}
+
+	if info.Size < 0 {
+		return fmt.Errorf("cache: invalid size in tag info: %v < 0", info.Size)
+	}
+
+	return nil
+}
+
+// SummarizeImageInfo builds the summary of the repository from its tag
+// records. The creation time of a previous summary is preserved so that it
+// reflects the first time the repository was indexed.
+func SummarizeImageInfo(name string, tags []distribution.TagInfo, previous distribution.ImageInfo) distribution.ImageInfo {
+	sort.Sort(tagInfosByTag(tags))
+
+	info := distribution.ImageInfo{
+		Name: name,
+		Tags: tags,
+		Size: len(tags),
+	}
+
+	for _, tag := range tags {
+		if info.LastModified.Before(tag.CreateTime) {
+			info.LastModified = tag.CreateTime
+		}
+		info.DownloadCount += tag.DownloadCount
+	}
+
+	info.CreateTime = previous.CreateTime
+	if info.CreateTime.IsZero() {
+		info.CreateTime = info.LastModified
+	}
+
+	return info
+}
+
+type tagInfosByTag []distribution.TagInfo
+
+func (t tagInfosByTag) Len() int           { return len(t) }
+func (t tagInfosByTag) Less(i, j int) bool { return t[i].Tag < t[j].Tag }
+func (t tagInfosByTag) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
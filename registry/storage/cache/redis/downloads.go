@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisDownloadCounter provides an implementation of
+// distribution.DownloadCounter based on redis. Every counter is a redis
+// integer updated with INCR, so increments from any number of registry
+// instances are never lost. Per-day counters expire once they fall outside of
+// the retained history.
+type redisDownloadCounter struct {
+	pool *redis.Pool
+}
+
+// NewRedisDownloadCounter returns a new redis-based DownloadCounter using the
+// provided redis connection pool.
+func NewRedisDownloadCounter(pool *redis.Pool) distribution.DownloadCounter {
+	return &redisDownloadCounter{
+		pool: pool,
+	}
+}
+
+func (rdc *redisDownloadCounter) Increment(ctx context.Context, name, tag string, at time.Time) error {
+	day := cache.DownloadDay(at)
+	expiry := int((cache.DownloadHistoryDays + 1) * 24 * time.Hour / time.Second)
+
+	conn := rdc.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("SADD", rdc.tagsSetKey(name), tag)
+	for _, prefix := range []string{rdc.repositoryKey(name), rdc.tagKey(name, tag)} {
+		conn.Send("INCR", prefix+"::total")
+		conn.Send("INCR", prefix+"::day::"+day)
+		conn.Send("EXPIRE", prefix+"::day::"+day, expiry)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (rdc *redisDownloadCounter) TagDownloads(ctx context.Context, name, tag string) (distribution.DownloadStats, error) {
+	conn := rdc.pool.Get()
+	defer conn.Close()
+
+	return rdc.stats(conn, rdc.tagKey(name, tag))
+}
+
+func (rdc *redisDownloadCounter) RepositoryDownloads(ctx context.Context, name string) (distribution.DownloadStats, error) {
+	conn := rdc.pool.Get()
+	defer conn.Close()
+
+	return rdc.stats(conn, rdc.repositoryKey(name))
+}
+
+func (rdc *redisDownloadCounter) DeleteTag(ctx context.Context, name, tag string) error {
+	conn := rdc.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", rdc.keys(rdc.tagKey(name, tag))...); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("SREM", rdc.tagsSetKey(name), tag)
+	return err
+}
+
+func (rdc *redisDownloadCounter) DeleteRepository(ctx context.Context, name string) error {
+	conn := rdc.pool.Get()
+	defer conn.Close()
+
+	tags, err := redis.Strings(conn.Do("SMEMBERS", rdc.tagsSetKey(name)))
+	if err != nil {
+		return err
+	}
+
+	keys := rdc.keys(rdc.repositoryKey(name))
+	keys = append(keys, rdc.tagsSetKey(name))
+	for _, tag := range tags {
+		keys = append(keys, rdc.keys(rdc.tagKey(name, tag))...)
+	}
+
+	_, err = conn.Do("DEL", keys...)
+	return err
+}
+
+// stats reads the total and the retained per-day counters under prefix.
+func (rdc *redisDownloadCounter) stats(conn redis.Conn, prefix string) (distribution.DownloadStats, error) {
+	days := cache.RecentDownloadDays(time.Now())
+	counts, err := redis.Ints(conn.Do("MGET", rdc.keys(prefix)...))
+	if err != nil {
+		return distribution.DownloadStats{}, err
+	}
+
+	stats := distribution.DownloadStats{
+		Total: counts[0],
+		Daily: make(map[string]int),
+	}
+	for i, day := range days {
+		if count := counts[i+1]; count > 0 {
+			stats.Daily[day] = count
+		}
+	}
+	return stats, nil
+}
+
+// keys returns the total key under prefix, followed by the keys of the
+// retained per-day counters, oldest first.
+func (rdc *redisDownloadCounter) keys(prefix string) []interface{} {
+	days := cache.RecentDownloadDays(time.Now())
+	keys := make([]interface{}, 0, len(days)+1)
+	keys = append(keys, prefix+"::total")
+	for _, day := range days {
+		keys = append(keys, prefix+"::day::"+day)
+	}
+	return keys
+}
+
+func (rdc *redisDownloadCounter) repositoryKey(name string) string {
+	return "downloads::repository::" + name
+}
+
+func (rdc *redisDownloadCounter) tagKey(name, tag string) string {
+	return "downloads::repository::" + name + "::tag::" + tag
+}
+
+func (rdc *redisDownloadCounter) tagsSetKey(name string) string {
+	return "downloads::repository::" + name + "::tags"
+}
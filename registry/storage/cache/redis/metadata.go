@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisMetadataIndex provides an implementation of distribution.MetadataIndex
+// based on redis. Tag records are stored as fields of a per-repository redis
+// hash, and repository summaries as fields of a single registry wide hash.
+// Every update only writes the fields of the affected repository, so several
+// registry instances may share the same redis database.
+type redisMetadataIndex struct {
+	pool *redis.Pool
+}
+
+// NewRedisMetadataIndex returns a new redis-based MetadataIndex using the
+// provided redis connection pool.
+func NewRedisMetadataIndex(pool *redis.Pool) distribution.MetadataIndex {
+	return &redisMetadataIndex{
+		pool: pool,
+	}
+}
+
+func (rmi *redisMetadataIndex) GetTagInfo(ctx context.Context, name, tag string) (distribution.TagInfo, error) {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	content, err := redis.Bytes(conn.Do("HGET", rmi.tagsHashKey(name), tag))
+	if err != nil {
+		if err == redis.ErrNil {
+			return distribution.TagInfo{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return distribution.TagInfo{}, err
+	}
+
+	var info distribution.TagInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return distribution.TagInfo{}, err
+	}
+	return info, nil
+}
+
+func (rmi *redisMetadataIndex) PutTagInfo(ctx context.Context, info distribution.TagInfo) error {
+	if err := cache.ValidateTagInfo(info); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HSET", rmi.tagsHashKey(info.Name), info.Tag, content); err != nil {
+		return err
+	}
+	return rmi.refreshImageInfo(ctx, conn, info.Name)
+}
+
+func (rmi *redisMetadataIndex) DeleteTagInfo(ctx context.Context, name, tag string) error {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Int(conn.Do("HDEL", rmi.tagsHashKey(name), tag))
+	if err != nil {
+		return err
+	}
+
+	if reply == 0 {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+	return rmi.refreshImageInfo(ctx, conn, name)
+}
+
+func (rmi *redisMetadataIndex) TagInfos(ctx context.Context, name string) ([]distribution.TagInfo, error) {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	return rmi.tagInfos(ctx, conn, name)
+}
+
+func (rmi *redisMetadataIndex) GetImageInfo(ctx context.Context, name string) (distribution.ImageInfo, error) {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	return rmi.imageInfo(ctx, conn, name)
+}
+
+func (rmi *redisMetadataIndex) DeleteImageInfo(ctx context.Context, name string) error {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", rmi.tagsHashKey(name)); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("HDEL", rmi.repositoriesHashKey(), name)
+	return err
+}
+
+func (rmi *redisMetadataIndex) ImageInfos(ctx context.Context) ([]distribution.ImageInfo, error) {
+	conn := rmi.pool.Get()
+	defer conn.Close()
+
+	contents, err := redis.StringMap(conn.Do("HGETALL", rmi.repositoriesHashKey()))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]distribution.ImageInfo, 0, len(names))
+	for _, name := range names {
+		var info distribution.ImageInfo
+		if err := json.Unmarshal([]byte(contents[name]), &info); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (rmi *redisMetadataIndex) tagInfos(ctx context.Context, conn redis.Conn, name string) ([]distribution.TagInfo, error) {
+	contents, err := redis.Strings(conn.Do("HVALS", rmi.tagsHashKey(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]distribution.TagInfo, len(contents))
+	for i, content := range contents {
+		if err := json.Unmarshal([]byte(content), &infos[i]); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+func (rmi *redisMetadataIndex) imageInfo(ctx context.Context, conn redis.Conn, name string) (distribution.ImageInfo, error) {
+	content, err := redis.Bytes(conn.Do("HGET", rmi.repositoriesHashKey(), name))
+	if err != nil {
+		if err == redis.ErrNil {
+			return distribution.ImageInfo{}, distribution.ErrRepositoryUnknown{Name: name}
+		}
+		return distribution.ImageInfo{}, err
+	}
+
+	var info distribution.ImageInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return distribution.ImageInfo{}, err
+	}
+	return info, nil
+}
+
+// refreshImageInfo rebuilds the summary of the repository from its tag
+// records. The tag records are watched, so that a summary built from records
+// updated concurrently, by this instance or another, is never written: the
+// summary is built again from the updated records instead.
+func (rmi *redisMetadataIndex) refreshImageInfo(ctx context.Context, conn redis.Conn, name string) error {
+	for attempt := 0; attempt < cache.MaxSummaryRefreshes; attempt++ {
+		if _, err := conn.Do("WATCH", rmi.tagsHashKey(name)); err != nil {
+			return err
+		}
+
+		// the pool unwatches the keys when the connection is returned
+		content, err := rmi.summarize(ctx, conn, name)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		if err := conn.Send("HSET", rmi.repositoriesHashKey(), name, content); err != nil {
+			return err
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+		// the tag records changed since they were read
+	}
+	return fmt.Errorf("redis: failed to refresh the image info of %s: tag records updated concurrently", name)
+}
+
+// summarize returns the summary of the repository built from its current tag
+// records.
+func (rmi *redisMetadataIndex) summarize(ctx context.Context, conn redis.Conn, name string) ([]byte, error) {
+	tags, err := rmi.tagInfos(ctx, conn, name)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := rmi.imageInfo(ctx, conn, name)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(cache.SummarizeImageInfo(name, tags, previous))
+}
+
+func (rmi *redisMetadataIndex) tagsHashKey(name string) string {
+	return "metadata::repository::" + name + "::tags"
+}
+
+func (rmi *redisMetadataIndex) repositoriesHashKey() string {
+	return "metadata::repositories"
+}
@@ -19,6 +19,24 @@ func init() {
 // TestRedisLayerInfoCache exercises a live redis instance using the cache
 // implementation.
 func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
+	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(newTestPool(t)))
+}
+
+// TestRedisMetadataIndex exercises a live redis instance using the metadata
+// index implementation.
+func TestRedisMetadataIndex(t *testing.T) {
+	cachecheck.CheckMetadataIndex(t, NewRedisMetadataIndex(newTestPool(t)))
+}
+
+// TestRedisDownloadCounter exercises a live redis instance using the download
+// counter implementation.
+func TestRedisDownloadCounter(t *testing.T) {
+	cachecheck.CheckDownloadCounter(t, NewRedisDownloadCounter(newTestPool(t)))
+}
+
+// newTestPool returns a pool connected to a flushed test instance of redis,
+// skipping the test if none is configured.
+func newTestPool(t *testing.T) *redis.Pool {
 	if redisAddr == "" {
 		// fallback to an environement variable
 		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
@@ -47,5 +65,5 @@ func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
 		t.Fatalf("unexpected error flushing redis db: %v", err)
 	}
 
-	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(pool))
+	return pool
 }
@@ -40,6 +40,12 @@ var catalogLock sync.RWMutex
 func (cs *cacheStore) CreateCatalogCache(ctx context.Context, size int) error {
 	catalogLock.Lock()
 	defer catalogLock.Unlock()
+	return cs.createCatalogCache(ctx, size)
+}
+
+// createCatalogCache builds the catalog cache. It must be called with
+// catalogLock held.
+func (cs *cacheStore) createCatalogCache(ctx context.Context, size int) error {
 	if size < maxSize {
 		size = maxSize
 	}
@@ -62,7 +68,16 @@ func (cs *cacheStore) UpdateCatalogCache(ctx context.Context, imageName string)
 	defer catalogLock.Unlock()
 	repos, err := cs.GetCatalog(ctx)
 	if err != nil {
-		return cs.CreateCatalogCache(ctx, 1)
+		return cs.createCatalogCache(ctx, 1)
+	}
+	if len(repos) == 0 {
+		content, err := json.Marshal(catalog{
+			Repositories: []string{imageName},
+		})
+		if err != nil {
+			return err
+		}
+		return cs.blobCache.CacheCatalog(ctx, content)
 	}
 	begin, end := 0, len(repos)-1
 	for begin < end {
@@ -99,6 +114,9 @@ func (cs *cacheStore) DeleteImageFromCatalogCache(ctx context.Context, imageName
 	if err != nil {
 		return cs.CreateCatalogCache(ctx, 1)
 	}
+	if len(repos) == 0 {
+		return nil
+	}
 	begin, end := 0, len(repos)-1
 	flag := -1
 	for begin < end {
@@ -113,8 +131,11 @@ func (cs *cacheStore) DeleteImageFromCatalogCache(ctx context.Context, imageName
 			break
 		}
 	}
-	if flag > -1 || strings.Compare(repos[begin], imageName) == 0 {
-		repos = append(repos[0:begin], repos[begin+1:]...)
+	if flag == -1 && begin < len(repos) && strings.Compare(repos[begin], imageName) == 0 {
+		flag = begin
+	}
+	if flag > -1 {
+		repos = append(repos[0:flag], repos[flag+1:]...)
 		content, err := json.Marshal(catalog{
 			Repositories: repos,
 		})
@@ -205,37 +226,40 @@ func (cs *cacheStore) GetTagList(ctx context.Context) ([]string, error) {
 	return tl.Tags, nil
 }
 
-func (cs *cacheStore) SaveTagInfo(ctx context.Context, tag string, content []byte) error {
-	name := cs.repository.Named().Name()
-	return cs.blobCache.SaveTagInfo(ctx, content, name, tag)
+func (cs *cacheStore) SaveTagInfo(ctx context.Context, info distribution.TagInfo) error {
+	info.Name = cs.repository.Named().Name()
+	return cs.repository.registry.metadataIndex.PutTagInfo(ctx, info)
 }
 
-func (cs *cacheStore) SaveImageInfo(ctx context.Context, content []byte) error {
+func (cs *cacheStore) DeleteTagInfo(ctx context.Context, tag string) error {
 	name := cs.repository.Named().Name()
-	return cs.blobCache.SaveImageInfo(ctx, content, name)
+	if err := cs.repository.registry.downloadCounter.DeleteTag(ctx, name, tag); err != nil {
+		return err
+	}
+	return cs.repository.registry.metadataIndex.DeleteTagInfo(ctx, name, tag)
 }
 
-func (cs *cacheStore) GetImageInfo(ctx context.Context) ([]byte, error) {
+func (cs *cacheStore) GetImageInfo(ctx context.Context) (distribution.ImageInfo, error) {
 	name := cs.repository.Named().Name()
-	return cs.blobCache.GetImageInfo(ctx, name)
+	return cs.repository.registry.metadataIndex.GetImageInfo(ctx, name)
 }
 
-func (cs *cacheStore) GetTagInfo(ctx context.Context, tag string) ([]byte, error) {
+func (cs *cacheStore) GetTagInfo(ctx context.Context, tag string) (distribution.TagInfo, error) {
 	name := cs.repository.Named().Name()
-	return cs.blobCache.GetTagInfo(ctx, name, tag)
+	return cs.repository.registry.metadataIndex.GetTagInfo(ctx, name, tag)
 }
 
-func (cs *cacheStore) GetImageItemList(ctx context.Context) ([]string, error) {
+func (cs *cacheStore) GetImageItemList(ctx context.Context) ([]distribution.ItemInfo, error) {
 	name := cs.repository.Named().Name()
 	return cs.blobCache.GetImageItemList(ctx, name)
 }
 
-func (cs *cacheStore) GetTagItemList(ctx context.Context, tag string) ([]string, error) {
+func (cs *cacheStore) GetTagItemList(ctx context.Context, tag string) ([]distribution.ItemInfo, error) {
 	name := cs.repository.Named().Name()
 	return cs.blobCache.GetTagItemList(ctx, name, tag)
 }
 
-func (cs *cacheStore) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error {
+func (cs *cacheStore) SaveImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) (distribution.Descriptor, error) {
 	name := cs.repository.Named().Name()
 	return cs.blobCache.SaveImageItem(ctx, w, r, name, item)
 }
@@ -252,17 +276,10 @@ func (cs *cacheStore) DeleteAllImageItems(ctx context.Context) error {
 
 func (cs *cacheStore) GetImageItem(ctx context.Context, w http.ResponseWriter, r *http.Request, item string) error {
 	name := cs.repository.Named().Name()
-	isp, err := pathFor(imageItemSavePathSpec{
-		name: name,
-		item: item,
-	})
-	if err != nil {
-		return err
-	}
-	return cs.blobCache.ServeItem(ctx, w, r, isp, item)
+	return cs.blobCache.ServeImageItem(ctx, w, r, name, item)
 }
 
-func (cs *cacheStore) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error {
+func (cs *cacheStore) SaveTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) (distribution.Descriptor, error) {
 	name := cs.repository.Named().Name()
 	return cs.blobCache.SaveTagItem(ctx, w, r, name, tag, item)
 
@@ -270,15 +287,7 @@ func (cs *cacheStore) SaveTagItem(ctx context.Context, w http.ResponseWriter, r
 
 func (cs *cacheStore) GetTagItem(ctx context.Context, w http.ResponseWriter, r *http.Request, tag, item string) error {
 	name := cs.repository.Named().Name()
-	sps, err := pathFor(tagItemSavePathSpec{
-		name: name,
-		tag:  tag,
-		item: item,
-	})
-	if err != nil {
-		return err
-	}
-	return cs.blobCache.ServeItem(ctx, w, r, sps, item)
+	return cs.blobCache.ServeTagItem(ctx, w, r, name, tag, item)
 }
 
 func (cs *cacheStore) DeleteTagItem(ctx context.Context, tag, item string) error {
@@ -297,15 +306,12 @@ func (cs *cacheStore) InitItem(ctx context.Context, tag string) error {
 	return cs.blobCache.InitItem(ctx, name, tag)
 }
 
-func (cs *cacheStore) SaveCatalogInfo(ctx context.Context, content []byte) error {
-	return cs.blobCache.CacheCatalogInfo(ctx, content)
-}
-
-func (cs *cacheStore) GetCatalogInfo(ctx context.Context) ([]byte, error) {
-	return cs.blobCache.GetCatalogInfo(ctx)
+func (cs *cacheStore) GetCatalogInfo(ctx context.Context) ([]distribution.ImageInfo, error) {
+	return cs.repository.registry.metadataIndex.ImageInfos(ctx)
 }
 
+// DeleteImageRepository removes the repository through the registry, see
+// (*registry).Remove.
 func (cs *cacheStore) DeleteImageRepository(ctx context.Context) error {
-	name := cs.repository.Named().Name()
-	return cs.blobCache.DeleteImageRepository(ctx, name)
+	return cs.repository.registry.Remove(ctx, cs.repository.Named())
 }
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/uuid"
+)
+
+// defaultDownloadFlushInterval is the period at which pending increments are
+// written to the storage driver when no interval is configured.
+const defaultDownloadFlushInterval = 10 * time.Second
+
+// downloadCounter implements distribution.DownloadCounter on top of the
+// storage driver. Increments are aggregated in memory and their deltas are
+// flushed periodically to the shards of the counters, as described by
+// defaultInstanceName.
+type downloadCounter struct {
+	ctx      context.Context
+	driver   driver.StorageDriver
+	instance string
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[downloadCounterKey]*distribution.DownloadStats
+	started sync.Once
+}
+
+// downloadCounterKey identifies a counter. An empty tag denotes the counter
+// of the whole repository.
+type downloadCounterKey struct {
+	name string
+	tag  string
+}
+
+var _ distribution.DownloadCounter = &downloadCounter{}
+
+// NewDownloadCounter returns a download counter backed by the storage driver.
+// Pending increments are flushed every interval, and once more when ctx is
+// done. The instance names the shard written by this process; if empty, the
+// hostname is used.
+func NewDownloadCounter(ctx context.Context, driver driver.StorageDriver, instance string, interval time.Duration) distribution.DownloadCounter {
+	if instance == "" {
+		instance = defaultInstanceName()
+	}
+	if interval <= 0 {
+		interval = defaultDownloadFlushInterval
+	}
+
+	return &downloadCounter{
+		ctx:      ctx,
+		driver:   driver,
+		instance: instance,
+		interval: interval,
+		pending:  make(map[downloadCounterKey]*distribution.DownloadStats),
+	}
+}
+
+func (dc *downloadCounter) Increment(ctx context.Context, name, tag string, at time.Time) error {
+	day := cache.DownloadDay(at)
+
+	dc.mu.Lock()
+	for _, key := range []downloadCounterKey{{name: name}, {name: name, tag: tag}} {
+		stats, ok := dc.pending[key]
+		if !ok {
+			stats = &distribution.DownloadStats{Daily: make(map[string]int)}
+			dc.pending[key] = stats
+		}
+		stats.Total++
+		stats.Daily[day]++
+	}
+	dc.mu.Unlock()
+
+	dc.started.Do(func() {
+		go dc.run()
+	})
+	return nil
+}
+
+func (dc *downloadCounter) TagDownloads(ctx context.Context, name, tag string) (distribution.DownloadStats, error) {
+	return dc.read(ctx, downloadCounterKey{name: name, tag: tag})
+}
+
+func (dc *downloadCounter) RepositoryDownloads(ctx context.Context, name string) (distribution.DownloadStats, error) {
+	return dc.read(ctx, downloadCounterKey{name: name})
+}
+
+func (dc *downloadCounter) DeleteTag(ctx context.Context, name, tag string) error {
+	key := downloadCounterKey{name: name, tag: tag}
+
+	dc.mu.Lock()
+	delete(dc.pending, key)
+	dc.mu.Unlock()
+
+	dir, err := dc.path(key)
+	if err != nil {
+		return err
+	}
+	return dc.delete(ctx, dir)
+}
+
+func (dc *downloadCounter) DeleteRepository(ctx context.Context, name string) error {
+	dc.mu.Lock()
+	for key := range dc.pending {
+		if key.name == name {
+			delete(dc.pending, key)
+		}
+	}
+	dc.mu.Unlock()
+
+	dir, err := pathFor(downloadsPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+	return dc.delete(ctx, dir)
+}
+
+// run flushes pending increments every interval until the context of the
+// counter is done.
+func (dc *downloadCounter) run() {
+	ticker := time.NewTicker(dc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dc.ctx.Done():
+			dc.flush(context.Background())
+			return
+		}
+
+		dc.flush(dc.ctx)
+	}
+}
+
+// flush adds the pending increments to the shards of this instance. Deltas
+// which cannot be written are kept pending for the next flush.
+func (dc *downloadCounter) flush(ctx context.Context) {
+	dc.mu.Lock()
+	pending := dc.pending
+	dc.pending = make(map[downloadCounterKey]*distribution.DownloadStats)
+	dc.mu.Unlock()
+
+	for key, delta := range pending {
+		if err := dc.write(ctx, key, *delta); err != nil {
+			context.GetLogger(ctx).Errorf("error flushing download counter of %s:%s: %v", key.name, key.tag, err)
+
+			dc.mu.Lock()
+			stats, ok := dc.pending[key]
+			if !ok {
+				stats = &distribution.DownloadStats{}
+				dc.pending[key] = stats
+			}
+			cache.MergeDownloadStats(stats, *delta)
+			dc.mu.Unlock()
+		}
+	}
+}
+
+func (dc *downloadCounter) write(ctx context.Context, key downloadCounterKey, delta distribution.DownloadStats) error {
+	dir, err := dc.path(key)
+	if err != nil {
+		return err
+	}
+	shard := path.Join(dir, dc.instance)
+
+	stats, err := dc.readShard(ctx, shard)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	now := time.Now()
+	cache.MergeDownloadStats(&stats, delta)
+	cache.PruneDownloadStats(&stats, now)
+
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return dc.driver.PutContent(ctx, shard, content)
+}
+
+// read sums the shards of all instances with the increments of this instance
+// which have not been flushed yet.
+func (dc *downloadCounter) read(ctx context.Context, key downloadCounterKey) (distribution.DownloadStats, error) {
+	stats := distribution.DownloadStats{
+		Daily: make(map[string]int),
+	}
+
+	dir, err := dc.path(key)
+	if err != nil {
+		return stats, err
+	}
+
+	shards, err := dc.driver.List(ctx, dir)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return stats, err
+		}
+	}
+
+	for _, shard := range shards {
+		shardStats, err := dc.readShard(ctx, shard)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				// removed concurrently
+				continue
+			}
+			return stats, err
+		}
+		cache.MergeDownloadStats(&stats, shardStats)
+	}
+
+	dc.mu.Lock()
+	if delta, ok := dc.pending[key]; ok {
+		cache.MergeDownloadStats(&stats, *delta)
+	}
+	dc.mu.Unlock()
+
+	cache.PruneDownloadStats(&stats, time.Now())
+	return stats, nil
+}
+
+func (dc *downloadCounter) readShard(ctx context.Context, shard string) (distribution.DownloadStats, error) {
+	var stats distribution.DownloadStats
+
+	content, err := dc.driver.GetContent(ctx, shard)
+	if err != nil {
+		return stats, err
+	}
+
+	if err := json.Unmarshal(content, &stats); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func (dc *downloadCounter) delete(ctx context.Context, dir string) error {
+	if err := dc.driver.Delete(ctx, dir); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dc *downloadCounter) path(key downloadCounterKey) (string, error) {
+	if key.tag == "" {
+		return pathFor(repositoryDownloadsPathSpec{
+			name: key.name,
+		})
+	}
+
+	return pathFor(tagDownloadsPathSpec{
+		name: key.name,
+		tag:  key.tag,
+	})
+}
+
+// defaultInstanceName names the shards written by this process after the
+// hostname, so that a restarted instance keeps adding to its own shards.
+//
+// The download counters, the usage of the quotas and the last use of robot
+// accounts are kept in shards: every registry instance writes its own, so
+// instances sharing a storage backend never overwrite each other's counts,
+// and reads combine the shards of all instances.
+func defaultInstanceName() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return uuid.Generate().String()
+}
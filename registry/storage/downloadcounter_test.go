@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache/cachecheck"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestDownloadCounter(t *testing.T) {
+	cachecheck.CheckDownloadCounter(t, NewDownloadCounter(context.Background(), inmemory.New(), "test", time.Hour))
+}
+
+// TestDownloadCounterFlush ensures that instances sharing a storage driver
+// see each other's flushed increments and never overwrite them.
+func TestDownloadCounterFlush(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	first := NewDownloadCounter(ctx, d, "first", time.Hour).(*downloadCounter)
+	second := NewDownloadCounter(ctx, d, "second", time.Hour).(*downloadCounter)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := first.Increment(ctx, "foo/bar", "latest", now); err != nil {
+			t.Fatalf("unexpected error incrementing counter: %v", err)
+		}
+		if err := second.Increment(ctx, "foo/bar", "latest", now); err != nil {
+			t.Fatalf("unexpected error incrementing counter: %v", err)
+		}
+	}
+
+	stats, err := second.TagDownloads(ctx, "foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+
+	if stats.Total != 3 {
+		t.Fatalf("expected only pending increments before flush: %d != 3", stats.Total)
+	}
+
+	// flush twice to ensure deltas are added to the shards only once
+	first.flush(ctx)
+	first.flush(ctx)
+	second.flush(ctx)
+
+	for _, counter := range []*downloadCounter{first, second} {
+		stats, err := counter.TagDownloads(ctx, "foo/bar", "latest")
+		if err != nil {
+			t.Fatalf("unexpected error reading counter: %v", err)
+		}
+
+		if stats.Total != 6 {
+			t.Fatalf("unexpected total after flush: %d != 6", stats.Total)
+		}
+
+		stats, err = counter.RepositoryDownloads(ctx, "foo/bar")
+		if err != nil {
+			t.Fatalf("unexpected error reading counter: %v", err)
+		}
+
+		if stats.Total != 6 {
+			t.Fatalf("unexpected repository total after flush: %d != 6", stats.Total)
+		}
+	}
+
+	// the counters must not show up as repositories of the metadata index
+	infos, err := NewMetadataIndex(d).ImageInfos(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing image infos: %v", err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("unexpected image infos: %#v", infos)
+	}
+}
@@ -73,7 +73,9 @@ func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
-	rc, err := d.Reader(ctx, path, 0)
+	// the lock is not taken again by Reader, which would deadlock with a
+	// writer waiting in between
+	rc, err := d.reader(path, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +110,11 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
 
+	return d.reader(path, offset)
+}
+
+// reader returns the reader of Reader, with the driver locked.
+func (d *driver) reader(path string, offset int64) (io.ReadCloser, error) {
 	if offset < 0 {
 		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
 	}
@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
@@ -17,24 +22,220 @@ func emit(format string, a ...interface{}) {
 
 // MarkAndSweep performs a mark and sweep of registry data
 func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, dryRun bool) error {
-	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
-	if !ok {
-		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	report, err := NewGarbageCollector(storageDriver, registry).Collect(ctx, GCOptions{
+		DryRun: dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, manifest := range report.UntaggedManifests {
+			emit("%s: untagged manifest %s", manifest.Repository, manifest.Digest)
+		}
+		emit("\n%d blobs marked, %d blobs eligible for deletion", report.Marked, len(report.Candidates))
+		for _, candidate := range report.Candidates {
+			emit("blob eligible for deletion: %s", candidate.Digest)
+		}
+	}
+	return nil
+}
+
+// GCOptions configures a garbage collection.
+type GCOptions struct {
+	// DryRun reports the blobs eligible for deletion without removing them.
+	DryRun bool
+
+	// GracePeriod protects the blobs written or linked into a repository
+	// more recently from being swept, so that the blobs of pushes in
+	// progress, which are not yet referenced by a manifest, are kept.
+	GracePeriod time.Duration
+
+	// BeforeSweep and AfterSweep, if set, are called around the sweep
+	// phase. The registry should not accept writes in between, since the
+	// blobs are marked a second time and then removed. No blob is removed
+	// if BeforeSweep fails, and AfterSweep is only called if it succeeds.
+	BeforeSweep func() error
+	AfterSweep  func()
+}
+
+// GCReport describes the outcome of a garbage collection.
+type GCReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DryRun     bool      `json:"dryRun"`
+
+	// Marked is the number of blobs referenced by a repository.
+	Marked int `json:"marked"`
+
+	// Candidates are the blobs which are not referenced by any repository
+	// and are older than the grace period.
+	Candidates []GCCandidate `json:"candidates"`
+
+	// ReclaimableBytes is the total size of the candidates.
+	ReclaimableBytes int64 `json:"reclaimableBytes"`
+
+	// Protected is the number of unreferenced blobs kept because they, or
+	// their links into a repository, are younger than the grace period.
+	Protected int `json:"protected"`
+
+	// Deleted is the number of blobs removed by the sweep.
+	Deleted int `json:"deleted"`
+
+	// UntaggedManifests are the manifests which are neither tagged nor
+	// referenced by a tagged manifest list. They are reported only, since
+	// they can still be pulled by digest.
+	UntaggedManifests []GCManifest `json:"untaggedManifests"`
+}
+
+// GCCandidate is a blob eligible for deletion.
+type GCCandidate struct {
+	Digest     digest.Digest `json:"digest"`
+	Size       int64         `json:"size"`
+	ModifiedAt time.Time     `json:"modifiedAt"`
+}
+
+// GCManifest identifies a manifest of a repository.
+type GCManifest struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+}
+
+// GarbageCollector removes the blobs not referenced by any repository. It
+// can run while the registry is serving requests: the blobs are marked
+// without blocking writes, and only the sweep has to be protected, see
+// GCOptions.
+type GarbageCollector struct {
+	driver   driver.StorageDriver
+	registry distribution.Namespace
+
+	mu   sync.Mutex
+	last *GCReport
+
+	// references caches the references read from each manifest during a
+	// collection, so that the second mark before the sweep only reads the
+	// new manifests. It is dropped when the collection completes.
+	references map[digest.Digest][]digest.Digest
+
+	// recent holds the blobs linked into a repository since cutoff, the
+	// start of the grace period of the collection. They may be referenced
+	// by a manifest being pushed, so they are kept like the blobs written
+	// since then.
+	cutoff time.Time
+	recent map[digest.Digest]struct{}
+}
+
+// NewGarbageCollector returns a garbage collector for the registry stored in
+// the driver.
+func NewGarbageCollector(storageDriver driver.StorageDriver, registry distribution.Namespace) *GarbageCollector {
+	return &GarbageCollector{
+		driver:   storageDriver,
+		registry: registry,
+	}
+}
+
+// LastReport returns the report of the last completed collection, if any.
+func (gc *GarbageCollector) LastReport() (GCReport, bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if gc.last == nil {
+		return GCReport{}, false
+	}
+	return *gc.last, true
+}
+
+// Collect runs a garbage collection. Only one collection runs at a time.
+func (gc *GarbageCollector) Collect(ctx context.Context, opts GCOptions) (GCReport, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	gc.references = make(map[digest.Digest][]digest.Digest)
+	gc.recent = make(map[digest.Digest]struct{})
+	gc.cutoff = time.Time{}
+	if opts.GracePeriod > 0 {
+		gc.cutoff = time.Now().Add(-opts.GracePeriod)
+	}
+	defer func() {
+		gc.references = nil
+		gc.recent = nil
+	}()
+
+	report := GCReport{
+		StartedAt:         time.Now().UTC(),
+		DryRun:            opts.DryRun,
+		Candidates:        []GCCandidate{},
+		UntaggedManifests: []GCManifest{},
 	}
 
 	// mark
-	markSet := make(map[digest.Digest]struct{})
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
-		if dryRun {
-			emit(repoName)
+	markSet, untagged, err := gc.mark(ctx)
+	if err != nil {
+		return GCReport{}, fmt.Errorf("failed to mark: %v\n", err)
+	}
+	report.Marked = len(markSet)
+	report.UntaggedManifests = untagged
+
+	candidates, err := gc.candidates(ctx, markSet, opts.GracePeriod, &report)
+	if err != nil {
+		return GCReport{}, err
+	}
+
+	if !opts.DryRun && len(candidates) > 0 {
+		// sweep
+		if opts.BeforeSweep != nil {
+			if err := opts.BeforeSweep(); err != nil {
+				return GCReport{}, fmt.Errorf("failed to prepare the sweep: %v", err)
+			}
+		}
+		if opts.AfterSweep != nil {
+			defer opts.AfterSweep()
 		}
 
-		var err error
+		// blobs may have been referenced since they were marked, so they
+		// are marked again now that no writes are accepted
+		markSet, _, err = gc.mark(ctx)
+		if err != nil {
+			return GCReport{}, fmt.Errorf("failed to mark: %v\n", err)
+		}
+
+		vacuum := NewVacuum(ctx, gc.driver)
+		for _, candidate := range candidates {
+			if _, ok := markSet[candidate.Digest]; ok {
+				continue
+			}
+			if _, ok := gc.recent[candidate.Digest]; ok {
+				continue
+			}
+			if err := vacuum.RemoveBlob(string(candidate.Digest)); err != nil {
+				return GCReport{}, fmt.Errorf("failed to delete blob %s: %v\n", candidate.Digest, err)
+			}
+			report.Deleted++
+		}
+	}
+
+	report.Candidates = candidates
+	report.FinishedAt = time.Now().UTC()
+	gc.last = &report
+	return report, nil
+}
+
+// mark returns the set of blobs referenced by the repositories, along with
+// the untagged manifests of each repository.
+func (gc *GarbageCollector) mark(ctx context.Context) (map[digest.Digest]struct{}, []GCManifest, error) {
+	repositoryEnumerator, ok := gc.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	markSet := make(map[digest.Digest]struct{})
+	untagged := []GCManifest{}
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
 		named, err := reference.ParseNamed(repoName)
 		if err != nil {
 			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
 		}
-		repository, err := registry.Repository(ctx, named)
+		repository, err := gc.registry.Repository(ctx, named)
 		if err != nil {
 			return fmt.Errorf("failed to construct repository: %v", err)
 		}
@@ -49,36 +250,19 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
 		}
 
+		var revisions []digest.Digest
 		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
 			// Mark the manifest's blob
-			if dryRun {
-				emit("%s: marking manifest %s ", repoName, dgst)
-			}
 			markSet[dgst] = struct{}{}
+			revisions = append(revisions, dgst)
 
-			manifest, err := manifestService.Get(ctx, dgst)
+			references, err := gc.manifestReferences(ctx, manifestService, dgst)
 			if err != nil {
-				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+				return err
 			}
-
-			descriptors := manifest.References()
-			for _, descriptor := range descriptors {
-				markSet[descriptor.Digest] = struct{}{}
-				if dryRun {
-					emit("%s: marking blob %s", repoName, descriptor.Digest)
-				}
+			for _, reference := range references {
+				markSet[reference] = struct{}{}
 			}
-
-			switch manifest.(type) {
-			case *schema2.DeserializedManifest:
-				config := manifest.(*schema2.DeserializedManifest).Config
-				if dryRun {
-					emit("%s: marking configuration %s", repoName, config.Digest)
-				}
-				markSet[config.Digest] = struct{}{}
-				break
-			}
-
 			return nil
 		})
 
@@ -88,46 +272,312 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			// error may be of type PathNotFound.
 			//
 			// In these cases we can continue marking other manifests safely.
-			if _, ok := err.(driver.PathNotFoundError); ok {
-				return nil
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
 			}
 		}
 
-		return err
+		manifests, err := gc.untaggedManifests(ctx, repository, revisions)
+		if err != nil {
+			return err
+		}
+		untagged = append(untagged, manifests...)
+
+		if err := gc.markRecentLinks(ctx, repoName); err != nil {
+			return err
+		}
+		return markItems(ctx, gc.driver, repoName, markSet)
 	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, nil, err
+		}
+		// the registry holds no repository yet
+	}
+	return markSet, untagged, nil
+}
+
+// manifestReferences returns the blobs and manifests referenced by the
+// manifest, including the configuration of schema2 manifests.
+func (gc *GarbageCollector) manifestReferences(ctx context.Context, manifestService distribution.ManifestService, dgst digest.Digest) ([]digest.Digest, error) {
+	if references, ok := gc.references[dgst]; ok {
+		return references, nil
+	}
 
+	manifest, err := manifestService.Get(ctx, dgst)
 	if err != nil {
-		return fmt.Errorf("failed to mark: %v\n", err)
+		return nil, fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+	}
+
+	var references []digest.Digest
+	for _, descriptor := range manifest.References() {
+		references = append(references, descriptor.Digest)
+	}
+	if m, ok := manifest.(*schema2.DeserializedManifest); ok {
+		references = append(references, m.Config.Digest)
+	}
+
+	gc.references[dgst] = references
+	return references, nil
+}
+
+// untaggedManifests returns the revisions of the repository which are
+// neither tagged nor referenced by a tagged manifest.
+func (gc *GarbageCollector) untaggedManifests(ctx context.Context, repository distribution.Repository, revisions []digest.Digest) ([]GCManifest, error) {
+	tagService := repository.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, err
+		}
+	}
+
+	reachable := make(map[digest.Digest]struct{})
+	for _, tag := range tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+		reachable[desc.Digest] = struct{}{}
+		// manifest lists reference manifests, which are pulled through them
+		for _, reference := range gc.references[desc.Digest] {
+			reachable[reference] = struct{}{}
+		}
 	}
 
-	// sweep
-	blobService := registry.Blobs()
-	deleteSet := make(map[digest.Digest]struct{})
-	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
-		// check if digest is in markSet. If not, delete it!
+	var untagged []GCManifest
+	for _, dgst := range revisions {
+		if _, ok := reachable[dgst]; !ok {
+			untagged = append(untagged, GCManifest{
+				Repository: repository.Named().Name(),
+				Digest:     dgst,
+			})
+		}
+	}
+	return untagged, nil
+}
+
+// candidates returns the unmarked blobs, sorted by digest. Blobs younger than
+// the grace period are counted as protected in the report.
+func (gc *GarbageCollector) candidates(ctx context.Context, markSet map[digest.Digest]struct{}, gracePeriod time.Duration, report *GCReport) ([]GCCandidate, error) {
+	var unmarked []digest.Digest
+	err := gc.registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		// check if digest is in markSet. If not, it may be deleted.
 		if _, ok := markSet[dgst]; !ok {
-			deleteSet[dgst] = struct{}{}
+			unmarked = append(unmarked, dgst)
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error enumerating blobs: %v", err)
-	}
-	if dryRun {
-		emit("\n%d blobs marked, %d blobs eligible for deletion", len(markSet), len(deleteSet))
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return []GCCandidate{}, nil
+		}
+		return nil, fmt.Errorf("error enumerating blobs: %v", err)
 	}
-	// Construct vacuum
-	vacuum := NewVacuum(ctx, storageDriver)
-	for dgst := range deleteSet {
-		if dryRun {
-			emit("blob eligible for deletion: %s", dgst)
+
+	cutoff := time.Now().Add(-gracePeriod)
+	candidates := []GCCandidate{}
+	for _, dgst := range unmarked {
+		if _, ok := gc.recent[dgst]; ok {
+			report.Protected++
 			continue
 		}
-		err = vacuum.RemoveBlob(string(dgst))
+
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := gc.driver.Stat(ctx, blobPath)
 		if err != nil {
-			return fmt.Errorf("failed to delete blob %s: %v\n", dgst, err)
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		if gracePeriod > 0 && fi.ModTime().After(cutoff) {
+			report.Protected++
+			continue
 		}
+
+		candidates = append(candidates, GCCandidate{
+			Digest:     dgst,
+			Size:       fi.Size(),
+			ModifiedAt: fi.ModTime(),
+		})
+		report.ReclaimableBytes += fi.Size()
 	}
 
+	sort.Sort(gcCandidatesByDigest(candidates))
+	return candidates, nil
+}
+
+// markRecentLinks records in recent the blobs and manifests linked into the
+// repository since the start of the grace period. The data of a blob pushed
+// long ago is older than the grace period, but its link is written when it
+// is pushed or mounted again, before the manifest referencing it.
+func (gc *GarbageCollector) markRecentLinks(ctx context.Context, repoName string) error {
+	if gc.cutoff.IsZero() {
+		return nil
+	}
+
+	root, err := pathFor(imageRootPathSpec{
+		name: repoName,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{path.Join(root, "_layers"), path.Join(root, "_manifests", "revisions")} {
+		err := Walk(ctx, gc.driver, dir, func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" || !fileInfo.ModTime().After(gc.cutoff) {
+				return nil
+			}
+
+			// links are stored at <algorithm>/<hex digest>/link
+			hexDir := path.Dir(fileInfo.Path())
+			dgst := digest.NewDigestFromHex(path.Base(path.Dir(hexDir)), path.Base(hexDir))
+			if err := dgst.Validate(); err != nil {
+				return nil
+			}
+			gc.recent[dgst] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type gcCandidatesByDigest []GCCandidate
+
+func (c gcCandidatesByDigest) Len() int           { return len(c) }
+func (c gcCandidatesByDigest) Less(i, j int) bool { return c[i].Digest < c[j].Digest }
+func (c gcCandidatesByDigest) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// markItems marks the blobs holding the content of the items of a
+// repository and of its tags.
+func markItems(ctx context.Context, storageDriver driver.StorageDriver, repoName string, markSet map[digest.Digest]struct{}) error {
+	root, err := pathFor(itemSaveRootPathSpec{
+		name: repoName,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = Walk(ctx, storageDriver, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if path.Base(fileInfo.Path()) == "_blobs" {
+				// blob links only grant access, items hold the references
+				return ErrSkipDir
+			}
+			return nil
+		}
+
+		if path.Base(path.Dir(fileInfo.Path())) != "links" {
+			return nil
+		}
+
+		content, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+
+		var link itemLink
+		if err := json.Unmarshal(content, &link); err != nil {
+			return fmt.Errorf("failed to parse item link %s: %v", fileInfo.Path(), err)
+		}
+		if link.Digest == "" {
+			return nil
+		}
+
+		markSet[link.Digest] = struct{}{}
+		return nil
+	})
+
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
 	return err
 }
+
+// sweepMarker is published in the storage while blobs are swept. It expires
+// unless it is refreshed, so that a registry crashing during a sweep does
+// not leave the others read-only.
+type sweepMarker struct {
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// PublishSweep publishes, or refreshes, the marker of a sweep started at
+// startedAt, which expires after ttl. The registries sharing the storage
+// refuse to link blobs until it is cleared or expires, see
+// EnableSweepFence. Blobs are checked before and after being linked, so a
+// link is either seen by the mark which follows the publication, or
+// refused.
+func PublishSweep(ctx context.Context, storageDriver driver.StorageDriver, startedAt time.Time, ttl time.Duration) error {
+	markerPath, err := pathFor(sweepMarkerPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(sweepMarker{
+		StartedAt: startedAt,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return storageDriver.PutContent(ctx, markerPath, content)
+}
+
+// ClearSweep removes the marker of a sweep.
+func ClearSweep(ctx context.Context, storageDriver driver.StorageDriver) error {
+	markerPath, err := pathFor(sweepMarkerPathSpec{})
+	if err != nil {
+		return err
+	}
+	return ignorePathNotFound(storageDriver.Delete(ctx, markerPath))
+}
+
+// checkSweep returns ErrSweepInProgress if a sweep is published.
+func checkSweep(ctx context.Context, storageDriver driver.StorageDriver) error {
+	published, err := SweepPublished(ctx, storageDriver)
+	if err != nil {
+		return err
+	}
+	if published {
+		return distribution.ErrSweepInProgress
+	}
+	return nil
+}
+
+// SweepPublished reports whether a registry sharing the storage is sweeping
+// blobs.
+func SweepPublished(ctx context.Context, storageDriver driver.StorageDriver) (bool, error) {
+	markerPath, err := pathFor(sweepMarkerPathSpec{})
+	if err != nil {
+		return false, err
+	}
+
+	content, err := storageDriver.GetContent(ctx, markerPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var marker sweepMarker
+	if err := json.Unmarshal(content, &marker); err != nil {
+		return false, err
+	}
+	return time.Now().Before(marker.ExpiresAt), nil
+}
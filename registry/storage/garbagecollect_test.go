@@ -4,6 +4,7 @@ import (
 	"io"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
@@ -374,3 +375,188 @@ func TestOrphanBlobDeleted(t *testing.T) {
 		}
 	}
 }
+
+func TestGarbageCollectorReport(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "report")
+	manifestService := makeManifestService(t, repo)
+
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	untagged := uploadRandomSchema2Image(t, repo)
+	orphan, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("orphan"))
+	if err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+
+	gc := NewGarbageCollector(inmemoryDriver, registry)
+
+	// the orphan blob was just written
+	report, err := gc.Collect(ctx, GCOptions{DryRun: true, GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if len(report.Candidates) != 0 || report.Protected != 1 {
+		t.Fatalf("expected the young blob to be protected: %#v", report)
+	}
+	if len(report.UntaggedManifests) != 1 || report.UntaggedManifests[0].Digest != untagged.manifestDigest {
+		t.Fatalf("unexpected untagged manifests: %v", report.UntaggedManifests)
+	}
+
+	report, err = gc.Collect(ctx, GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if len(report.Candidates) != 1 || report.Candidates[0].Digest != orphan.Digest || report.ReclaimableBytes != orphan.Size {
+		t.Fatalf("unexpected candidates: %#v", report)
+	}
+	if _, ok := allBlobs(t, registry)[orphan.Digest]; !ok {
+		t.Fatal("expected the dry run to keep the blob")
+	}
+
+	if last, ok := gc.LastReport(); !ok || last.StartedAt != report.StartedAt {
+		t.Fatalf("unexpected last report: %#v", last)
+	}
+
+	// the untagged manifest is deleted, so its blobs become candidates
+	if err := manifestService.Delete(ctx, untagged.manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+
+	var sweeping []bool
+	report, err = gc.Collect(ctx, GCOptions{
+		BeforeSweep: func() error {
+			sweeping = append(sweeping, true)
+			return nil
+		},
+		AfterSweep: func() { sweeping = append(sweeping, false) },
+	})
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if len(sweeping) != 2 || !sweeping[0] || sweeping[1] {
+		t.Fatalf("expected the sweep hooks to be called once each: %v", sweeping)
+	}
+	if report.Deleted != len(report.Candidates) || report.Deleted != 2+len(untagged.layers) {
+		t.Fatalf("unexpected number of deleted blobs: %#v", report)
+	}
+
+	blobs := allBlobs(t, registry)
+	if _, ok := blobs[orphan.Digest]; ok {
+		t.Fatal("expected the orphan blob to be deleted")
+	}
+	if _, ok := blobs[tagged.manifestDigest]; !ok {
+		t.Fatal("expected the tagged manifest to be kept")
+	}
+	for dgst := range tagged.layers {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("expected the layer %s of the tagged manifest to be kept", dgst)
+		}
+	}
+}
+
+func TestSweepMarker(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	if published, err := SweepPublished(ctx, d); err != nil || published {
+		t.Fatalf("expected no sweep before one is published: %v %v", published, err)
+	}
+
+	if err := PublishSweep(ctx, d, time.Now(), time.Hour); err != nil {
+		t.Fatalf("unexpected error publishing sweep: %v", err)
+	}
+	if published, err := SweepPublished(ctx, d); err != nil || !published {
+		t.Fatalf("expected the sweep to be published: %v %v", published, err)
+	}
+
+	if err := ClearSweep(ctx, d); err != nil {
+		t.Fatalf("unexpected error clearing sweep: %v", err)
+	}
+	if published, err := SweepPublished(ctx, d); err != nil || published {
+		t.Fatalf("expected the sweep to be cleared: %v %v", published, err)
+	}
+	if err := ClearSweep(ctx, d); err != nil {
+		t.Fatalf("unexpected error clearing a cleared sweep: %v", err)
+	}
+
+	// the marker of a registry which crashed while sweeping expires
+	if err := PublishSweep(ctx, d, time.Now(), -time.Second); err != nil {
+		t.Fatalf("unexpected error publishing sweep: %v", err)
+	}
+	if published, err := SweepPublished(ctx, d); err != nil || published {
+		t.Fatalf("expected the sweep to be expired: %v %v", published, err)
+	}
+}
+
+func TestSweepFence(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableSweepFence)
+	if err != nil {
+		t.Fatalf("failed to construct namespace: %v", err)
+	}
+	repo := makeRepository(t, registry, "foo/bar")
+
+	if err := PublishSweep(ctx, d, time.Now(), time.Hour); err != nil {
+		t.Fatalf("unexpected error publishing sweep: %v", err)
+	}
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("content")); err != distribution.ErrSweepInProgress {
+		t.Fatalf("expected blobs not to be linked during a sweep, got %v", err)
+	}
+
+	if err := ClearSweep(ctx, d); err != nil {
+		t.Fatalf("unexpected error clearing sweep: %v", err)
+	}
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("content")); err != nil {
+		t.Fatalf("unexpected error linking blob after the sweep: %v", err)
+	}
+}
+
+func TestGarbageCollectorProtectsRecentLinks(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	old := makeRepository(t, registry, "old")
+	desc, err := old.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("layer"))
+	if err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+	if err := old.Blobs(ctx).Delete(ctx, desc.Digest); err != nil {
+		t.Fatalf("failed to delete blob link: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// the blob is linked again, ahead of the manifest referencing it
+	repo := makeRepository(t, registry, "new")
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("layer")); err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+
+	gc := NewGarbageCollector(inmemoryDriver, registry)
+	report, err := gc.Collect(ctx, GCOptions{GracePeriod: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if len(report.Candidates) != 0 || report.Protected != 1 {
+		t.Fatalf("expected the recently linked blob to be protected: %#v", report)
+	}
+	if _, ok := allBlobs(t, registry)[desc.Digest]; !ok {
+		t.Fatal("expected the recently linked blob to be kept")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	report, err = gc.Collect(ctx, GCOptions{GracePeriod: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if report.Deleted != 1 {
+		t.Fatalf("expected the blob to be collected once the grace period ends: %#v", report)
+	}
+}
@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+)
+
+const (
+	// defaultItemMediaType is recorded for items uploaded without a content
+	// type.
+	defaultItemMediaType = "application/octet-stream"
+
+	// itemAnnotationHeader carries the annotations of an item, one key=value
+	// pair per header, both on upload and when the item is served.
+	itemAnnotationHeader = "Docker-Item-Annotation"
+)
+
+// itemLink is the record kept for an item of a repository or of a tag. The
+// content of the item is stored in the blob store, so identical items are
+// only stored once.
+type itemLink struct {
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	MediaType   string            `json:"mediaType"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UploadedAt  time.Time         `json:"uploadedAt"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// info returns the description of the item served to clients.
+func (link itemLink) info(item string) distribution.ItemInfo {
+	createdAt := link.CreatedAt
+	if createdAt.IsZero() {
+		// the link was written before creation times were recorded
+		createdAt = link.UploadedAt
+	}
+
+	return distribution.ItemInfo{
+		Name:        item,
+		Size:        link.Size,
+		Digest:      link.Digest,
+		MediaType:   link.MediaType,
+		CreatedAt:   createdAt,
+		ModifiedAt:  link.UploadedAt,
+		Annotations: link.Annotations,
+	}
+}
+
+// etag returns the entity tag of the item, which is its quoted digest like
+// for blobs.
+func (link itemLink) etag() string {
+	return fmt.Sprintf(`"%s"`, link.Digest)
+}
+
+// descriptor returns the blob descriptor of the item.
+func (link itemLink) descriptor() distribution.Descriptor {
+	return distribution.Descriptor{
+		Digest:    link.Digest,
+		Size:      link.Size,
+		MediaType: link.MediaType,
+	}
+}
+
+// itemBlobs returns the blob store holding the items of the repository. Item
+// blobs are linked into the repository under _items, so they can not be
+// fetched as layers.
+func (bc *blobCache) itemBlobs(ctx context.Context, name string) (*linkedBlobStore, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := bc.registry.Repository(ctx, named)
+	if err != nil {
+		return nil, err
+	}
+
+	linkPathFns := []linkPathFunc{itemBlobLinkPath}
+	return &linkedBlobStore{
+		registry:   bc.registry,
+		blobStore:  bc.registry.blobStore,
+		blobServer: bc.registry.blobServer,
+		blobAccessController: &linkedBlobStatter{
+			blobStore:   bc.registry.blobStore,
+			repository:  repo,
+			linkPathFns: linkPathFns,
+		},
+		repository:            repo,
+		ctx:                   ctx,
+		linkPathFns:           linkPathFns,
+		linkDirectoryPathSpec: itemBlobsPathSpec{name: name},
+		deleteEnabled:         true,
+		sweepFence:            bc.registry.sweepFence,
+	}, nil
+}
+
+// putItem stores the request body in the blob store and writes the item link
+// at linkpath. The media type of the item is taken from the Content-Type
+// header of the request and its annotations from the Docker-Item-Annotation
+// headers. The If-Match and If-None-Match headers of the request are checked
+// against the current item, both before and after the upload, so concurrent
+// updates are not lost.
+func (bc *blobCache) putItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name, item, linkpath, legacypath string) (distribution.Descriptor, error) {
+	annotations, err := parseItemAnnotations(r.Header[itemAnnotationHeader])
+	if err != nil {
+		return distribution.Descriptor{}, distribution.ErrItemInvalid{Reason: err}
+	}
+
+	if _, err := bc.checkItemPreconditions(ctx, r, name, item, linkpath, legacypath); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	limit := bc.registry.maxItemSize
+	if limit > 0 && r.ContentLength > limit {
+		return distribution.Descriptor{}, distribution.ErrItemTooLarge{Size: r.ContentLength, Limit: limit}
+	}
+
+	var body io.Reader = r.Body
+	if limit > 0 {
+		// read one byte past the limit to detect larger bodies
+		body = io.LimitReader(r.Body, limit+1)
+	}
+
+	mediaType := r.Header.Get("Content-Type")
+	if mediaType == "" {
+		mediaType = defaultItemMediaType
+	}
+
+	link, err := bc.ingestItem(ctx, name, func(dest io.Writer) error {
+		return writeItem(ctx, w, r, body, dest)
+	}, func(size int64) error {
+		if limit > 0 && size > limit {
+			return distribution.ErrItemTooLarge{Size: size, Limit: limit}
+		}
+		return nil
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	current, err := bc.checkItemPreconditions(ctx, r, name, item, linkpath, legacypath)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	link.MediaType = mediaType
+	link.Annotations = annotations
+	link.UploadedAt = time.Now().UTC()
+	link.CreatedAt = link.UploadedAt
+	if current != nil {
+		link.CreatedAt = current.info(item).CreatedAt
+	}
+	if err := bc.putItemLink(ctx, linkpath, link); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return link.descriptor(), nil
+}
+
+// checkItemPreconditions checks the If-Match and If-None-Match headers of an
+// item upload against the current item, which is returned. A nil link is
+// returned if the item does not exist yet.
+func (bc *blobCache) checkItemPreconditions(ctx context.Context, r *http.Request, name, item, linkpath, legacypath string) (*itemLink, error) {
+	var current *itemLink
+	link, err := bc.getItemLink(ctx, name, linkpath, legacypath)
+	switch err.(type) {
+	case nil:
+		current = &link
+	case driver.PathNotFoundError:
+	default:
+		return nil, err
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if current == nil || !etagMatches(ifMatch, current.etag()) {
+			return nil, distribution.ErrItemPreconditionFailed{Item: item, Condition: "If-Match"}
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if current != nil && etagMatches(ifNoneMatch, current.etag()) {
+			return nil, distribution.ErrItemPreconditionFailed{Item: item, Condition: "If-None-Match"}
+		}
+	}
+
+	return current, nil
+}
+
+// itemInfos returns the descriptions of the listed items. The paths function
+// returns the link path and the legacy path of an item. Items removed since
+// the list was written are skipped.
+func (bc *blobCache) itemInfos(ctx context.Context, name string, items []string, paths func(item string) (string, string, error)) ([]distribution.ItemInfo, error) {
+	infos := make([]distribution.ItemInfo, 0, len(items))
+	for _, item := range items {
+		linkpath, legacypath, err := paths(item)
+		if err != nil {
+			return nil, err
+		}
+
+		link, err := bc.getItemLink(ctx, name, linkpath, legacypath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		infos = append(infos, link.info(item))
+	}
+	return infos, nil
+}
+
+// ingestItem writes an item into the blob store of the repository. The copy
+// function writes the content, and check may reject it based on its size
+// before it is committed.
+func (bc *blobCache) ingestItem(ctx context.Context, name string, copy func(dest io.Writer) error, check func(size int64) error) (itemLink, error) {
+	blobs, err := bc.itemBlobs(ctx, name)
+	if err != nil {
+		return itemLink{}, err
+	}
+
+	bw, err := blobs.Create(ctx)
+	if err != nil {
+		return itemLink{}, err
+	}
+
+	digester := digest.Canonical.New()
+	if err := copy(io.MultiWriter(bw, digester.Hash())); err != nil {
+		bw.Cancel(ctx)
+		return itemLink{}, err
+	}
+
+	if err := check(bw.Size()); err != nil {
+		bw.Cancel(ctx)
+		return itemLink{}, err
+	}
+
+	desc, err := bw.Commit(ctx, distribution.Descriptor{
+		Digest: digester.Digest(),
+		Size:   bw.Size(),
+	})
+	if err != nil {
+		bw.Cancel(ctx)
+		return itemLink{}, err
+	}
+
+	return itemLink{
+		Digest: desc.Digest,
+		Size:   desc.Size,
+	}, nil
+}
+
+func (bc *blobCache) putItemLink(ctx context.Context, linkpath string, link itemLink) error {
+	content, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	return bc.driver.PutContent(ctx, linkpath, content)
+}
+
+// getItemLink reads the item link at linkpath. Items saved before they were
+// kept in the blob store are migrated from legacypath on first access. If
+// neither exists, a driver.PathNotFoundError is returned.
+func (bc *blobCache) getItemLink(ctx context.Context, name, linkpath, legacypath string) (itemLink, error) {
+	content, err := bc.driver.GetContent(ctx, linkpath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return bc.migrateLegacyItem(ctx, name, linkpath, legacypath)
+		}
+		return itemLink{}, err
+	}
+
+	var link itemLink
+	if err := json.Unmarshal(content, &link); err != nil {
+		return itemLink{}, err
+	}
+	return link, nil
+}
+
+// migrateLegacyItem moves an item stored in place at legacypath into the
+// blob store and links it at linkpath.
+func (bc *blobCache) migrateLegacyItem(ctx context.Context, name, linkpath, legacypath string) (itemLink, error) {
+	fi, err := bc.driver.Stat(ctx, legacypath)
+	if err != nil {
+		return itemLink{}, err
+	}
+
+	fr, err := newFileReader(ctx, bc.driver, legacypath, fi.Size())
+	if err != nil {
+		return itemLink{}, err
+	}
+	defer fr.Close()
+
+	link, err := bc.ingestItem(ctx, name, func(dest io.Writer) error {
+		_, err := io.Copy(dest, fr)
+		return err
+	}, func(size int64) error {
+		if size != fi.Size() {
+			return distribution.ErrBlobInvalidLength
+		}
+		return nil
+	})
+	if err != nil {
+		return itemLink{}, err
+	}
+
+	link.MediaType = defaultItemMediaType
+	link.UploadedAt = fi.ModTime().UTC()
+	if err := bc.putItemLink(ctx, linkpath, link); err != nil {
+		return itemLink{}, err
+	}
+
+	context.GetLogger(ctx).Infof("migrated item %s to blob %s", legacypath, link.Digest)
+	if err := bc.driver.Delete(ctx, legacypath); err != nil {
+		context.GetLogger(ctx).Errorf("error removing migrated item %s: %v", legacypath, err)
+	}
+	return link, nil
+}
+
+// serveItem serves the content of the item through the blob server, so that
+// ETag, range requests and redirects behave as they do for layers. Item names
+// are mutable, so clients must revalidate their cached copies, using the
+// If-None-Match or If-Modified-Since headers.
+func (bc *blobCache) serveItem(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, link itemLink) error {
+	blobs, err := bc.itemBlobs(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	desc, err := blobs.Stat(ctx, link.Digest) // access check
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", link.etag())
+	w.Header().Set("Last-Modified", link.UploadedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	keys := make([]string, 0, len(link.Annotations))
+	for key := range link.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.Header().Add(itemAnnotationHeader, key+"="+link.Annotations[key])
+	}
+
+	if itemNotModified(r, link) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", link.MediaType)
+	return bc.registry.blobServer.ServeBlob(ctx, w, r, desc.Digest)
+}
+
+// itemNotModified reports whether the client holds the current content of
+// the item, according to the If-None-Match or, in its absence, the
+// If-Modified-Since header of the request.
+func itemNotModified(r *http.Request, link itemLink) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, link.etag())
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// Last-Modified has a resolution of one second
+		return !link.UploadedAt.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// etagMatches reports whether the list of entity tags of an If-Match or
+// If-None-Match header matches the entity tag. Weak tags are compared as
+// strong ones, since items are only ever served with strong tags.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseItemAnnotations parses the values of the Docker-Item-Annotation
+// headers of an upload, each holding a key=value pair.
+func parseItemAnnotations(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	annotations := make(map[string]string, len(values))
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("annotation must be a key=value pair: %q", value)
+		}
+		annotations[strings.TrimSpace(value[:i])] = strings.TrimSpace(value[i+1:])
+	}
+	return annotations, nil
+}
+
+// deleteItemLink removes the item link at linkpath, or the legacy item at
+// legacypath. The blob of the item is left for the garbage collector. If
+// neither exists, a driver.PathNotFoundError is returned.
+func (bc *blobCache) deleteItemLink(ctx context.Context, linkpath, legacypath string) error {
+	err := bc.driver.Delete(ctx, linkpath)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return bc.driver.Delete(ctx, legacypath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := bc.driver.Delete(ctx, legacypath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemBlobLinkPath provides the path to the link of an item blob.
+func itemBlobLinkPath(name string, dgst digest.Digest) (string, error) {
+	return pathFor(itemBlobLinkPathSpec{name: name, digest: dgst})
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func saveItemRequest(content, mediaType string) *http.Request {
+	r := httptest.NewRequest("POST", "/", bytes.NewBufferString(content))
+	if mediaType != "" {
+		r.Header.Set("Content-Type", mediaType)
+	}
+	return r
+}
+
+func TestItemsAreDeduplicated(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/bar")
+	caches := repo.Caches(ctx)
+
+	content := "# foo/bar\n"
+	imageDesc, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), saveItemRequest(content, "text/markdown"), "README.md")
+	if err != nil {
+		t.Fatalf("unexpected error saving image item: %v", err)
+	}
+	if err := caches.InitItem(ctx, "latest"); err != nil {
+		t.Fatalf("unexpected error initializing tag items: %v", err)
+	}
+	tagDesc, err := caches.SaveTagItem(ctx, httptest.NewRecorder(), saveItemRequest(content, ""), "latest", "README.md")
+	if err != nil {
+		t.Fatalf("unexpected error saving tag item: %v", err)
+	}
+
+	if expected := digest.FromBytes([]byte(content)); imageDesc.Digest != expected || tagDesc.Digest != expected {
+		t.Fatalf("unexpected item digests: %s, %s != %s", imageDesc.Digest, tagDesc.Digest, expected)
+	}
+	if imageDesc.Size != int64(len(content)) || imageDesc.MediaType != "text/markdown" {
+		t.Fatalf("unexpected image item descriptor: %#v", imageDesc)
+	}
+	if tagDesc.MediaType != defaultItemMediaType {
+		t.Fatalf("unexpected tag item media type: %q != %q", tagDesc.MediaType, defaultItemMediaType)
+	}
+
+	if blobs := allBlobs(t, registry); len(blobs) != 1 {
+		t.Fatalf("expected the items to share one blob, got %d", len(blobs))
+	}
+
+	w := httptest.NewRecorder()
+	if err := caches.GetImageItem(ctx, w, httptest.NewRequest("GET", "/", nil), "README.md"); err != nil {
+		t.Fatalf("unexpected error getting image item: %v", err)
+	}
+	if w.Body.String() != content {
+		t.Fatalf("unexpected item content: %q != %q", w.Body.String(), content)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/markdown" {
+		t.Fatalf("unexpected item content type: %q", contentType)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"`+imageDesc.Digest.String()+`"` {
+		t.Fatalf("unexpected item etag: %q", etag)
+	}
+
+	names, err := caches.GetTagItemList(ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error listing tag items: %v", err)
+	}
+	if len(names) != 1 || names[0].Name != "README.md" {
+		t.Fatalf("unexpected tag items: %v", names)
+	}
+
+	if err := caches.DeleteImageItem(ctx, "README.md"); err != nil {
+		t.Fatalf("unexpected error deleting image item: %v", err)
+	}
+	err = caches.GetImageItem(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "README.md")
+	if _, ok := err.(distribution.ErrItemUnknown); !ok {
+		t.Fatalf("expected ErrItemUnknown getting a deleted item, got %v", err)
+	}
+	if _, ok := caches.DeleteImageItem(ctx, "README.md").(distribution.ErrItemUnknown); !ok {
+		t.Fatal("expected ErrItemUnknown deleting a deleted item")
+	}
+}
+
+func TestItemMaxSize(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), ItemMaxSize(4))
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+	caches := makeRepository(t, registry, "foo/bar").Caches(ctx)
+
+	if _, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), saveItemRequest("four", ""), "small"); err != nil {
+		t.Fatalf("unexpected error saving item within the limit: %v", err)
+	}
+
+	r := saveItemRequest("large", "")
+	_, err = caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "large")
+	if _, ok := err.(distribution.ErrItemTooLarge); !ok {
+		t.Fatalf("expected ErrItemTooLarge, got %v", err)
+	}
+
+	// the limit also holds when the length is not announced
+	r = saveItemRequest("large", "")
+	r.ContentLength = -1
+	_, err = caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "large")
+	if _, ok := err.(distribution.ErrItemTooLarge); !ok {
+		t.Fatalf("expected ErrItemTooLarge without content length, got %v", err)
+	}
+}
+
+func TestLegacyItemMigration(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	caches := makeRepository(t, registry, "foo/bar").Caches(ctx)
+
+	legacypath, err := pathFor(imageItemSavePathSpec{name: "foo/bar", item: "report.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := `{"vulnerabilities":[]}`
+	if err := d.PutContent(ctx, legacypath, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := caches.GetImageItemList(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing legacy items: %v", err)
+	}
+	if len(names) != 1 || names[0].Name != "report.json" {
+		t.Fatalf("unexpected legacy items: %v", names)
+	}
+
+	w := httptest.NewRecorder()
+	if err := caches.GetImageItem(ctx, w, httptest.NewRequest("GET", "/", nil), "report.json"); err != nil {
+		t.Fatalf("unexpected error getting legacy item: %v", err)
+	}
+	if w.Body.String() != content {
+		t.Fatalf("unexpected legacy item content: %q != %q", w.Body.String(), content)
+	}
+
+	if _, err := d.Stat(ctx, legacypath); err == nil {
+		t.Fatal("expected the legacy item to be removed after migration")
+	}
+	if _, ok := allBlobs(t, registry)[digest.FromBytes([]byte(content))]; !ok {
+		t.Fatal("expected the legacy item to be moved into the blob store")
+	}
+}
+
+func TestGarbageCollectionKeepsItems(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/bar")
+	caches := repo.Caches(ctx)
+
+	// a layer link makes the repository part of the catalog
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("layer")); err != nil {
+		t.Fatalf("unexpected error putting layer: %v", err)
+	}
+
+	desc, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), saveItemRequest("readme", ""), "README")
+	if err != nil {
+		t.Fatalf("unexpected error saving item: %v", err)
+	}
+
+	if err := MarkAndSweep(ctx, d, registry, false); err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[desc.Digest]; !ok {
+		t.Fatal("expected the item blob to survive garbage collection")
+	}
+
+	if err := caches.DeleteImageItem(ctx, "README"); err != nil {
+		t.Fatalf("unexpected error deleting item: %v", err)
+	}
+	if err := MarkAndSweep(ctx, d, registry, false); err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[desc.Digest]; ok {
+		t.Fatal("expected the blob of the deleted item to be collected")
+	}
+}
+
+func TestItemMetadata(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	caches := makeRepository(t, registry, "foo/bar").Caches(ctx)
+
+	r := saveItemRequest("v1", "text/plain")
+	r.Header.Add("Docker-Item-Annotation", "owner=qa")
+	r.Header.Add("Docker-Item-Annotation", "stage = draft")
+	if _, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "notes"); err != nil {
+		t.Fatalf("unexpected error saving item: %v", err)
+	}
+
+	infos, err := caches.GetImageItemList(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing items: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("unexpected items: %v", infos)
+	}
+	created := infos[0]
+	if created.Name != "notes" || created.Size != 2 || created.MediaType != "text/plain" || created.Digest != digest.FromBytes([]byte("v1")) {
+		t.Fatalf("unexpected item info: %#v", created)
+	}
+	if created.Annotations["owner"] != "qa" || created.Annotations["stage"] != "draft" {
+		t.Fatalf("unexpected item annotations: %v", created.Annotations)
+	}
+	if created.CreatedAt.IsZero() || !created.CreatedAt.Equal(created.ModifiedAt) {
+		t.Fatalf("unexpected item times: %v, %v", created.CreatedAt, created.ModifiedAt)
+	}
+
+	if _, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), saveItemRequest("v2", ""), "notes"); err != nil {
+		t.Fatalf("unexpected error updating item: %v", err)
+	}
+	infos, err = caches.GetImageItemList(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing items: %v", err)
+	}
+	if !infos[0].CreatedAt.Equal(created.CreatedAt) || infos[0].ModifiedAt.Before(created.ModifiedAt) {
+		t.Fatalf("expected the creation time to be kept on update: %#v", infos[0])
+	}
+	if infos[0].Annotations != nil {
+		t.Fatalf("expected the annotations to be replaced on update: %v", infos[0].Annotations)
+	}
+
+	r = saveItemRequest("v3", "")
+	r.Header.Add("Docker-Item-Annotation", "invalid")
+	_, err = caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "notes")
+	if _, ok := err.(distribution.ErrItemInvalid); !ok {
+		t.Fatalf("expected ErrItemInvalid for a malformed annotation, got %v", err)
+	}
+}
+
+func TestItemConditionalRequests(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	caches := makeRepository(t, registry, "foo/bar").Caches(ctx)
+
+	r := saveItemRequest("v1", "")
+	r.Header.Set("If-None-Match", "*")
+	desc, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "config")
+	if err != nil {
+		t.Fatalf("unexpected error creating item: %v", err)
+	}
+	etag := `"` + desc.Digest.String() + `"`
+
+	r = saveItemRequest("v2", "")
+	r.Header.Set("If-None-Match", "*")
+	_, err = caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "config")
+	if _, ok := err.(distribution.ErrItemPreconditionFailed); !ok {
+		t.Fatalf("expected ErrItemPreconditionFailed creating an existing item, got %v", err)
+	}
+
+	r = saveItemRequest("v2", "")
+	r.Header.Set("If-Match", `"`+digest.FromBytes([]byte("other")).String()+`"`)
+	_, err = caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "config")
+	if _, ok := err.(distribution.ErrItemPreconditionFailed); !ok {
+		t.Fatalf("expected ErrItemPreconditionFailed for a stale If-Match, got %v", err)
+	}
+
+	r = saveItemRequest("v2", "")
+	r.Header.Set("If-Match", etag)
+	if _, err := caches.SaveImageItem(ctx, httptest.NewRecorder(), r, "config"); err != nil {
+		t.Fatalf("unexpected error updating item with a matching If-Match: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	if err := caches.GetImageItem(ctx, w, r, "config"); err != nil {
+		t.Fatalf("unexpected error getting item: %v", err)
+	}
+	etag = w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || w.Body.String() != "v2" || lastModified == "" {
+		t.Fatalf("unexpected item response: %d %q, Last-Modified %q", w.Code, w.Body.String(), lastModified)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	if err := caches.GetImageItem(ctx, w, r, "config"); err != nil {
+		t.Fatalf("unexpected error getting item: %v", err)
+	}
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304 for a matching If-None-Match, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+	if err := caches.GetImageItem(ctx, w, r, "config"); err != nil {
+		t.Fatalf("unexpected error getting item: %v", err)
+	}
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for an unmodified item, got %d", w.Code)
+	}
+}
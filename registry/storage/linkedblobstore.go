@@ -31,6 +31,13 @@ type linkedBlobStore struct {
 	deleteEnabled          bool
 	resumableDigestEnabled bool
 
+	// quotas, if set, counts the blobs linked into the repository against
+	// its quota.
+	quotas distribution.QuotaService
+
+	// sweepFence, if set, refuses to link blobs while a sweep is published.
+	sweepFence bool
+
 	// linkPathFns specifies one or more path functions allowing one to
 	// control the repository blob link set to which the blob store
 	// dispatches. This is required because manifest and layer blobs have not
@@ -227,7 +234,7 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 	}
 
 	// Ensure the blob is available for deletion
-	_, err := lbs.blobAccessController.Stat(ctx, dgst)
+	desc, err := lbs.blobAccessController.Stat(ctx, dgst)
 	if err != nil {
 		return err
 	}
@@ -237,6 +244,10 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 		return err
 	}
 
+	if lbs.quotas != nil {
+		return lbs.quotas.Add(ctx, lbs.repository.Named().Name(), -desc.Size)
+	}
+
 	return nil
 }
 
@@ -335,7 +346,7 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 
 // linkBlob links a valid, written blob into the registry under the named
 // repository for the upload controller.
-func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution.Descriptor, aliases ...digest.Digest) error {
+func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution.Descriptor, aliases ...digest.Digest) (err error) {
 	dgsts := append([]digest.Digest{canonical.Digest}, aliases...)
 
 	// TODO(stevvooe): Need to write out mediatype for only canonical hash
@@ -348,6 +359,34 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 	// only use the first link
 	linkPathFn := lbs.linkPathFns[0]
 
+	if lbs.quotas != nil {
+		counted, err := lbs.countLink(ctx, linkPathFn, canonical)
+		if err != nil {
+			return err
+		}
+		if counted {
+			defer func() {
+				if err != nil {
+					// the blob was not linked after all
+					lbs.quotas.Add(ctx, lbs.repository.Named().Name(), -canonical.Size)
+				}
+			}()
+		}
+	}
+
+	if lbs.sweepFence {
+		if err := checkSweep(ctx, lbs.blobStore.driver); err != nil {
+			return err
+		}
+		// a sweep published while the links are written may have marked
+		// the repository before them, and so remove the blob
+		defer func() {
+			if err == nil {
+				err = checkSweep(ctx, lbs.blobStore.driver)
+			}
+		}()
+	}
+
 	for _, dgst := range dgsts {
 		if _, seen := seenDigests[dgst]; seen {
 			continue
@@ -367,6 +406,28 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 	return nil
 }
 
+// countLink adds the blob to the usage of the repository unless it is
+// already linked, returning ErrQuotaExceeded if the repository has no room
+// for it. It returns true if the blob was counted.
+func (lbs *linkedBlobStore) countLink(ctx context.Context, linkPathFn linkPathFunc, canonical distribution.Descriptor) (bool, error) {
+	name := lbs.repository.Named().Name()
+	linkPath, err := linkPathFn(name, canonical.Digest)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := lbs.blobStore.driver.Stat(ctx, linkPath); err == nil {
+		return false, nil
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return false, err
+	}
+
+	if err := lbs.quotas.Add(ctx, name, canonical.Size); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 type linkedBlobStatter struct {
 	*blobStore
 	repository distribution.Repository
@@ -123,9 +123,21 @@ func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest
 	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 }
 
-// Delete removes the revision of the specified manfiest.
+// Delete removes the revision of the specified manifest. ErrTagProtected is
+// returned if a tag pointing to it is protected against deletion.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	context.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
+
+	if len(ms.repository.registry.tagRules) > 0 {
+		tags, err := ms.repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := ms.repository.registry.checkUntag(ms.repository.Named().Name(), tags...); err != nil {
+			return err
+		}
+	}
+
 	return ms.blobStore.Delete(ctx, dgst)
 }
 
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/docker/distribution/registry/storage/driver"
+)
+
+// metadataIndex implements distribution.MetadataIndex on top of the storage
+// driver. Each tag record and each repository summary is kept in a file of
+// its own, so that updates to one repository never rewrite the records of
+// another.
+type metadataIndex struct {
+	driver driver.StorageDriver
+}
+
+var _ distribution.MetadataIndex = &metadataIndex{}
+
+// NewMetadataIndex returns a metadata index backed by the storage driver.
+func NewMetadataIndex(driver driver.StorageDriver) distribution.MetadataIndex {
+	return &metadataIndex{
+		driver: driver,
+	}
+}
+
+func (mi *metadataIndex) GetTagInfo(ctx context.Context, name, tag string) (distribution.TagInfo, error) {
+	tp, err := pathFor(metadataTagInfoPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return distribution.TagInfo{}, err
+	}
+
+	content, err := mi.driver.GetContent(ctx, tp)
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return distribution.TagInfo{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return distribution.TagInfo{}, err
+	}
+
+	var info distribution.TagInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return distribution.TagInfo{}, err
+	}
+	return info, nil
+}
+
+func (mi *metadataIndex) PutTagInfo(ctx context.Context, info distribution.TagInfo) error {
+	if err := cache.ValidateTagInfo(info); err != nil {
+		return err
+	}
+
+	tp, err := pathFor(metadataTagInfoPathSpec{
+		name: info.Name,
+		tag:  info.Tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	if err := mi.driver.PutContent(ctx, tp, content); err != nil {
+		return err
+	}
+	return mi.refreshImageInfo(ctx, info.Name)
+}
+
+func (mi *metadataIndex) DeleteTagInfo(ctx context.Context, name, tag string) error {
+	tp, err := pathFor(metadataTagInfoPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := mi.driver.Delete(ctx, tp); err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return distribution.ErrTagUnknown{Tag: tag}
+		}
+		return err
+	}
+	return mi.refreshImageInfo(ctx, name)
+}
+
+func (mi *metadataIndex) TagInfos(ctx context.Context, name string) ([]distribution.TagInfo, error) {
+	tp, err := pathFor(metadataTagInfosPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := mi.driver.List(ctx, tp)
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return []distribution.TagInfo{}, nil
+		}
+		return nil, err
+	}
+
+	infos := make([]distribution.TagInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := mi.GetTagInfo(ctx, name, path.Base(entry))
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				// removed concurrently
+				continue
+			}
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (mi *metadataIndex) GetImageInfo(ctx context.Context, name string) (distribution.ImageInfo, error) {
+	ip, err := pathFor(metadataImageInfoPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return distribution.ImageInfo{}, err
+	}
+	return mi.readImageInfo(ctx, name, ip)
+}
+
+func (mi *metadataIndex) DeleteImageInfo(ctx context.Context, name string) error {
+	ip, err := pathFor(metadataImageInfoPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+	tp, err := pathFor(metadataTagInfosPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{tp, ip} {
+		if err := mi.driver.Delete(ctx, p); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (mi *metadataIndex) ImageInfos(ctx context.Context) ([]distribution.ImageInfo, error) {
+	root, err := pathFor(metadataRepositoriesRootPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []distribution.ImageInfo{}
+	err = Walk(ctx, mi.driver, root, func(fileInfo driver.FileInfo) error {
+		filePath := fileInfo.Path()
+		_, file := path.Split(filePath)
+
+		if fileInfo.IsDir() {
+			if file == "_tags" || file == "_downloads" {
+				return ErrSkipDir
+			}
+			return nil
+		}
+
+		if file != "_info.json" {
+			return nil
+		}
+
+		name := strings.TrimPrefix(path.Dir(filePath), root+"/")
+		info, err := mi.readImageInfo(ctx, name, filePath)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+				return nil
+			}
+			return err
+		}
+		infos = append(infos, info)
+		return nil
+	})
+
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return infos, nil
+		}
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (mi *metadataIndex) readImageInfo(ctx context.Context, name, ip string) (distribution.ImageInfo, error) {
+	content, err := mi.driver.GetContent(ctx, ip)
+	if err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			return distribution.ImageInfo{}, distribution.ErrRepositoryUnknown{Name: name}
+		}
+		return distribution.ImageInfo{}, err
+	}
+
+	var info distribution.ImageInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return distribution.ImageInfo{}, err
+	}
+	return info, nil
+}
+
+// refreshImageInfo rebuilds the summary of the repository from its tag
+// records. The storage driver offers no transaction, so an instance may
+// write a summary built from records another instance has just updated,
+// after the summary of the other instance. The records are read again once
+// the summary is written, and the summary rebuilt until it matches them, so
+// that the last update of the records is reflected in the summary. Without
+// a shared lock, a summary may still miss an update made between these
+// reads by an instance whose own summary was written first; the next update
+// of the repository, or the warm-up, repairs it.
+func (mi *metadataIndex) refreshImageInfo(ctx context.Context, name string) error {
+	ip, err := pathFor(metadataImageInfoPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	previous, err := mi.GetImageInfo(ctx, name)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return err
+		}
+	}
+
+	content, err := mi.summarize(ctx, name, previous)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < cache.MaxSummaryRefreshes; attempt++ {
+		if err := mi.driver.PutContent(ctx, ip, content); err != nil {
+			return err
+		}
+
+		current, err := mi.summarize(ctx, name, previous)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(current, content) {
+			return nil
+		}
+		content = current
+	}
+	return fmt.Errorf("failed to refresh the image info of %s: tag records updated concurrently", name)
+}
+
+// summarize returns the summary of the repository built from its current tag
+// records.
+func (mi *metadataIndex) summarize(ctx context.Context, name string, previous distribution.ImageInfo) ([]byte, error) {
+	tags, err := mi.TagInfos(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cache.SummarizeImageInfo(name, tags, previous))
+}
+
+// legacyMetadataIndex imports the tag records kept next to the tags, before
+// the metadata index, into the index the first time they are read. The
+// warm-up reads the record of every tag, and so imports them all.
+type legacyMetadataIndex struct {
+	distribution.MetadataIndex
+	driver driver.StorageDriver
+}
+
+func (li *legacyMetadataIndex) GetTagInfo(ctx context.Context, name, tag string) (distribution.TagInfo, error) {
+	info, err := li.MetadataIndex.GetTagInfo(ctx, name, tag)
+	if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		return info, err
+	}
+
+	legacy, imported, lerr := li.importTagInfo(ctx, name, tag)
+	if lerr != nil {
+		context.GetLogger(ctx).Errorf("error importing the legacy tag info of %s:%s: %v", name, tag, lerr)
+		return info, err
+	}
+	if !imported {
+		return info, err
+	}
+	return legacy, nil
+}
+
+// importTagInfo moves the legacy record of the tag, if any, into the index.
+func (li *legacyMetadataIndex) importTagInfo(ctx context.Context, name, tag string) (distribution.TagInfo, bool, error) {
+	lp, err := pathFor(legacyTagInfoPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return distribution.TagInfo{}, false, err
+	}
+
+	content, err := li.driver.GetContent(ctx, lp)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.TagInfo{}, false, nil
+		}
+		return distribution.TagInfo{}, false, err
+	}
+
+	// the legacy records are a subset of the current ones
+	var info distribution.TagInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return distribution.TagInfo{}, false, err
+	}
+	info.Name, info.Tag = name, tag
+	if err := li.MetadataIndex.PutTagInfo(ctx, info); err != nil {
+		return distribution.TagInfo{}, false, err
+	}
+
+	// Deleting a tag record must not bring back its legacy record.
+	if err := li.driver.Delete(ctx, lp); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return distribution.TagInfo{}, false, err
+		}
+	}
+	context.GetLogger(ctx).Infof("imported the legacy tag info of %s:%s", name, tag)
+	return info, true, nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/cache/cachecheck"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestMetadataIndex(t *testing.T) {
+	cachecheck.CheckMetadataIndex(t, NewMetadataIndex(inmemory.New()))
+}
+
+func TestLegacyTagInfo(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	created := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	content, err := json.Marshal(map[string]interface{}{
+		"name":          "foo/bar",
+		"tag":           "latest",
+		"createTime":    created,
+		"downloadCount": 42,
+		"size":          1024,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error marshaling the legacy tag info: %v", err)
+	}
+	lp, err := pathFor(legacyTagInfoPathSpec{name: "foo/bar", tag: "latest"})
+	if err != nil {
+		t.Fatalf("unexpected error building the legacy path: %v", err)
+	}
+	if err := d.PutContent(ctx, lp, content); err != nil {
+		t.Fatalf("unexpected error writing the legacy tag info: %v", err)
+	}
+
+	index := registry.MetadataIndex()
+	info, err := index.GetTagInfo(ctx, "foo/bar", "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting the legacy tag info: %v", err)
+	}
+	if info.DownloadCount != 42 || info.Size != 1024 || !info.CreateTime.Equal(created) {
+		t.Fatalf("unexpected tag info: %#v", info)
+	}
+
+	imageinfo, err := index.GetImageInfo(ctx, "foo/bar")
+	if err != nil || len(imageinfo.Tags) != 1 || imageinfo.DownloadCount != 42 {
+		t.Fatalf("expected the legacy tag info to be imported: %#v, %v", imageinfo, err)
+	}
+	if _, err := d.GetContent(ctx, lp); err == nil {
+		t.Fatalf("expected the legacy tag info to be removed once imported")
+	}
+
+	// a deleted record is not imported again
+	if err := index.DeleteTagInfo(ctx, "foo/bar", "latest"); err != nil {
+		t.Fatalf("unexpected error deleting the tag info: %v", err)
+	}
+	if _, err := index.GetTagInfo(ctx, "foo/bar", "latest"); err == nil {
+		t.Fatalf("expected the deleted tag info to be unknown")
+	}
+	if _, err := index.GetTagInfo(ctx, "foo/bar", "other"); err == nil {
+		t.Fatalf("expected a tag without records to be unknown")
+	}
+}
+
+func TestMetadataIndexStaleSummary(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	index := NewMetadataIndex(d).(*metadataIndex)
+
+	if err := index.PutTagInfo(ctx, distribution.TagInfo{Name: "foo/bar", Tag: "a"}); err != nil {
+		t.Fatalf("unexpected error putting tag info: %v", err)
+	}
+
+	// another instance writes a summary missing a record it has not seen
+	stale, err := json.Marshal(distribution.ImageInfo{Name: "foo/bar"})
+	if err != nil {
+		t.Fatalf("unexpected error marshaling the stale summary: %v", err)
+	}
+	ip, err := pathFor(metadataImageInfoPathSpec{name: "foo/bar"})
+	if err != nil {
+		t.Fatalf("unexpected error building the summary path: %v", err)
+	}
+	if err := d.PutContent(ctx, ip, stale); err != nil {
+		t.Fatalf("unexpected error writing the stale summary: %v", err)
+	}
+
+	if err := index.refreshImageInfo(ctx, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error refreshing the summary: %v", err)
+	}
+	info, err := index.GetImageInfo(ctx, "foo/bar")
+	if err != nil || len(info.Tags) != 1 {
+		t.Fatalf("expected the summary to be repaired: %#v, %v", info, err)
+	}
+}
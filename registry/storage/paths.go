@@ -26,6 +26,7 @@ const (
 //		<root>/v2
 //			-> repositories/
 //				->catalog.json
+//				->warmup.json
 // 				-><name>/
 // 					-> _manifests/
 // 						revisions
@@ -90,11 +91,55 @@ const (
 // 	imageItemInfoPathSpec:                        <root>/v2/uploarepositories/<name>/info.json
 // 	tagItemSavePathSpec:                          <root>/v2/repositories/<name>/tags/<tag>/items
 // 	tagItemInfoPathSpec:                          <root>/v2/repositories/<name>/tags/<tag>/info.json
+// 	imageItemLinksPathSpec:                       <root>/v2/repositories/<name>/_items/links/
+// 	imageItemLinkPathSpec:                        <root>/v2/repositories/<name>/_items/links/<item>
+// 	tagItemLinksPathSpec:                         <root>/v2/repositories/<name>/_items/tags/<tag>/links/
+// 	tagItemLinkPathSpec:                          <root>/v2/repositories/<name>/_items/tags/<tag>/links/<item>
+// 	itemBlobsPathSpec:                            <root>/v2/repositories/<name>/_items/_blobs/
+// 	itemBlobLinkPathSpec:                         <root>/v2/repositories/<name>/_items/_blobs/<algorithm>/<hex digest>/link
+//
+//	Metadata index:
+//
+// 	metadataRepositoriesRootPathSpec:     <root>/v2/metadata/repositories/
+// 	metadataImageInfoPathSpec:            <root>/v2/metadata/repositories/<name>/_info.json
+// 	metadataTagInfosPathSpec:             <root>/v2/metadata/repositories/<name>/_tags/
+// 	metadataTagInfoPathSpec:              <root>/v2/metadata/repositories/<name>/_tags/<tag>
+// 	legacyTagInfoPathSpec:                <root>/v2/repositories/<name>/_manifests/tags/<tag>/info.json
+// 	downloadsPathSpec:                    <root>/v2/metadata/repositories/<name>/_downloads/
+// 	repositoryDownloadsPathSpec:          <root>/v2/metadata/repositories/<name>/_downloads/repository/
+// 	tagDownloadsPathSpec:                 <root>/v2/metadata/repositories/<name>/_downloads/tags/<tag>/
 //
 // 	Blobs:
 //
 // 	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
 //
+//	Caches:
+//
+// 	catalogCachePathSpec:                 <root>/v2/repositories/catalog.json
+// 	tagListCachePathSpec:                 <root>/v2/repositories/<name>/_manifests/tags/taglist.json
+// 	warmUpCheckpointPathSpec:             <root>/v2/repositories/warmup.json
+//
+//	Repository removals:
+//
+// 	repositoryRemovalsPathSpec:           <root>/v2/_journal/removals/
+// 	repositoryRemovalPathSpec:            <root>/v2/_journal/removals/<name>/journal.json
+//
+//	Quotas:
+//
+// 	quotasPathSpec:                       <root>/v2/_quotas/quotas.json
+// 	quotaUsagesPathSpec:                  <root>/v2/_quotas/usage/<name>/
+// 	quotaUsageShardPathSpec:              <root>/v2/_quotas/usage/<name>/_shards/<instance>
+//
+//	Robot accounts:
+//
+// 	robotAccountsPathSpec:                <root>/v2/_robots/
+// 	robotAccountPathSpec:                 <root>/v2/_robots/<name>/account.json
+// 	robotLastUsedPathSpec:                <root>/v2/_robots/<name>/_lastused/<instance>
+//
+//	Maintenance:
+//
+// 	sweepMarkerPathSpec:                  <root>/v2/_maintenance/sweep.json
+//
 //	Uploads:
 //
 // 	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
@@ -187,11 +232,70 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 		return path.Join(root, "tags", v.tag, "save"), nil
 
+	case imageItemLinksPathSpec:
+		root, err := pathFor(itemSaveRootPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, "links"), nil
+
+	case imageItemLinkPathSpec:
+		root, err := pathFor(imageItemLinksPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, v.item), nil
+
+	case tagItemLinksPathSpec:
+		root, err := pathFor(itemSaveRootPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, "tags", v.tag, "links"), nil
+
+	case tagItemLinkPathSpec:
+		root, err := pathFor(tagItemLinksPathSpec{
+			name: v.name,
+			tag:  v.tag,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, v.item), nil
+
+	case itemBlobsPathSpec:
+		root, err := pathFor(itemSaveRootPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, "_blobs"), nil
+
+	case itemBlobLinkPathSpec:
+		root, err := pathFor(itemBlobsPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(path.Join(append([]string{root}, components...)...), "link"), nil
+
 	case catalogCachePathSpec:
 		return path.Join(append(repoPrefix, "catalog.json")...), nil
 
-	case catalogInfoPathSpec:
-		return path.Join(append(repoPrefix, "info.json")...), nil
+	case warmUpCheckpointPathSpec:
+		return path.Join(append(repoPrefix, "warmup.json")...), nil
 
 	case tagListCachePathSpec:
 		root, err := pathFor(manifestTagsPathSpec{
@@ -205,10 +309,25 @@ func pathFor(spec pathSpec) (string, error) {
 	case imageRootPathSpec:
 		return path.Join(append(repoPrefix, v.name)...), nil
 
-	case imageInfoCachePathSpec:
-		return path.Join(append(repoPrefix, v.name, "_manifests", "info.json")...), nil
+	case metadataRepositoriesRootPathSpec:
+		return path.Join(append(rootPrefix, "metadata", "repositories")...), nil
+
+	case metadataImageInfoPathSpec:
+		return path.Join(append(rootPrefix, "metadata", "repositories", v.name, "_info.json")...), nil
+
+	case metadataTagInfosPathSpec:
+		return path.Join(append(rootPrefix, "metadata", "repositories", v.name, "_tags")...), nil
 
-	case tagInfoCachePathSpec:
+	case metadataTagInfoPathSpec:
+		root, err := pathFor(metadataTagInfosPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, v.tag), nil
+
+	case legacyTagInfoPathSpec:
 		root, err := pathFor(manifestTagPathSpec{
 			name: v.name,
 			tag:  v.tag,
@@ -218,6 +337,27 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 		return path.Join(root, "info.json"), nil
 
+	case downloadsPathSpec:
+		return path.Join(append(rootPrefix, "metadata", "repositories", v.name, "_downloads")...), nil
+
+	case repositoryDownloadsPathSpec:
+		root, err := pathFor(downloadsPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, "repository"), nil
+
+	case tagDownloadsPathSpec:
+		root, err := pathFor(downloadsPathSpec{
+			name: v.name,
+		})
+		if err != nil {
+			return "", err
+		}
+		return path.Join(root, "tags", v.tag), nil
+
 	case manifestRevisionsPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_manifests", "revisions")...), nil
 
@@ -348,6 +488,24 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case repositoryRemovalsPathSpec:
+		return path.Join(append(rootPrefix, "_journal", "removals")...), nil
+	case repositoryRemovalPathSpec:
+		return path.Join(append(rootPrefix, "_journal", "removals", v.name, "journal.json")...), nil
+	case quotasPathSpec:
+		return path.Join(append(rootPrefix, "_quotas", "quotas.json")...), nil
+	case quotaUsagesPathSpec:
+		return path.Join(append(rootPrefix, "_quotas", "usage", v.name)...), nil
+	case quotaUsageShardPathSpec:
+		return path.Join(append(rootPrefix, "_quotas", "usage", v.name, "_shards", v.instance)...), nil
+	case robotAccountsPathSpec:
+		return path.Join(append(rootPrefix, "_robots")...), nil
+	case robotAccountPathSpec:
+		return path.Join(append(rootPrefix, "_robots", v.name, "account.json")...), nil
+	case robotLastUsedPathSpec:
+		return path.Join(append(rootPrefix, "_robots", v.name, "_lastused", v.instance)...), nil
+	case sweepMarkerPathSpec:
+		return path.Join(append(rootPrefix, "_maintenance", "sweep.json")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -366,10 +524,12 @@ type catalogCachePathSpec struct {
 
 func (catalogCachePathSpec) pathSpec() {}
 
-type catalogInfoPathSpec struct {
+// warmUpCheckpointPathSpec describes the path of the progress of the cache
+// warm-up run when the registry starts.
+type warmUpCheckpointPathSpec struct {
 }
 
-func (catalogInfoPathSpec) pathSpec() {}
+func (warmUpCheckpointPathSpec) pathSpec() {}
 
 type tagListCachePathSpec struct {
 	name string
@@ -383,18 +543,70 @@ type imageRootPathSpec struct {
 
 func (imageRootPathSpec) pathSpec() {}
 
-type imageInfoCachePathSpec struct {
+// metadataRepositoriesRootPathSpec describes the root of the storage driver
+// backed metadata index.
+type metadataRepositoriesRootPathSpec struct{}
+
+func (metadataRepositoriesRootPathSpec) pathSpec() {}
+
+// metadataImageInfoPathSpec describes the path of the repository summary
+// kept by the metadata index.
+type metadataImageInfoPathSpec struct {
+	name string
+}
+
+func (metadataImageInfoPathSpec) pathSpec() {}
+
+// metadataTagInfosPathSpec describes the directory holding the tag records of
+// a repository in the metadata index.
+type metadataTagInfosPathSpec struct {
+	name string
+}
+
+func (metadataTagInfosPathSpec) pathSpec() {}
+
+// metadataTagInfoPathSpec describes the path of a single tag record in the
+// metadata index.
+type metadataTagInfoPathSpec struct {
 	name string
+	tag  string
 }
 
-func (imageInfoCachePathSpec) pathSpec() {}
+func (metadataTagInfoPathSpec) pathSpec() {}
 
-type tagInfoCachePathSpec struct {
+// legacyTagInfoPathSpec describes the path where tag records were kept before
+// the metadata index, next to the tag.
+type legacyTagInfoPathSpec struct {
 	name string
 	tag  string
 }
 
-func (tagInfoCachePathSpec) pathSpec() {}
+func (legacyTagInfoPathSpec) pathSpec() {}
+
+// downloadsPathSpec describes the directory holding the download counters of
+// a repository.
+type downloadsPathSpec struct {
+	name string
+}
+
+func (downloadsPathSpec) pathSpec() {}
+
+// repositoryDownloadsPathSpec describes the directory holding the download
+// counter shards of a repository, one per registry instance.
+type repositoryDownloadsPathSpec struct {
+	name string
+}
+
+func (repositoryDownloadsPathSpec) pathSpec() {}
+
+// tagDownloadsPathSpec describes the directory holding the download counter
+// shards of a tag, one per registry instance.
+type tagDownloadsPathSpec struct {
+	name string
+	tag  string
+}
+
+func (tagDownloadsPathSpec) pathSpec() {}
 
 type imageItemSavePathSpec struct {
 	name, item string
@@ -432,6 +644,53 @@ func (tagItemListPathSpec) pathSpec() {}
 
 func (tagItemInfoPathSpec) pathSpec() {}
 
+// imageItemLinksPathSpec describes the directory holding the item links of a
+// repository. Each link records the digest, size and media type of an item,
+// whose content is kept in the blob store.
+type imageItemLinksPathSpec struct {
+	name string
+}
+
+func (imageItemLinksPathSpec) pathSpec() {}
+
+// imageItemLinkPathSpec describes the link of a repository item.
+type imageItemLinkPathSpec struct {
+	name, item string
+}
+
+func (imageItemLinkPathSpec) pathSpec() {}
+
+// tagItemLinksPathSpec describes the directory holding the item links of a
+// tag.
+type tagItemLinksPathSpec struct {
+	name, tag string
+}
+
+func (tagItemLinksPathSpec) pathSpec() {}
+
+// tagItemLinkPathSpec describes the link of a tag item.
+type tagItemLinkPathSpec struct {
+	name, tag, item string
+}
+
+func (tagItemLinkPathSpec) pathSpec() {}
+
+// itemBlobsPathSpec describes the directory holding the links of the item
+// blobs of a repository, which grant the repository access to them.
+type itemBlobsPathSpec struct {
+	name string
+}
+
+func (itemBlobsPathSpec) pathSpec() {}
+
+// itemBlobLinkPathSpec describes the link of an item blob of a repository.
+type itemBlobLinkPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (itemBlobLinkPathSpec) pathSpec() {}
+
 type itemSaveRootPathSpec struct {
 	name string
 }
@@ -661,3 +920,70 @@ func digestFromPath(digestPath string) (digest.Digest, error) {
 	dgst := digest.NewDigestFromHex(algo, hex)
 	return dgst, dgst.Validate()
 }
+
+// repositoryRemovalsPathSpec describes the directory holding the journals of
+// the repository removals in progress.
+type repositoryRemovalsPathSpec struct{}
+
+func (repositoryRemovalsPathSpec) pathSpec() {}
+
+// repositoryRemovalPathSpec describes the journal of the removal of the named
+// repository. The journal lives outside of the repository, so it survives the
+// removal of the repository directory.
+type repositoryRemovalPathSpec struct {
+	name string
+}
+
+func (repositoryRemovalPathSpec) pathSpec() {}
+
+// quotasPathSpec describes the file holding the quotas set through the
+// admin API, which override the configured ones.
+type quotasPathSpec struct{}
+
+func (quotasPathSpec) pathSpec() {}
+
+// quotaUsagesPathSpec describes the directory holding the usage of the named
+// repository and of the repositories below it. An empty name denotes the
+// usage of all repositories.
+type quotaUsagesPathSpec struct {
+	name string
+}
+
+func (quotaUsagesPathSpec) pathSpec() {}
+
+// quotaUsageShardPathSpec describes the bytes stored by the named repository
+// as counted by one registry instance.
+type quotaUsageShardPathSpec struct {
+	name     string
+	instance string
+}
+
+func (quotaUsageShardPathSpec) pathSpec() {}
+
+// robotAccountsPathSpec describes the directory holding the robot accounts.
+type robotAccountsPathSpec struct{}
+
+func (robotAccountsPathSpec) pathSpec() {}
+
+// robotAccountPathSpec describes the file holding the named robot account,
+// with the hash of its secret.
+type robotAccountPathSpec struct {
+	name string
+}
+
+func (robotAccountPathSpec) pathSpec() {}
+
+// robotLastUsedPathSpec describes the last use of the named robot account as
+// recorded by one registry instance.
+type robotLastUsedPathSpec struct {
+	name     string
+	instance string
+}
+
+func (robotLastUsedPathSpec) pathSpec() {}
+
+// sweepMarkerPathSpec describes the file published while a garbage
+// collection removes blobs, during which every registry refuses writes.
+type sweepMarkerPathSpec struct{}
+
+func (sweepMarkerPathSpec) pathSpec() {}
@@ -0,0 +1,623 @@
+package storage
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/storage/driver"
+)
+
+// quotaReconcileInterval is the age after which the usage of a quota cached
+// by an instance is read again from the shards of all instances.
+const quotaReconcileInterval = 30 * time.Second
+
+// quotaService implements distribution.QuotaService on top of the storage
+// driver. The usage of a repository is kept in shards, as described by
+// defaultInstanceName. Quotas are checked against the usage of their prefix
+// cached by this instance: the usage added by this instance is counted
+// immediately, and the usage added by other instances when the cache is
+// reconciled with the shards in the background. Concurrent pushes to several
+// instances may thus exceed a quota by the size of the blobs they push within
+// quotaReconcileInterval.
+type quotaService struct {
+	driver     driver.StorageDriver
+	statter    distribution.BlobStatter
+	instance   string
+	configured []distribution.Quota
+
+	// mu guards the maps and the cached quotas below. It is never held
+	// during storage I/O.
+	mu sync.Mutex
+
+	// repositories maps the names of repositories to the lock of their
+	// shard.
+	repositories map[string]*repositoryShard
+
+	// prefixes maps the prefixes of quotas to their cached usage.
+	prefixes map[string]*prefixUsage
+
+	// cached are the quotas read at cachedAt. The generation changes when
+	// they are updated by this instance.
+	cached     []distribution.Quota
+	cachedAt   time.Time
+	generation uint64
+
+	// overridesMu serializes the updates of the quotas set through
+	// SetQuota by this instance.
+	overridesMu sync.Mutex
+}
+
+// repositoryShard serializes the updates of the shard of a repository by
+// this instance.
+type repositoryShard struct {
+	mu sync.Mutex
+
+	// counted is true once the repository is known to have shards.
+	counted bool
+}
+
+// prefixUsage is the cached usage of the repositories covered by a prefix.
+type prefixUsage struct {
+	usage  int64
+	readAt time.Time
+
+	// reconciling is true while the usage is read from the shards, and
+	// added sums the usage added by this instance in the meantime.
+	reconciling bool
+	added       int64
+}
+
+// usageShard is the usage of a repository counted by an instance.
+type usageShard struct {
+	Usage int64 `json:"usage"`
+}
+
+var _ distribution.QuotaService = &quotaService{}
+
+// NewQuotaService returns a quota service keeping usage in the storage
+// driver. The configured quotas apply unless overridden through SetQuota.
+// The instance names the shards written by this process; if empty, the
+// hostname is used.
+func NewQuotaService(driver driver.StorageDriver, instance string, quotas []distribution.Quota) distribution.QuotaService {
+	if instance == "" {
+		instance = defaultInstanceName()
+	}
+
+	return &quotaService{
+		driver:       driver,
+		statter:      &blobStatter{driver: driver},
+		instance:     instance,
+		configured:   quotas,
+		repositories: make(map[string]*repositoryShard),
+		prefixes:     make(map[string]*prefixUsage),
+	}
+}
+
+func (qs *quotaService) Quota(ctx context.Context, name string) (distribution.QuotaUsage, bool, error) {
+	covering, err := qs.covering(ctx, name)
+	if err != nil || len(covering) == 0 {
+		return distribution.QuotaUsage{}, false, err
+	}
+
+	// covering is sorted by prefix, so the longest prefix comes last
+	quota := covering[len(covering)-1]
+	usage, err := qs.usage(ctx, quota.Prefix)
+	if err != nil {
+		return distribution.QuotaUsage{}, false, err
+	}
+	return distribution.QuotaUsage{Quota: quota, Usage: usage}, true, nil
+}
+
+func (qs *quotaService) Quotas(ctx context.Context) ([]distribution.QuotaUsage, error) {
+	quotas, err := qs.quotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]distribution.QuotaUsage, len(quotas))
+	for i, quota := range quotas {
+		usage, err := qs.usage(ctx, quota.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		usages[i] = distribution.QuotaUsage{Quota: quota, Usage: usage}
+	}
+	return usages, nil
+}
+
+func (qs *quotaService) SetQuota(ctx context.Context, quota distribution.Quota) error {
+	qs.overridesMu.Lock()
+	defer qs.overridesMu.Unlock()
+	defer qs.expireQuotas()
+
+	overrides, err := qs.overrides(ctx)
+	if err != nil {
+		return err
+	}
+
+	set := false
+	for i := range overrides {
+		if overrides[i].Prefix == quota.Prefix {
+			overrides[i] = quota
+			set = true
+		}
+	}
+	if !set {
+		overrides = append(overrides, quota)
+	}
+	return qs.writeOverrides(ctx, overrides)
+}
+
+func (qs *quotaService) DeleteQuota(ctx context.Context, prefix string) error {
+	qs.overridesMu.Lock()
+	defer qs.overridesMu.Unlock()
+	defer qs.expireQuotas()
+
+	overrides, err := qs.overrides(ctx)
+	if err != nil {
+		return err
+	}
+
+	var kept []distribution.Quota
+	for _, quota := range overrides {
+		if quota.Prefix != prefix {
+			kept = append(kept, quota)
+		}
+	}
+	return qs.writeOverrides(ctx, kept)
+}
+
+func (qs *quotaService) Usage(ctx context.Context, name string) (int64, error) {
+	usage, _, err := qs.repositoryUsage(ctx, name)
+	return usage, err
+}
+
+func (qs *quotaService) Add(ctx context.Context, name string, size int64) error {
+	shard := qs.repositoryShard(name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Repositories pushed to before quotas were enabled have no shards yet.
+	if !shard.counted {
+		_, counted, err := qs.repositoryUsage(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !counted {
+			total, err := qs.recount(ctx, name)
+			if err != nil {
+				return err
+			}
+			qs.added(name, total)
+		}
+		shard.counted = true
+	}
+
+	if size > 0 {
+		covering, err := qs.cachedCovering(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, quota := range covering {
+			if quota.Limit <= 0 {
+				continue
+			}
+			usage, err := qs.prefixUsage(ctx, quota.Prefix)
+			if err != nil {
+				return err
+			}
+			if usage+size > quota.Limit {
+				return distribution.ErrQuotaExceeded{
+					Name:  name,
+					Quota: distribution.QuotaUsage{Quota: quota, Usage: usage},
+					Size:  size,
+				}
+			}
+		}
+	}
+
+	usage, err := qs.readShard(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := qs.writeShard(ctx, name, usage+size); err != nil {
+		return err
+	}
+	qs.added(name, size)
+	return nil
+}
+
+func (qs *quotaService) Recount(ctx context.Context, name string) (int64, error) {
+	shard := qs.repositoryShard(name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	defer qs.expirePrefixes(name)
+
+	total, err := qs.recount(ctx, name)
+	if err == nil {
+		shard.counted = true
+	}
+	return total, err
+}
+
+func (qs *quotaService) DeleteRepository(ctx context.Context, name string) error {
+	shard := qs.repositoryShard(name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	defer qs.expirePrefixes(name)
+
+	shards, err := pathFor(quotaUsageShardPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+	shard.counted = false
+	return ignorePathNotFound(qs.driver.Delete(ctx, shards))
+}
+
+// repositoryShard returns the lock of the shard of the named repository.
+func (qs *quotaService) repositoryShard(name string) *repositoryShard {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	shard, ok := qs.repositories[name]
+	if !ok {
+		shard = &repositoryShard{}
+		qs.repositories[name] = shard
+	}
+	return shard
+}
+
+// prefixUsage returns the cached usage of the repositories covered by
+// prefix. The usage is read from the shards the first time, and reconciled
+// with them in the background once older than quotaReconcileInterval.
+func (qs *quotaService) prefixUsage(ctx context.Context, prefix string) (int64, error) {
+	qs.mu.Lock()
+	if cached, ok := qs.prefixes[prefix]; ok {
+		if !cached.reconciling && time.Since(cached.readAt) >= quotaReconcileInterval {
+			cached.reconciling = true
+			cached.added = 0
+			go qs.reconcile(context.Background(), prefix, cached)
+		}
+		usage := cached.usage
+		qs.mu.Unlock()
+		return usage, nil
+	}
+	qs.mu.Unlock()
+
+	usage, err := qs.usage(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if cached, ok := qs.prefixes[prefix]; ok {
+		// read concurrently
+		return cached.usage, nil
+	}
+	qs.prefixes[prefix] = &prefixUsage{usage: usage, readAt: time.Now()}
+	return usage, nil
+}
+
+// reconcile reads the usage of the repositories covered by prefix from the
+// shards of all instances. The usage added by this instance meanwhile is
+// added to it, even if the shards already count some of it: the usage is
+// overestimated rather than underestimated until the next reconciliation.
+func (qs *quotaService) reconcile(ctx context.Context, prefix string, cached *prefixUsage) {
+	usage, err := qs.usage(ctx, prefix)
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	cached.reconciling = false
+	if err != nil {
+		// retried by the next check of the quota
+		context.GetLogger(ctx).Errorf("error reconciling usage of quota %q: %v", prefix, err)
+		return
+	}
+	cached.usage = usage + cached.added
+	cached.added = 0
+	cached.readAt = time.Now()
+}
+
+// added adds size to the cached usage of the prefixes covering the named
+// repository.
+func (qs *quotaService) added(name string, size int64) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	for prefix, cached := range qs.prefixes {
+		if (distribution.Quota{Prefix: prefix}).Covers(name) {
+			cached.usage += size
+			if cached.reconciling {
+				cached.added += size
+			}
+		}
+	}
+}
+
+// expirePrefixes has the cached usage of the prefixes covering the named
+// repository reconciled by the next check of their quota.
+func (qs *quotaService) expirePrefixes(name string) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	for prefix, cached := range qs.prefixes {
+		if (distribution.Quota{Prefix: prefix}).Covers(name) {
+			cached.readAt = time.Time{}
+		}
+	}
+}
+
+// cachedCovering returns the quotas covering the named repository, sorted by
+// prefix. The quotas are read again once older than quotaReconcileInterval,
+// so that quotas set through other instances apply.
+func (qs *quotaService) cachedCovering(ctx context.Context, name string) ([]distribution.Quota, error) {
+	qs.mu.Lock()
+	quotas, cachedAt, generation := qs.cached, qs.cachedAt, qs.generation
+	qs.mu.Unlock()
+
+	if quotas == nil || time.Since(cachedAt) >= quotaReconcileInterval {
+		var err error
+		if quotas, err = qs.quotas(ctx); err != nil {
+			return nil, err
+		}
+		qs.mu.Lock()
+		if qs.generation == generation {
+			qs.cached, qs.cachedAt = quotas, time.Now()
+		}
+		qs.mu.Unlock()
+	}
+
+	var covering []distribution.Quota
+	for _, quota := range quotas {
+		if quota.Covers(name) {
+			covering = append(covering, quota)
+		}
+	}
+	return covering, nil
+}
+
+// expireQuotas has the quotas read again by the next check.
+func (qs *quotaService) expireQuotas() {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	qs.cached = nil
+	qs.generation++
+}
+
+// recount sums the sizes of the blobs linked into the named repository and
+// sets the shard of this instance so that the shards of all instances add up
+// to it.
+func (qs *quotaService) recount(ctx context.Context, name string) (int64, error) {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+
+	linked := make(map[digest.Digest]struct{})
+	for _, dir := range []string{"_layers", path.Join("_manifests", "revisions")} {
+		err := Walk(ctx, qs.driver, path.Join(root, name, dir), func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+				return nil
+			}
+			content, err := qs.driver.GetContent(ctx, fileInfo.Path())
+			if err != nil {
+				return err
+			}
+			dgst, err := digest.ParseDigest(string(content))
+			if err != nil {
+				return err
+			}
+			linked[dgst] = struct{}{}
+			return nil
+		})
+		if err := ignorePathNotFound(err); err != nil {
+			return 0, err
+		}
+	}
+
+	var total int64
+	for dgst := range linked {
+		desc, err := qs.statter.Stat(ctx, dgst)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				continue
+			}
+			return 0, err
+		}
+		total += desc.Size
+	}
+
+	usage, _, err := qs.repositoryUsage(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	shard, err := qs.readShard(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return total, qs.writeShard(ctx, name, shard+total-usage)
+}
+
+// repositoryUsage sums the shards of the named repository. If no instance
+// has counted the repository yet, counted is false.
+func (qs *quotaService) repositoryUsage(ctx context.Context, name string) (usage int64, counted bool, err error) {
+	dir, err := pathFor(quotaUsageShardPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	shards, err := qs.driver.List(ctx, dir)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	for _, shard := range shards {
+		shardUsage, err := qs.readShardPath(ctx, shard)
+		if err != nil {
+			return 0, false, err
+		}
+		usage += shardUsage
+	}
+	return usage, len(shards) > 0, nil
+}
+
+// usage sums the shards of the repositories covered by prefix.
+func (qs *quotaService) usage(ctx context.Context, prefix string) (int64, error) {
+	dir, err := pathFor(quotaUsagesPathSpec{
+		name: prefix,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var usage int64
+	err = Walk(ctx, qs.driver, dir, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(path.Dir(fileInfo.Path())) != "_shards" {
+			return nil
+		}
+		shardUsage, err := qs.readShardPath(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		usage += shardUsage
+		return nil
+	})
+	return usage, ignorePathNotFound(err)
+}
+
+func (qs *quotaService) readShard(ctx context.Context, name string) (int64, error) {
+	shard, err := pathFor(quotaUsageShardPathSpec{
+		name:     name,
+		instance: qs.instance,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return qs.readShardPath(ctx, shard)
+}
+
+// readShardPath reads the usage in a shard. A missing shard, which may have
+// been removed concurrently, counts as zero.
+func (qs *quotaService) readShardPath(ctx context.Context, shard string) (int64, error) {
+	content, err := qs.driver.GetContent(ctx, shard)
+	if err != nil {
+		return 0, ignorePathNotFound(err)
+	}
+
+	var s usageShard
+	if err := json.Unmarshal(content, &s); err != nil {
+		return 0, err
+	}
+	return s.Usage, nil
+}
+
+func (qs *quotaService) writeShard(ctx context.Context, name string, usage int64) error {
+	shard, err := pathFor(quotaUsageShardPathSpec{
+		name:     name,
+		instance: qs.instance,
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(usageShard{Usage: usage})
+	if err != nil {
+		return err
+	}
+	return qs.driver.PutContent(ctx, shard, content)
+}
+
+// quotas returns the configured quotas merged with the ones set through
+// SetQuota, sorted by prefix.
+func (qs *quotaService) quotas(ctx context.Context) ([]distribution.Quota, error) {
+	overrides, err := qs.overrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byPrefix := make(map[string]distribution.Quota)
+	for _, quota := range qs.configured {
+		byPrefix[quota.Prefix] = quota
+	}
+	for _, quota := range overrides {
+		byPrefix[quota.Prefix] = quota
+	}
+
+	quotas := make([]distribution.Quota, 0, len(byPrefix))
+	for _, quota := range byPrefix {
+		quotas = append(quotas, quota)
+	}
+	sort.Sort(quotasByPrefix(quotas))
+	return quotas, nil
+}
+
+// covering returns the quotas covering the named repository, sorted by
+// prefix.
+func (qs *quotaService) covering(ctx context.Context, name string) ([]distribution.Quota, error) {
+	quotas, err := qs.quotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var covering []distribution.Quota
+	for _, quota := range quotas {
+		if quota.Covers(name) {
+			covering = append(covering, quota)
+		}
+	}
+	return covering, nil
+}
+
+func (qs *quotaService) overrides(ctx context.Context) ([]distribution.Quota, error) {
+	p, err := pathFor(quotasPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := qs.driver.GetContent(ctx, p)
+	if err != nil {
+		return nil, ignorePathNotFound(err)
+	}
+
+	var quotas []distribution.Quota
+	if err := json.Unmarshal(content, &quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+func (qs *quotaService) writeOverrides(ctx context.Context, quotas []distribution.Quota) error {
+	p, err := pathFor(quotasPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(quotas)
+	if err != nil {
+		return err
+	}
+	return qs.driver.PutContent(ctx, p, content)
+}
+
+// quotasByPrefix sorts quotas by prefix, so that a quota comes before the
+// quotas of the repositories below it.
+type quotasByPrefix []distribution.Quota
+
+func (q quotasByPrefix) Len() int           { return len(q) }
+func (q quotasByPrefix) Less(i, j int) bool { return q[i].Prefix < q[j].Prefix }
+func (q quotasByPrefix) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
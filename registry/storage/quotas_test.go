@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	quotas := NewQuotaService(d, "first", []distribution.Quota{{Prefix: "team", Limit: 100}})
+	registry, err := NewRegistry(ctx, d, EnableDelete, Quotas(quotas))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	blobs := func(name string) distribution.BlobStore {
+		named, _ := reference.ParseNamed(name)
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository %s: %v", name, err)
+		}
+		return repo.Blobs(ctx)
+	}
+	put := func(name string, size int, fill byte) (distribution.Descriptor, error) {
+		p := make([]byte, size)
+		for i := range p {
+			p[i] = fill
+		}
+		return blobs(name).Put(ctx, "application/octet-stream", p)
+	}
+	usage := func(name string, expected int64) {
+		u, err := quotas.Usage(ctx, name)
+		if err != nil {
+			t.Fatalf("unexpected error reading usage of %s: %v", name, err)
+		}
+		if u != expected {
+			t.Fatalf("unexpected usage of %s: %d != %d", name, u, expected)
+		}
+	}
+
+	desc, err := put("team/app", 60, 'a')
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	// a blob already linked into the repository is counted once
+	if _, err := put("team/app", 60, 'a'); err != nil {
+		t.Fatalf("unexpected error putting blob again: %v", err)
+	}
+	usage("team/app", 60)
+
+	// the quota of the namespace covers all of its repositories
+	if _, err := put("team/other", 50, 'b'); err == nil {
+		t.Fatalf("expected the quota of the namespace to be exceeded")
+	} else if exceeded, ok := err.(distribution.ErrQuotaExceeded); !ok || exceeded.Quota.Prefix != "team" || exceeded.Quota.Usage != 60 {
+		t.Fatalf("unexpected error exceeding quota: %v", err)
+	}
+	if _, err := put("team/other", 40, 'b'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if _, err := put("teamwork", 200, 'c'); err != nil {
+		t.Fatalf("unexpected error putting blob in a repository without quota: %v", err)
+	}
+	usage("team/other", 40)
+
+	quota, ok, err := quotas.Quota(ctx, "team/app")
+	if err != nil || !ok || quota.Prefix != "team" || quota.Usage != 100 {
+		t.Fatalf("unexpected quota of team/app: %#v, %v, %v", quota, ok, err)
+	}
+
+	// every quota covering a repository is enforced
+	if err := quotas.SetQuota(ctx, distribution.Quota{Prefix: "team", Limit: 200}); err != nil {
+		t.Fatalf("unexpected error setting quota: %v", err)
+	}
+	if err := quotas.SetQuota(ctx, distribution.Quota{Prefix: "team/app", Limit: 70}); err != nil {
+		t.Fatalf("unexpected error setting quota: %v", err)
+	}
+	if _, err := put("team/app", 20, 'd'); err == nil {
+		t.Fatalf("expected the quota of the repository to be exceeded")
+	}
+	if _, err := put("team/other", 20, 'd'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// deleting a blob frees its room
+	if err := blobs("team/app").Delete(ctx, desc.Digest); err != nil {
+		t.Fatalf("unexpected error deleting blob: %v", err)
+	}
+	usage("team/app", 0)
+	if _, err := put("team/app", 20, 'd'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	if err := quotas.DeleteQuota(ctx, "team"); err != nil {
+		t.Fatalf("unexpected error deleting quota: %v", err)
+	}
+	all, err := quotas.Quotas(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing quotas: %v", err)
+	}
+	if len(all) != 2 || all[0].Prefix != "team" || all[0].Limit != 100 || all[0].Usage != 80 || all[1].Prefix != "team/app" {
+		t.Fatalf("unexpected quotas: %#v", all)
+	}
+
+	// another instance recounting the repository agrees with the shards
+	// written so far
+	second := NewQuotaService(d, "second", nil)
+	if err := second.Add(ctx, "team/other", 0); err != nil {
+		t.Fatalf("unexpected error adding usage: %v", err)
+	}
+	for _, qs := range []distribution.QuotaService{quotas, second} {
+		total, err := qs.Recount(ctx, "team/other")
+		if err != nil {
+			t.Fatalf("unexpected error recounting: %v", err)
+		}
+		if total != 60 {
+			t.Fatalf("unexpected recounted usage: %d != 60", total)
+		}
+	}
+	usage("team/other", 60)
+}
+
+func TestQuotaReconcile(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	configured := []distribution.Quota{{Prefix: "team", Limit: 100}}
+	first := NewQuotaService(d, "first", configured).(*quotaService)
+	second := NewQuotaService(d, "second", configured)
+
+	if err := first.Add(ctx, "team/app", 10); err != nil {
+		t.Fatalf("unexpected error adding usage: %v", err)
+	}
+	if err := second.Add(ctx, "team/other", 50); err != nil {
+		t.Fatalf("unexpected error adding usage: %v", err)
+	}
+
+	// the usage added by other instances is only seen once reconciled
+	if err := first.Add(ctx, "team/app", 60); err != nil {
+		t.Fatalf("unexpected error adding usage before reconciling: %v", err)
+	}
+	first.reconcile(ctx, "team", first.prefixes["team"])
+	if err := first.Add(ctx, "team/app", 1); err == nil {
+		t.Fatalf("expected the quota to be exceeded once reconciled")
+	} else if exceeded, ok := err.(distribution.ErrQuotaExceeded); !ok || exceeded.Quota.Usage != 120 {
+		t.Fatalf("unexpected error exceeding quota: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"sync"
+
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/reference"
@@ -15,9 +17,16 @@ type registry struct {
 	blobStore                    *blobStore
 	blobServer                   *blobServer
 	blobCache                    *blobCache
+	metadataIndex                distribution.MetadataIndex
+	downloadCounter              distribution.DownloadCounter
+	quotas                       distribution.QuotaService
+	tagRules                     []TagRule
+	immutableTagLocks            [immutableTagLockCount]sync.Mutex
+	maxItemSize                  int64
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	sweepFence                   bool
 	resumableDigestEnabled       bool
 	schema1SigningKey            libtrust.PrivateKey
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
@@ -40,6 +49,14 @@ func EnableDelete(registry *registry) error {
 	return nil
 }
 
+// EnableSweepFence is a functional option for NewRegistry. It refuses to
+// link blobs while a garbage collection of any registry sharing the storage
+// removes blobs, see PublishSweep.
+func EnableSweepFence(registry *registry) error {
+	registry.sweepFence = true
+	return nil
+}
+
 // DisableDigestResumption is a functional option for NewRegistry. It should be
 // used if the registry is acting as a caching proxy.
 func DisableDigestResumption(registry *registry) error {
@@ -85,6 +102,50 @@ func BlobDescriptorCacheProvider(blobDescriptorCacheProvider cache.BlobDescripto
 	}
 }
 
+// MetadataIndex returns a functional option for NewRegistry. It sets the
+// index used to keep tag and repository metadata. When not provided, the
+// metadata is kept in the storage driver.
+func MetadataIndex(index distribution.MetadataIndex) RegistryOption {
+	return func(registry *registry) error {
+		if index != nil {
+			registry.metadataIndex = index
+		}
+		return nil
+	}
+}
+
+// DownloadCounter returns a functional option for NewRegistry. It sets the
+// counters used to track tag and repository pulls. When not provided, the
+// counters are aggregated in memory and flushed to the storage driver.
+func DownloadCounter(counter distribution.DownloadCounter) RegistryOption {
+	return func(registry *registry) error {
+		if counter != nil {
+			registry.downloadCounter = counter
+		}
+		return nil
+	}
+}
+
+// Quotas returns a functional option for NewRegistry. It sets the service
+// tracking the bytes stored by repositories, which rejects the blobs and
+// manifests exceeding their quota. When not provided, usage is not tracked.
+func Quotas(quotas distribution.QuotaService) RegistryOption {
+	return func(registry *registry) error {
+		registry.quotas = quotas
+		return nil
+	}
+}
+
+// ItemMaxSize returns a functional option for NewRegistry. It limits the size
+// of the items saved in repositories and tags. A size of zero or less means
+// items are not limited.
+func ItemMaxSize(size int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.maxItemSize = size
+		return nil
+	}
+}
+
 // NewRegistry creates a new registry instance from the provided driver. The
 // resulting registry may be shared by multiple goroutines but is cheap to
 // allocate. If the Redirect option is specified, the backend blob server will
@@ -107,18 +168,25 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 			statter: statter,
 			pathFn:  bs.path,
 		},
-		blobCache: &blobCache{
-			driver: driver,
-		},
+		metadataIndex:          NewMetadataIndex(driver),
+		downloadCounter:        NewDownloadCounter(ctx, driver, "", defaultDownloadFlushInterval),
 		statter:                statter,
 		resumableDigestEnabled: true,
 	}
+	registry.blobCache = &blobCache{
+		driver:   driver,
+		registry: registry,
+	}
 
 	for _, option := range options {
 		if err := option(registry); err != nil {
 			return nil, err
 		}
 	}
+	registry.metadataIndex = &legacyMetadataIndex{
+		MetadataIndex: registry.metadataIndex,
+		driver:        driver,
+	}
 
 	return registry, nil
 }
@@ -162,6 +230,22 @@ func (reg *registry) BlobCache() distribution.BlobCache {
 	return reg.blobCache
 }
 
+// MetadataIndex returns the index holding tag and repository metadata.
+func (reg *registry) MetadataIndex() distribution.MetadataIndex {
+	return reg.metadataIndex
+}
+
+// DownloadCounter returns the counters tracking tag and repository pulls.
+func (reg *registry) DownloadCounter() distribution.DownloadCounter {
+	return reg.downloadCounter
+}
+
+// Quotas returns the service enforcing storage quotas, or nil if quotas are
+// not enabled.
+func (reg *registry) Quotas() distribution.QuotaService {
+	return reg.quotas
+}
+
 // repository provides name-scoped access to various services.
 type repository struct {
 	*registry
@@ -213,6 +297,8 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		repository:           repo,
 		deleteEnabled:        repo.registry.deleteEnabled,
 		blobAccessController: statter,
+		quotas:               repo.registry.quotas,
+		sweepFence:           repo.registry.sweepFence,
 
 		// TODO(stevvooe): linkPath limits this blob store to only
 		// manifests. This instance cannot be used for blob checks.
@@ -283,6 +369,8 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		linkPathFns:            []linkPathFunc{blobLinkPath},
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
+		quotas:                 repo.registry.quotas,
+		sweepFence:             repo.registry.sweepFence,
 	}
 }
 
@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+)
+
+var _ distribution.RepositoryRemover = &registry{}
+
+// The steps of a repository removal, in the order they are carried out. Each
+// step may be repeated safely, so a removal interrupted by a crash is resumed
+// from the first step not recorded in its journal.
+const (
+	removalStepUntag      = "untag"
+	removalStepManifests  = "manifests"
+	removalStepLayers     = "layers"
+	removalStepUploads    = "uploads"
+	removalStepItems      = "items"
+	removalStepMetadata   = "metadata"
+	removalStepRepository = "repository"
+	removalStepCatalog    = "catalog"
+)
+
+var removalSteps = []string{
+	removalStepUntag,
+	removalStepManifests,
+	removalStepLayers,
+	removalStepUploads,
+	removalStepItems,
+	removalStepMetadata,
+	removalStepRepository,
+	removalStepCatalog,
+}
+
+// repositoryDirs are the directories owned by a repository under its root.
+// Anything else under the root belongs to nested repositories, such as
+// <name>/sub, which are kept when <name> is removed.
+var repositoryDirs = []string{"_manifests", "_layers", "_uploads", "_items"}
+
+// removalJournal records the progress of a repository removal.
+type removalJournal struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"startedAt"`
+
+	// Tags are the tags of the repository when the removal started.
+	Tags []string `json:"tags"`
+
+	// Done lists the steps that have completed.
+	Done []string `json:"done"`
+}
+
+func (j *removalJournal) done(step string) bool {
+	for _, s := range j.Done {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes the named repository. The removal is recorded in a journal
+// before anything is deleted, and the journal is only dropped once every
+// step has completed. A removal that fails or is interrupted can be finished
+// by calling Remove again or by ResumeRepositoryRemovals.
+// ErrRepositoryUnknown is returned if the repository does not exist.
+func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
+	journal, err := reg.getRemovalJournal(ctx, name.Name())
+	switch err.(type) {
+	case nil:
+		context.GetLogger(ctx).Infof("resuming removal of repository %s", name.Name())
+	case driver.PathNotFoundError:
+		journal, err = reg.startRemoval(ctx, name)
+		if err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	return reg.finishRemoval(ctx, name, journal)
+}
+
+// ResumeRepositoryRemovals finishes the repository removals interrupted by a
+// crash of the registry. It should be called when the registry starts.
+func ResumeRepositoryRemovals(ctx context.Context, ns distribution.Namespace) error {
+	reg, ok := ns.(*registry)
+	if !ok {
+		return nil
+	}
+
+	root, err := pathFor(repositoryRemovalsPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	err = Walk(ctx, reg.blobStore.driver, root, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() && path.Base(fileInfo.Path()) == "journal.json" {
+			names = append(names, path.Dir(fileInfo.Path())[len(root)+1:])
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range names {
+		named, err := reference.ParseNamed(name)
+		if err != nil {
+			return err
+		}
+		if err := reg.Remove(ctx, named); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startRemoval writes the journal of a new removal of the repository.
+func (reg *registry) startRemoval(ctx context.Context, name reference.Named) (*removalJournal, error) {
+	root, err := pathFor(imageRootPathSpec{
+		name: name.Name(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	exists, err := reg.repositoryExists(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, distribution.ErrRepositoryUnknown{Name: name.Name()}
+	}
+
+	repo, err := reg.Repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, err
+		}
+	}
+	if err := reg.checkUntag(name.Name(), tags...); err != nil {
+		return nil, err
+	}
+
+	journal := &removalJournal{
+		Name:      name.Name(),
+		StartedAt: time.Now().UTC(),
+		Tags:      tags,
+		Done:      []string{},
+	}
+	if err := reg.putRemovalJournal(ctx, journal); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// finishRemoval carries out the steps not recorded in the journal, then
+// drops the journal.
+func (reg *registry) finishRemoval(ctx context.Context, name reference.Named, journal *removalJournal) error {
+	repo, err := reg.Repository(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range removalSteps {
+		if journal.done(step) {
+			continue
+		}
+
+		if err := reg.removalStep(ctx, repo, journal, step); err != nil {
+			return err
+		}
+
+		journal.Done = append(journal.Done, step)
+		if err := reg.putRemovalJournal(ctx, journal); err != nil {
+			return err
+		}
+	}
+
+	journalPath, err := pathFor(repositoryRemovalPathSpec{
+		name: name.Name(),
+	})
+	if err != nil {
+		return err
+	}
+	// only the journal is deleted, the directory may hold the journals of
+	// nested repositories
+	return ignorePathNotFound(reg.blobStore.driver.Delete(ctx, journalPath))
+}
+
+// removalStep carries out one step of the removal of the repository.
+func (reg *registry) removalStep(ctx context.Context, repo distribution.Repository, journal *removalJournal, step string) error {
+	name := repo.Named().Name()
+	d := reg.blobStore.driver
+
+	root, err := pathFor(imageRootPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch step {
+	case removalStepUntag:
+		// the tags were checked when the removal started, and a rule added
+		// since must not leave the removal unfinished
+		tags := repo.Tags(ctx).(*tagStore)
+		for _, tag := range journal.Tags {
+			switch err := tags.untag(ctx, tag).(type) {
+			case nil, distribution.ErrTagUnknown, driver.PathNotFoundError:
+			default:
+				return err
+			}
+		}
+		return nil
+	case removalStepManifests:
+		return ignorePathNotFound(d.Delete(ctx, path.Join(root, "_manifests")))
+	case removalStepLayers:
+		return ignorePathNotFound(d.Delete(ctx, path.Join(root, "_layers")))
+	case removalStepUploads:
+		return ignorePathNotFound(d.Delete(ctx, path.Join(root, "_uploads")))
+	case removalStepItems:
+		itemsRoot, err := pathFor(itemSaveRootPathSpec{
+			name: name,
+		})
+		if err != nil {
+			return err
+		}
+		return ignorePathNotFound(d.Delete(ctx, itemsRoot))
+	case removalStepMetadata:
+		if err := reg.metadataIndex.DeleteImageInfo(ctx, name); err != nil {
+			return err
+		}
+		if err := reg.downloadCounter.DeleteRepository(ctx, name); err != nil {
+			return err
+		}
+		if reg.quotas != nil {
+			return reg.quotas.DeleteRepository(ctx, name)
+		}
+		return nil
+	case removalStepRepository:
+		// the directories of the repository are gone, and its root is
+		// removed unless nested repositories are left under it
+		children, err := d.List(ctx, root)
+		switch err.(type) {
+		case nil:
+		case driver.PathNotFoundError:
+			return nil
+		default:
+			return err
+		}
+		if len(children) > 0 {
+			return nil
+		}
+		return ignorePathNotFound(d.Delete(ctx, root))
+	case removalStepCatalog:
+		// the catalog cache is rebuilt without the repository if missing,
+		// so this step comes after the repository directory is gone
+		return repo.Caches(ctx).DeleteImageFromCatalogCache(ctx, name)
+	}
+	return nil
+}
+
+// repositoryExists reports whether the repository rooted at root has any of
+// its own directories, rather than only nested repositories.
+func (reg *registry) repositoryExists(ctx context.Context, root string) (bool, error) {
+	for _, dir := range repositoryDirs {
+		_, err := reg.blobStore.driver.Stat(ctx, path.Join(root, dir))
+		switch err.(type) {
+		case nil:
+			return true, nil
+		case driver.PathNotFoundError:
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (reg *registry) getRemovalJournal(ctx context.Context, name string) (*removalJournal, error) {
+	journalPath, err := pathFor(repositoryRemovalPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := reg.blobStore.driver.GetContent(ctx, journalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal removalJournal
+	if err := json.Unmarshal(content, &journal); err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
+
+func (reg *registry) putRemovalJournal(ctx context.Context, journal *removalJournal) error {
+	journalPath, err := pathFor(repositoryRemovalPathSpec{
+		name: journal.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return reg.blobStore.driver.PutContent(ctx, journalPath, content)
+}
+
+// ignorePathNotFound drops the error returned when deleting a path that
+// does not exist, so that removal steps can be repeated.
+func ignorePathNotFound(err error) error {
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
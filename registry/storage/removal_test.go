@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestRemoveRepository(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/bar")
+	other := makeRepository(t, registry, "foo/other")
+
+	image := uploadRandomSchema2Image(t, repo)
+	desc := distribution.Descriptor{Digest: image.manifestDigest}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", desc); err != nil {
+		t.Fatalf("unexpected error tagging manifest: %v", err)
+	}
+	uploadRandomSchema2Image(t, other)
+	if _, err := repo.Caches(ctx).SaveImageItem(ctx, httptest.NewRecorder(), saveItemRequest("readme", ""), "README"); err != nil {
+		t.Fatalf("unexpected error saving item: %v", err)
+	}
+	if err := repo.Caches(ctx).CreateCatalogCache(ctx, 1); err != nil {
+		t.Fatalf("unexpected error caching catalog: %v", err)
+	}
+	before := allBlobs(t, registry)
+
+	if err := registry.(distribution.RepositoryRemover).Remove(ctx, repo.Named()); err != nil {
+		t.Fatalf("unexpected error removing repository: %v", err)
+	}
+
+	if _, err := repo.Tags(ctx).Get(ctx, "latest"); err == nil {
+		t.Fatal("expected the tag to be removed")
+	}
+	manifests := makeManifestService(t, repo)
+	if exists, _ := manifests.Exists(ctx, image.manifestDigest); exists {
+		t.Fatal("expected the manifest revision to be removed")
+	}
+	for dgst := range image.layers {
+		if _, err := repo.Blobs(ctx).Stat(ctx, dgst); err == nil {
+			t.Fatal("expected the layer links to be removed")
+		}
+	}
+
+	catalog, err := repo.Caches(ctx).GetCatalog(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting catalog: %v", err)
+	}
+	if len(catalog) != 1 || catalog[0] != "foo/other" {
+		t.Fatalf("unexpected cached catalog: %v", catalog)
+	}
+	repos := make([]string, 10)
+	n, _ := registry.Repositories(ctx, repos, "")
+	if n != 1 || repos[0] != "foo/other" {
+		t.Fatalf("unexpected repositories: %v", repos[:n])
+	}
+
+	// blobs are left to the garbage collector
+	if after := allBlobs(t, registry); len(after) != len(before) {
+		t.Fatalf("expected blobs to be kept: %d != %d", len(after), len(before))
+	}
+	if err := MarkAndSweep(ctx, d, registry, false); err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[image.manifestDigest]; ok {
+		t.Fatal("expected the manifest blob to be collected")
+	}
+
+	journalPath, err := pathFor(repositoryRemovalPathSpec{name: "foo/bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, journalPath); err == nil {
+		t.Fatal("expected the journal to be removed")
+	}
+
+	err = registry.(distribution.RepositoryRemover).Remove(ctx, repo.Named())
+	if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("expected ErrRepositoryUnknown removing a removed repository, got %v", err)
+	}
+}
+
+func TestResumeRepositoryRemovals(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	ns := createRegistry(t, d)
+	repo := makeRepository(t, ns, "foo/bar")
+	uploadRandomSchema2Image(t, repo)
+
+	// simulate a crash after the tags were removed
+	reg := ns.(*registry)
+	journal, err := reg.startRemoval(ctx, repo.Named())
+	if err != nil {
+		t.Fatalf("unexpected error starting removal: %v", err)
+	}
+	journal.Done = append(journal.Done, removalStepUntag)
+	if err := reg.putRemovalJournal(ctx, journal); err != nil {
+		t.Fatalf("unexpected error writing journal: %v", err)
+	}
+
+	if err := ResumeRepositoryRemovals(ctx, ns); err != nil {
+		t.Fatalf("unexpected error resuming removals: %v", err)
+	}
+
+	root, err := pathFor(imageRootPathSpec{name: "foo/bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, root); err == nil {
+		t.Fatal("expected the repository to be removed")
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		t.Fatalf("unexpected error checking repository: %v", err)
+	}
+
+	named, _ := reference.ParseNamed("foo/bar")
+	if _, err := reg.getRemovalJournal(ctx, named.Name()); err == nil {
+		t.Fatal("expected the journal to be removed")
+	}
+}
+
+func TestRemoveRepositoryKeepsNestedRepositories(t *testing.T) {
+	for _, unfinished := range []bool{false, true} {
+		ctx := context.Background()
+		d := inmemory.New()
+		ns := createRegistry(t, d)
+		reg := ns.(*registry)
+		parent := makeRepository(t, ns, "foo")
+		nested := makeRepository(t, ns, "foo/bar")
+		uploadRandomSchema2Image(t, parent)
+		image := uploadRandomSchema2Image(t, nested)
+		desc := distribution.Descriptor{Digest: image.manifestDigest}
+		if err := nested.Tags(ctx).Tag(ctx, "latest", desc); err != nil {
+			t.Fatalf("unexpected error tagging manifest: %v", err)
+		}
+
+		if unfinished {
+			// a removal of the nested repository interrupted by a crash
+			journal, err := reg.startRemoval(ctx, nested.Named())
+			if err != nil {
+				t.Fatalf("unexpected error starting removal: %v", err)
+			}
+			journal.Done = append(journal.Done, removalStepUntag)
+			if err := reg.putRemovalJournal(ctx, journal); err != nil {
+				t.Fatalf("unexpected error writing journal: %v", err)
+			}
+		}
+
+		if err := reg.Remove(ctx, parent.Named()); err != nil {
+			t.Fatalf("unexpected error removing repository: %v", err)
+		}
+
+		err := reg.Remove(ctx, parent.Named())
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			t.Fatalf("expected ErrRepositoryUnknown removing a removed repository, got %v", err)
+		}
+		if _, err := reg.getRemovalJournal(ctx, "foo"); err == nil {
+			t.Fatal("expected the journal to be removed")
+		}
+
+		if unfinished {
+			if _, err := reg.getRemovalJournal(ctx, "foo/bar"); err != nil {
+				t.Fatalf("expected the journal of the nested repository to be kept: %v", err)
+			}
+			if err := ResumeRepositoryRemovals(ctx, ns); err != nil {
+				t.Fatalf("unexpected error resuming removals: %v", err)
+			}
+			if _, err := reg.getRemovalJournal(ctx, "foo/bar"); err == nil {
+				t.Fatal("expected the journal of the nested repository to be removed")
+			}
+			repos := make([]string, 10)
+			if n, _ := ns.Repositories(ctx, repos, ""); n != 0 {
+				t.Fatalf("unexpected repositories: %v", repos[:n])
+			}
+			continue
+		}
+
+		if _, err := nested.Tags(ctx).Get(ctx, "latest"); err != nil {
+			t.Fatalf("expected the nested repository to be kept: %v", err)
+		}
+		if _, err := makeManifestService(t, nested).Get(ctx, image.manifestDigest); err != nil {
+			t.Fatalf("expected the nested manifest to be kept: %v", err)
+		}
+		repos := make([]string, 10)
+		n, _ := ns.Repositories(ctx, repos, "")
+		if n != 1 || repos[0] != "foo/bar" {
+			t.Fatalf("unexpected repositories: %v", repos[:n])
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/cache"
+	"github.com/docker/distribution/registry/storage/driver"
+)
+
+// RetentionRule selects the tags kept in the repositories covered by its
+// prefix. A tag is kept if it meets any of the criteria of the rule, and
+// pruned otherwise.
+type RetentionRule struct {
+	// Prefix covers the repository it names and the repositories below it.
+	// An empty prefix covers every repository.
+	Prefix string
+
+	// KeepLast keeps the most recently created tags.
+	KeepLast int
+
+	// KeepDays keeps the tags pulled within the last days, today included.
+	// The download counters only keep cache.DownloadHistoryDays days.
+	KeepDays int
+
+	// KeepTags are patterns, as understood by path.Match, of the tags which
+	// are always kept.
+	KeepTags []string
+}
+
+// Covers returns true if the rule applies to the named repository.
+func (r RetentionRule) Covers(name string) bool {
+	return r.Prefix == "" || name == r.Prefix || strings.HasPrefix(name, r.Prefix+"/")
+}
+
+// keeps returns true if the tag matches one of the patterns always kept.
+func (r RetentionRule) keeps(tag string) bool {
+	for _, pattern := range r.KeepTags {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionOptions configures a run of the retention rules.
+type RetentionOptions struct {
+	// DryRun reports the tags which would be pruned without removing them.
+	DryRun bool
+
+	// UpdateCaches removes the pruned tags from the tag list cache, and
+	// their metadata and items, as deleting their manifests through the API
+	// does when the enhanced features are enabled.
+	UpdateCaches bool
+}
+
+// RetentionReport describes the outcome of a run of the retention rules.
+type RetentionReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DryRun     bool      `json:"dryRun"`
+
+	// Repositories is the number of repositories covered by a rule.
+	Repositories int `json:"repositories"`
+
+	// Kept is the number of tags kept by the rules.
+	Kept int `json:"kept"`
+
+	// Protected is the number of tags which fall out of the rules but are
+	// kept because tag protection forbids deleting them.
+	Protected int `json:"protected"`
+
+	// Pruned are the tags which fall out of the rules.
+	Pruned []RetentionTag `json:"pruned"`
+
+	// Manifests are the manifests of the pruned tags which no kept tag
+	// references, and Deleted the number of them actually deleted, which
+	// requires deletes to be enabled.
+	Manifests []GCManifest `json:"manifests"`
+	Deleted   int          `json:"deleted"`
+}
+
+// RetentionTag is a tag evaluated by the retention rules.
+type RetentionTag struct {
+	Repository string        `json:"repository"`
+	Tag        string        `json:"tag"`
+	Digest     digest.Digest `json:"digest"`
+	CreateTime time.Time     `json:"createTime"`
+}
+
+// Retention prunes the tags falling out of the retention rules, along with
+// the manifests only they referenced. Blobs are left to the garbage
+// collector.
+type Retention struct {
+	driver   driver.StorageDriver
+	registry distribution.Namespace
+	rules    []RetentionRule
+
+	// run serializes the runs, while mu only guards the last report, so
+	// that it can be read during a run.
+	run  sync.Mutex
+	mu   sync.Mutex
+	last *RetentionReport
+}
+
+// NewRetention returns the retention of the registry stored in the driver.
+// The rule with the longest prefix covering a repository applies to it.
+func NewRetention(storageDriver driver.StorageDriver, registry distribution.Namespace, rules []RetentionRule) *Retention {
+	rules = append([]RetentionRule{}, rules...)
+	sort.Sort(retentionRulesByPrefix(rules))
+
+	return &Retention{
+		driver:   storageDriver,
+		registry: registry,
+		rules:    rules,
+	}
+}
+
+// LastReport returns the report of the last completed run, if any.
+func (r *Retention) LastReport() (RetentionReport, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last == nil {
+		return RetentionReport{}, false
+	}
+	return *r.last, true
+}
+
+// Apply runs the retention rules on every repository. Only one run happens
+// at a time.
+func (r *Retention) Apply(ctx context.Context, opts RetentionOptions) (RetentionReport, error) {
+	r.run.Lock()
+	defer r.run.Unlock()
+
+	report := RetentionReport{
+		StartedAt: time.Now().UTC(),
+		DryRun:    opts.DryRun,
+		Pruned:    []RetentionTag{},
+		Manifests: []GCManifest{},
+	}
+
+	repositoryEnumerator, ok := r.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return RetentionReport{}, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(name string) error {
+		rule, ok := r.rule(name)
+		if !ok {
+			return nil
+		}
+		report.Repositories++
+		if err := r.apply(ctx, name, rule, opts, &report); err != nil {
+			return fmt.Errorf("failed to apply retention to %s: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return RetentionReport{}, err
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	last := report
+	r.mu.Lock()
+	r.last = &last
+	r.mu.Unlock()
+	return report, nil
+}
+
+// rule returns the rule with the longest prefix covering the named
+// repository.
+func (r *Retention) rule(name string) (RetentionRule, bool) {
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		if r.rules[i].Covers(name) {
+			return r.rules[i], true
+		}
+	}
+	return RetentionRule{}, false
+}
+
+// apply runs a rule on the named repository.
+func (r *Retention) apply(ctx context.Context, name string, rule RetentionRule, opts RetentionOptions, report *RetentionReport) error {
+	named, err := reference.ParseNamed(name)
+	if err != nil {
+		return err
+	}
+	repository, err := r.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := r.candidates(ctx, repository)
+	if err != nil {
+		return err
+	}
+	sort.Sort(retentionTagsByAge(candidates))
+
+	now := time.Now()
+	var kept, pruned []RetentionTag
+	for i, candidate := range candidates {
+		keep := i < rule.KeepLast || rule.keeps(candidate.Tag)
+		if !keep && rule.KeepDays > 0 {
+			keep, err = r.pulledWithin(ctx, candidate, rule.KeepDays, now)
+			if err != nil {
+				return err
+			}
+		}
+		if !keep && r.protected(name, candidate.Tag) {
+			report.Protected++
+			keep = true
+		}
+
+		if keep {
+			kept = append(kept, candidate)
+		} else {
+			pruned = append(pruned, candidate)
+		}
+	}
+	report.Kept += len(kept)
+	report.Pruned = append(report.Pruned, pruned...)
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	referenced, err := r.referenced(ctx, manifests, kept)
+	if err != nil {
+		return err
+	}
+
+	var unreferenced []digest.Digest
+	for _, tag := range pruned {
+		if _, ok := referenced[tag.Digest]; ok {
+			continue
+		}
+		referenced[tag.Digest] = struct{}{}
+		unreferenced = append(unreferenced, tag.Digest)
+		report.Manifests = append(report.Manifests, GCManifest{Repository: name, Digest: tag.Digest})
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	tags := repository.Tags(ctx)
+	caches := repository.Caches(ctx)
+	for _, tag := range pruned {
+		// the tag may have been moved since it was evaluated, in which case
+		// it points to a manifest the rules have not seen
+		desc, err := tags.Get(ctx, tag.Tag)
+		switch err.(type) {
+		case nil:
+		case distribution.ErrTagUnknown:
+			continue
+		default:
+			return err
+		}
+		if desc.Digest != tag.Digest {
+			context.GetLogger(ctx).Infof("retention: keeping tag %s:%s, moved to %s", name, tag.Tag, desc.Digest)
+			continue
+		}
+
+		context.GetLogger(ctx).Infof("retention: pruning tag %s:%s", name, tag.Tag)
+		switch err := tags.Untag(ctx, tag.Tag).(type) {
+		case nil, distribution.ErrTagUnknown, driver.PathNotFoundError:
+		default:
+			return err
+		}
+		if opts.UpdateCaches {
+			if err := caches.DeleteTagFromTagListCache(ctx, tag.Tag); err != nil {
+				return err
+			}
+			if err := caches.DeleteAllTagItems(ctx, tag.Tag); err != nil {
+				return err
+			}
+			if err := caches.DeleteTagInfo(ctx, tag.Tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Tags pushed or moved during the run may reference the manifests about
+	// to be deleted, so the tags are resolved again. A tag pushed between
+	// this check and the delete still loses its manifest, as with deleting
+	// a manifest through the API.
+	current, err := r.current(ctx, tags)
+	if err != nil {
+		return err
+	}
+	referenced, err = r.referenced(ctx, manifests, current)
+	if err != nil {
+		return err
+	}
+
+	for _, dgst := range unreferenced {
+		if _, ok := referenced[dgst]; ok {
+			context.GetLogger(ctx).Infof("retention: keeping manifest %s@%s, tagged during the run", name, dgst)
+			continue
+		}
+		switch err := manifests.Delete(ctx, dgst); err {
+		case nil:
+			report.Deleted++
+		case distribution.ErrUnsupported:
+			// deletes are disabled, the manifests stay pullable by digest
+			return nil
+		case distribution.ErrBlobUnknown:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// current returns the tags of the repository with the digests they point
+// to now.
+func (r *Retention) current(ctx context.Context, tags distribution.TagService) ([]RetentionTag, error) {
+	all, err := tags.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	current := make([]RetentionTag, 0, len(all))
+	for _, tag := range all {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+		current = append(current, RetentionTag{Tag: tag, Digest: desc.Digest})
+	}
+	return current, nil
+}
+
+// candidates returns the tags of the repository with the digests they point
+// to and their creation times.
+func (r *Retention) candidates(ctx context.Context, repository distribution.Repository) ([]RetentionTag, error) {
+	name := repository.Named().Name()
+	candidates, err := r.current(ctx, repository.Tags(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		candidates[i].Repository = name
+		candidates[i].CreateTime, err = r.createTime(ctx, name, candidates[i].Tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+// createTime returns the creation time recorded in the metadata of the tag,
+// or, for the tags pushed before their metadata was recorded, the time the
+// tag was last moved.
+func (r *Retention) createTime(ctx context.Context, name, tag string) (time.Time, error) {
+	info, err := r.registry.MetadataIndex().GetTagInfo(ctx, name, tag)
+	if err == nil && !info.CreateTime.IsZero() {
+		return info.CreateTime, nil
+	}
+
+	current, err := pathFor(manifestTagCurrentPathSpec{
+		name: name,
+		tag:  tag,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := r.driver.Stat(ctx, current)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// pulledWithin returns true if the tag was pulled within the last days.
+func (r *Retention) pulledWithin(ctx context.Context, tag RetentionTag, days int, now time.Time) (bool, error) {
+	stats, err := r.registry.DownloadCounter().TagDownloads(ctx, tag.Repository, tag.Tag)
+	if err != nil {
+		return false, err
+	}
+
+	recent := cache.RecentDownloadDays(now)
+	if days < len(recent) {
+		recent = recent[len(recent)-days:]
+	}
+	for _, day := range recent {
+		if stats.Daily[day] > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// protected returns true if tag protection forbids deleting the tag.
+func (r *Retention) protected(name, tag string) bool {
+	reg, ok := r.registry.(*registry)
+	if !ok {
+		return false
+	}
+	_, noDelete := reg.tagProtection(name, tag)
+	return noDelete
+}
+
+// referenced returns the manifests the kept tags point to, along with the
+// manifests referenced by the manifest lists among them.
+func (r *Retention) referenced(ctx context.Context, manifests distribution.ManifestService, kept []RetentionTag) (map[digest.Digest]struct{}, error) {
+	referenced := make(map[digest.Digest]struct{})
+	for _, tag := range kept {
+		if _, ok := referenced[tag.Digest]; ok {
+			continue
+		}
+		referenced[tag.Digest] = struct{}{}
+
+		manifest, err := manifests.Get(ctx, tag.Digest)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				continue
+			}
+			return nil, err
+		}
+		if _, ok := manifest.(*manifestlist.DeserializedManifestList); !ok {
+			continue
+		}
+		for _, descriptor := range manifest.References() {
+			referenced[descriptor.Digest] = struct{}{}
+		}
+	}
+	return referenced, nil
+}
+
+// retentionRulesByPrefix sorts rules by prefix, so that a rule comes before
+// the rules of the repositories below it.
+type retentionRulesByPrefix []RetentionRule
+
+func (r retentionRulesByPrefix) Len() int           { return len(r) }
+func (r retentionRulesByPrefix) Less(i, j int) bool { return r[i].Prefix < r[j].Prefix }
+func (r retentionRulesByPrefix) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// retentionTagsByAge sorts tags from the most recently created.
+type retentionTagsByAge []RetentionTag
+
+func (t retentionTagsByAge) Len() int { return len(t) }
+func (t retentionTagsByAge) Less(i, j int) bool {
+	if !t[i].CreateTime.Equal(t[j].CreateTime) {
+		return t[i].CreateTime.After(t[j].CreateTime)
+	}
+	return t[i].Tag > t[j].Tag
+}
+func (t retentionTagsByAge) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableDelete, TagRules([]TagRule{
+		{TagRegexp: regexp.MustCompile(`^release-`), NoDelete: true},
+	}))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	repo := makeRepository(t, registry, "ci/app")
+	created := time.Now().Add(-48 * time.Hour).UTC()
+	digests := make(map[string]digest.Digest)
+	tag := func(tag string, dgst digest.Digest, age int) {
+		if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatalf("unexpected error tagging %s: %v", tag, err)
+		}
+		info := distribution.TagInfo{Name: "ci/app", Tag: tag, CreateTime: created.Add(time.Duration(age) * time.Hour)}
+		if err := registry.MetadataIndex().PutTagInfo(ctx, info); err != nil {
+			t.Fatalf("unexpected error saving tag info of %s: %v", tag, err)
+		}
+		digests[tag] = dgst
+	}
+	for i, name := range []string{"t1", "t2", "t3", "t4", "t5"} {
+		tag(name, uploadRandomSchema2Image(t, repo).manifestDigest, i)
+	}
+	tag("latest", digests["t5"], 4)
+	tag("release-1", digests["t1"], 0)
+	if err := registry.DownloadCounter().Increment(ctx, "ci/app", "t2", time.Now()); err != nil {
+		t.Fatalf("unexpected error counting a pull: %v", err)
+	}
+
+	other := makeRepository(t, registry, "other/app")
+	if err := other.Tags(ctx).Tag(ctx, "old", distribution.Descriptor{Digest: uploadRandomSchema2Image(t, other).manifestDigest}); err != nil {
+		t.Fatalf("unexpected error tagging: %v", err)
+	}
+
+	retention := NewRetention(d, registry, []RetentionRule{
+		{Prefix: "ci", KeepLast: 1, KeepTags: []string{"latest"}},
+		{Prefix: "ci/app", KeepLast: 2, KeepDays: 7},
+	})
+
+	checkReport := func(report RetentionReport) {
+		if report.Repositories != 1 || report.Kept != 4 || report.Protected != 1 {
+			t.Fatalf("unexpected report: %#v", report)
+		}
+		pruned := make(map[string]bool)
+		for _, tag := range report.Pruned {
+			pruned[tag.Tag] = true
+		}
+		if len(pruned) != 3 || !pruned["t1"] || !pruned["t3"] || !pruned["t4"] {
+			t.Fatalf("unexpected pruned tags: %#v", report.Pruned)
+		}
+		// the manifest of t1 is still referenced by release-1
+		if len(report.Manifests) != 2 {
+			t.Fatalf("unexpected manifests: %#v", report.Manifests)
+		}
+		for _, manifest := range report.Manifests {
+			if manifest.Digest != digests["t3"] && manifest.Digest != digests["t4"] {
+				t.Fatalf("unexpected manifest: %#v", manifest)
+			}
+		}
+	}
+
+	report, err := retention.Apply(ctx, RetentionOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error applying retention: %v", err)
+	}
+	checkReport(report)
+	if tags, _ := repo.Tags(ctx).All(ctx); len(tags) != 7 {
+		t.Fatalf("dry run removed tags: %v", tags)
+	}
+
+	report, err = retention.Apply(ctx, RetentionOptions{UpdateCaches: true})
+	if err != nil {
+		t.Fatalf("unexpected error applying retention: %v", err)
+	}
+	checkReport(report)
+	if report.Deleted != 2 {
+		t.Fatalf("expected 2 manifests to be deleted, got %d", report.Deleted)
+	}
+	if last, ok := retention.LastReport(); !ok || last.DryRun {
+		t.Fatalf("unexpected last report: %#v", last)
+	}
+
+	for _, tag := range []string{"t5", "latest", "t2", "release-1"} {
+		if _, err := repo.Tags(ctx).Get(ctx, tag); err != nil {
+			t.Fatalf("expected %s to be kept: %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"t1", "t3", "t4"} {
+		if _, err := repo.Tags(ctx).Get(ctx, tag); err == nil {
+			t.Fatalf("expected %s to be pruned", tag)
+		}
+		if _, err := registry.MetadataIndex().GetTagInfo(ctx, "ci/app", tag); err == nil {
+			t.Fatalf("expected the metadata of %s to be removed", tag)
+		}
+	}
+	tagList, err := repo.Caches(ctx).GetTagList(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting the tag list cache: %v", err)
+	}
+	if len(tagList) != 4 {
+		t.Fatalf("unexpected tag list cache: %v", tagList)
+	}
+
+	manifests := makeManifestService(t, repo)
+	if _, err := manifests.Get(ctx, digests["t3"]); err == nil {
+		t.Fatalf("expected the manifest of t3 to be deleted")
+	}
+	if _, err := manifests.Get(ctx, digests["t1"]); err != nil {
+		t.Fatalf("unexpected error getting the manifest of release-1: %v", err)
+	}
+	if _, err := other.Tags(ctx).Get(ctx, "old"); err != nil {
+		t.Fatalf("expected a repository covered by no rule to be left alone: %v", err)
+	}
+}
+
+// movingCounter moves a tag the first time the pulls of a tag are read,
+// while the retention rules are evaluated.
+type movingCounter struct {
+	distribution.DownloadCounter
+	move func()
+}
+
+func (c *movingCounter) TagDownloads(ctx context.Context, name, tag string) (distribution.DownloadStats, error) {
+	if c.move != nil {
+		c.move()
+		c.move = nil
+	}
+	return c.DownloadCounter.TagDownloads(ctx, name, tag)
+}
+
+type movingRegistry struct {
+	*registry
+	counter *movingCounter
+}
+
+func (r movingRegistry) DownloadCounter() distribution.DownloadCounter {
+	return r.counter
+}
+
+func TestRetentionMovedTag(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	ns, err := NewRegistry(ctx, d, EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	repo := makeRepository(t, ns, "ci/app")
+	old := uploadRandomSchema2Image(t, repo).manifestDigest
+	moved := uploadRandomSchema2Image(t, repo).manifestDigest
+	for _, tag := range []string{"old", "other"} {
+		if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: old}); err != nil {
+			t.Fatalf("unexpected error tagging %s: %v", tag, err)
+		}
+	}
+
+	counter := &movingCounter{DownloadCounter: ns.DownloadCounter()}
+	counter.move = func() {
+		// other is moved away, and old is pushed again on a new manifest
+		if err := repo.Tags(ctx).Tag(ctx, "other", distribution.Descriptor{Digest: moved}); err != nil {
+			t.Fatalf("unexpected error moving other: %v", err)
+		}
+		if err := repo.Tags(ctx).Tag(ctx, "new", distribution.Descriptor{Digest: old}); err != nil {
+			t.Fatalf("unexpected error tagging new: %v", err)
+		}
+	}
+	retention := NewRetention(d, movingRegistry{registry: ns.(*registry), counter: counter}, []RetentionRule{
+		{KeepDays: 1},
+	})
+
+	report, err := retention.Apply(ctx, RetentionOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error applying retention: %v", err)
+	}
+	if report.Deleted != 0 {
+		t.Fatalf("expected no manifest to be deleted, got %#v", report)
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "old"); err == nil {
+		t.Fatalf("expected old to be pruned")
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "other")
+	if err != nil || desc.Digest != moved {
+		t.Fatalf("expected the moved tag to be kept: %v, %v", desc, err)
+	}
+	if _, err := makeManifestService(t, repo).Get(ctx, old); err != nil {
+		t.Fatalf("expected the manifest tagged during the run to be kept: %v", err)
+	}
+}
+
+func TestRetentionLastReportDuringRun(t *testing.T) {
+	retention := NewRetention(inmemory.New(), nil, nil)
+	retention.run.Lock()
+	defer retention.run.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		retention.LastReport()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("LastReport blocked during a run")
+	}
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultRobotLastUsedInterval is the minimum time between two recordings of
+// the use of a robot account by an instance, when no interval is configured.
+const defaultRobotLastUsedInterval = time.Minute
+
+// robotAccountService implements distribution.RobotAccountService on top of
+// the storage driver. Accounts are read from the driver on every
+// authentication, so that every instance sharing the storage backend sees
+// updates and revocations immediately. Only the bcrypt hash of the secret of
+// an account is stored; the secrets verified by this instance are remembered
+// by their SHA-256 digest, so that clients do not pay for bcrypt on every
+// request. The last use of an account is kept in shards, as described by
+// defaultInstanceName, and reads take the latest of all shards.
+type robotAccountService struct {
+	driver   driver.StorageDriver
+	instance string
+	interval time.Duration
+
+	// mu serializes the updates of accounts by this instance and guards the
+	// maps below.
+	mu sync.Mutex
+
+	// verified maps the names of accounts to their last verified secret.
+	verified map[string]verifiedSecret
+
+	// recorded maps the names of accounts to the last use recorded by this
+	// instance.
+	recorded map[string]time.Time
+}
+
+// verifiedSecret is a secret which was found to match the hash of an
+// account.
+type verifiedSecret struct {
+	hash   string
+	digest [sha256.Size]byte
+}
+
+// robotAccountRecord is a robot account as stored, with the hash of its
+// secret.
+type robotAccountRecord struct {
+	distribution.RobotAccount
+	SecretHash string `json:"secretHash"`
+}
+
+// robotLastUsed is the last use of a robot account recorded by an instance.
+type robotLastUsed struct {
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+var _ distribution.RobotAccountService = &robotAccountService{}
+
+// NewRobotAccountService returns a robot account service keeping accounts in
+// the storage driver. The use of an account is recorded at most once every
+// interval by this instance, which the instance parameter names; if empty,
+// the hostname is used.
+func NewRobotAccountService(driver driver.StorageDriver, instance string, interval time.Duration) distribution.RobotAccountService {
+	if instance == "" {
+		instance = defaultInstanceName()
+	}
+	if interval <= 0 {
+		interval = defaultRobotLastUsedInterval
+	}
+
+	return &robotAccountService{
+		driver:   driver,
+		instance: instance,
+		interval: interval,
+		verified: make(map[string]verifiedSecret),
+		recorded: make(map[string]time.Time),
+	}
+}
+
+func (rs *robotAccountService) Create(ctx context.Context, account distribution.RobotAccount) (string, error) {
+	if err := validateRobotAccount(account); err != nil {
+		return "", err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	_, err := rs.read(ctx, account.Name)
+	switch err.(type) {
+	case nil:
+		return "", distribution.ErrRobotAccountExists{Name: account.Name}
+	case distribution.ErrRobotAccountUnknown:
+	default:
+		return "", err
+	}
+
+	// drop the last uses of a revoked account of the same name
+	dir, err := rs.accountDir(account.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := ignorePathNotFound(rs.driver.Delete(ctx, dir)); err != nil {
+		return "", err
+	}
+	delete(rs.recorded, account.Name)
+
+	secret, hash, err := generateRobotSecret()
+	if err != nil {
+		return "", err
+	}
+
+	account.CreatedAt = time.Now().UTC()
+	return secret, rs.write(ctx, robotAccountRecord{RobotAccount: account, SecretHash: hash})
+}
+
+func (rs *robotAccountService) Get(ctx context.Context, name string) (distribution.RobotAccount, error) {
+	record, err := rs.read(ctx, name)
+	if err != nil {
+		return distribution.RobotAccount{}, err
+	}
+	account := record.RobotAccount
+	account.LastUsedAt, err = rs.lastUsed(ctx, name)
+	return account, err
+}
+
+func (rs *robotAccountService) List(ctx context.Context) ([]distribution.RobotAccount, error) {
+	root, err := pathFor(robotAccountsPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, err := rs.driver.List(ctx, root)
+	if err != nil {
+		return nil, ignorePathNotFound(err)
+	}
+
+	accounts := make([]distribution.RobotAccount, 0, len(dirs))
+	for _, dir := range dirs {
+		account, err := rs.Get(ctx, path.Base(dir))
+		if err != nil {
+			if _, ok := err.(distribution.ErrRobotAccountUnknown); ok {
+				// revoked concurrently
+				continue
+			}
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	sort.Sort(robotAccountsByName(accounts))
+	return accounts, nil
+}
+
+func (rs *robotAccountService) Update(ctx context.Context, account distribution.RobotAccount) error {
+	if err := validateRobotAccount(account); err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	record, err := rs.read(ctx, account.Name)
+	if err != nil {
+		return err
+	}
+	record.Scopes = account.Scopes
+	record.ExpiresAt = account.ExpiresAt
+	return rs.write(ctx, record)
+}
+
+func (rs *robotAccountService) Regenerate(ctx context.Context, name string) (string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	record, err := rs.read(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	secret, hash, err := generateRobotSecret()
+	if err != nil {
+		return "", err
+	}
+	record.SecretHash = hash
+	return secret, rs.write(ctx, record)
+}
+
+func (rs *robotAccountService) Revoke(ctx context.Context, name string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if _, err := rs.read(ctx, name); err != nil {
+		return err
+	}
+
+	dir, err := rs.accountDir(name)
+	if err != nil {
+		return err
+	}
+	delete(rs.verified, name)
+	delete(rs.recorded, name)
+	return ignorePathNotFound(rs.driver.Delete(ctx, dir))
+}
+
+func (rs *robotAccountService) Authenticate(ctx context.Context, name, secret string) (distribution.RobotAccount, error) {
+	if !distribution.RobotAccountNameRegexp.MatchString(name) {
+		return distribution.RobotAccount{}, distribution.ErrRobotAuthenticationFailure
+	}
+
+	record, err := rs.read(ctx, name)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRobotAccountUnknown); ok {
+			return distribution.RobotAccount{}, distribution.ErrRobotAuthenticationFailure
+		}
+		return distribution.RobotAccount{}, err
+	}
+
+	now := time.Now()
+	if record.Expired(now) || !rs.verify(name, record.SecretHash, secret) {
+		return distribution.RobotAccount{}, distribution.ErrRobotAuthenticationFailure
+	}
+
+	if err := rs.recordUse(ctx, name, now); err != nil {
+		context.GetLogger(ctx).Errorf("error recording the use of robot account %q: %v", name, err)
+	}
+	return record.RobotAccount, nil
+}
+
+// verify returns true if the secret matches the hash of the named account.
+func (rs *robotAccountService) verify(name, hash, secret string) bool {
+	digest := sha256.Sum256([]byte(secret))
+
+	rs.mu.Lock()
+	verified, ok := rs.verified[name]
+	rs.mu.Unlock()
+	if ok && verified.hash == hash {
+		return subtle.ConstantTimeCompare(verified.digest[:], digest[:]) == 1
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+
+	rs.mu.Lock()
+	rs.verified[name] = verifiedSecret{hash: hash, digest: digest}
+	rs.mu.Unlock()
+	return true
+}
+
+// recordUse writes the last use of the named account to the shard of this
+// instance, unless it did less than an interval ago.
+func (rs *robotAccountService) recordUse(ctx context.Context, name string, now time.Time) error {
+	rs.mu.Lock()
+	if now.Sub(rs.recorded[name]) < rs.interval {
+		rs.mu.Unlock()
+		return nil
+	}
+	rs.recorded[name] = now
+	rs.mu.Unlock()
+
+	shard, err := pathFor(robotLastUsedPathSpec{
+		name:     name,
+		instance: rs.instance,
+	})
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(robotLastUsed{LastUsedAt: now.UTC()})
+	if err != nil {
+		return err
+	}
+	return rs.driver.PutContent(ctx, shard, content)
+}
+
+// lastUsed returns the latest use of the named account recorded by any
+// instance.
+func (rs *robotAccountService) lastUsed(ctx context.Context, name string) (time.Time, error) {
+	dir, err := pathFor(robotLastUsedPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	shards, err := rs.driver.List(ctx, dir)
+	if err != nil {
+		return time.Time{}, ignorePathNotFound(err)
+	}
+
+	var latest time.Time
+	for _, shard := range shards {
+		content, err := rs.driver.GetContent(ctx, shard)
+		if err != nil {
+			if err := ignorePathNotFound(err); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+		var used robotLastUsed
+		if err := json.Unmarshal(content, &used); err != nil {
+			return time.Time{}, err
+		}
+		if used.LastUsedAt.After(latest) {
+			latest = used.LastUsedAt
+		}
+	}
+	return latest, nil
+}
+
+func (rs *robotAccountService) accountDir(name string) (string, error) {
+	p, err := pathFor(robotAccountPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return "", err
+	}
+	return path.Dir(p), nil
+}
+
+func (rs *robotAccountService) read(ctx context.Context, name string) (robotAccountRecord, error) {
+	var record robotAccountRecord
+	if !distribution.RobotAccountNameRegexp.MatchString(name) {
+		return record, distribution.ErrRobotAccountUnknown{Name: name}
+	}
+
+	p, err := pathFor(robotAccountPathSpec{
+		name: name,
+	})
+	if err != nil {
+		return record, err
+	}
+
+	content, err := rs.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return record, distribution.ErrRobotAccountUnknown{Name: name}
+		}
+		return record, err
+	}
+
+	if err := json.Unmarshal(content, &record); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+func (rs *robotAccountService) write(ctx context.Context, record robotAccountRecord) error {
+	p, err := pathFor(robotAccountPathSpec{
+		name: record.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the last use is kept in the shards of the instances
+	record.LastUsedAt = time.Time{}
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return rs.driver.PutContent(ctx, p, content)
+}
+
+// generateRobotSecret returns a random secret and its bcrypt hash.
+func generateRobotSecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+
+	h, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, string(h), nil
+}
+
+// validateRobotAccount checks the name and scopes of an account.
+func validateRobotAccount(account distribution.RobotAccount) error {
+	invalid := func(reason error) error {
+		return distribution.ErrRobotAccountInvalid{Name: account.Name, Reason: reason}
+	}
+
+	if !distribution.RobotAccountNameRegexp.MatchString(account.Name) {
+		return invalid(fmt.Errorf("name must match %s", distribution.RobotAccountNameRegexp))
+	}
+	if len(account.Scopes) == 0 {
+		return invalid(errors.New("at least one scope is required"))
+	}
+	for _, scope := range account.Scopes {
+		if _, err := path.Match(scope.Repository, ""); err != nil || scope.Repository == "" {
+			return invalid(fmt.Errorf("invalid repository pattern %q", scope.Repository))
+		}
+		if len(scope.Actions) == 0 {
+			return invalid(fmt.Errorf("no actions granted on %q", scope.Repository))
+		}
+		for _, action := range scope.Actions {
+			switch action {
+			case "pull", "push", "delete", "*":
+			default:
+				return invalid(fmt.Errorf("unknown action %q", action))
+			}
+		}
+	}
+	return nil
+}
+
+// robotAccountsByName sorts robot accounts by name.
+type robotAccountsByName []distribution.RobotAccount
+
+func (a robotAccountsByName) Len() int           { return len(a) }
+func (a robotAccountsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a robotAccountsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestRobotAccounts(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	first := NewRobotAccountService(d, "first", time.Nanosecond)
+	second := NewRobotAccountService(d, "second", time.Nanosecond)
+
+	account := distribution.RobotAccount{
+		Name:   "ci",
+		Scopes: []distribution.RobotScope{{Repository: "ci/*", Actions: []string{"pull", "push"}}},
+	}
+	secret, err := first.Create(ctx, account)
+	if err != nil {
+		t.Fatalf("unexpected error creating account: %v", err)
+	}
+	if _, err := first.Create(ctx, account); err != (distribution.ErrRobotAccountExists{Name: "ci"}) {
+		t.Fatalf("expected creating an existing account to fail, got %v", err)
+	}
+
+	for _, invalid := range []distribution.RobotAccount{
+		{Name: "CI", Scopes: account.Scopes},
+		{Name: "../ci", Scopes: account.Scopes},
+		{Name: "ci2"},
+		{Name: "ci2", Scopes: []distribution.RobotScope{{Repository: "[", Actions: []string{"pull"}}}},
+		{Name: "ci2", Scopes: []distribution.RobotScope{{Repository: "ci", Actions: []string{"admin"}}}},
+	} {
+		if _, err := first.Create(ctx, invalid); err == nil {
+			t.Fatalf("expected account %#v to be rejected", invalid)
+		} else if _, ok := err.(distribution.ErrRobotAccountInvalid); !ok {
+			t.Fatalf("unexpected error creating account %#v: %v", invalid, err)
+		}
+	}
+
+	// accounts authenticate on every instance
+	authenticated, err := second.Authenticate(ctx, "ci", secret)
+	if err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	if !authenticated.Allowed("ci/app/web", "push") || authenticated.Allowed("ci", "pull") || authenticated.Allowed("ci/app", "delete") {
+		t.Fatalf("unexpected scopes: %#v", authenticated.Scopes)
+	}
+	if _, err := first.Authenticate(ctx, "ci", "wrong"); err != distribution.ErrRobotAuthenticationFailure {
+		t.Fatalf("expected a wrong secret to fail authentication, got %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "unknown", secret); err != distribution.ErrRobotAuthenticationFailure {
+		t.Fatalf("expected an unknown account to fail authentication, got %v", err)
+	}
+
+	// the last use is the latest of all instances
+	time.Sleep(time.Millisecond)
+	if _, err := first.Authenticate(ctx, "ci", secret); err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	firstUse, err := second.Get(ctx, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error getting account: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := second.Authenticate(ctx, "ci", secret); err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	accounts, err := first.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing accounts: %v", err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "ci" || !accounts[0].LastUsedAt.After(firstUse.LastUsedAt) || firstUse.LastUsedAt.IsZero() {
+		t.Fatalf("unexpected accounts: %#v, first used at %v", accounts, firstUse.LastUsedAt)
+	}
+
+	// updates and new secrets apply to every instance
+	account.Scopes = []distribution.RobotScope{{Repository: "ci", Actions: []string{"*"}}}
+	account.ExpiresAt = time.Now().Add(time.Hour)
+	if err := second.Update(ctx, account); err != nil {
+		t.Fatalf("unexpected error updating account: %v", err)
+	}
+	authenticated, err = first.Authenticate(ctx, "ci", secret)
+	if err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	if !authenticated.Allowed("ci", "delete") {
+		t.Fatalf("expected the updated scopes to apply: %#v", authenticated.Scopes)
+	}
+
+	regenerated, err := second.Regenerate(ctx, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error regenerating secret: %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "ci", secret); err != distribution.ErrRobotAuthenticationFailure {
+		t.Fatalf("expected the previous secret to fail authentication, got %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "ci", regenerated); err != nil {
+		t.Fatalf("unexpected error authenticating with the new secret: %v", err)
+	}
+
+	account.ExpiresAt = time.Now().Add(-time.Second)
+	if err := second.Update(ctx, account); err != nil {
+		t.Fatalf("unexpected error updating account: %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "ci", regenerated); err != distribution.ErrRobotAuthenticationFailure {
+		t.Fatalf("expected an expired account to fail authentication, got %v", err)
+	}
+
+	// revocations apply to every instance
+	account.ExpiresAt = time.Time{}
+	if err := second.Update(ctx, account); err != nil {
+		t.Fatalf("unexpected error updating account: %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "ci", regenerated); err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+	if err := second.Revoke(ctx, "ci"); err != nil {
+		t.Fatalf("unexpected error revoking account: %v", err)
+	}
+	if _, err := first.Authenticate(ctx, "ci", regenerated); err != distribution.ErrRobotAuthenticationFailure {
+		t.Fatalf("expected a revoked account to fail authentication, got %v", err)
+	}
+	if _, err := first.Get(ctx, "ci"); err != (distribution.ErrRobotAccountUnknown{Name: "ci"}) {
+		t.Fatalf("expected a revoked account to be unknown, got %v", err)
+	}
+	if err := first.Revoke(ctx, "ci"); err != (distribution.ErrRobotAccountUnknown{Name: "ci"}) {
+		t.Fatalf("expected revoking a revoked account to fail, got %v", err)
+	}
+
+	// an account created again does not inherit the last use
+	if _, err := first.Create(ctx, account); err != nil {
+		t.Fatalf("unexpected error creating account: %v", err)
+	}
+	recreated, err := second.Get(ctx, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error getting account: %v", err)
+	}
+	if !recreated.LastUsedAt.IsZero() {
+		t.Fatalf("expected the account to be unused, last used at %v", recreated.LastUsedAt)
+	}
+}
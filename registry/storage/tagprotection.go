@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"hash/fnv"
+	"path"
+	"regexp"
+
+	"github.com/docker/distribution"
+)
+
+// TagRule protects the tags matching its patterns, in the repositories
+// matching its patterns, from being moved or deleted.
+type TagRule struct {
+	// Repositories are patterns, as understood by path.Match, of the
+	// repositories the rule applies to. An empty list matches every
+	// repository.
+	Repositories []string
+
+	// Tags are patterns, as understood by path.Match, of the tags protected
+	// by the rule, and TagRegexp a regular expression matching more of them.
+	Tags      []string
+	TagRegexp *regexp.Regexp
+
+	// Immutable prevents the tags from pointing to another manifest once
+	// pushed.
+	Immutable bool
+
+	// NoDelete prevents the tags, and the manifests they point to, from
+	// being deleted.
+	NoDelete bool
+}
+
+// AppliesTo returns true if the rule applies to the named repository.
+func (r TagRule) AppliesTo(name string) bool {
+	if len(r.Repositories) == 0 {
+		return true
+	}
+	for _, pattern := range r.Repositories {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Protects returns true if the rule protects the tag of the named
+// repository.
+func (r TagRule) Protects(name, tag string) bool {
+	if !r.AppliesTo(name) {
+		return false
+	}
+	for _, pattern := range r.Tags {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return r.TagRegexp != nil && r.TagRegexp.MatchString(tag)
+}
+
+// TagRules returns a functional option for NewRegistry. It sets the rules
+// protecting tags from being moved or deleted.
+func TagRules(rules []TagRule) RegistryOption {
+	return func(registry *registry) error {
+		registry.tagRules = rules
+		return nil
+	}
+}
+
+// tagProtection returns whether the tag of the named repository is immutable
+// and whether it may not be deleted, according to the rules of the
+// registry.
+func (reg *registry) tagProtection(name, tag string) (immutable, noDelete bool) {
+	for _, rule := range reg.tagRules {
+		if rule.Protects(name, tag) {
+			immutable = immutable || rule.Immutable
+			noDelete = noDelete || rule.NoDelete
+		}
+	}
+	return immutable, noDelete
+}
+
+// checkUntag returns ErrTagProtected if deleting any of the tags of the named
+// repository is forbidden.
+func (reg *registry) checkUntag(name string, tags ...string) error {
+	for _, tag := range tags {
+		if _, noDelete := reg.tagProtection(name, tag); noDelete {
+			return distribution.ErrTagProtected{Name: name, Tag: tag}
+		}
+	}
+	return nil
+}
+
+// immutableTagLockCount is the number of locks serializing the pushes of
+// immutable tags, shared by the tags hashing to the same lock.
+const immutableTagLockCount = 32
+
+// lockImmutableTag serializes, within this registry instance, the pushes of
+// an immutable tag, which are checked and written in several steps. It
+// returns the function unlocking the tag.
+func (reg *registry) lockImmutableTag(name, tag string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + tag))
+	mu := &reg.immutableTagLocks[h.Sum32()%immutableTagLockCount]
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestTagProtection(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), EnableDelete, TagRules([]TagRule{
+		{Repositories: []string{"team/*"}, Tags: []string{"v*"}, Immutable: true},
+		{Repositories: []string{"team/*"}, TagRegexp: regexp.MustCompile(`^release-[0-9]+$`), Immutable: true, NoDelete: true},
+	}))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repo := makeRepository(t, registry, "team/app")
+	tags := repo.Tags(ctx)
+	first := distribution.Descriptor{Digest: uploadRandomSchema2Image(t, repo).manifestDigest}
+	second := distribution.Descriptor{Digest: uploadRandomSchema2Image(t, repo).manifestDigest}
+
+	for _, tag := range []string{"v1", "release-1", "latest"} {
+		if err := tags.Tag(ctx, tag, first); err != nil {
+			t.Fatalf("unexpected error tagging %s: %v", tag, err)
+		}
+		// pushing the same manifest again is allowed
+		if err := tags.Tag(ctx, tag, first); err != nil {
+			t.Fatalf("unexpected error tagging %s again: %v", tag, err)
+		}
+	}
+
+	for _, tag := range []string{"v1", "release-1"} {
+		if _, ok := tags.Tag(ctx, tag, second).(distribution.ErrTagImmutable); !ok {
+			t.Fatalf("expected %s to be immutable", tag)
+		}
+	}
+	if err := tags.Tag(ctx, "latest", second); err != nil {
+		t.Fatalf("unexpected error moving latest: %v", err)
+	}
+
+	// the rules only apply to the matching repositories
+	other := makeRepository(t, registry, "library/app")
+	otherFirst := distribution.Descriptor{Digest: uploadRandomSchema2Image(t, other).manifestDigest}
+	otherSecond := distribution.Descriptor{Digest: uploadRandomSchema2Image(t, other).manifestDigest}
+	if err := other.Tags(ctx).Tag(ctx, "v1", otherFirst); err != nil {
+		t.Fatalf("unexpected error tagging: %v", err)
+	}
+	if err := other.Tags(ctx).Tag(ctx, "v1", otherSecond); err != nil {
+		t.Fatalf("unexpected error moving a tag of another repository: %v", err)
+	}
+
+	if err := tags.Untag(ctx, "v1"); err != nil {
+		t.Fatalf("unexpected error deleting an immutable tag: %v", err)
+	}
+	if _, ok := tags.Untag(ctx, "release-1").(distribution.ErrTagProtected); !ok {
+		t.Fatalf("expected release-1 to be protected against deletion")
+	}
+	manifests := makeManifestService(t, repo)
+	if _, ok := manifests.Delete(ctx, first.Digest).(distribution.ErrTagProtected); !ok {
+		t.Fatalf("expected the manifest of release-1 to be protected against deletion")
+	}
+	if err := manifests.Delete(ctx, second.Digest); err != nil {
+		t.Fatalf("unexpected error deleting a manifest: %v", err)
+	}
+	if _, ok := registry.(distribution.RepositoryRemover).Remove(ctx, repo.Named()).(distribution.ErrTagProtected); !ok {
+		t.Fatalf("expected the repository to be protected against removal")
+	}
+	if _, err := tags.Get(ctx, "release-1"); err != nil {
+		t.Fatalf("unexpected error getting protected tag: %v", err)
+	}
+}
+
+func TestImmutableTagConcurrentPush(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), TagRules([]TagRule{
+		{Repositories: []string{"team/*"}, Tags: []string{"v*"}, Immutable: true},
+	}))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repo := makeRepository(t, registry, "team/app")
+	tags := repo.Tags(ctx)
+
+	descs := make([]distribution.Descriptor, 8)
+	for i := range descs {
+		descs[i] = distribution.Descriptor{Digest: uploadRandomSchema2Image(t, repo).manifestDigest}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(descs))
+	for i := range descs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = tags.Tag(ctx, "v1", descs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	current, err := tags.Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+	for i, err := range errs {
+		if descs[i].Digest == current.Digest {
+			if err != nil {
+				t.Fatalf("unexpected error pushing the current manifest: %v", err)
+			}
+		} else if _, ok := err.(distribution.ErrTagImmutable); !ok {
+			t.Fatalf("expected push of %s to fail, got %v", descs[i].Digest, err)
+		}
+	}
+}
@@ -75,10 +75,17 @@ func (ts *tagStore) exists(ctx context.Context, tag string) (bool, error) {
 }
 
 // Tag tags the digest with the given tag, updating the the store to point at
-// the current tag. The digest must point to a manifest.
+// the current tag. The digest must point to a manifest. ErrTagImmutable is
+// returned if the tag is immutable and points to another manifest.
+//
+// Pushes of an immutable tag are serialized within this instance. Instances
+// sharing the storage are not: the tag is read back once written, failing the
+// pushes whose manifest was overwritten, but as the driver has no transaction
+// a push writing after another has read its own write back still moves the tag.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	name := ts.repository.Named().Name()
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
-		name: ts.repository.Named().Name(),
+		name: name,
 		tag:  tag,
 	})
 
@@ -86,6 +93,22 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	immutable, _ := ts.repository.registry.tagProtection(name, tag)
+	if immutable {
+		defer ts.repository.registry.lockImmutableTag(name, tag)()
+
+		current, err := ts.Get(ctx, tag)
+		switch err.(type) {
+		case nil:
+			if current.Digest != desc.Digest {
+				return distribution.ErrTagImmutable{Name: name, Tag: tag}
+			}
+		case distribution.ErrTagUnknown:
+		default:
+			return err
+		}
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -94,7 +117,21 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 	}
 
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	if immutable {
+		// another instance may have pushed the tag since it was checked
+		current, err := ts.Get(ctx, tag)
+		if err != nil {
+			return err
+		}
+		if current.Digest != desc.Digest {
+			return distribution.ErrTagImmutable{Name: name, Tag: tag}
+		}
+	}
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -121,8 +158,17 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 	return distribution.Descriptor{Digest: revision}, nil
 }
 
-// Untag removes the tag association
+// Untag removes the tag association. ErrTagProtected is returned if the tag
+// is protected against deletion.
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
+	if err := ts.repository.registry.checkUntag(ts.repository.Named().Name(), tag); err != nil {
+		return err
+	}
+	return ts.untag(ctx, tag)
+}
+
+// untag removes the tag association, whatever the rules protecting it.
+func (ts *tagStore) untag(ctx context.Context, tag string) error {
 	tagPath, err := pathFor(manifestTagPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth/tokenserver"
+	"github.com/docker/distribution/version"
+	gorhandlers "github.com/gorilla/handlers"
+	"github.com/spf13/cobra"
+)
+
+// defaultTokenServerPrefix is the path prefix of the endpoints of the token
+// server, unless configured otherwise.
+const defaultTokenServerPrefix = "/auth"
+
+// TokenServerCmd is the cobra command that corresponds to the token-server
+// subcommand
+var TokenServerCmd = &cobra.Command{
+	Use:   "token-server <config>",
+	Short: "`token-server` issues tokens for registries using token authentication",
+	Long:  "`token-server` issues tokens for registries using token authentication.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.WithVersion(context.Background(), version.Version)
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		if config.TokenServer.Addr == "" {
+			fmt.Fprintln(os.Stderr, "configuration error: tokenserver.addr is required")
+			os.Exit(1)
+		}
+
+		ts, err := newTokenServer(ctx, config)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		prefix := tokenServerPrefix(config)
+		handler := mountTokenServer(prefix, ts, http.NotFoundHandler())
+		handler = alive("/", handler)
+		handler = panicHandler(handler)
+		handler = gorhandlers.CombinedLoggingHandler(os.Stdout, handler)
+
+		tls := config.HTTP.TLS
+		if tls.Certificate != "" {
+			context.GetLogger(ctx).Infof("token server listening on %v%s, tls", config.TokenServer.Addr, prefix)
+			err = http.ListenAndServeTLS(config.TokenServer.Addr, tls.Certificate, tls.Key, handler)
+		} else {
+			context.GetLogger(ctx).Infof("token server listening on %v%s", config.TokenServer.Addr, prefix)
+			err = http.ListenAndServe(config.TokenServer.Addr, handler)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+// newTokenServer creates the token server configured by the tokenserver
+// section.
+func newTokenServer(ctx context.Context, config *configuration.Configuration) (*tokenserver.Server, error) {
+	options := tokenserver.Options{
+		Issuer:            config.TokenServer.Issuer,
+		Realm:             config.TokenServer.Realm,
+		Expiration:        config.TokenServer.Expiration,
+		RefreshExpiration: config.TokenServer.RefreshExpiration,
+		Keys:              config.TokenServer.Keys,
+		ACL:               config.TokenServer.ACL,
+		ReloadInterval:    config.TokenServer.ReloadInterval,
+	}
+	for _, backend := range config.TokenServer.Backends {
+		options.Backends = append(options.Backends, tokenserver.BackendOptions{
+			Type:    backend.Type,
+			Options: backend.Options,
+		})
+	}
+
+	ts, err := tokenserver.New(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure token server: %v", err)
+	}
+	return ts, nil
+}
+
+func tokenServerPrefix(config *configuration.Configuration) string {
+	prefix := strings.TrimRight(config.TokenServer.Prefix, "/")
+	if prefix == "" {
+		prefix = defaultTokenServerPrefix
+	}
+	return prefix
+}
+
+// mountTokenServer serves the requests under the prefix with the token
+// server, and passes the other requests to the provided handler.
+func mountTokenServer(prefix string, ts http.Handler, handler http.Handler) http.Handler {
+	ts = http.StripPrefix(prefix, ts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix+"/") {
+			ts.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
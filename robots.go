@@ -0,0 +1,121 @@
+package distribution
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// RobotAccountNameRegexp matches the valid names of robot accounts.
+var RobotAccountNameRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// RobotScope grants actions on repositories to a robot account.
+type RobotScope struct {
+	// Repository is a pattern, as understood by path.Match, matching the
+	// repositories of the scope and the repositories below them: "ci/*"
+	// covers "ci/app" as well as "ci/app/web".
+	Repository string `json:"repository"`
+
+	// Actions lists the granted actions, which are "pull", "push" and
+	// "delete". "*" grants every action.
+	Actions []string `json:"actions"`
+}
+
+// Covers returns true if the scope applies to the named repository.
+func (s RobotScope) Covers(name string) bool {
+	for {
+		if ok, _ := path.Match(s.Repository, name); ok {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// Grants returns true if the scope grants the action on the named
+// repository.
+func (s RobotScope) Grants(name, action string) bool {
+	if !s.Covers(name) {
+		return false
+	}
+	for _, granted := range s.Actions {
+		if granted == action || granted == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// RobotAccount is an account meant for automated clients, such as CI
+// systems, which authenticates with a secret generated by the registry and
+// is only granted the actions of its scopes.
+type RobotAccount struct {
+	Name   string       `json:"name"`
+	Scopes []RobotScope `json:"scopes"`
+
+	// ExpiresAt is the time after which the account may no longer
+	// authenticate. The zero time never expires.
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// LastUsedAt is the last time the account authenticated, as recorded by
+	// any registry instance, or the zero time if it never did. It is
+	// recorded with the precision of the service.
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// Expired returns true if the account may no longer authenticate at the
+// given time.
+func (a RobotAccount) Expired(now time.Time) bool {
+	return !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt)
+}
+
+// Allowed returns true if a scope of the account grants the action on the
+// named repository.
+func (a RobotAccount) Allowed(name, action string) bool {
+	for _, scope := range a.Scopes {
+		if scope.Grants(name, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// RobotAccountService manages robot accounts and authenticates them. The
+// accounts are read again on every authentication, so that updates and
+// revocations apply immediately to every registry instance sharing them.
+type RobotAccountService interface {
+	// Create creates an account and returns its secret, which is only kept
+	// hashed and can not be retrieved later. If the account exists,
+	// ErrRobotAccountExists is returned.
+	Create(ctx context.Context, account RobotAccount) (secret string, err error)
+
+	// Get returns the named account, or ErrRobotAccountUnknown.
+	Get(ctx context.Context, name string) (RobotAccount, error)
+
+	// List returns all accounts, sorted by name.
+	List(ctx context.Context) ([]RobotAccount, error)
+
+	// Update replaces the scopes and expiry of an account, keeping its
+	// secret.
+	Update(ctx context.Context, account RobotAccount) error
+
+	// Regenerate replaces the secret of the named account and returns it.
+	// The previous secret no longer authenticates.
+	Regenerate(ctx context.Context, name string) (secret string, err error)
+
+	// Revoke removes the named account, which no longer authenticates.
+	Revoke(ctx context.Context, name string) error
+
+	// Authenticate returns the named account if the secret is its own and
+	// it did not expire, and records that it was used. Otherwise,
+	// ErrRobotAuthenticationFailure is returned.
+	Authenticate(ctx context.Context, name, secret string) (RobotAccount, error)
+}